@@ -0,0 +1,445 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package clients
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/intel-secl/intel-secl/v4/pkg/clients/kbs"
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/crypt"
+	"github.com/intel-secl/intel-secl/v4/pkg/model/hvs"
+	commLog "intel/isecl/lib/common/v4/log"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var log = commLog.GetDefaultLogger()
+
+// defaultCertsCheckInterval is the minimum time between two checks of the trusted CA certificates and CA bundles
+const defaultCertsCheckInterval = 10 * time.Second
+
+// tokenRefreshMargin is the time before the expiry of the AAS bearer token at which a new token is requested
+const tokenRefreshMargin = time.Minute
+
+// StatusError is the error of a request to HVS, AAS or KBS answered with an unexpected HTTP status
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Request made to %s returned status %d", e.URL, e.StatusCode)
+}
+
+// ResponseStatus returns the HTTP status of the unexpected response an error of the clients is about, and false
+// if the error is not about an unexpected response
+func ResponseStatus(err error) (int, bool) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode, true
+	}
+	return 0, false
+}
+
+// newHTTPClient creates an HTTP client verifying the TLS certificates of the services with caCerts
+func newHTTPClient(caCerts []x509.Certificate) *http.Client {
+	rootCAs := x509.NewCertPool()
+	for i := range caCerts {
+		rootCAs.AddCert(&caCerts[i])
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				RootCAs:    rootCAs,
+			},
+		},
+	}
+}
+
+// send sends a request and returns the body of the response. A response with a status other than
+// 200 OK or 201 Created is returned as a *StatusError.
+func send(client *http.Client, req *http.Request) ([]byte, error) {
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "clients/manager:send() Error making request")
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK && rsp.StatusCode != http.StatusCreated {
+		return nil, &StatusError{URL: req.URL.String(), StatusCode: rsp.StatusCode}
+	}
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "clients/manager:send() Error reading response body")
+	}
+	return body, nil
+}
+
+// tokenExpiry returns the expiry time of a JWT bearer token, and the zero time if the token does not expire
+func tokenExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("clients/manager:tokenExpiry() Bearer token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "clients/manager:tokenExpiry() Unable to decode bearer token claims")
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, errors.Wrap(err, "clients/manager:tokenExpiry() Unable to parse bearer token claims")
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// kbsClient transfers keys from a KBS, verifying its TLS certificate with the CA certificates it was built with
+type kbsClient struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+}
+
+// TransferKeyWithSaml requests the key with keyID from KBS for the host the SAML report is about
+func (k *kbsClient) TransferKeyWithSaml(keyID, saml string) ([]byte, error) {
+	log.Trace("clients/manager:TransferKeyWithSaml() Entering")
+	defer log.Trace("clients/manager:TransferKeyWithSaml() Leaving")
+	transferURL := k.baseURL.ResolveReference(&url.URL{Path: "keys/" + keyID + "/transfer"})
+	req, err := http.NewRequest(http.MethodPost, transferURL.String(), bytes.NewBufferString(saml))
+	if err != nil {
+		return nil, errors.Wrap(err, "clients/manager:TransferKeyWithSaml() Error creating key transfer request")
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+	req.Header.Set("Content-Type", "application/samlassertion+xml")
+	return send(k.httpClient, req)
+}
+
+// kbsClientID identifies a KBS client, the same KBS can be configured with different CA bundles
+type kbsClientID struct {
+	baseURL  string
+	caBundle string
+}
+
+// kbsClientEntry is a KBS client along with the stamp of the CA bundle it was built with
+type kbsClientEntry struct {
+	client      kbs.KBSClient
+	bundleStamp string
+	checked     time.Time
+}
+
+// Manager holds the HVS and KBS clients WLS uses, shared by all requests. The AAS bearer token used with HVS is
+// shared as well, and replaced shortly before it expires or when HVS rejects it. The clients are replaced when
+// the files of the trusted CA directory change, and a KBS client when its CA bundle changes.
+type Manager struct {
+	hvsBaseURL        string
+	aasBaseURL        string
+	username          string
+	password          string
+	trustedCaCertsDir string
+
+	certsCheckInterval time.Duration
+	newKBSClient       func(baseURL *url.URL, caCerts []x509.Certificate) kbs.KBSClient
+
+	tokenMtx    *sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	certsMtx     *sync.Mutex
+	certsStamp   string
+	certsChecked time.Time
+	caCerts      []x509.Certificate
+	httpClient   *http.Client
+	kbsClients   map[kbsClientID]*kbsClientEntry
+}
+
+// NewManager creates a client manager for the given HVS and AAS base URLs, using the WLS service credentials
+// for AAS and the certificates in trustedCaCertsDir to verify the TLS certificates of the services
+func NewManager(hvsBaseURL, aasBaseURL, username, password, trustedCaCertsDir string) *Manager {
+	log.Trace("clients/manager:NewManager() Entering")
+	defer log.Trace("clients/manager:NewManager() Leaving")
+	if !strings.HasSuffix(hvsBaseURL, "/") {
+		hvsBaseURL = hvsBaseURL + "/"
+	}
+	if !strings.HasSuffix(aasBaseURL, "/") {
+		aasBaseURL = aasBaseURL + "/"
+	}
+	return &Manager{
+		hvsBaseURL:         hvsBaseURL,
+		aasBaseURL:         aasBaseURL,
+		username:           username,
+		password:           password,
+		trustedCaCertsDir:  trustedCaCertsDir,
+		certsCheckInterval: defaultCertsCheckInterval,
+		newKBSClient: func(baseURL *url.URL, caCerts []x509.Certificate) kbs.KBSClient {
+			return &kbsClient{baseURL: baseURL, httpClient: newHTTPClient(caCerts)}
+		},
+		tokenMtx:   &sync.Mutex{},
+		certsMtx:   &sync.Mutex{},
+		kbsClients: make(map[kbsClientID]*kbsClientEntry),
+	}
+}
+
+// filesStamp summarizes the names, sizes and modification times of files
+func filesStamp(files []os.FileInfo) string {
+	var stamp []string
+	for _, f := range files {
+		stamp = append(stamp, fmt.Sprintf("%s:%d:%d", f.Name(), f.Size(), f.ModTime().UnixNano()))
+	}
+	sort.Strings(stamp)
+	return strings.Join(stamp, ";")
+}
+
+// certsDirStamp summarizes the names, sizes and modification times of the certificate files in dir
+func certsDirStamp(dir string) (string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var certFiles []os.FileInfo
+	for _, f := range files {
+		if !f.IsDir() && filepath.Ext(f.Name()) == ".pem" {
+			certFiles = append(certFiles, f)
+		}
+	}
+	return filesStamp(certFiles), nil
+}
+
+// caBundleStamp summarizes the size and modification time of a CA bundle file
+func caBundleStamp(caBundle string) (string, error) {
+	f, err := os.Stat(caBundle)
+	if err != nil {
+		return "", errors.Wrapf(err, "clients/manager:caBundleStamp() Unable to read CA bundle %s", caBundle)
+	}
+	return filesStamp([]os.FileInfo{f}), nil
+}
+
+// refreshCerts reloads the trusted CA certificates when the trusted CA directory changed since they were loaded.
+// On reload, the clients built with the previous certificates are replaced. The certificates mutex must be held.
+func (m *Manager) refreshCerts() error {
+	now := time.Now()
+	if m.caCerts != nil && now.Sub(m.certsChecked) < m.certsCheckInterval {
+		return nil
+	}
+	stamp, err := certsDirStamp(m.trustedCaCertsDir)
+	if err != nil {
+		return errors.Wrap(err, "clients/manager:refreshCerts() Unable to read trusted CA certificates directory")
+	}
+	m.certsChecked = now
+	if m.caCerts != nil && stamp == m.certsStamp {
+		return nil
+	}
+
+	caCerts, err := crypt.GetCertsFromDir(m.trustedCaCertsDir)
+	if err != nil {
+		return errors.Wrap(err, "clients/manager:refreshCerts() Unable to load CA certificates")
+	}
+	if caCerts == nil {
+		caCerts = []x509.Certificate{}
+	}
+	log.Infof("clients/manager:refreshCerts() Loaded %d trusted CA certificates", len(caCerts))
+	m.certsStamp = stamp
+	m.caCerts = caCerts
+	m.httpClient = newHTTPClient(caCerts)
+	m.kbsClients = make(map[kbsClientID]*kbsClientEntry)
+	m.tokenMtx.Lock()
+	m.token = ""
+	m.tokenMtx.Unlock()
+	return nil
+}
+
+// LoadCABundle reads the certificates of a PEM encoded CA bundle
+func LoadCABundle(caBundle string) ([]x509.Certificate, error) {
+	bundle, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return nil, errors.Wrapf(err, "clients/manager:LoadCABundle() Unable to read CA bundle %s", caBundle)
+	}
+	var caCerts []x509.Certificate
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "clients/manager:LoadCABundle() Unable to parse certificate in CA bundle %s", caBundle)
+		}
+		caCerts = append(caCerts, *cert)
+	}
+	if len(caCerts) == 0 {
+		return nil, errors.Errorf("clients/manager:LoadCABundle() CA bundle %s holds no certificate", caBundle)
+	}
+	return caCerts, nil
+}
+
+// KBSClient returns the KBS client for the KBS at baseURL, creating it on first use. The TLS certificate of the KBS
+// is verified with the certificates of caBundle if set, with the trusted CA certificates otherwise. The client is
+// replaced when the CA bundle or the trusted CA certificates change.
+func (m *Manager) KBSClient(baseURL, caBundle string) (kbs.KBSClient, error) {
+	log.Trace("clients/manager:KBSClient() Entering")
+	defer log.Trace("clients/manager:KBSClient() Leaving")
+	m.certsMtx.Lock()
+	defer m.certsMtx.Unlock()
+	if err := m.refreshCerts(); err != nil {
+		return nil, err
+	}
+
+	id := kbsClientID{baseURL: baseURL, caBundle: caBundle}
+	entry, exists := m.kbsClients[id]
+	now := time.Now()
+	if exists && (caBundle == "" || now.Sub(entry.checked) < m.certsCheckInterval) {
+		return entry.client, nil
+	}
+	var bundleStamp string
+	if caBundle != "" {
+		var err error
+		if bundleStamp, err = caBundleStamp(caBundle); err != nil {
+			delete(m.kbsClients, id)
+			return nil, err
+		}
+		if exists && bundleStamp == entry.bundleStamp {
+			entry.checked = now
+			return entry.client, nil
+		}
+	}
+
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL = baseURL + "/"
+	}
+	kbsURL, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "clients/manager:KBSClient() Invalid KBS base URL")
+	}
+	caCerts := m.caCerts
+	if caBundle != "" {
+		if caCerts, err = LoadCABundle(caBundle); err != nil {
+			delete(m.kbsClients, id)
+			return nil, err
+		}
+		if exists {
+			log.Infof("clients/manager:KBSClient() CA bundle %s changed, replacing the client of KBS %s", caBundle, baseURL)
+		}
+	}
+	kc := m.newKBSClient(kbsURL, caCerts)
+	m.kbsClients[id] = &kbsClientEntry{client: kc, bundleStamp: bundleStamp, checked: now}
+	return kc, nil
+}
+
+// getHTTPClient returns the HTTP client verifying the TLS certificates of HVS and AAS with the trusted CA certificates
+func (m *Manager) getHTTPClient() (*http.Client, error) {
+	m.certsMtx.Lock()
+	defer m.certsMtx.Unlock()
+	if err := m.refreshCerts(); err != nil {
+		return nil, err
+	}
+	return m.httpClient, nil
+}
+
+// getToken returns the shared AAS bearer token, requesting a new one from AAS if there is none or if it expires
+// within tokenRefreshMargin. Concurrent requests needing a new token share a single AAS request.
+func (m *Manager) getToken(httpClient *http.Client) (string, error) {
+	m.tokenMtx.Lock()
+	defer m.tokenMtx.Unlock()
+	if m.token != "" && (m.tokenExpiry.IsZero() || time.Now().Before(m.tokenExpiry.Add(-tokenRefreshMargin))) {
+		return m.token, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"username": m.username, "password": m.password})
+	if err != nil {
+		return "", errors.Wrap(err, "clients/manager:getToken() Error marshalling AAS credentials")
+	}
+	req, err := http.NewRequest(http.MethodPost, m.aasBaseURL+"token", bytes.NewBuffer(body))
+	if err != nil {
+		return "", errors.Wrap(err, "clients/manager:getToken() Error creating AAS token request")
+	}
+	req.Header.Set("Accept", "application/jwt")
+	req.Header.Set("Content-Type", "application/json")
+	token, err := send(httpClient, req)
+	if err != nil {
+		return "", errors.Wrap(err, "clients/manager:getToken() Failed to fetch token from AAS")
+	}
+	expiry, err := tokenExpiry(string(token))
+	if err != nil {
+		// the token is still used, and only replaced once HVS rejects it
+		log.WithError(err).Warn("clients/manager:getToken() Unable to read the expiry time of the AAS bearer token")
+	}
+	m.token = string(token)
+	m.tokenExpiry = expiry
+	return m.token, nil
+}
+
+// dropToken drops the shared AAS bearer token if it is still the rejected one, so that concurrent
+// requests rejected with the same token only lead to a single new token
+func (m *Manager) dropToken(rejected string) {
+	m.tokenMtx.Lock()
+	defer m.tokenMtx.Unlock()
+	if m.token == rejected {
+		m.token = ""
+	}
+}
+
+// createReport requests a new SAML report from HVS with the given bearer token
+func (m *Manager) createReport(httpClient *http.Client, token string, request hvs.ReportCreateRequest) ([]byte, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "clients/manager:createReport() Error marshalling report create request")
+	}
+	req, err := http.NewRequest(http.MethodPost, m.hvsBaseURL+"reports", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "clients/manager:createReport() Error creating SAML report request")
+	}
+	req.Header.Set("Accept", "application/samlassertion+xml")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return send(httpClient, req)
+}
+
+// CreateSAMLReport requests a new SAML report for the host with hardwareUUID from HVS.
+// If HVS rejects the shared bearer token, a new token is requested from AAS and the request is retried once.
+func (m *Manager) CreateSAMLReport(hardwareUUID string) ([]byte, error) {
+	log.Trace("clients/manager:CreateSAMLReport() Entering")
+	defer log.Trace("clients/manager:CreateSAMLReport() Leaving")
+	hwid, err := uuid.Parse(hardwareUUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "clients/manager:CreateSAMLReport() Invalid hardware UUID")
+	}
+	request := hvs.ReportCreateRequest{HardwareUUID: hwid}
+
+	httpClient, err := m.getHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	token, err := m.getToken(httpClient)
+	if err != nil {
+		return nil, err
+	}
+	saml, err := m.createReport(httpClient, token, request)
+	if status, ok := ResponseStatus(err); ok && status == http.StatusUnauthorized {
+		log.Debug("clients/manager:CreateSAMLReport() Bearer token rejected by HVS, requesting a new token")
+		m.dropToken(token)
+		if token, err = m.getToken(httpClient); err != nil {
+			return nil, err
+		}
+		saml, err = m.createReport(httpClient, token, request)
+	}
+	return saml, err
+}
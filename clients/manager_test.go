@@ -0,0 +1,421 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package clients
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/intel-secl/intel-secl/v4/pkg/clients/kbs"
+	"github.com/intel-secl/intel-secl/v4/pkg/model/hvs"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServices serves AAS tokens and HVS SAML reports. Every token issued expires after tokenLifetime,
+// and HVS only accepts the last token issued.
+type fakeServices struct {
+	mtx            sync.Mutex
+	server         *httptest.Server
+	tokensIssued   int
+	validToken     string
+	tokenLifetime  time.Duration
+	reportRequests int
+	aasStatus      int
+}
+
+func newFakeServices() *fakeServices {
+	f := &fakeServices{tokenLifetime: time.Hour}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/aas/token", f.issueToken)
+	mux.HandleFunc("/mtwilson/v2/reports", f.createSAMLReport)
+	f.server = httptest.NewServer(mux)
+	return f
+}
+
+// jwt creates an unsigned bearer token expiring at exp
+func jwt(exp time.Time) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(fmt.Sprintf(`{"sub":"wls","exp":%d}`, exp.Unix()))) + "."
+}
+
+func (f *fakeServices) issueToken(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.aasStatus != 0 {
+		w.WriteHeader(f.aasStatus)
+		return
+	}
+	f.tokensIssued++
+	f.validToken = jwt(time.Now().Add(f.tokenLifetime)) + strconv.Itoa(f.tokensIssued)
+	w.Write([]byte(f.validToken))
+}
+
+func (f *fakeServices) createSAMLReport(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.reportRequests++
+	if r.Header.Get("Authorization") != "Bearer "+f.validToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var request hvs.ReportCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("<saml2:Assertion>" + request.HardwareUUID.String() + "</saml2:Assertion>"))
+}
+
+func (f *fakeServices) revokeToken() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.validToken = ""
+}
+
+type fakeKBSClient struct {
+	baseURL string
+}
+
+func (k *fakeKBSClient) TransferKeyWithSaml(keyID, saml string) ([]byte, error) {
+	return []byte(keyID), nil
+}
+
+func writeCACert(t *testing.T, dir, name string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("could not generate CA key")
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("could not create CA certificate")
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".pem"), certPem, 0600); err != nil {
+		t.Fatal("could not write CA certificate")
+	}
+}
+
+func setupManager(t *testing.T, services *fakeServices) (*Manager, func()) {
+	dir, err := ioutil.TempDir("", "trustedca")
+	if err != nil {
+		t.Fatal("could not create trusted CA directory")
+	}
+	writeCACert(t, dir, "root-ca")
+	m := NewManager(services.server.URL+"/mtwilson/v2", services.server.URL+"/aas", "wls", "password", dir)
+	return m, func() {
+		services.server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func createSAMLReports(m *Manager, count int) []error {
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = m.CreateSAMLReport("3e7f9b8a-9d63-4c51-8a0a-4a6f3a0a2b11")
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestResponseStatus(t *testing.T) {
+	log.Trace("clients/manager_test:TestResponseStatus() Entering")
+	defer log.Trace("clients/manager_test:TestResponseStatus() Leaving")
+	assert := assert.New(t)
+
+	err := pkgerrors.Wrap(&StatusError{URL: "https://kbs.server.com:9443/v1/keys/transfer", StatusCode: http.StatusServiceUnavailable}, "Failed to retrieve key")
+	status, ok := ResponseStatus(err)
+	assert.True(ok)
+	assert.Equal(http.StatusServiceUnavailable, status)
+
+	// a status only mentioned in the message of an error is not a response status
+	for _, err := range []error{nil, errors.New("dial tcp 10.0.0.1:9443: connect: connection refused"), errors.New("Request made to https://kbs.server.com returned status 503")} {
+		_, ok := ResponseStatus(err)
+		assert.False(ok)
+	}
+}
+
+func TestTokenReusedByConcurrentRequests(t *testing.T) {
+	log.Trace("clients/manager_test:TestTokenReusedByConcurrentRequests() Entering")
+	defer log.Trace("clients/manager_test:TestTokenReusedByConcurrentRequests() Leaving")
+	assert := assert.New(t)
+	services := newFakeServices()
+	m, cleanup := setupManager(t, services)
+	defer cleanup()
+
+	for _, err := range createSAMLReports(m, 20) {
+		assert.NoError(err)
+	}
+	assert.Equal(1, services.tokensIssued)
+	assert.Equal(20, services.reportRequests)
+}
+
+func TestTokenReplacedOnUnauthorized(t *testing.T) {
+	log.Trace("clients/manager_test:TestTokenReplacedOnUnauthorized() Entering")
+	defer log.Trace("clients/manager_test:TestTokenReplacedOnUnauthorized() Leaving")
+	assert := assert.New(t)
+	services := newFakeServices()
+	m, cleanup := setupManager(t, services)
+	defer cleanup()
+
+	_, err := m.CreateSAMLReport("3e7f9b8a-9d63-4c51-8a0a-4a6f3a0a2b11")
+	assert.NoError(err)
+	services.revokeToken()
+
+	// all the requests rejected with the revoked token share a single new token
+	for _, err := range createSAMLReports(m, 20) {
+		assert.NoError(err)
+	}
+	assert.Equal(2, services.tokensIssued)
+}
+
+func TestCreateSAMLReportFailure(t *testing.T) {
+	log.Trace("clients/manager_test:TestCreateSAMLReportFailure() Entering")
+	defer log.Trace("clients/manager_test:TestCreateSAMLReportFailure() Leaving")
+	assert := assert.New(t)
+	services := newFakeServices()
+	services.aasStatus = http.StatusBadGateway
+	m, cleanup := setupManager(t, services)
+	defer cleanup()
+
+	_, err := m.CreateSAMLReport("3e7f9b8a-9d63-4c51-8a0a-4a6f3a0a2b11")
+	status, ok := ResponseStatus(err)
+	assert.True(ok)
+	assert.Equal(http.StatusBadGateway, status)
+
+	// a token that could not be fetched is fetched again by the next request
+	services.aasStatus = 0
+	_, err = m.CreateSAMLReport("3e7f9b8a-9d63-4c51-8a0a-4a6f3a0a2b11")
+	assert.NoError(err)
+
+	_, err = m.CreateSAMLReport("not-a-uuid")
+	assert.Error(err)
+}
+
+func TestTokenRefreshedBeforeExpiry(t *testing.T) {
+	log.Trace("clients/manager_test:TestTokenRefreshedBeforeExpiry() Entering")
+	defer log.Trace("clients/manager_test:TestTokenRefreshedBeforeExpiry() Leaving")
+	assert := assert.New(t)
+	services := newFakeServices()
+	services.tokenLifetime = tokenRefreshMargin / 2
+	m, cleanup := setupManager(t, services)
+	defer cleanup()
+
+	// a token about to expire is replaced before HVS has a chance to reject it
+	for i := 0; i < 3; i++ {
+		_, err := m.CreateSAMLReport("3e7f9b8a-9d63-4c51-8a0a-4a6f3a0a2b11")
+		assert.NoError(err)
+	}
+	assert.Equal(3, services.tokensIssued)
+	assert.Equal(3, services.reportRequests)
+
+	services.tokenLifetime = time.Hour
+	for i := 0; i < 3; i++ {
+		_, err := m.CreateSAMLReport("3e7f9b8a-9d63-4c51-8a0a-4a6f3a0a2b11")
+		assert.NoError(err)
+	}
+	assert.Equal(4, services.tokensIssued)
+}
+
+func TestTokenExpiry(t *testing.T) {
+	log.Trace("clients/manager_test:TestTokenExpiry() Entering")
+	defer log.Trace("clients/manager_test:TestTokenExpiry() Leaving")
+	assert := assert.New(t)
+
+	exp := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	expiry, err := tokenExpiry(jwt(exp) + "signature")
+	assert.NoError(err)
+	assert.True(exp.Equal(expiry))
+
+	_, err = tokenExpiry("not-a-jwt")
+	assert.Error(err)
+}
+
+func TestKBSTransferKey(t *testing.T) {
+	log.Trace("clients/manager_test:TestKBSTransferKey() Entering")
+	defer log.Trace("clients/manager_test:TestKBSTransferKey() Leaving")
+	assert := assert.New(t)
+	m, cleanup := setupManager(t, newFakeServices())
+	defer cleanup()
+
+	kbsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/kbs/v1/keys/cbd9dc2a-6a4c-4bb3-b0e0-5b4bb2a6a0ff/transfer" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal("application/samlassertion+xml", r.Header.Get("Content-Type"))
+		w.Write([]byte("key"))
+	}))
+	defer kbsServer.Close()
+
+	kc, err := m.KBSClient(kbsServer.URL+"/kbs/v1", "")
+	if !assert.NoError(err) {
+		return
+	}
+	key, err := kc.TransferKeyWithSaml("cbd9dc2a-6a4c-4bb3-b0e0-5b4bb2a6a0ff", "<saml2:Assertion/>")
+	assert.NoError(err)
+	assert.Equal([]byte("key"), key)
+
+	_, err = kc.TransferKeyWithSaml("e3a3b4a5-0c0c-4c8e-a1d5-7a1f8f1b6a10", "<saml2:Assertion/>")
+	status, ok := ResponseStatus(err)
+	assert.True(ok)
+	assert.Equal(http.StatusServiceUnavailable, status)
+}
+
+func TestKBSClientPerBaseURL(t *testing.T) {
+	log.Trace("clients/manager_test:TestKBSClientPerBaseURL() Entering")
+	defer log.Trace("clients/manager_test:TestKBSClientPerBaseURL() Leaving")
+	assert := assert.New(t)
+	m, cleanup := setupManager(t, newFakeServices())
+	defer cleanup()
+
+	var created sync.Map
+	var createdCount int
+	m.newKBSClient = func(baseURL *url.URL, caCerts []x509.Certificate) kbs.KBSClient {
+		createdCount++
+		kc := &fakeKBSClient{baseURL: baseURL.String()}
+		created.Store(kc.baseURL, kc)
+		return kc
+	}
+
+	baseURLs := []string{"https://kbs1.server.com:9443/v1/", "https://kbs2.server.com:9443/v1/"}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(baseURL string) {
+			defer wg.Done()
+			kc, err := m.KBSClient(baseURL, "")
+			if assert.NoError(err) {
+				expected, _ := created.Load(baseURL)
+				assert.Same(expected, kc)
+			}
+		}(baseURLs[i%2])
+	}
+	wg.Wait()
+	assert.Equal(2, createdCount)
+}
+
+func TestCACertsReloadedOnChange(t *testing.T) {
+	log.Trace("clients/manager_test:TestCACertsReloadedOnChange() Entering")
+	defer log.Trace("clients/manager_test:TestCACertsReloadedOnChange() Leaving")
+	assert := assert.New(t)
+	services := newFakeServices()
+	m, cleanup := setupManager(t, services)
+	defer cleanup()
+	m.certsCheckInterval = 0
+
+	createdCount := 0
+	m.newKBSClient = func(baseURL *url.URL, caCerts []x509.Certificate) kbs.KBSClient {
+		createdCount++
+		return &fakeKBSClient{baseURL: baseURL.String()}
+	}
+
+	first, err := m.KBSClient("https://kbs.server.com:9443/v1/", "")
+	assert.NoError(err)
+	second, err := m.KBSClient("https://kbs.server.com:9443/v1/", "")
+	assert.NoError(err)
+	assert.Same(first, second)
+	assert.Equal(1, createdCount)
+	_, err = m.CreateSAMLReport("3e7f9b8a-9d63-4c51-8a0a-4a6f3a0a2b11")
+	assert.NoError(err)
+
+	// a new CA certificate replaces the clients built with the previous certificates
+	writeCACert(t, m.trustedCaCertsDir, "intermediate-ca")
+	third, err := m.KBSClient("https://kbs.server.com:9443/v1/", "")
+	assert.NoError(err)
+	assert.NotSame(first, third)
+	assert.Equal(2, createdCount)
+	_, err = m.CreateSAMLReport("3e7f9b8a-9d63-4c51-8a0a-4a6f3a0a2b11")
+	assert.NoError(err)
+	assert.Equal(2, services.tokensIssued)
+}
+
+func TestKBSClientCABundle(t *testing.T) {
+	log.Trace("clients/manager_test:TestKBSClientCABundle() Entering")
+	defer log.Trace("clients/manager_test:TestKBSClientCABundle() Leaving")
+	assert := assert.New(t)
+	m, cleanup := setupManager(t, newFakeServices())
+	defer cleanup()
+	m.certsCheckInterval = 0
+
+	bundleDir, err := ioutil.TempDir("", "kbsca")
+	if err != nil {
+		t.Fatal("could not create CA bundle directory")
+	}
+	defer os.RemoveAll(bundleDir)
+	writeCACert(t, bundleDir, "kbs-ca")
+	writeCACert(t, bundleDir, "other-kbs-ca")
+
+	var kbsCACerts []x509.Certificate
+	m.newKBSClient = func(baseURL *url.URL, caCerts []x509.Certificate) kbs.KBSClient {
+		kbsCACerts = caCerts
+		return &fakeKBSClient{baseURL: baseURL.String()}
+	}
+
+	// the KBS with a CA bundle is only verified with the certificates of the bundle
+	bundled, err := m.KBSClient("https://kbs1.server.com:9443/v1/", filepath.Join(bundleDir, "kbs-ca.pem"))
+	assert.NoError(err)
+	if assert.Len(kbsCACerts, 1) {
+		assert.Equal("kbs-ca", kbsCACerts[0].Subject.CommonName)
+	}
+	again, err := m.KBSClient("https://kbs1.server.com:9443/v1/", filepath.Join(bundleDir, "kbs-ca.pem"))
+	assert.NoError(err)
+	assert.Same(bundled, again)
+
+	// the same KBS configured with another CA bundle has its own client
+	other, err := m.KBSClient("https://kbs1.server.com:9443/v1/", filepath.Join(bundleDir, "other-kbs-ca.pem"))
+	assert.NoError(err)
+	assert.NotSame(bundled, other)
+	if assert.Len(kbsCACerts, 1) {
+		assert.Equal("other-kbs-ca", kbsCACerts[0].Subject.CommonName)
+	}
+
+	// a rotated CA bundle replaces the client built with the previous bundle
+	time.Sleep(10 * time.Millisecond)
+	writeCACert(t, bundleDir, "kbs-ca")
+	rotated, err := m.KBSClient("https://kbs1.server.com:9443/v1/", filepath.Join(bundleDir, "kbs-ca.pem"))
+	assert.NoError(err)
+	assert.NotSame(bundled, rotated)
+
+	_, err = m.KBSClient("https://kbs2.server.com:9443/v1/", "")
+	assert.NoError(err)
+	assert.Equal(m.caCerts, kbsCACerts)
+
+	_, err = m.KBSClient("https://kbs3.server.com:9443/v1/", filepath.Join(bundleDir, "missing.pem"))
+	assert.Error(err)
+}
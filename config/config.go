@@ -25,6 +25,12 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// KBSEndpoint is a KBS trusted to release keys, along with the optional CA bundle its TLS certificate is verified with
+type KBSEndpoint struct {
+	URL      string `yaml:"url"`
+	CABundle string `yaml:"ca_bundle"`
+}
+
 // Configuration is the global configuration struct that is marshalled/unmarshaled to a persisted yaml file
 var Configuration struct {
 	Port             int
@@ -48,12 +54,42 @@ var Configuration struct {
 		User     string
 		Password string
 	}
-	TLSKeyFile        string
-	TLSCertFile       string
-	LogLevel          string
-	LogEnableStdout   bool
-	LogEntryMaxLength int
-	KeyCacheSeconds   int `yaml:"key_cache_seconds"`
+	TLSKeyFile           string
+	TLSCertFile          string
+	LogLevel             string
+	LogEnableStdout      bool
+	LogEntryMaxLength    int
+	KeyCacheSeconds      int    `yaml:"key_cache_seconds"`
+	KeyCacheMaxEntries   int    `yaml:"key_cache_max_entries"`
+	SamlCacheDisabled    bool   `yaml:"saml_cache_disabled"`
+	SamlCacheMaxEntries  int    `yaml:"saml_cache_max_entries"`
+	SamlClockSkewSeconds int    `yaml:"saml_clock_skew_seconds"`
+	SamlIssuer           string `yaml:"saml_issuer"`
+	SamlAudience         string `yaml:"saml_audience"`
+	ReportsMaxPageSize   int    `yaml:"reports_max_page_size"`
+	ReportsMaxBulkSize   int    `yaml:"reports_max_bulk_size"`
+	ReportsMaxBulkBytes  int    `yaml:"reports_max_bulk_bytes"`
+	ReportRetention      struct {
+		MaxAgeDays            int `yaml:"max_age_days"`
+		MaxReportsPerInstance int `yaml:"max_reports_per_instance"`
+		PurgeIntervalMinutes  int `yaml:"purge_interval_minutes"`
+		PurgeBatchSize        int `yaml:"purge_batch_size"`
+	} `yaml:"report_retention"`
+	KeyHostBinding struct {
+		Mode  string `yaml:"mode"`
+		Claim string `yaml:"claim"`
+	} `yaml:"key_host_binding"`
+	KBSEndpoints []KBSEndpoint `yaml:"kbs_endpoints"`
+	Events       struct {
+		Webhooks []struct {
+			URL          string `yaml:"url"`
+			Secret       string `yaml:"secret"`
+			ImageID      string `yaml:"image_id"`
+			HardwareUUID string `yaml:"hardware_uuid"`
+		} `yaml:"webhooks"`
+		WebhookMaxAttempts  int `yaml:"webhook_max_attempts"`
+		WebhookRetrySeconds int `yaml:"webhook_retry_seconds"`
+	} `yaml:"events"`
 	ReadTimeout       time.Duration
 	ReadHeaderTimeout time.Duration
 	WriteTimeout      time.Duration
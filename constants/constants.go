@@ -17,6 +17,7 @@ const (
 	SecurityLogFile           = LogDir + "wls-security.log"
 	TrustedJWTSigningCertsDir = ConfigDir + "certs/trustedjwt/"
 	TrustedCaCertsDir         = ConfigDir + "certs/trustedca/"
+	FlavorSigningCertsDir     = ConfigDir + "certs/flavorsign/"
 	DefaultTLSCertPath        = ConfigDir + "tls-cert.pem"
 	DefaultTLSKeyPath         = ConfigDir + "tls.key"
 	CertApproverGroupName     = "CertApprover"
@@ -27,6 +28,22 @@ const (
 	DefaultSSLCertFilePath    = ConfigDir + "wlsdbsslcert.pem"
 	ReportCreationGroupName   = "ReportsCreate"
 	DefaultKeyCacheSeconds    = 300
+	DefaultKeyCacheMaxEntries = 1000
+	DefaultSamlCacheEntries   = 1000
+	DefaultSamlClockSkewSecs  = 60
+	DefaultKeyHostClaim       = "sub"
+	UpstreamRetryAfterSecs    = 30
+	DefaultReportsMaxPageSize = 1000
+	DefaultReportsMaxBulkSize = 500
+	DefaultReportsBulkBytes   = 16 << 20
+	DefaultReportPurgeMinutes = 60
+	DefaultReportPurgeBatch   = 1000
+	DefaultEventReplaySize    = 1000
+	EventSubscriberBuffer     = 100
+	DefaultWebhookMaxAttempts = 5
+	DefaultWebhookRetrySecs   = 2
+	DefaultWebhookTimeout     = 10 * time.Second
+	EventStreamHeartbeat      = 15 * time.Second
 	JWTCertsCacheTime         = "1m"
 	HttpLogFile               = "/var/log/workload-service/http.log"
 	SamlCaCertFilePath        = TrustedCaCertsDir + "SamlCaCert.pem"
@@ -64,8 +81,32 @@ const (
 	WlsTLsCertCnEnv               = "WLS_TLS_CERT_CN"
 	WlsCertSANListEnv             = "SAN_LIST"
 	KeyCacheSecondsEnv            = "KEY_CACHE_SECONDS"
+	KeyCacheMaxEntriesEnv         = "KEY_CACHE_MAX_ENTRIES"
+	SamlCacheDisabledEnv          = "SAML_CACHE_DISABLED"
+	SamlCacheMaxEntriesEnv        = "SAML_CACHE_MAX_ENTRIES"
+	SamlClockSkewSecondsEnv       = "SAML_CLOCK_SKEW_SECONDS"
+	SamlIssuerEnv                 = "SAML_ISSUER"
+	SamlAudienceEnv               = "SAML_AUDIENCE"
+	KeyHostBindingEnv             = "KEY_HOST_BINDING"
+	KeyHostBindingClaimEnv        = "KEY_HOST_BINDING_CLAIM"
+	ReportsMaxPageSizeEnv         = "REPORTS_MAX_PAGE_SIZE"
+	ReportsMaxBulkSizeEnv         = "REPORTS_MAX_BULK_SIZE"
+	ReportsMaxBulkBytesEnv        = "REPORTS_MAX_BULK_BYTES"
+	ReportMaxAgeDaysEnv           = "REPORT_MAX_AGE_DAYS"
+	ReportsPerInstanceEnv         = "REPORT_MAX_PER_INSTANCE"
+	ReportPurgeIntervalEnv        = "REPORT_PURGE_INTERVAL_MINUTES"
+	ReportPurgeBatchSizeEnv       = "REPORT_PURGE_BATCH_SIZE"
 	CmsTlsCertDigestEnv           = "CMS_TLS_CERT_SHA384"
 	LogEntryMaxlengthEnv          = "LOG_ENTRY_MAXLENGTH"
+	FlavorSigningCertPathEnv      = "FLAVOR_SIGNING_CERT_PATH"
+	KbsEndpointsEnv               = "KBS_ENDPOINTS"
+)
+
+// Modes binding the hardware UUID of key requests to the identity of the caller
+const (
+	KeyHostBindingNone = "none"
+	KeyHostBindingJWT  = "jwt"
+	KeyHostBindingCert = "cert"
 )
 
 //Resource endpoints
@@ -91,6 +132,12 @@ const (
 	ImagesCreate   = "images:create"
 	ImagesDelete   = "images:delete"
 
+	KeyReleasePoliciesRetrieve = "key_release_policies:retrieve"
+	KeyReleasePoliciesStore    = "key_release_policies:store"
+	KeyReleasePoliciesDelete   = "key_release_policies:delete"
+
+	KeyReleasesSearch = "key_releases:search"
+
 	ReportsSearch = "reports:search"
 	ReportsCreate = "reports:create"
 	ReportsDelete = "reports:delete"
@@ -11,10 +11,11 @@ WORKLOAD_SERVICE_CONFIGURATION=/etc/workload-service
 WORKLOAD_SERVICE_LOGS=/var/log/workload-service
 WORKLOAD_SERVICE_TRUSTEDCA_DIR=${WORKLOAD_SERVICE_CONFIGURATION}/certs/trustedca
 WORKLOAD_SERVICE_JWT_DIR=${WORKLOAD_SERVICE_CONFIGURATION}/certs/trustedjwt
+WORKLOAD_SERVICE_FLAVOR_SIGNING_DIR=${WORKLOAD_SERVICE_CONFIGURATION}/certs/flavorsign
 
 # Create application directories (chown will be repeated near end of this script, after setup)
 if [ ! -f $WORKLOAD_SERVICE_CONFIGURATION/.setup_done ]; then
-  for directory in $WORKLOAD_SERVICE_CONFIGURATION $WORKLOAD_SERVICE_LOGS $WORKLOAD_SERVICE_TRUSTEDCA_DIR $WORKLOAD_SERVICE_JWT_DIR; do
+  for directory in $WORKLOAD_SERVICE_CONFIGURATION $WORKLOAD_SERVICE_LOGS $WORKLOAD_SERVICE_TRUSTEDCA_DIR $WORKLOAD_SERVICE_JWT_DIR $WORKLOAD_SERVICE_FLAVOR_SIGNING_DIR; do
     mkdir -p $directory
     if [ $? -ne 0 ]; then
       echo "Cannot create directory: $directory"
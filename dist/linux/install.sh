@@ -129,6 +129,9 @@ chown wls:wls /etc/workload-service/certs/trustedca
 mkdir -p /etc/workload-service/certs/trustedjwt
 chown wls:wls /etc/workload-service/certs/trustedjwt
 
+mkdir -p /etc/workload-service/certs/flavorsign
+chown wls:wls /etc/workload-service/certs/flavorsign
+
 # Create PID file directory in /var/run
 mkdir -p /var/run/workload-service
 chown wls:wls /var/run/workload-service
@@ -0,0 +1,196 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package events
+
+import (
+	commLog "intel/isecl/lib/common/v4/log"
+	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/model"
+	"sync"
+	"time"
+)
+
+var log = commLog.GetDefaultLogger()
+
+// TrustChangedEvent is the type of the events published when the trust status of an instance changes
+const TrustChangedEvent = "trust_changed"
+
+// TrustChange is published when a report of an instance is trusted and the previous report of the instance was not,
+// or the other way around. ID orders the events published by a broker.
+type TrustChange struct {
+	ID              uint64    `json:"id"`
+	Type            string    `json:"type"`
+	ReportID        string    `json:"report_id"`
+	InstanceID      string    `json:"instance_id"`
+	HardwareUUID    string    `json:"hardware_uuid"`
+	ImageID         string    `json:"image_id"`
+	PolicyName      string    `json:"policy_name"`
+	PreviousTrusted bool      `json:"previous_trusted"`
+	Trusted         bool      `json:"trusted"`
+	ReportedAt      time.Time `json:"reported_at"`
+}
+
+// DetectTrustChange compares a new report of an instance with the previous one, if any. It returns the change of
+// trust status between the two, and false if the trust status did not change or the instance had no report.
+func DetectTrustChange(previous *model.Report, report *model.Report) (TrustChange, bool) {
+	if previous == nil || previous.Trusted == report.Trusted {
+		return TrustChange{}, false
+	}
+	info := report.Manifest.InstanceInfo
+	return TrustChange{
+		Type:            TrustChangedEvent,
+		ReportID:        report.ID,
+		InstanceID:      info.InstanceID,
+		HardwareUUID:    info.HostHardwareUUID,
+		ImageID:         info.ImageID,
+		PolicyName:      report.PolicyName,
+		PreviousTrusted: previous.Trusted,
+		Trusted:         report.Trusted,
+	}, true
+}
+
+// Filter selects the events of an image, of a host, or both. Empty fields match any value.
+type Filter struct {
+	ImageID      string
+	HardwareUUID string
+}
+
+// Matches tells whether the event is selected by the filter
+func (f Filter) Matches(e TrustChange) bool {
+	return (f.ImageID == "" || f.ImageID == e.ImageID) && (f.HardwareUUID == "" || f.HardwareUUID == e.HardwareUUID)
+}
+
+// Subscription receives the events matching its filter on C. C is closed when the subscription is closed, or when
+// the subscriber falls too far behind the publisher, in which case the subscriber should subscribe again from the
+// last event it received. Dropped is the number of events published after the last event received that the broker
+// no longer kept when the subscription resumed, and could not be replayed.
+type Subscription struct {
+	C       <-chan TrustChange
+	Dropped uint64
+	c       chan TrustChange
+	filter  Filter
+	broker  *Broker
+}
+
+// Close stops the delivery of events to the subscription
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// Broker publishes events to its subscriptions. It keeps the latest events, so that subscribers can resume from
+// the last event they received.
+type Broker struct {
+	mtx           *sync.Mutex
+	subscriptions map[*Subscription]struct{}
+	recent        []TrustChange
+	maxRecent     int
+	lastID        uint64
+}
+
+// NewBroker creates a broker keeping the latest maxRecent events for subscribers resuming a subscription
+func NewBroker(maxRecent int) *Broker {
+	log.Trace("events/events:NewBroker() Entering")
+	defer log.Trace("events/events:NewBroker() Leaving")
+	return &Broker{
+		mtx:           &sync.Mutex{},
+		subscriptions: make(map[*Subscription]struct{}),
+		maxRecent:     maxRecent,
+	}
+}
+
+// Publish numbers the event and delivers it to the subscriptions whose filter it matches. It never blocks: a
+// subscription whose buffer is full is closed.
+func (b *Broker) Publish(e TrustChange) TrustChange {
+	log.Trace("events/events:Publish() Entering")
+	defer log.Trace("events/events:Publish() Leaving")
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.lastID++
+	e.ID = b.lastID
+	if e.ReportedAt.IsZero() {
+		e.ReportedAt = time.Now().UTC()
+	}
+	if b.maxRecent > 0 {
+		if len(b.recent) == b.maxRecent {
+			b.recent = append(b.recent[:0], b.recent[1:]...)
+		}
+		b.recent = append(b.recent, e)
+	}
+	for s := range b.subscriptions {
+		if !s.filter.Matches(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			log.Warnf("events/events:Publish() Subscriber too slow, closing its subscription after event %d", e.ID-1)
+			delete(b.subscriptions, s)
+			close(s.c)
+		}
+	}
+	return e
+}
+
+// Subscribe subscribes to the events matching the filter. The events published after the event with ID lastID that
+// the broker still keeps are delivered first, lastID 0 only subscribes to new events.
+func (b *Broker) Subscribe(f Filter, lastID uint64) *Subscription {
+	log.Trace("events/events:Subscribe() Entering")
+	defer log.Trace("events/events:Subscribe() Leaving")
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	var missed []TrustChange
+	if lastID > 0 {
+		for _, e := range b.recent {
+			if e.ID > lastID && f.Matches(e) {
+				missed = append(missed, e)
+			}
+		}
+	}
+	var dropped uint64
+	if lastID > 0 && lastID < b.lastID {
+		oldest := b.lastID + 1
+		if len(b.recent) > 0 {
+			oldest = b.recent[0].ID
+		}
+		if oldest > lastID+1 {
+			dropped = oldest - lastID - 1
+		}
+	}
+	c := make(chan TrustChange, len(missed)+constants.EventSubscriberBuffer)
+	for _, e := range missed {
+		c <- e
+	}
+	s := &Subscription{C: c, Dropped: dropped, c: c, filter: f, broker: b}
+	b.subscriptions[s] = struct{}{}
+	return s
+}
+
+// last returns the ID of the last event published
+func (b *Broker) last() uint64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.lastID
+}
+
+func (b *Broker) unsubscribe(s *Subscription) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if _, ok := b.subscriptions[s]; ok {
+		delete(b.subscriptions, s)
+		close(s.c)
+	}
+}
+
+var global = NewBroker(constants.DefaultEventReplaySize)
+
+// Publish publishes the event with the default global broker
+func Publish(e TrustChange) TrustChange {
+	return global.Publish(e)
+}
+
+// Subscribe subscribes to the events of the default global broker
+func Subscribe(f Filter, lastID uint64) *Subscription {
+	return global.Subscribe(f, lastID)
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package events
+
+import (
+	"intel/isecl/lib/common/v4/pkg/instance"
+	"intel/isecl/lib/verifier/v4"
+	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func report(trusted bool) *model.Report {
+	return &model.Report{
+		ID: "f52023eb-7991-47ba-91fc-c43bd9d80c29",
+		InstanceTrustReport: verifier.InstanceTrustReport{
+			Manifest: instance.Manifest{
+				InstanceInfo: instance.Info{
+					InstanceID:       "7b280921-83f7-4f44-9f8d-2dcf36e7af33",
+					HostHardwareUUID: "59eed8f0-28c5-4070-91fc-f5e2e5443f6b",
+					ImageID:          "670f263e-b34e-4e07-a520-40ac9a89f62d",
+				},
+			},
+			PolicyName: "Intel VM Policy",
+			Trusted:    trusted,
+		},
+	}
+}
+
+func TestDetectTrustChange(t *testing.T) {
+	log.Trace("events/events_test:TestDetectTrustChange() Entering")
+	defer log.Trace("events/events_test:TestDetectTrustChange() Leaving")
+	assert := assert.New(t)
+
+	// the first report of an instance is not a change
+	_, changed := DetectTrustChange(nil, report(false))
+	assert.False(changed)
+	_, changed = DetectTrustChange(report(true), report(true))
+	assert.False(changed)
+	_, changed = DetectTrustChange(report(false), report(false))
+	assert.False(changed)
+
+	e, changed := DetectTrustChange(report(true), report(false))
+	assert.True(changed)
+	assert.Equal(TrustChange{
+		Type:            TrustChangedEvent,
+		ReportID:        "f52023eb-7991-47ba-91fc-c43bd9d80c29",
+		InstanceID:      "7b280921-83f7-4f44-9f8d-2dcf36e7af33",
+		HardwareUUID:    "59eed8f0-28c5-4070-91fc-f5e2e5443f6b",
+		ImageID:         "670f263e-b34e-4e07-a520-40ac9a89f62d",
+		PolicyName:      "Intel VM Policy",
+		PreviousTrusted: true,
+		Trusted:         false,
+	}, e)
+
+	e, changed = DetectTrustChange(report(false), report(true))
+	assert.True(changed)
+	assert.False(e.PreviousTrusted)
+	assert.True(e.Trusted)
+}
+
+func TestBrokerFiltersEvents(t *testing.T) {
+	log.Trace("events/events_test:TestBrokerFiltersEvents() Entering")
+	defer log.Trace("events/events_test:TestBrokerFiltersEvents() Leaving")
+	assert := assert.New(t)
+	b := NewBroker(10)
+
+	all := b.Subscribe(Filter{}, 0)
+	defer all.Close()
+	host := b.Subscribe(Filter{HardwareUUID: "host-1"}, 0)
+	defer host.Close()
+	image := b.Subscribe(Filter{ImageID: "image-2", HardwareUUID: "host-1"}, 0)
+	defer image.Close()
+
+	first := b.Publish(TrustChange{HardwareUUID: "host-1", ImageID: "image-1"})
+	second := b.Publish(TrustChange{HardwareUUID: "host-2", ImageID: "image-2"})
+	third := b.Publish(TrustChange{HardwareUUID: "host-1", ImageID: "image-2"})
+	assert.Equal([]uint64{1, 2, 3}, []uint64{first.ID, second.ID, third.ID})
+	assert.False(first.ReportedAt.IsZero())
+
+	assert.Equal(first, <-all.C)
+	assert.Equal(second, <-all.C)
+	assert.Equal(third, <-all.C)
+	assert.Equal(first, <-host.C)
+	assert.Equal(third, <-host.C)
+	assert.Equal(third, <-image.C)
+	assert.Empty(all.C)
+	assert.Empty(host.C)
+	assert.Empty(image.C)
+
+	image.Close()
+	_, open := <-image.C
+	assert.False(open)
+}
+
+func TestBrokerResumesSubscriptions(t *testing.T) {
+	log.Trace("events/events_test:TestBrokerResumesSubscriptions() Entering")
+	defer log.Trace("events/events_test:TestBrokerResumesSubscriptions() Leaving")
+	assert := assert.New(t)
+	b := NewBroker(3)
+	for i := 0; i < 5; i++ {
+		b.Publish(TrustChange{HardwareUUID: "host-1"})
+	}
+
+	// only the latest events are kept, the subscriber is told how many it missed
+	s := b.Subscribe(Filter{}, 1)
+	defer s.Close()
+	var ids []uint64
+	for len(s.C) > 0 {
+		ids = append(ids, (<-s.C).ID)
+	}
+	assert.Equal([]uint64{3, 4, 5}, ids)
+	assert.Equal(uint64(1), s.Dropped)
+
+	resumed := b.Subscribe(Filter{HardwareUUID: "host-1"}, 4)
+	defer resumed.Close()
+	assert.Equal(uint64(5), (<-resumed.C).ID)
+	assert.Zero(resumed.Dropped)
+
+	// events filtered out are not dropped
+	filtered := b.Subscribe(Filter{HardwareUUID: "host-2"}, 2)
+	defer filtered.Close()
+	assert.Empty(filtered.C)
+	assert.Zero(filtered.Dropped)
+
+	// without a last event, only new events are received
+	current := b.Subscribe(Filter{}, 0)
+	defer current.Close()
+	assert.Empty(current.C)
+}
+
+func TestBrokerClosesSlowSubscriptions(t *testing.T) {
+	log.Trace("events/events_test:TestBrokerClosesSlowSubscriptions() Entering")
+	defer log.Trace("events/events_test:TestBrokerClosesSlowSubscriptions() Leaving")
+	assert := assert.New(t)
+	b := NewBroker(constants.EventSubscriberBuffer * 2)
+	slow := b.Subscribe(Filter{}, 0)
+
+	// publishing never blocks on a subscriber
+	for i := 0; i <= constants.EventSubscriberBuffer; i++ {
+		b.Publish(TrustChange{})
+	}
+	var last uint64
+	for e := range slow.C {
+		last = e.ID
+	}
+	assert.Equal(uint64(constants.EventSubscriberBuffer), last)
+	slow.Close()
+
+	// the subscriber resumes from the last event it received
+	resumed := b.Subscribe(Filter{}, last)
+	defer resumed.Close()
+	assert.Equal(uint64(constants.EventSubscriberBuffer+1), (<-resumed.C).ID)
+	assert.Zero(resumed.Dropped)
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"intel/isecl/lib/common/v4/log/message"
+	cos "intel/isecl/lib/common/v4/os"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/constants"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Webhook headers, the signature is the hex encoded HMAC-SHA256 of the body keyed with the secret of the webhook
+const (
+	EventTypeHeader = "X-WLS-Event"
+	EventIDHeader   = "X-WLS-Event-ID"
+	SignatureHeader = "X-WLS-Signature"
+)
+
+// Webhook posts the events matching its filter to a URL, retrying failed deliveries with an exponential backoff
+type Webhook struct {
+	URL           string
+	Secret        string
+	Filter        Filter
+	MaxAttempts   int
+	RetryInterval time.Duration
+	Client        *http.Client
+}
+
+// trustedCaCertsDir holds the CA certificates the TLS certificates of the webhooks are verified with, in addition
+// to the system ones
+var trustedCaCertsDir = constants.TrustedCaCertsDir
+
+// webhookRootCAs returns the system CA certificates along with the trusted CA certificates of WLS
+func webhookRootCAs() (*x509.CertPool, error) {
+	// Get the SystemCertPool, continue with an empty pool on error
+	rootCAs, _ := x509.SystemCertPool()
+	if rootCAs == nil {
+		rootCAs = x509.NewCertPool()
+	}
+	rootCaCertPems, err := cos.GetDirFileContents(trustedCaCertsDir, "*.pem")
+	if err != nil {
+		return nil, errors.Wrap(err, "events/webhook:webhookRootCAs() Could not read trusted CA certificates")
+	}
+	for _, rootCACert := range rootCaCertPems {
+		if ok := rootCAs.AppendCertsFromPEM(rootCACert); !ok {
+			return nil, errors.New("events/webhook:webhookRootCAs() Could not append trusted CA certificate")
+		}
+	}
+	return rootCAs, nil
+}
+
+// ConfiguredWebhooks returns the webhooks of the configuration, with defaults for the values that are not set.
+// Webhooks with an invalid URL or without a secret are ignored.
+func ConfiguredWebhooks() []Webhook {
+	log.Trace("events/webhook:ConfiguredWebhooks() Entering")
+	defer log.Trace("events/webhook:ConfiguredWebhooks() Leaving")
+
+	c := config.Configuration.Events
+	maxAttempts := c.WebhookMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = constants.DefaultWebhookMaxAttempts
+	}
+	retryInterval := time.Duration(c.WebhookRetrySeconds) * time.Second
+	if retryInterval <= 0 {
+		retryInterval = constants.DefaultWebhookRetrySecs * time.Second
+	}
+	if len(c.Webhooks) == 0 {
+		return nil
+	}
+	rootCAs, err := webhookRootCAs()
+	if err != nil {
+		// the webhooks can't be verified with the CA certificates they are expected to be issued by
+		log.WithError(err).Errorf("events/webhook:ConfiguredWebhooks() %s : Failed to load the trusted CA certificates, the webhooks are ignored", message.AppRuntimeErr)
+		log.Tracef("%+v", err)
+		return nil
+	}
+	client := &http.Client{
+		Timeout: constants.DefaultWebhookTimeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				RootCAs:    rootCAs,
+			},
+		},
+	}
+
+	var webhooks []Webhook
+	for _, w := range c.Webhooks {
+		if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			log.Errorf("events/webhook:ConfiguredWebhooks() %s : Invalid webhook URL %q, the webhook is ignored", message.InvalidInputBadParam, w.URL)
+			continue
+		}
+		// the signature of the events is what lets the receiver trust them, it can be forged without a secret
+		if w.Secret == "" {
+			log.Errorf("events/webhook:ConfiguredWebhooks() %s : Webhook %q has no secret, the webhook is ignored", message.InvalidInputBadParam, w.URL)
+			continue
+		}
+		webhooks = append(webhooks, Webhook{
+			URL:           w.URL,
+			Secret:        w.Secret,
+			Filter:        Filter{ImageID: w.ImageID, HardwareUUID: w.HardwareUUID},
+			MaxAttempts:   maxAttempts,
+			RetryInterval: retryInterval,
+			Client:        client,
+		})
+	}
+	return webhooks
+}
+
+// Sign returns the signature of a webhook body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver posts the event to the webhook, until the webhook accepts it or MaxAttempts attempts failed. Transport
+// errors, 429 and 5xx responses are retried, other responses are final. stop aborts the retries.
+func (w Webhook) Deliver(e TrustChange, stop <-chan struct{}) error {
+	log.Trace("events/webhook:Deliver() Entering")
+	defer log.Trace("events/webhook:Deliver() Leaving")
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "events/webhook:Deliver() Failed to marshal event")
+	}
+	signature := Sign(w.Secret, body)
+
+	retryInterval := w.RetryInterval
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(e, body, signature)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.MaxAttempts {
+			return errors.Wrapf(err, "events/webhook:Deliver() Failed to deliver event %d after %d attempts", e.ID, attempt)
+		}
+		log.WithError(err).Warnf("events/webhook:Deliver() Failed to deliver event %d, retrying in %s", e.ID, retryInterval)
+		select {
+		case <-stop:
+			return errors.Wrapf(err, "events/webhook:Deliver() Stopped delivering event %d", e.ID)
+		case <-time.After(retryInterval):
+		}
+		retryInterval *= 2
+	}
+}
+
+// post posts the event once, and tells whether the delivery should be retried when it fails
+func (w Webhook) post(e TrustChange, body []byte, signature string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "events/webhook:post() Failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, e.Type)
+	req.Header.Set(EventIDHeader, strconv.FormatUint(e.ID, 10))
+	req.Header.Set(SignatureHeader, signature)
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, errors.Wrap(err, "events/webhook:post() Failed to post event")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, errors.Errorf("events/webhook:post() Webhook responded with status %d", resp.StatusCode)
+}
+
+// Run delivers the events published with the default global broker to the webhook one at a time, until stop is
+// closed. When the webhook falls too far behind, it resumes from the last event delivered, and logs the events
+// that the broker no longer kept.
+func (w Webhook) Run(stop <-chan struct{}) {
+	log.Trace("events/webhook:Run() Entering")
+	defer log.Trace("events/webhook:Run() Leaving")
+	w.run(global, stop)
+}
+
+func (w Webhook) run(b *Broker, stop <-chan struct{}) {
+	lastID := b.last()
+	for {
+		s := b.Subscribe(w.Filter, lastID)
+		if s.Dropped > 0 {
+			log.Warnf("events/webhook:Run() Webhook %s fell too far behind, events %d to %d were dropped without being delivered", w.URL, lastID+1, lastID+s.Dropped)
+		}
+		for subscribed := true; subscribed; {
+			select {
+			case <-stop:
+				s.Close()
+				return
+			case e, ok := <-s.C:
+				if !ok {
+					subscribed = false
+					break
+				}
+				if err := w.Deliver(e, stop); err != nil {
+					log.WithError(err).Errorf("events/webhook:Run() Failed to deliver event to webhook %s", w.URL)
+					log.Tracef("%+v", err)
+				}
+				lastID = e.ID
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package events
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/constants"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// webhookReceiver is a webhook endpoint that responds with the given status codes, then with 200
+type webhookReceiver struct {
+	mtx      sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newWebhookReceiver(statuses ...int) (*webhookReceiver, *httptest.Server) {
+	wr := &webhookReceiver{statuses: statuses, received: make(chan struct{}, 100)}
+	return wr, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		wr.mtx.Lock()
+		status := http.StatusOK
+		if len(wr.requests) < len(wr.statuses) {
+			status = wr.statuses[len(wr.requests)]
+		}
+		wr.requests = append(wr.requests, r)
+		wr.bodies = append(wr.bodies, body)
+		wr.mtx.Unlock()
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			wr.received <- struct{}{}
+		}
+	}))
+}
+
+func (wr *webhookReceiver) attempts() int {
+	wr.mtx.Lock()
+	defer wr.mtx.Unlock()
+	return len(wr.requests)
+}
+
+func TestWebhookRetriesUntilDelivered(t *testing.T) {
+	log.Trace("events/webhook_test:TestWebhookRetriesUntilDelivered() Entering")
+	defer log.Trace("events/webhook_test:TestWebhookRetriesUntilDelivered() Leaving")
+	assert := assert.New(t)
+	receiver, server := newWebhookReceiver(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer server.Close()
+	webhook := Webhook{URL: server.URL, Secret: "s3cr3t", MaxAttempts: 3, RetryInterval: time.Millisecond}
+
+	e := TrustChange{ID: 7, Type: TrustChangedEvent, InstanceID: "7b280921-83f7-4f44-9f8d-2dcf36e7af33", Trusted: true}
+	assert.NoError(webhook.Deliver(e, nil))
+	if assert.Equal(3, receiver.attempts()) {
+		for i, req := range receiver.requests {
+			assert.Equal("application/json", req.Header.Get("Content-Type"))
+			assert.Equal(TrustChangedEvent, req.Header.Get(EventTypeHeader))
+			assert.Equal("7", req.Header.Get(EventIDHeader))
+			assert.Equal(Sign("s3cr3t", receiver.bodies[i]), req.Header.Get(SignatureHeader))
+		}
+		var delivered TrustChange
+		assert.NoError(json.Unmarshal(receiver.bodies[2], &delivered))
+		assert.Equal(e, delivered)
+	}
+	assert.NotEqual(Sign("s3cr3t", receiver.bodies[0]), Sign("other", receiver.bodies[0]))
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	log.Trace("events/webhook_test:TestWebhookGivesUp() Entering")
+	defer log.Trace("events/webhook_test:TestWebhookGivesUp() Leaving")
+	assert := assert.New(t)
+
+	receiver, server := newWebhookReceiver(http.StatusInternalServerError, http.StatusBadGateway, http.StatusInternalServerError)
+	defer server.Close()
+	webhook := Webhook{URL: server.URL, Secret: "s3cr3t", MaxAttempts: 3, RetryInterval: time.Millisecond}
+	assert.Error(webhook.Deliver(TrustChange{ID: 1}, nil))
+	assert.Equal(3, receiver.attempts())
+
+	// client errors are not retried
+	receiver, server = newWebhookReceiver(http.StatusUnauthorized)
+	defer server.Close()
+	webhook.URL = server.URL
+	assert.Error(webhook.Deliver(TrustChange{ID: 1}, nil))
+	assert.Equal(1, receiver.attempts())
+
+	// unreachable webhooks are retried
+	server.Close()
+	stop := make(chan struct{})
+	close(stop)
+	webhook.RetryInterval = time.Hour
+	assert.Error(webhook.Deliver(TrustChange{ID: 1}, stop))
+}
+
+func TestWebhookRunDeliversMatchingEvents(t *testing.T) {
+	log.Trace("events/webhook_test:TestWebhookRunDeliversMatchingEvents() Entering")
+	defer log.Trace("events/webhook_test:TestWebhookRunDeliversMatchingEvents() Leaving")
+	assert := assert.New(t)
+	receiver, server := newWebhookReceiver(http.StatusServiceUnavailable)
+	defer server.Close()
+	b := NewBroker(10)
+	webhook := Webhook{URL: server.URL, Filter: Filter{ImageID: "image-1"}, MaxAttempts: 2, RetryInterval: time.Millisecond}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		webhook.run(b, stop)
+		close(done)
+	}()
+	// wait for the webhook to subscribe
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		b.mtx.Lock()
+		subscribed := len(b.subscriptions) > 0
+		b.mtx.Unlock()
+		if subscribed || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	b.Publish(TrustChange{ImageID: "image-2"})
+	b.Publish(TrustChange{ImageID: "image-1"})
+	select {
+	case <-receiver.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered to the webhook")
+	}
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail("webhook did not stop")
+	}
+
+	if assert.Equal(2, receiver.attempts()) {
+		var delivered TrustChange
+		assert.NoError(json.Unmarshal(receiver.bodies[1], &delivered))
+		assert.Equal(uint64(2), delivered.ID)
+	}
+}
+
+func TestConfiguredWebhooks(t *testing.T) {
+	log.Trace("events/webhook_test:TestConfiguredWebhooks() Entering")
+	defer log.Trace("events/webhook_test:TestConfiguredWebhooks() Leaving")
+	assert := assert.New(t)
+	previous := config.Configuration.Events
+	defer func() { config.Configuration.Events = previous }()
+
+	// the webhook is issued its certificate by a CA of the trusted CA directory
+	wr, server := newWebhookReceiver()
+	server.Close()
+	server = httptest.NewTLSServer(server.Config.Handler)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "trustedca")
+	if err != nil {
+		t.Fatal("could not create trusted CA directory")
+	}
+	defer os.RemoveAll(dir)
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(filepath.Join(dir, "webhook-ca.pem"), caPem, 0600); err != nil {
+		t.Fatal("could not write CA certificate")
+	}
+	previousDir := trustedCaCertsDir
+	trustedCaCertsDir = dir + "/"
+	defer func() { trustedCaCertsDir = previousDir }()
+
+	err = yaml.Unmarshal([]byte(`
+webhooks:
+- url: `+server.URL+`
+  secret: s3cr3t
+  image_id: 670f263e-b34e-4e07-a520-40ac9a89f62d
+- url: ftp://siem.example.com/wls
+  secret: s3cr3t
+- url: https://unsigned.example.com/wls
+webhook_retry_seconds: 5
+`), &config.Configuration.Events)
+	assert.NoError(err)
+	webhooks := ConfiguredWebhooks()
+	if assert.Len(webhooks, 1) {
+		assert.Equal(server.URL, webhooks[0].URL)
+		assert.Equal("s3cr3t", webhooks[0].Secret)
+		assert.Equal(Filter{ImageID: "670f263e-b34e-4e07-a520-40ac9a89f62d"}, webhooks[0].Filter)
+		assert.Equal(constants.DefaultWebhookMaxAttempts, webhooks[0].MaxAttempts)
+		assert.Equal(5*time.Second, webhooks[0].RetryInterval)
+		if assert.NotNil(webhooks[0].Client) {
+			assert.NoError(webhooks[0].Deliver(TrustChange{ID: 1, Type: "trust_changed"}, nil))
+			assert.Equal(1, wr.attempts())
+		}
+	}
+
+	// without the trusted CA certificates, no webhook can be verified
+	trustedCaCertsDir = filepath.Join(dir, "missing") + "/"
+	assert.Empty(ConfiguredWebhooks())
+}
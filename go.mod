@@ -6,6 +6,7 @@ require (
 	github.com/gorilla/mux v1.7.3
 	github.com/intel-secl/intel-secl/v4 v4.2.0-Beta
 	github.com/jinzhu/gorm v1.9.16
+	github.com/lib/pq v1.1.1
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.7.0
 	github.com/stretchr/testify v1.6.1
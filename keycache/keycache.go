@@ -5,8 +5,11 @@
 package keycache
 
 import (
+	"github.com/google/uuid"
 	commLog "intel/isecl/lib/common/v4/log"
-	"sync"
+	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/ttlcache"
+	"strings"
 	"time"
 )
 
@@ -20,63 +23,144 @@ type Key struct {
 	Expired time.Time
 }
 
-// Cache is a mutex protected cache for quick storage and retrieval of keys by ImageUUID
-// This implements an in-memory store only, and any data is effectively lost on application exit
+// Stats holds the counters of a key cache
+type Stats = ttlcache.Stats
+
+// cacheKey identifies a cached key. A host can hold several keys, one for each encrypted image it launches.
+type cacheKey struct {
+	hardwareUUID string
+	keyID        string
+}
+
+// newCacheKey normalizes the hardware UUID and key ID, so that a key is found whatever the case they are spelled in
+func newCacheKey(hardwareUUID string, keyID string) cacheKey {
+	if hwid, err := uuid.Parse(hardwareUUID); err == nil {
+		hardwareUUID = hwid.String()
+	}
+	return cacheKey{hardwareUUID: strings.ToLower(hardwareUUID), keyID: strings.ToLower(keyID)}
+}
+
+// Cache is a mutex protected cache for quick storage and retrieval of keys by host hardware UUID and key ID
+// This implements an in-memory store only, and any data is effectively lost on application exit.
+// Keys expire after the TTL of the cache and are removed by a background sweeper. When the cache
+// holds its maximum number of entries, the least recently used key is evicted to make room.
+// Key bytes are zeroed when a key leaves the cache.
 type Cache struct {
-	keys map[string]Key
-	mtx  *sync.Mutex
+	ttl     time.Duration
+	entries *ttlcache.Cache
 }
 
-// NewCache creates a new instance of a key cache
+// NewCache creates a new instance of a key cache whose keys expire after ttl, holding at most maxEntries keys.
+// A ttl <= 0 uses the default key cache duration, and a maxEntries <= 0 does not bound the cache.
 // It returns a pointer to the Cache struct
-func NewCache() *Cache {
+func NewCache(ttl time.Duration, maxEntries int) *Cache {
 	log.Trace("keycache/keycache:NewCache() Entering")
 	defer log.Trace("keycache/keycache:NewCache() Leaving")
+	if ttl <= 0 {
+		ttl = time.Second * time.Duration(constants.DefaultKeyCacheSeconds)
+	}
 	return &Cache{
-		keys: make(map[string]Key),
-		mtx:  &sync.Mutex{},
+		ttl: ttl,
+		entries: ttlcache.New(ttlcache.Options{
+			MaxEntries:    maxEntries,
+			SweepInterval: ttl,
+			Copy: func(value interface{}) interface{} {
+				return copyKey(value.(Key))
+			},
+			Release: func(value interface{}) {
+				zero(value.(Key).Bytes)
+			},
+		}),
+	}
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func copyKey(key Key) Key {
+	if key.Bytes != nil {
+		key.Bytes = append([]byte(nil), key.Bytes...)
 	}
+	return key
 }
 
-// Get retrieves a key by its keyID
-// It returns a byte slice containing the key data, as well as a bool that indicates
-// if the key exists in the cache
-func (c *Cache) Get(imageUUID string) (key Key, exists bool) {
+// Get retrieves the key with keyID cached for the host with hardwareUUID
+// It returns a copy of the key data, as well as a bool that indicates
+// if the key exists in the cache and has not expired
+func (c *Cache) Get(hardwareUUID string, keyID string) (key Key, exists bool) {
 	log.Trace("keycache/keycache:Get() Entering")
 	defer log.Trace("keycache/keycache:Get() Leaving")
-	c.mtx.Lock()
-	defer c.mtx.Unlock()
-	key, exists = c.keys[imageUUID]
-	return
+	value, exists := c.entries.Get(newCacheKey(hardwareUUID, keyID))
+	if !exists {
+		return Key{}, false
+	}
+	return value.(Key), true
 }
 
-// Store persists a key in the cache by its keyID
-func (c *Cache) Store(imageID string, key Key) {
+// Store persists a copy of the key in the cache for the host with hardwareUUID, by the ID of the key
+// If the key has no creation or expiry time, they are set from the current time and the TTL of the cache
+func (c *Cache) Store(hardwareUUID string, key Key) {
 	log.Trace("keycache/keycache:Store() Entering")
 	defer log.Trace("keycache/keycache:Store() Leaving")
-	c.mtx.Lock()
-	defer c.mtx.Unlock()
-	c.keys[imageID] = key
+	key = copyKey(key)
+	if key.Created.IsZero() {
+		key.Created = time.Now()
+	}
+	if key.Expired.IsZero() {
+		key.Expired = key.Created.Add(c.ttl)
+	}
+	c.entries.Store(newCacheKey(hardwareUUID, key.ID), key, key.Expired)
+}
+
+// Stats returns the hit, miss and eviction counters and the number of keys in the cache
+func (c *Cache) Stats() Stats {
+	return c.entries.Stats()
+}
+
+// Close stops the background sweeper and zeroes all the keys held by the cache
+func (c *Cache) Close() {
+	log.Trace("keycache/keycache:Close() Entering")
+	defer log.Trace("keycache/keycache:Close() Leaving")
+	c.entries.Close()
 }
 
-var global *Cache
+var global *ttlcache.Global
 
 func init() {
 	log.Trace("keycache/keycache:init() Entering")
 	defer log.Trace("keycache/keycache:init() Leaving")
-	global = NewCache()
+	global = ttlcache.NewGlobal(NewCache(time.Second*time.Duration(constants.DefaultKeyCacheSeconds), constants.DefaultKeyCacheMaxEntries))
 }
 
-// Get retrieves a key by its imageID from the default global keycache
-func Get(imageID string) (key Key, exists bool) {
+// Configure replaces the default global keycache with one using the given TTL and maximum number of entries
+func Configure(ttl time.Duration, maxEntries int) {
+	log.Trace("keycache/keycache:Configure() Entering")
+	defer log.Trace("keycache/keycache:Configure() Leaving")
+	global.Replace(NewCache(ttl, maxEntries))
+}
+
+func globalCache() *Cache {
+	return global.Load().(*Cache)
+}
+
+// Get retrieves the key with keyID cached for the host with hardwareUUID from the default global keycache
+func Get(hardwareUUID string, keyID string) (key Key, exists bool) {
 	log.Trace("keycache/keycache:Get() Entering")
 	defer log.Trace("keycache/keycache:Get() Leaving")
-	return global.Get(imageID)
+	return globalCache().Get(hardwareUUID, keyID)
 }
 
-// Store persists a key by its keyID from the default global keycache
-func Store(imageID string, key Key) {
+// Store persists a key for the host with hardwareUUID in the default global keycache
+func Store(hardwareUUID string, key Key) {
 	log.Trace("keycache/keycache:Store() Entering")
 	defer log.Trace("keycache/keycache:Store() Leaving")
-	global.Store(imageID, key)
+	globalCache().Store(hardwareUUID, key)
+}
+
+// GetStats returns the counters of the default global keycache
+func GetStats() Stats {
+	return globalCache().Stats()
 }
@@ -5,7 +5,9 @@
 package keycache
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
 	"time"
 )
@@ -18,11 +20,11 @@ func TestGetAndStore(t *testing.T) {
 	log.Trace("keycache/keycache_test:TestGetAndStore() Entering")
 	defer log.Trace("keycache/keycache_test:TestGetAndStore() Leaving")
 	assert := assert.New(t)
-	cache := NewCache()
+	cache := NewCache(time.Minute, 0)
 
 	key := Key{"keyid", []byte{0, 1, 2, 3}, t1, t2}
 	cache.Store("foobar", key)
-	actual, exists := cache.Get("foobar")
+	actual, exists := cache.Get("foobar", "keyid")
 	assert.True(exists)
 	assert.Equal(key, actual)
 }
@@ -31,8 +33,8 @@ func TestGetNone(t *testing.T) {
 	log.Trace("keycache/keycache_test:TestGetNone() Entering")
 	defer log.Trace("keycache/keycache_test:TestGetNone() Leaving")
 	assert := assert.New(t)
-	cache := NewCache()
-	actual, exists := cache.Get("foobar")
+	cache := NewCache(time.Minute, 0)
+	actual, exists := cache.Get("foobar", "keyid")
 	assert.False(exists)
 	assert.Zero(actual)
 }
@@ -41,12 +43,189 @@ func TestOverwrite(t *testing.T) {
 	log.Trace("keycache/keycache_test:TestOverwrite() Entering")
 	defer log.Trace("keycache/keycache_test:TestOverwrite() Leaving ")
 	assert := assert.New(t)
-	cache := NewCache()
+	cache := NewCache(time.Minute, 0)
 	key1 := Key{"foo", []byte{0, 1, 2, 3}, t1, t2}
-	key2 := Key{"bar", []byte{4, 5, 6, 7}, t1, t2}
+	key2 := Key{"foo", []byte{4, 5, 6, 7}, t1, t2}
 	cache.Store("foobar", key1)
 	cache.Store("foobar", key2)
-	actual, exists := cache.Get("foobar")
+	actual, exists := cache.Get("foobar", "foo")
 	assert.True(exists)
 	assert.Equal(key2, actual)
+	assert.Equal(1, cache.Stats().Entries)
+}
+
+func TestMultipleKeysPerHost(t *testing.T) {
+	log.Trace("keycache/keycache_test:TestMultipleKeysPerHost() Entering")
+	defer log.Trace("keycache/keycache_test:TestMultipleKeysPerHost() Leaving")
+	assert := assert.New(t)
+	cache := NewCache(time.Minute, 0)
+	defer cache.Close()
+
+	fooKey := Key{"foo", []byte{0, 1, 2, 3}, t1, t2}
+	barKey := Key{"bar", []byte{4, 5, 6, 7}, t1, t2}
+	cache.Store("host1", fooKey)
+	cache.Store("host1", barKey)
+
+	// alternating image launches on one host keep hitting the cache
+	for i := 0; i < 3; i++ {
+		actual, exists := cache.Get("host1", "foo")
+		assert.True(exists)
+		assert.Equal(fooKey, actual)
+		actual, exists = cache.Get("host1", "bar")
+		assert.True(exists)
+		assert.Equal(barKey, actual)
+	}
+	stats := cache.Stats()
+	assert.Equal(uint64(6), stats.Hits)
+	assert.Zero(stats.Misses)
+	assert.Zero(stats.Evictions)
+	assert.Equal(2, stats.Entries)
+
+	// keys cached for one host are not released to another host
+	_, exists := cache.Get("host2", "foo")
+	assert.False(exists)
+}
+
+func TestExpiry(t *testing.T) {
+	log.Trace("keycache/keycache_test:TestExpiry() Entering")
+	defer log.Trace("keycache/keycache_test:TestExpiry() Leaving")
+	assert := assert.New(t)
+	cache := NewCache(50*time.Millisecond, 0)
+	defer cache.Close()
+
+	keyBytes := []byte{0, 1, 2, 3}
+	cache.Store("foobar", Key{ID: "keyid", Bytes: keyBytes})
+	actual, exists := cache.Get("foobar", "keyid")
+	assert.True(exists)
+	assert.Equal(keyBytes, actual.Bytes)
+	assert.Equal(50*time.Millisecond, actual.Expired.Sub(actual.Created))
+
+	time.Sleep(100 * time.Millisecond)
+	_, exists = cache.Get("foobar", "keyid")
+	assert.False(exists)
+	stats := cache.Stats()
+	assert.Equal(uint64(1), stats.Hits)
+	assert.Equal(uint64(1), stats.Misses)
+	assert.Equal(uint64(1), stats.Evictions)
+	assert.Zero(stats.Entries)
+}
+
+func TestSweeper(t *testing.T) {
+	log.Trace("keycache/keycache_test:TestSweeper() Entering")
+	defer log.Trace("keycache/keycache_test:TestSweeper() Leaving")
+	assert := assert.New(t)
+	cache := NewCache(20*time.Millisecond, 0)
+	defer cache.Close()
+
+	cache.Store("host", Key{ID: "foo", Bytes: []byte{0, 1, 2, 3}})
+	cache.Store("host", Key{ID: "bar", Bytes: []byte{4, 5, 6, 7}})
+	assert.Equal(2, cache.Stats().Entries)
+
+	// the sweeper removes expired keys without any access to them
+	time.Sleep(100 * time.Millisecond)
+	stats := cache.Stats()
+	assert.Zero(stats.Entries)
+	assert.Equal(uint64(2), stats.Evictions)
+	assert.Zero(stats.Misses)
+}
+
+func TestCapacity(t *testing.T) {
+	log.Trace("keycache/keycache_test:TestCapacity() Entering")
+	defer log.Trace("keycache/keycache_test:TestCapacity() Leaving")
+	assert := assert.New(t)
+	cache := NewCache(time.Minute, 2)
+	defer cache.Close()
+
+	fooBytes := []byte{0, 1, 2, 3}
+	cache.Store("host", Key{ID: "foo", Bytes: fooBytes})
+	cache.Store("host", Key{ID: "bar", Bytes: []byte{4, 5, 6, 7}})
+	// foo becomes the most recently used key, so bar is evicted when baz is stored
+	_, exists := cache.Get("host", "foo")
+	assert.True(exists)
+	cache.Store("host", Key{ID: "baz", Bytes: []byte{8, 9, 10, 11}})
+
+	_, exists = cache.Get("host", "bar")
+	assert.False(exists)
+	_, exists = cache.Get("host", "foo")
+	assert.True(exists)
+	_, exists = cache.Get("host", "baz")
+	assert.True(exists)
+	stats := cache.Stats()
+	assert.Equal(2, stats.Entries)
+	assert.Equal(uint64(1), stats.Evictions)
+	assert.Equal(uint64(3), stats.Hits)
+	assert.Equal(uint64(1), stats.Misses)
+
+	// the cache holds its own copy of the key, the caller's slice is left untouched
+	assert.Equal([]byte{0, 1, 2, 3}, fooBytes)
+}
+
+func TestEvictionZeroesKey(t *testing.T) {
+	log.Trace("keycache/keycache_test:TestEvictionZeroesKey() Entering")
+	defer log.Trace("keycache/keycache_test:TestEvictionZeroesKey() Leaving")
+	assert := assert.New(t)
+	cache := NewCache(time.Minute, 1)
+	defer cache.Close()
+
+	cache.Store("host", Key{ID: "foo", Bytes: []byte{1, 2, 3, 4}})
+	cached, _ := cache.entries.Peek(newCacheKey("host", "foo"))
+	cachedBytes := cached.(Key).Bytes
+	cache.Store("host", Key{ID: "bar", Bytes: []byte{5, 6, 7, 8}})
+	assert.Equal([]byte{0, 0, 0, 0}, cachedBytes)
+
+	cached, _ = cache.entries.Peek(newCacheKey("host", "bar"))
+	cachedBytes = cached.(Key).Bytes
+	cache.Close()
+	assert.Equal([]byte{0, 0, 0, 0}, cachedBytes)
+	assert.Zero(cache.Stats().Entries)
+}
+
+func TestKeyIDCaseInsensitive(t *testing.T) {
+	log.Trace("keycache/keycache_test:TestKeyIDCaseInsensitive() Entering")
+	defer log.Trace("keycache/keycache_test:TestKeyIDCaseInsensitive() Leaving")
+	assert := assert.New(t)
+	cache := NewCache(time.Minute, 0)
+	defer cache.Close()
+
+	key := Key{"6E4BC1F5-5C3D-4A2B-9C4D-0F1E2D3C4B5A", []byte{0, 1, 2, 3}, t1, t2}
+	cache.Store("00ECD3AB-9AF4-E711-906E-001560A04062", key)
+
+	// hosts and key URLs may spell the same UUIDs in upper or lower case
+	actual, exists := cache.Get("00ecd3ab-9af4-e711-906e-001560a04062", "6e4bc1f5-5c3d-4a2b-9c4d-0f1e2d3c4b5a")
+	assert.True(exists)
+	assert.Equal(key, actual)
+	actual, exists = cache.Get("{00ecd3ab-9af4-e711-906e-001560a04062}", "6E4BC1F5-5C3D-4A2B-9C4D-0F1E2D3C4B5A")
+	assert.True(exists)
+	assert.Equal(key, actual)
+	assert.Equal(1, cache.Stats().Entries)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	log.Trace("keycache/keycache_test:TestConcurrentAccess() Entering")
+	defer log.Trace("keycache/keycache_test:TestConcurrentAccess() Leaving")
+	assert := assert.New(t)
+	cache := NewCache(10*time.Millisecond, 16)
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				hardwareUUID := fmt.Sprintf("host-%d", i%4)
+				keyID := fmt.Sprintf("key-%d", j%8)
+				cache.Store(hardwareUUID, Key{ID: keyID, Bytes: []byte{byte(i), byte(j)}})
+				if key, exists := cache.Get(hardwareUUID, keyID); exists {
+					assert.Equal(keyID, key.ID)
+					assert.Len(key.Bytes, 2)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := cache.Stats()
+	assert.LessOrEqual(stats.Entries, 16)
+	assert.Equal(uint64(8*500), stats.Hits+stats.Misses)
 }
@@ -71,6 +71,7 @@ func main() {
 			args[1] != "download_cert" &&
 			args[1] != "update_service_config" &&
 			args[1] != "download_saml_ca_cert" &&
+			args[1] != "import_flavor_signing_cert" &&
 			args[1] != "database" &&
 			args[1] != "hvsconnection" &&
 			args[1] != "all" {
@@ -150,6 +151,9 @@ func main() {
 				setup.Download_Saml_Ca_Cert{
 					Flags: flags,
 				},
+				setup.Import_Flavor_Signing_Cert{
+					Flags: flags,
+				},
 			},
 			AskInput: false,
 		}
@@ -213,6 +217,35 @@ func main() {
 			fmt.Println("Failed to stop service")
 		}
 
+	case "migrate":
+		config.LogConfiguration(config.Configuration.LogEnableStdout, true)
+		if len(args) < 2 || (args[1] != "up" && args[1] != "down" && args[1] != "status") {
+			fmt.Fprintln(os.Stderr, "Error: migrate requires one of up, down or status")
+			printUsage()
+			os.Exit(1)
+		}
+		if err := migrate(args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to migrate database:", err)
+			log.WithError(err).Error("main:main() Failed to migrate database")
+			log.Tracef("%+v", err)
+			os.Exit(1)
+		}
+
+	case "purge-reports":
+		config.LogConfiguration(config.Configuration.LogEnableStdout, true)
+		if len(args) > 2 || (len(args) == 2 && args[1] != "--dry-run") {
+			fmt.Fprintln(os.Stderr, "Error: purge-reports only accepts the --dry-run option")
+			printUsage()
+			os.Exit(1)
+		}
+		dryRun := len(args) == 2
+		if err := purgeReports(dryRun); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to purge reports:", err)
+			log.WithError(err).Error("main:main() Failed to purge reports")
+			log.Tracef("%+v", err)
+			os.Exit(1)
+		}
+
 	case "uninstall":
 		config.LogConfiguration(false, false)
 		fmt.Println("Uninstalling workload-service...")
@@ -339,6 +372,8 @@ func printUsage() {
 	fmt.Fprintln(os.Stdout, "    status               Determine if workload-service is running")
 	fmt.Fprintln(os.Stdout, "    uninstall [--purge]  Uninstall workload-service. --purge option needs to be applied to remove configuration and data files")
 	fmt.Fprintln(os.Stdout, "    setup                Run workload-service setup tasks")
+	fmt.Fprintln(os.Stdout, "    migrate up|down|status  Apply the missing database migrations, revert the last applied migration, or list the migrations")
+	fmt.Fprintln(os.Stdout, "    purge-reports [--dry-run]  Delete the reports expired according to the retention policy. --dry-run option only counts them. Nothing is purged unless REPORT_MAX_AGE_DAYS or REPORT_MAX_PER_INSTANCE is configured")
 	fmt.Fprintln(os.Stdout, "")
 	fmt.Fprintln(os.Stdout, "Setup command usage:     workload-service setup [task] [--force]")
 	fmt.Fprintln(os.Stdout, "")
@@ -425,6 +460,11 @@ func printUsage() {
 	fmt.Fprintln(os.Stdout, "                                    Required env variables specific to setup task are:")
 	fmt.Fprintln(os.Stdout, "                                        - HVS_URL=<url>      : HVS API Endpoint URL")
 	fmt.Fprintln(os.Stdout, "                                        - BEARER_TOKEN=<token> for authenticating with HVS")
+	fmt.Fprintln(os.Stdout, "")
+	fmt.Fprintln(os.Stdout, "   import_flavor_signing_cert       Setup to import the certificates trusted to sign image flavors")
+	fmt.Fprintln(os.Stdout, "                                    - Option [--force] imports the certificates even if flavor signing certificates already exist")
+	fmt.Fprintln(os.Stdout, "                                    Required env variable specific to setup task if no flavor signing certificates were imported before:")
+	fmt.Fprintln(os.Stdout, "                                        - FLAVOR_SIGNING_CERT_PATH=<path>   : Path of the PEM file containing the flavor signing certificate(s)")
 }
 
 func printVersion() {
@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package main
+
+import (
+	"fmt"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/repository/postgres"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// migrate applies the missing migrations with up, reverts the last applied migration with down, and prints the
+// migrations and when they were applied with status
+func migrate(command string) error {
+	log.Trace("main:migrate() Entering")
+	defer log.Trace("main:migrate() Leaving")
+
+	wlsDB, err := postgres.Open(config.Configuration.Postgres.Hostname, config.Configuration.Postgres.Port, config.Configuration.Postgres.DBName,
+		config.Configuration.Postgres.UserName, config.Configuration.Postgres.Password, config.Configuration.Postgres.SSLMode, config.Configuration.Postgres.SSLCert)
+	if err != nil {
+		return errors.Wrap(err, "main:migrate() failed to open Postgres database")
+	}
+	defer wlsDB.Close()
+
+	switch command {
+	case "up":
+		if err := wlsDB.Migrate(); err != nil {
+			return err
+		}
+	case "down":
+		version, err := wlsDB.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		if version == 0 {
+			fmt.Println("No migration to revert")
+			return nil
+		}
+		if err := wlsDB.MigrateTo(version - 1); err != nil {
+			return err
+		}
+	case "status":
+		status, err := wlsDB.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED AT\tDESCRIPTION")
+		for _, m := range status {
+			appliedAt := "pending"
+			if m.AppliedAt != nil {
+				appliedAt = m.AppliedAt.Format("2006-01-02T15:04:05Z07:00")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, appliedAt, m.Description)
+		}
+		return w.Flush()
+	}
+
+	version, err := wlsDB.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Database schema is at version %d, latest version is %d\n", version, postgres.LatestSchemaVersion())
+	return nil
+}
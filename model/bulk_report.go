@@ -0,0 +1,24 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package model
+
+// BulkReportResponse holds the status of each report of a bulk report request, in the order of the request.
+// Created counts the reports created, including the reports that were already created by an earlier request.
+type BulkReportResponse struct {
+	Created int                `json:"created"`
+	Failed  int                `json:"failed"`
+	Results []BulkReportResult `json:"results"`
+}
+
+// BulkReportResult is the status of a report of a bulk report request. Status is the HTTP status code the report
+// would have been created with by POST /reports, 200 when it was already created. ID is set when the report is
+// created, Code and Message when it is not.
+type BulkReportResult struct {
+	Index   int    `json:"index"`
+	Status  int    `json:"status"`
+	ID      string `json:"id,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,13 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package model
+
+// ErrorResponse is the body returned by the API when a request fails
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id"`
+	Details   interface{} `json:"details,omitempty"`
+}
@@ -0,0 +1,32 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package model
+
+import "time"
+
+// Decisions of key release attempts: the key was released, the request was rejected, or the key could not be
+// retrieved because of an error
+const (
+	KeyReleaseReleased = "released"
+	KeyReleaseDenied   = "denied"
+	KeyReleaseFailed   = "failed"
+)
+
+// KeyRelease records an attempt to release a key to a host, it is set by WLS and never read from input
+type KeyRelease struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Principal is the subject of the bearer token of the caller, or the common name of its client certificate
+	Principal    string `json:"principal,omitempty"`
+	HardwareUUID string `json:"hardware_uuid"`
+	// ImageID is only known when the key is requested with the flavor of an image
+	ImageID  string `json:"image_id,omitempty"`
+	KeyID    string `json:"key_id,omitempty"`
+	Decision string `json:"decision"`
+	// DenialReason is why the key was not released
+	DenialReason string `json:"denial_reason,omitempty"`
+	// SamlAssertionID is the ID of the SAML report of the host the decision was made with
+	SamlAssertionID string `json:"saml_assertion_id,omitempty"`
+}
@@ -0,0 +1,23 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package model
+
+import "time"
+
+// KeyReleasePolicy lists the SAML attributes a host must have for the key of an image to be released to it, on top
+// of TRUST_OVERALL being true
+type KeyReleasePolicy struct {
+	// ImageID is set by WLS from the image the policy is stored for
+	ImageID            string              `json:"image_id"`
+	RequiredAttributes []RequiredAttribute `json:"required_attributes"`
+	// UpdatedAt is set by WLS and never read from input
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// RequiredAttribute is a SAML attribute, such as TRUST_OS or TAG_Location, and the values it may have
+type RequiredAttribute struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
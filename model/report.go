@@ -7,6 +7,7 @@ package model
 import (
 	"intel/isecl/lib/common/v4/crypt"
 	"intel/isecl/lib/verifier/v4"
+	"time"
 )
 
 // Report is an alias to verifier.VMTrustReport
@@ -14,4 +15,10 @@ type Report struct {
 	ID string `json:"id,omitempty"`
 	verifier.InstanceTrustReport
 	crypt.SignedData
+	// Signer is the subject of the verified report signing certificate, it is set by WLS and never read from input
+	Signer string `json:"-"`
+	// ExpiresOn is when the report is purged according to the retention policy, it is set by WLS and never read from input
+	ExpiresOn time.Time `json:"-"`
+	// SignedReport is the signed report exactly as it was submitted, it is set by WLS and never read from input
+	SignedReport []byte `json:"-"`
 }
@@ -0,0 +1,18 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package model
+
+// ReportSummary counts the trusted and untrusted instances of each group, according to the latest report of each instance
+type ReportSummary struct {
+	GroupBy string               `json:"group_by"`
+	Groups  []ReportSummaryGroup `json:"groups"`
+}
+
+// ReportSummaryGroup counts the trusted and untrusted instances of a host, an image or a policy
+type ReportSummaryGroup struct {
+	Key       string `json:"key"`
+	Trusted   int    `json:"trusted"`
+	Untrusted int    `json:"untrusted"`
+}
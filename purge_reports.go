@@ -0,0 +1,43 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package main
+
+import (
+	"fmt"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/repository/postgres"
+	"intel/isecl/workload-service/v4/retention"
+
+	"github.com/pkg/errors"
+)
+
+// purgeReports deletes the expired reports, or only prints how many reports are expired when dryRun is set
+func purgeReports(dryRun bool) error {
+	log.Trace("main:purgeReports() Entering")
+	defer log.Trace("main:purgeReports() Leaving")
+
+	policy := retention.ConfiguredPolicy()
+	if !policy.Enabled() {
+		fmt.Println("No report retention limit is configured, reports are kept indefinitely")
+		return nil
+	}
+	wlsDB, err := postgres.Open(config.Configuration.Postgres.Hostname, config.Configuration.Postgres.Port, config.Configuration.Postgres.DBName,
+		config.Configuration.Postgres.UserName, config.Configuration.Postgres.Password, config.Configuration.Postgres.SSLMode, config.Configuration.Postgres.SSLCert)
+	if err != nil {
+		return errors.Wrap(err, "main:purgeReports() failed to open Postgres database")
+	}
+	defer wlsDB.Close()
+
+	purged, err := retention.Purge(wlsDB.ReportRepository(), policy, dryRun)
+	if dryRun {
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d reports would be purged\n", purged)
+		return nil
+	}
+	fmt.Printf("%d reports purged\n", purged)
+	return err
+}
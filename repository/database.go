@@ -13,5 +13,7 @@ type WlsDatabase interface {
 	FlavorRepository() FlavorRepository
 	ImageRepository() ImageRepository
 	ReportRepository() ReportRepository
+	KeyReleasePolicyRepository() KeyReleasePolicyRepository
+	KeyReleaseRepository() KeyReleaseRepository
 	Driver() *gorm.DB
 }
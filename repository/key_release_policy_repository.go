@@ -0,0 +1,30 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package repository
+
+import (
+	"errors"
+	"intel/isecl/workload-service/v4/model"
+)
+
+var (
+	// ErrKeyReleasePolicyNotFound error when an image has no key release policy
+	ErrKeyReleasePolicyNotFound = errors.New("key release policy not found for image")
+	// ErrKeyReleasePolicyImageNotFound error when a key release policy is stored for an image that does not exist
+	ErrKeyReleasePolicyImageNotFound = errors.New("image of the key release policy does not exist")
+)
+
+// KeyReleasePolicyRepository defines the persistence operations for the key release policies of images
+type KeyReleasePolicyRepository interface {
+	// C
+	// Store creates the key release policy of an image, or replaces it if the image already has one
+	Store(policy *model.KeyReleasePolicy) error
+	// R
+	RetrieveByImageID(imageID string) (*model.KeyReleasePolicy, error)
+	// RetrieveByKeyID retrieves the policies of the images whose flavor is encrypted with the key
+	RetrieveByKeyID(keyID string) ([]model.KeyReleasePolicy, error)
+	// D
+	DeleteByImageID(imageID string) error
+}
@@ -0,0 +1,35 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package repository
+
+import (
+	"intel/isecl/workload-service/v4/model"
+)
+
+// KeyReleaseFilter defines the filter criteria of the key release records, each field may be empty.
+// Limit and Offset select a page of the matching records sorted by creation time in SortOrder, ascending by default.
+// They are ignored when counting the matching records.
+type KeyReleaseFilter struct {
+	HardwareUUID string `json:"hardware_uuid,omitempty"`
+	ImageID      string `json:"image_id,omitempty"`
+	KeyID        string `json:"key_id,omitempty"`
+	Principal    string `json:"principal,omitempty"`
+	Decision     string `json:"decision,omitempty"`
+	FromDate     string `json:"from_date,omitempty"`
+	ToDate       string `json:"to_date,omitempty"`
+	Limit        int    `json:"limit,omitempty"`
+	Offset       int    `json:"offset,omitempty"`
+	SortOrder    string `json:"sort_order,omitempty"`
+}
+
+// KeyReleaseRepository defines the persistence operations for the audit trail of key releases. Records are only
+// ever created, never updated.
+type KeyReleaseRepository interface {
+	// C
+	Create(release *model.KeyRelease) error
+	// R
+	RetrieveByFilterCriteria(filter KeyReleaseFilter) ([]model.KeyRelease, error)
+	CountByFilterCriteria(filter KeyReleaseFilter) (int, error)
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package mock
+
+import (
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+)
+
+// MockKeyReleasePolicy has no key release policy unless its functions are set
+type MockKeyReleasePolicy struct {
+	StoreFn             func(*model.KeyReleasePolicy) error
+	RetrieveByImageIDFn func(string) (*model.KeyReleasePolicy, error)
+	RetrieveByKeyIDFn   func(string) ([]model.KeyReleasePolicy, error)
+	DeleteByImageIDFn   func(string) error
+}
+
+func (m *MockKeyReleasePolicy) Store(policy *model.KeyReleasePolicy) error {
+	log.Trace("repository/mock/key_release_policy_repository:Store() Entering")
+	defer log.Trace("repository/mock/key_release_policy_repository:Store() Leaving")
+	if m.StoreFn != nil {
+		return m.StoreFn(policy)
+	}
+	return nil
+}
+
+func (m *MockKeyReleasePolicy) RetrieveByImageID(imageID string) (*model.KeyReleasePolicy, error) {
+	log.Trace("repository/mock/key_release_policy_repository:RetrieveByImageID() Entering")
+	defer log.Trace("repository/mock/key_release_policy_repository:RetrieveByImageID() Leaving")
+	if m.RetrieveByImageIDFn != nil {
+		return m.RetrieveByImageIDFn(imageID)
+	}
+	return nil, repository.ErrKeyReleasePolicyNotFound
+}
+
+func (m *MockKeyReleasePolicy) RetrieveByKeyID(keyID string) ([]model.KeyReleasePolicy, error) {
+	log.Trace("repository/mock/key_release_policy_repository:RetrieveByKeyID() Entering")
+	defer log.Trace("repository/mock/key_release_policy_repository:RetrieveByKeyID() Leaving")
+	if m.RetrieveByKeyIDFn != nil {
+		return m.RetrieveByKeyIDFn(keyID)
+	}
+	return nil, nil
+}
+
+func (m *MockKeyReleasePolicy) DeleteByImageID(imageID string) error {
+	log.Trace("repository/mock/key_release_policy_repository:DeleteByImageID() Entering")
+	defer log.Trace("repository/mock/key_release_policy_repository:DeleteByImageID() Leaving")
+	if m.DeleteByImageIDFn != nil {
+		return m.DeleteByImageIDFn(imageID)
+	}
+	return repository.ErrKeyReleasePolicyNotFound
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package mock
+
+import (
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+)
+
+// MockKeyRelease records nothing and has no key release unless its functions are set
+type MockKeyRelease struct {
+	CreateFn                   func(*model.KeyRelease) error
+	RetrieveByFilterCriteriaFn func(repository.KeyReleaseFilter) ([]model.KeyRelease, error)
+	CountByFilterCriteriaFn    func(repository.KeyReleaseFilter) (int, error)
+}
+
+func (m *MockKeyRelease) Create(release *model.KeyRelease) error {
+	log.Trace("repository/mock/key_release_repository:Create() Entering")
+	defer log.Trace("repository/mock/key_release_repository:Create() Leaving")
+	if m.CreateFn != nil {
+		return m.CreateFn(release)
+	}
+	return nil
+}
+
+func (m *MockKeyRelease) RetrieveByFilterCriteria(filter repository.KeyReleaseFilter) ([]model.KeyRelease, error) {
+	log.Trace("repository/mock/key_release_repository:RetrieveByFilterCriteria() Entering")
+	defer log.Trace("repository/mock/key_release_repository:RetrieveByFilterCriteria() Leaving")
+	if m.RetrieveByFilterCriteriaFn != nil {
+		return m.RetrieveByFilterCriteriaFn(filter)
+	}
+	return nil, nil
+}
+
+func (m *MockKeyRelease) CountByFilterCriteria(filter repository.KeyReleaseFilter) (int, error) {
+	log.Trace("repository/mock/key_release_repository:CountByFilterCriteria() Entering")
+	defer log.Trace("repository/mock/key_release_repository:CountByFilterCriteria() Leaving")
+	if m.CountByFilterCriteriaFn != nil {
+		return m.CountByFilterCriteriaFn(filter)
+	}
+	return 0, nil
+}
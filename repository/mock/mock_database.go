@@ -11,9 +11,11 @@ import (
 
 // Database provides a mock Db
 type Database struct {
-	MockFlavor MockFlavor
-	MockImage  MockImage
-	MockReport MockReport
+	MockFlavor           MockFlavor
+	MockImage            MockImage
+	MockReport           MockReport
+	MockKeyReleasePolicy MockKeyReleasePolicy
+	MockKeyRelease       MockKeyRelease
 }
 
 func (m *Database) Migrate() error {
@@ -40,6 +42,18 @@ func (m *Database) ReportRepository() repository.ReportRepository {
 	return &m.MockReport
 }
 
+func (m *Database) KeyReleasePolicyRepository() repository.KeyReleasePolicyRepository {
+	log.Trace("repository/mock/mock_database:KeyReleasePolicyRepository() Entering")
+	defer log.Trace("repository/mock/mock_database:KeyReleasePolicyRepository() Leaving")
+	return &m.MockKeyReleasePolicy
+}
+
+func (m *Database) KeyReleaseRepository() repository.KeyReleaseRepository {
+	log.Trace("repository/mock/mock_database:KeyReleaseRepository() Entering")
+	defer log.Trace("repository/mock/mock_database:KeyReleaseRepository() Leaving")
+	return &m.MockKeyRelease
+}
+
 func (m *Database) Driver() *gorm.DB {
 	log.Trace("repository/mock/mock_database:Driver() Entering ")
 	defer log.Trace("repository/mock/mock_database:Driver() Leaving")
@@ -10,9 +10,16 @@ import (
 )
 
 type MockReport struct {
-	CreateFn                   func(*model.Report) error
-	RetrieveByFilterCriteriaFn func(repository.ReportFilter) ([]model.Report, error)
-	DeleteByReportIDFn         func(string) error
+	CreateFn                      func(*model.Report) error
+	CreateManyFn                  func([]*model.Report) error
+	RetrieveByFilterCriteriaFn    func(repository.ReportFilter) ([]model.Report, error)
+	RetrieveByIDsFn               func([]string) ([]model.Report, error)
+	RetrieveLatestByInstanceIDsFn func([]string) ([]model.Report, error)
+	CountByFilterCriteriaFn       func(repository.ReportFilter) (int, error)
+	CountExpiredFn                func(repository.ReportExpiry) (int, error)
+	SummarizeFn                   func(repository.ReportFilter, string) ([]model.ReportSummaryGroup, error)
+	DeleteByReportIDFn            func(string) error
+	DeleteExpiredFn               func(repository.ReportExpiry, int) (int, error)
 }
 
 func (m *MockReport) Create(r *model.Report) error {
@@ -25,6 +32,21 @@ func (m *MockReport) Create(r *model.Report) error {
 	return nil
 }
 
+func (m *MockReport) CreateMany(reports []*model.Report) error {
+	log.Trace("repository/mock/report_repository:CreateMany() Entering")
+	defer log.Trace("repository/mock/report_repository:CreateMany() Leaving")
+	log.Debug("repository/mock/report_repository:CreateMany() Create mock reports")
+	if m.CreateManyFn != nil {
+		return m.CreateManyFn(reports)
+	}
+	for _, r := range reports {
+		if err := m.Create(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *MockReport) RetrieveByFilterCriteria(filter repository.ReportFilter) ([]model.Report, error) {
 	log.Trace("repository/mock/report_repository:RetrieveByFilterCriteria() Entering")
 	defer log.Trace("repository/mock/report_repository:RetrieveByFilterCriteria() Leaving")
@@ -35,6 +57,36 @@ func (m *MockReport) RetrieveByFilterCriteria(filter repository.ReportFilter) ([
 	return []model.Report{r}, nil
 }
 
+func (m *MockReport) RetrieveByIDs(ids []string) ([]model.Report, error) {
+	log.Trace("repository/mock/report_repository:RetrieveByIDs() Entering")
+	defer log.Trace("repository/mock/report_repository:RetrieveByIDs() Leaving")
+	log.Debug("repository/mock/report_repository:RetrieveByIDs() Retrieve mock reports by IDs")
+	if m.RetrieveByIDsFn != nil {
+		return m.RetrieveByIDsFn(ids)
+	}
+	return nil, nil
+}
+
+func (m *MockReport) RetrieveLatestByInstanceIDs(instanceIDs []string) ([]model.Report, error) {
+	log.Trace("repository/mock/report_repository:RetrieveLatestByInstanceIDs() Entering")
+	defer log.Trace("repository/mock/report_repository:RetrieveLatestByInstanceIDs() Leaving")
+	log.Debug("repository/mock/report_repository:RetrieveLatestByInstanceIDs() Retrieve latest mock reports by instance IDs")
+	if m.RetrieveLatestByInstanceIDsFn != nil {
+		return m.RetrieveLatestByInstanceIDsFn(instanceIDs)
+	}
+	return nil, nil
+}
+
+func (m *MockReport) CountByFilterCriteria(filter repository.ReportFilter) (int, error) {
+	log.Trace("repository/mock/report_repository:CountByFilterCriteria() Entering")
+	defer log.Trace("repository/mock/report_repository:CountByFilterCriteria() Leaving")
+	log.Debug("repository/mock/report_repository:CountByFilterCriteria() Count mock reports by filter criteria")
+	if m.CountByFilterCriteriaFn != nil {
+		return m.CountByFilterCriteriaFn(filter)
+	}
+	return 1, nil
+}
+
 func (m *MockReport) DeleteByReportID(reportID string) error {
 	log.Trace("repository/mock/report_repository:DeleteByReportID() Entering")
 	defer log.Trace("repository/mock/report_repository:DeleteByReportID() Leaving")
@@ -44,3 +96,33 @@ func (m *MockReport) DeleteByReportID(reportID string) error {
 	}
 	return nil
 }
+
+func (m *MockReport) CountExpired(expiry repository.ReportExpiry) (int, error) {
+	log.Trace("repository/mock/report_repository:CountExpired() Entering")
+	defer log.Trace("repository/mock/report_repository:CountExpired() Leaving")
+	log.Debug("repository/mock/report_repository:CountExpired() Count expired mock reports")
+	if m.CountExpiredFn != nil {
+		return m.CountExpiredFn(expiry)
+	}
+	return 0, nil
+}
+
+func (m *MockReport) DeleteExpired(expiry repository.ReportExpiry, limit int) (int, error) {
+	log.Trace("repository/mock/report_repository:DeleteExpired() Entering")
+	defer log.Trace("repository/mock/report_repository:DeleteExpired() Leaving")
+	log.Debug("repository/mock/report_repository:DeleteExpired() Delete expired mock reports")
+	if m.DeleteExpiredFn != nil {
+		return m.DeleteExpiredFn(expiry, limit)
+	}
+	return 0, nil
+}
+
+func (m *MockReport) Summarize(filter repository.ReportFilter, groupBy string) ([]model.ReportSummaryGroup, error) {
+	log.Trace("repository/mock/report_repository:Summarize() Entering")
+	defer log.Trace("repository/mock/report_repository:Summarize() Leaving")
+	log.Debug("repository/mock/report_repository:Summarize() Summarize mock reports")
+	if m.SummarizeFn != nil {
+		return m.SummarizeFn(filter, groupBy)
+	}
+	return []model.ReportSummaryGroup{{Key: r.Manifest.InstanceInfo.HostHardwareUUID, Trusted: 1}}, nil
+}
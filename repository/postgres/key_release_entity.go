@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package postgres
+
+import (
+	"intel/isecl/workload-service/v4/model"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+type keyReleaseEntity struct {
+	ID              string    `gorm:"type:uuid;primary_key;"`
+	CreatedAt       time.Time `sql:"type:timestamp"`
+	Principal       string
+	HardwareUUID    string `gorm:"index:idx_key_releases_hardware_uuid"`
+	ImageID         string `gorm:"index:idx_key_releases_image_id"`
+	KeyID           string `gorm:"index:idx_key_releases_key_id"`
+	Decision        string
+	DenialReason    string
+	SamlAssertionID string
+}
+
+func (kre keyReleaseEntity) TableName() string {
+	log.Trace("repository/postgres/key_release_entity:TableName() Entering")
+	defer log.Trace("repository/postgres/key_release_entity:TableName() Leaving")
+	return "key_releases"
+}
+
+func (kre *keyReleaseEntity) BeforeCreate(scope *gorm.Scope) error {
+	log.Trace("repository/postgres/key_release_entity:BeforeCreate() Entering")
+	defer log.Trace("repository/postgres/key_release_entity:BeforeCreate() Leaving")
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return errors.New("repository/postgres/key_release_entity:BeforeCreate() unable to create uuid")
+	}
+	if err := scope.SetColumn("id", id.String()); err != nil {
+		return errors.New("repository/postgres/key_release_entity:BeforeCreate() unable to set column value")
+	}
+	return nil
+}
+
+func (kre *keyReleaseEntity) KeyRelease() model.KeyRelease {
+	log.Trace("repository/postgres/key_release_entity:KeyRelease() Entering")
+	defer log.Trace("repository/postgres/key_release_entity:KeyRelease() Leaving")
+
+	return model.KeyRelease{
+		ID:              kre.ID,
+		CreatedAt:       kre.CreatedAt,
+		Principal:       kre.Principal,
+		HardwareUUID:    kre.HardwareUUID,
+		ImageID:         kre.ImageID,
+		KeyID:           kre.KeyID,
+		Decision:        kre.Decision,
+		DenialReason:    kre.DenialReason,
+		SamlAssertionID: kre.SamlAssertionID,
+	}
+}
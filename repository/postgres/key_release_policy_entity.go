@@ -0,0 +1,38 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package postgres
+
+import (
+	"encoding/json"
+	"intel/isecl/workload-service/v4/model"
+	"time"
+
+	"github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/pkg/errors"
+)
+
+type keyReleasePolicyEntity struct {
+	ImageID            string         `gorm:"type:uuid;primary_key;"`
+	CreatedAt          time.Time      `sql:"type:timestamp"`
+	UpdatedAt          time.Time      `sql:"type:timestamp"`
+	RequiredAttributes postgres.Jsonb `gorm:"type:jsonb;not null"`
+}
+
+func (kpe keyReleasePolicyEntity) TableName() string {
+	log.Trace("repository/postgres/key_release_policy_entity:TableName() Entering")
+	defer log.Trace("repository/postgres/key_release_policy_entity:TableName() Leaving")
+	return "key_release_policies"
+}
+
+func (kpe *keyReleasePolicyEntity) KeyReleasePolicy() (model.KeyReleasePolicy, error) {
+	log.Trace("repository/postgres/key_release_policy_entity:KeyReleasePolicy() Entering")
+	defer log.Trace("repository/postgres/key_release_policy_entity:KeyReleasePolicy() Leaving")
+
+	policy := model.KeyReleasePolicy{ImageID: kpe.ImageID, UpdatedAt: kpe.UpdatedAt}
+	if err := json.Unmarshal(kpe.RequiredAttributes.RawMessage, &policy.RequiredAttributes); err != nil {
+		return policy, errors.Wrap(err, "repository/postgres/key_release_policy_entity:KeyReleasePolicy() Failed to unmarshal the required attributes")
+	}
+	return policy, nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package postgres
+
+import (
+	"encoding/json"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+type keyReleasePolicyRepo struct {
+	db *gorm.DB
+}
+
+func getKeyReleasePolicyModels(entities []keyReleasePolicyEntity) ([]model.KeyReleasePolicy, error) {
+	log.Trace("repository/postgres/key_release_policy_repository:getKeyReleasePolicyModels() Entering")
+	defer log.Trace("repository/postgres/key_release_policy_repository:getKeyReleasePolicyModels() Leaving")
+
+	policies := make([]model.KeyReleasePolicy, len(entities))
+	for i := range entities {
+		policy, err := entities[i].KeyReleasePolicy()
+		if err != nil {
+			return nil, err
+		}
+		policies[i] = policy
+	}
+	return policies, nil
+}
+
+func (repo keyReleasePolicyRepo) Store(policy *model.KeyReleasePolicy) error {
+	log.Trace("repository/postgres/key_release_policy_repository:Store() Entering")
+	defer log.Trace("repository/postgres/key_release_policy_repository:Store() Leaving")
+
+	if policy == nil {
+		return errors.New("repository/postgres/key_release_policy_repository:Store() key release policy must not be nil")
+	}
+	attributes, err := json.Marshal(policy.RequiredAttributes)
+	if err != nil {
+		return errors.Wrap(err, "repository/postgres/key_release_policy_repository:Store() Failed to marshal the required attributes")
+	}
+
+	var images int
+	if err := repo.db.Model(&imageEntity{}).Where("id = ?", policy.ImageID).Count(&images).Error; err != nil {
+		return errors.Wrap(err, "repository/postgres/key_release_policy_repository:Store() Failed to retrieve the image of the key release policy")
+	}
+	if images == 0 {
+		return repository.ErrKeyReleasePolicyImageNotFound
+	}
+
+	now := time.Now().UTC()
+	// the policy replaces the one already stored for the image, keeping when the image first had a policy
+	err = repo.db.Exec(`INSERT INTO key_release_policies (image_id, created_at, updated_at, required_attributes) VALUES (?, ?, ?, ?)
+ON CONFLICT (image_id) DO UPDATE SET updated_at = EXCLUDED.updated_at, required_attributes = EXCLUDED.required_attributes`,
+		policy.ImageID, now, now, string(attributes)).Error
+	if err != nil {
+		// the image may have been deleted since it was found
+		var remaining int
+		if countErr := repo.db.Model(&imageEntity{}).Where("id = ?", policy.ImageID).Count(&remaining).Error; countErr == nil && remaining == 0 {
+			return repository.ErrKeyReleasePolicyImageNotFound
+		}
+		return errors.Wrap(err, "repository/postgres/key_release_policy_repository:Store() Failed to store the key release policy")
+	}
+	policy.UpdatedAt = now
+	return nil
+}
+
+func (repo keyReleasePolicyRepo) RetrieveByImageID(imageID string) (*model.KeyReleasePolicy, error) {
+	log.Trace("repository/postgres/key_release_policy_repository:RetrieveByImageID() Entering")
+	defer log.Trace("repository/postgres/key_release_policy_repository:RetrieveByImageID() Leaving")
+
+	var entity keyReleasePolicyEntity
+	if err := repo.db.First(&entity, "image_id = ?", imageID).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, repository.ErrKeyReleasePolicyNotFound
+		}
+		return nil, errors.Wrap(err, "repository/postgres/key_release_policy_repository:RetrieveByImageID() Failed to retrieve the key release policy")
+	}
+	policy, err := entity.KeyReleasePolicy()
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (repo keyReleasePolicyRepo) RetrieveByKeyID(keyID string) ([]model.KeyReleasePolicy, error) {
+	log.Trace("repository/postgres/key_release_policy_repository:RetrieveByKeyID() Entering")
+	defer log.Trace("repository/postgres/key_release_policy_repository:RetrieveByKeyID() Leaving")
+
+	// the key ID is a UUID, it has no LIKE wildcard to escape
+	var entities []keyReleasePolicyEntity
+	err := repo.db.Select("DISTINCT key_release_policies.*").
+		Joins("JOIN image_flavors ON image_flavors.image_id = key_release_policies.image_id").
+		Joins("JOIN flavors ON flavors.id = image_flavors.flavor_id").
+		Where("flavors.content -> 'encryption' ->> 'key_url' ILIKE ?", "%/keys/"+keyID+"/transfer").
+		Find(&entities).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "repository/postgres/key_release_policy_repository:RetrieveByKeyID() Failed to retrieve the key release policies")
+	}
+	return getKeyReleasePolicyModels(entities)
+}
+
+func (repo keyReleasePolicyRepo) DeleteByImageID(imageID string) error {
+	log.Trace("repository/postgres/key_release_policy_repository:DeleteByImageID() Entering")
+	defer log.Trace("repository/postgres/key_release_policy_repository:DeleteByImageID() Leaving")
+
+	result := repo.db.Where("image_id = ?", imageID).Delete(&keyReleasePolicyEntity{})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "repository/postgres/key_release_policy_repository:DeleteByImageID() Failed to delete the key release policy")
+	}
+	if result.RowsAffected == 0 {
+		return repository.ErrKeyReleasePolicyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// +build integration
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package postgres
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	flvr "intel/isecl/lib/flavor/v4"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createEncryptedImage stores an image with an image flavor encrypted with the key, and returns the image ID
+func createEncryptedImage(t *testing.T, wlsDB PostgresDatabase, keyID string) string {
+	f, err := flvr.GetImageFlavor("policy-"+keyID, true, "https://kbs.server.com:9443/v1/keys/"+keyID+"/transfer", "1160f92d07a3e9bf2633c49bfc2654428c517ee5a648d715bf984c83f266a4fd")
+	if err != nil {
+		t.Fatal("could not create image flavor")
+	}
+	if err := wlsDB.FlavorRepository().Create(&flvr.SignedImageFlavor{ImageFlavor: f.Image}); err != nil {
+		t.Fatal("could not seed flavor")
+	}
+	imageID := uuid.New().String()
+	if err := wlsDB.ImageRepository().Create(&model.Image{ID: imageID, FlavorIDs: []string{f.Image.Meta.ID}}); err != nil {
+		t.Fatal("could not seed image")
+	}
+	return imageID
+}
+
+func TestKeyReleasePolicyRepository(t *testing.T) {
+	log.Trace("repository/postgres/key_release_policy_repository_integration_test:TestKeyReleasePolicyRepository() Entering")
+	defer log.Trace("repository/postgres/key_release_policy_repository_integration_test:TestKeyReleasePolicyRepository() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.KeyReleasePolicyRepository()
+
+	keyID := uuid.New().String()
+	imageID := createEncryptedImage(t, wlsDB, keyID)
+	otherImageID := createEncryptedImage(t, wlsDB, keyID)
+	_, err := repo.RetrieveByImageID(imageID)
+	assert.Equal(repository.ErrKeyReleasePolicyNotFound, err)
+
+	policy := model.KeyReleasePolicy{
+		ImageID:            imageID,
+		RequiredAttributes: []model.RequiredAttribute{{Name: "TRUST_OS", Values: []string{"true"}}},
+	}
+	assert.NoError(repo.Store(&policy))
+	// storing a policy again replaces it
+	policy.RequiredAttributes = append(policy.RequiredAttributes, model.RequiredAttribute{Name: "TAG_Location", Values: []string{"Portland", "Hillsboro"}})
+	assert.NoError(repo.Store(&policy))
+	assert.NoError(repo.Store(&model.KeyReleasePolicy{
+		ImageID:            otherImageID,
+		RequiredAttributes: []model.RequiredAttribute{{Name: "FEATURE_TPM", Values: []string{"true"}}},
+	}))
+
+	stored, err := repo.RetrieveByImageID(imageID)
+	if assert.NoError(err) {
+		assert.Equal(policy.RequiredAttributes, stored.RequiredAttributes)
+		assert.False(stored.UpdatedAt.IsZero())
+	}
+
+	// the policies of all the images encrypted with the key apply, whatever the case of the key ID
+	policies, err := repo.RetrieveByKeyID(strings.ToUpper(keyID))
+	assert.NoError(err)
+	assert.Len(policies, 2)
+	policies, err = repo.RetrieveByKeyID(uuid.New().String())
+	assert.NoError(err)
+	assert.Empty(policies)
+
+	err = repo.Store(&model.KeyReleasePolicy{ImageID: uuid.New().String(), RequiredAttributes: policy.RequiredAttributes})
+	assert.True(errors.Is(err, repository.ErrKeyReleasePolicyImageNotFound))
+
+	assert.NoError(repo.DeleteByImageID(imageID))
+	assert.Equal(repository.ErrKeyReleasePolicyNotFound, repo.DeleteByImageID(imageID))
+	// the policy of an image is deleted with the image
+	assert.NoError(wlsDB.ImageRepository().DeleteByUUID(otherImageID))
+	policies, err = repo.RetrieveByKeyID(keyID)
+	assert.NoError(err)
+	assert.Empty(policies)
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package postgres
+
+import (
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+type keyReleaseRepo struct {
+	db *gorm.DB
+}
+
+var (
+	keyReleaseCreatedAt    = column("created_at")
+	keyReleaseHardwareUUID = column("hardware_uuid")
+	keyReleaseImageID      = column("image_id")
+	keyReleaseKeyID        = column("key_id")
+	keyReleasePrincipal    = column("principal")
+	keyReleaseDecision     = column("decision")
+)
+
+func (repo keyReleaseRepo) Create(release *model.KeyRelease) error {
+	log.Trace("repository/postgres/key_release_repository:Create() Entering")
+	defer log.Trace("repository/postgres/key_release_repository:Create() Leaving")
+
+	if release == nil {
+		return errors.New("repository/postgres/key_release_repository:Create() key release must not be nil")
+	}
+	entity := keyReleaseEntity{
+		Principal:       release.Principal,
+		HardwareUUID:    release.HardwareUUID,
+		ImageID:         release.ImageID,
+		KeyID:           release.KeyID,
+		Decision:        release.Decision,
+		DenialReason:    release.DenialReason,
+		SamlAssertionID: release.SamlAssertionID,
+	}
+	if err := repo.db.Create(&entity).Error; err != nil {
+		return errors.Wrap(err, "repository/postgres/key_release_repository:Create() Failed to create key release")
+	}
+	release.ID = entity.ID
+	release.CreatedAt = entity.CreatedAt
+	return nil
+}
+
+// keyReleasesQuery narrows the query down to the key releases matching the filter criteria
+func keyReleasesQuery(filter repository.KeyReleaseFilter, db *gorm.DB) (*gorm.DB, error) {
+	log.Trace("repository/postgres/key_release_repository:keyReleasesQuery() Entering")
+	defer log.Trace("repository/postgres/key_release_repository:keyReleasesQuery() Leaving")
+
+	where := &whereClause{}
+	if filter.HardwareUUID != "" {
+		where.equals(keyReleaseHardwareUUID, filter.HardwareUUID)
+	}
+	if filter.ImageID != "" {
+		where.equals(keyReleaseImageID, filter.ImageID)
+	}
+	if filter.KeyID != "" {
+		where.equals(keyReleaseKeyID, filter.KeyID)
+	}
+	if filter.Principal != "" {
+		where.equals(keyReleasePrincipal, filter.Principal)
+	}
+	if filter.Decision != "" {
+		where.equals(keyReleaseDecision, filter.Decision)
+	}
+	// created_at has no time zone, so the dates are compared as they were formatted
+	if filter.FromDate != "" {
+		fromDate, err := parseTime(filter.FromDate)
+		if err != nil {
+			return nil, err
+		}
+		where.atLeast(keyReleaseCreatedAt, fromDate.Format(dateFormatString))
+	}
+	if filter.ToDate != "" {
+		toDate, err := parseTime(filter.ToDate)
+		if err != nil {
+			return nil, err
+		}
+		where.atMost(keyReleaseCreatedAt, toDate.Format(dateFormatString))
+	}
+	return where.apply(db.Model(&keyReleaseEntity{})), nil
+}
+
+func (repo keyReleaseRepo) RetrieveByFilterCriteria(filter repository.KeyReleaseFilter) ([]model.KeyRelease, error) {
+	log.Trace("repository/postgres/key_release_repository:RetrieveByFilterCriteria() Entering")
+	defer log.Trace("repository/postgres/key_release_repository:RetrieveByFilterCriteria() Leaving")
+
+	query, err := keyReleasesQuery(filter, repo.db)
+	if err != nil {
+		return nil, err
+	}
+	sortOrder := repository.SortAscending
+	if filter.SortOrder == repository.SortDescending {
+		sortOrder = repository.SortDescending
+	}
+	// key releases recorded at the same time are ordered by ID, so that pages do not overlap
+	query = query.Order(string(keyReleaseCreatedAt) + " " + sortOrder).Order("id " + sortOrder)
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+	var entities []keyReleaseEntity
+	if err := query.Find(&entities).Error; err != nil {
+		return nil, errors.Wrap(err, "repository/postgres/key_release_repository:RetrieveByFilterCriteria() Failed to retrieve key releases")
+	}
+	releases := make([]model.KeyRelease, len(entities))
+	for i := range entities {
+		releases[i] = entities[i].KeyRelease()
+	}
+	return releases, nil
+}
+
+func (repo keyReleaseRepo) CountByFilterCriteria(filter repository.KeyReleaseFilter) (int, error) {
+	log.Trace("repository/postgres/key_release_repository:CountByFilterCriteria() Entering")
+	defer log.Trace("repository/postgres/key_release_repository:CountByFilterCriteria() Leaving")
+
+	query, err := keyReleasesQuery(filter, repo.db)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := query.Count(&count).Error; err != nil {
+		return 0, errors.Wrap(err, "repository/postgres/key_release_repository:CountByFilterCriteria() Failed to count key releases")
+	}
+	return count, nil
+}
@@ -0,0 +1,76 @@
+// +build integration
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package postgres
+
+import (
+	"github.com/google/uuid"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyReleaseRepository(t *testing.T) {
+	log.Trace("repository/postgres/key_release_repository_integration_test:TestKeyReleaseRepository() Entering")
+	defer log.Trace("repository/postgres/key_release_repository_integration_test:TestKeyReleaseRepository() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.KeyReleaseRepository()
+
+	hwid := uuid.New().String()
+	keyID := uuid.New().String()
+	decisions := []string{model.KeyReleaseReleased, model.KeyReleaseDenied, model.KeyReleaseReleased}
+	for _, decision := range decisions {
+		release := model.KeyRelease{
+			Principal:       "global_admin_user",
+			HardwareUUID:    hwid,
+			KeyID:           keyID,
+			Decision:        decision,
+			SamlAssertionID: "HostTrustAssertion",
+		}
+		if decision == model.KeyReleaseDenied {
+			release.DenialReason = "Host is untrusted"
+		}
+		assert.NoError(repo.Create(&release))
+		assert.NotEmpty(release.ID)
+		assert.False(release.CreatedAt.IsZero())
+	}
+
+	releases, err := repo.RetrieveByFilterCriteria(repository.KeyReleaseFilter{HardwareUUID: hwid, Limit: 10})
+	assert.NoError(err)
+	if assert.Len(releases, 3) {
+		// the oldest releases come first unless sorted in descending order
+		assert.Equal(model.KeyReleaseDenied, releases[1].Decision)
+		assert.Equal("Host is untrusted", releases[1].DenialReason)
+		assert.Equal(keyID, releases[0].KeyID)
+		assert.Equal("HostTrustAssertion", releases[0].SamlAssertionID)
+	}
+	descending, err := repo.RetrieveByFilterCriteria(repository.KeyReleaseFilter{HardwareUUID: hwid, Limit: 10, SortOrder: repository.SortDescending})
+	assert.NoError(err)
+	if assert.Len(descending, 3) && len(releases) == 3 {
+		assert.Equal(releases[2].ID, descending[0].ID)
+	}
+
+	filter := repository.KeyReleaseFilter{KeyID: keyID, Decision: model.KeyReleaseReleased, Limit: 1, Offset: 1}
+	releases, err = repo.RetrieveByFilterCriteria(filter)
+	assert.NoError(err)
+	assert.Len(releases, 1)
+	count, err := repo.CountByFilterCriteria(filter)
+	assert.NoError(err)
+	assert.Equal(2, count)
+
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02T15:04:05")
+	count, err = repo.CountByFilterCriteria(repository.KeyReleaseFilter{HardwareUUID: hwid, FromDate: tomorrow})
+	assert.NoError(err)
+	assert.Zero(count)
+	count, err = repo.CountByFilterCriteria(repository.KeyReleaseFilter{HardwareUUID: hwid, ToDate: tomorrow, Principal: "global_admin_user"})
+	assert.NoError(err)
+	assert.Equal(3, count)
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package postgres
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+const createSchemaMigrations = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer PRIMARY KEY,
+	description text NOT NULL,
+	applied_at timestamp with time zone NOT NULL
+)`
+
+// migrationsLockID identifies the advisory lock that serializes the migrations run by concurrent service instances
+const migrationsLockID = 6873201
+
+// ErrSchemaTooNew is returned when the database schema is newer than the latest schema this service works with
+var ErrSchemaTooNew = errors.New("database schema is newer than the schema supported by this version of workload-service")
+
+// MigrationStatus is a migration of the database schema, and when it was applied. AppliedAt is nil if it was not.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+}
+
+// Migrate applies the migrations missing from the database schema
+func (pd PostgresDatabase) Migrate() error {
+	log.Trace("repository/postgres/migrate:Migrate() Entering")
+	defer log.Trace("repository/postgres/migrate:Migrate() Leaving")
+	return pd.MigrateTo(LatestSchemaVersion())
+}
+
+// MigrateTo applies or reverts migrations until the database schema is at the given version, 0 reverting all of them.
+// It fails with ErrSchemaTooNew if the database schema is newer than the latest version.
+func (pd PostgresDatabase) MigrateTo(version int) error {
+	log.Trace("repository/postgres/migrate:MigrateTo() Entering")
+	defer log.Trace("repository/postgres/migrate:MigrateTo() Leaving")
+
+	if version < 0 || version > LatestSchemaVersion() {
+		return errors.Errorf("repository/postgres/migrate:MigrateTo() Unknown schema version %d", version)
+	}
+	current, err := pd.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return errors.Wrapf(ErrSchemaTooNew, "repository/postgres/migrate:MigrateTo() Database schema version %d, latest supported version %d", current, LatestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version > current && m.version <= version {
+			if err := migrateStep(pd.DB, m, true); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if m := migrations[i]; m.version <= current && m.version > version {
+			if err := migrateStep(pd.DB, m, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last migration applied to the database schema, 0 if none was
+func (pd PostgresDatabase) SchemaVersion() (int, error) {
+	log.Trace("repository/postgres/migrate:SchemaVersion() Entering")
+	defer log.Trace("repository/postgres/migrate:SchemaVersion() Leaving")
+	return schemaVersion(pd.DB)
+}
+
+// MigrationStatus returns the migrations known by this service and the migrations applied to the database schema,
+// ordered by version
+func (pd PostgresDatabase) MigrationStatus() ([]MigrationStatus, error) {
+	log.Trace("repository/postgres/migrate:MigrationStatus() Entering")
+	defer log.Trace("repository/postgres/migrate:MigrationStatus() Leaving")
+
+	if err := pd.DB.Exec(createSchemaMigrations).Error; err != nil {
+		return nil, errors.Wrap(err, "repository/postgres/migrate:MigrationStatus() Failed to create schema_migrations table")
+	}
+	rows, err := pd.DB.Raw("SELECT version, description, applied_at FROM schema_migrations ORDER BY version").Rows()
+	if err != nil {
+		return nil, errors.Wrap(err, "repository/postgres/migrate:MigrationStatus() Failed to retrieve applied migrations")
+	}
+	defer rows.Close()
+
+	var status []MigrationStatus
+	next := 0
+	for rows.Next() {
+		var applied MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&applied.Version, &applied.Description, &appliedAt); err != nil {
+			return nil, errors.Wrap(err, "repository/postgres/migrate:MigrationStatus() Failed to read applied migration")
+		}
+		applied.AppliedAt = &appliedAt
+		for ; next < len(migrations) && migrations[next].version < applied.Version; next++ {
+			status = append(status, MigrationStatus{Version: migrations[next].version, Description: migrations[next].description})
+		}
+		if next < len(migrations) && migrations[next].version == applied.Version {
+			next++
+		}
+		status = append(status, applied)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "repository/postgres/migrate:MigrationStatus() Failed to retrieve applied migrations")
+	}
+	for ; next < len(migrations); next++ {
+		status = append(status, MigrationStatus{Version: migrations[next].version, Description: migrations[next].description})
+	}
+	return status, nil
+}
+
+func schemaVersion(db *gorm.DB) (int, error) {
+	if err := db.Exec(createSchemaMigrations).Error; err != nil {
+		return 0, errors.Wrap(err, "repository/postgres/migrate:schemaVersion() Failed to create schema_migrations table")
+	}
+	var version int
+	if err := db.Raw("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Row().Scan(&version); err != nil {
+		return 0, errors.Wrap(err, "repository/postgres/migrate:schemaVersion() Failed to retrieve schema version")
+	}
+	return version, nil
+}
+
+// migrateStep applies or reverts a migration and records it in schema_migrations, in a single transaction
+func migrateStep(db *gorm.DB, m migration, up bool) error {
+	log.Trace("repository/postgres/migrate:migrateStep() Entering")
+	defer log.Trace("repository/postgres/migrate:migrateStep() Leaving")
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errors.Wrap(tx.Error, "repository/postgres/migrate:migrateStep() Failed to begin transaction")
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	// another service instance may have migrated the schema while waiting for the lock
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationsLockID).Error; err != nil {
+		return errors.Wrap(err, "repository/postgres/migrate:migrateStep() Failed to lock schema migrations")
+	}
+	current, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if (up && current >= m.version) || (!up && current < m.version) {
+		return nil
+	}
+
+	// migrations are run as they are, without gorm replacing placeholders in them
+	if up {
+		log.Infof("repository/postgres/migrate:migrateStep() Applying migration %d: %s", m.version, m.description)
+		if _, err := tx.CommonDB().Exec(m.up); err != nil {
+			return errors.Wrapf(err, "repository/postgres/migrate:migrateStep() Failed to apply migration %d", m.version)
+		}
+		err = tx.Exec("INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)", m.version, m.description, time.Now()).Error
+	} else {
+		log.Infof("repository/postgres/migrate:migrateStep() Reverting migration %d: %s", m.version, m.description)
+		if _, err := tx.CommonDB().Exec(m.down); err != nil {
+			return errors.Wrapf(err, "repository/postgres/migrate:migrateStep() Failed to revert migration %d", m.version)
+		}
+		err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.version).Error
+	}
+	if err != nil {
+		return errors.Wrapf(err, "repository/postgres/migrate:migrateStep() Failed to record migration %d", m.version)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.Wrapf(err, "repository/postgres/migrate:migrateStep() Failed to commit migration %d", m.version)
+	}
+	return nil
+}
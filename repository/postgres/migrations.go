@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package postgres
+
+// migration is a numbered change of the database schema, with the SQL statements applying and reverting it
+type migration struct {
+	version     int
+	description string
+	up          string
+	down        string
+}
+
+// migrations are the schema changes in the order they are applied. Released migrations must never be modified,
+// schema changes are added as new migrations at the end of the list.
+// The first migration matches the schema created by previous releases with gorm AutoMigrate, so databases created by
+// these releases are migrated as if they were created by it.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create flavors, images and reports tables",
+		up: `
+CREATE TABLE IF NOT EXISTS flavors (
+	id uuid PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	label text NOT NULL UNIQUE,
+	flavor_part text NOT NULL,
+	content jsonb NOT NULL,
+	signature text
+);
+CREATE TABLE IF NOT EXISTS images (
+	id uuid PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+CREATE TABLE IF NOT EXISTS image_flavors (
+	image_id uuid REFERENCES images(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	flavor_id uuid REFERENCES flavors(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	PRIMARY KEY (image_id, flavor_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS image_flavor_index ON image_flavors (image_id, flavor_id);
+CREATE TABLE IF NOT EXISTS reports (
+	id uuid PRIMARY KEY,
+	created_at timestamp,
+	expires_on timestamp,
+	instance_id uuid NOT NULL,
+	saml text,
+	trust_report jsonb NOT NULL,
+	signed_data jsonb NOT NULL
+);`,
+		down: `
+DROP TABLE IF EXISTS reports;
+DROP TABLE IF EXISTS image_flavors;
+DROP TABLE IF EXISTS images;
+DROP TABLE IF EXISTS flavors;`,
+	},
+	{
+		version:     2,
+		description: "add the signer of reports",
+		up: `
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS signer text;`,
+		down: `
+ALTER TABLE reports DROP COLUMN IF EXISTS signer;`,
+	},
+	{
+		version:     3,
+		description: "add indexed host, image and trust status columns to reports",
+		up: `
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS host_hardware_uuid text;
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS image_id text;
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS trusted boolean;
+UPDATE reports SET
+	host_hardware_uuid = COALESCE(trust_report -> 'instance_manifest' -> 'instance_info' ->> 'host_hardware_uuid', ''),
+	image_id = COALESCE(trust_report -> 'instance_manifest' -> 'instance_info' ->> 'image_id', ''),
+	trusted = COALESCE((trust_report ->> 'trusted')::boolean, FALSE)
+WHERE host_hardware_uuid IS NULL;
+CREATE INDEX IF NOT EXISTS idx_reports_instance_id ON reports (instance_id);
+CREATE INDEX IF NOT EXISTS idx_reports_host_hardware_uuid ON reports (host_hardware_uuid);
+CREATE INDEX IF NOT EXISTS idx_reports_image_id ON reports (image_id);
+CREATE INDEX IF NOT EXISTS idx_reports_trusted ON reports (trusted);`,
+		down: `
+DROP INDEX IF EXISTS idx_reports_trusted;
+DROP INDEX IF EXISTS idx_reports_image_id;
+DROP INDEX IF EXISTS idx_reports_host_hardware_uuid;
+DROP INDEX IF EXISTS idx_reports_instance_id;
+ALTER TABLE reports DROP COLUMN IF EXISTS trusted;
+ALTER TABLE reports DROP COLUMN IF EXISTS image_id;
+ALTER TABLE reports DROP COLUMN IF EXISTS host_hardware_uuid;`,
+	},
+	{
+		version:     4,
+		description: "add the signed reports as submitted",
+		up: `
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS signed_report bytea;`,
+		down: `
+ALTER TABLE reports DROP COLUMN IF EXISTS signed_report;`,
+	},
+	{
+		version:     5,
+		description: "add the key release policies of images",
+		up: `
+CREATE TABLE IF NOT EXISTS key_release_policies (
+	image_id uuid PRIMARY KEY REFERENCES images(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	created_at timestamp,
+	updated_at timestamp,
+	required_attributes jsonb NOT NULL
+);`,
+		down: `
+DROP TABLE IF EXISTS key_release_policies;`,
+	},
+	{
+		version:     6,
+		description: "add the audit trail of key releases",
+		up: `
+CREATE TABLE IF NOT EXISTS key_releases (
+	id uuid PRIMARY KEY,
+	created_at timestamp NOT NULL,
+	principal text,
+	hardware_uuid text NOT NULL,
+	image_id text,
+	key_id text,
+	decision text NOT NULL,
+	denial_reason text,
+	saml_assertion_id text
+);
+CREATE INDEX IF NOT EXISTS idx_key_releases_created_at ON key_releases (created_at);
+CREATE INDEX IF NOT EXISTS idx_key_releases_hardware_uuid ON key_releases (hardware_uuid);
+CREATE INDEX IF NOT EXISTS idx_key_releases_image_id ON key_releases (image_id);
+CREATE INDEX IF NOT EXISTS idx_key_releases_key_id ON key_releases (key_id);`,
+		down: `
+DROP TABLE IF EXISTS key_releases;`,
+	},
+}
+
+// LatestSchemaVersion returns the version of the database schema this service works with
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
@@ -0,0 +1,26 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsNumberedInOrder(t *testing.T) {
+	log.Trace("repository/postgres/migrations_test:TestMigrationsNumberedInOrder() Entering")
+	defer log.Trace("repository/postgres/migrations_test:TestMigrationsNumberedInOrder() Leaving")
+	assert := assert.New(t)
+
+	for i, m := range migrations {
+		assert.Equal(i+1, m.version)
+		assert.NotEmpty(m.description, "migration %d", m.version)
+		assert.NotEmpty(strings.TrimSpace(m.up), "migration %d", m.version)
+		assert.NotEmpty(strings.TrimSpace(m.down), "migration %d", m.version)
+	}
+	assert.Equal(len(migrations), LatestSchemaVersion())
+}
@@ -17,18 +17,6 @@ type PostgresDatabase struct {
 	DB *gorm.DB
 }
 
-func (pd PostgresDatabase) Migrate() error {
-	log.Trace("repository/postgres/postgres_database:Migrate() Entering")
-	defer log.Trace("repository/postgres/postgres_database:Migrate() Leaving")
-
-	pd.DB.AutoMigrate(&flavorEntity{}, &imageEntity{}, &reportEntity{})
-	pd.DB.Table("image_flavors").
-		AddForeignKey("image_id", "images(id)", "CASCADE", "CASCADE").
-		AddForeignKey("flavor_id", "flavors(id)", "CASCADE", "CASCADE").
-		AddUniqueIndex("image_flavor_index", "image_id", "flavor_id")
-	return nil
-}
-
 func (pd PostgresDatabase) Driver() *gorm.DB {
 	log.Trace("repository/postgres/postgres_database:Driver() Entering")
 	defer log.Trace("repository/postgres/postgres_database:Driver() Leaving")
@@ -53,6 +41,18 @@ func (pd PostgresDatabase) ImageRepository() repository.ImageRepository {
 	return imageRepo{db: pd.DB}
 }
 
+func (pd PostgresDatabase) KeyReleasePolicyRepository() repository.KeyReleasePolicyRepository {
+	log.Trace("repository/postgres/postgres_database:KeyReleasePolicyRepository() Entering")
+	defer log.Trace("repository/postgres/postgres_database:KeyReleasePolicyRepository() Leaving")
+	return keyReleasePolicyRepo{db: pd.DB}
+}
+
+func (pd PostgresDatabase) KeyReleaseRepository() repository.KeyReleaseRepository {
+	log.Trace("repository/postgres/postgres_database:KeyReleaseRepository() Entering")
+	defer log.Trace("repository/postgres/postgres_database:KeyReleaseRepository() Leaving")
+	return keyReleaseRepo{db: pd.DB}
+}
+
 func (pd *PostgresDatabase) Close() {
 	log.Trace("repository/postgres/postgres_database:Close() Entering")
 	defer log.Trace("repository/postgres/postgres_database:Close() Leaving")
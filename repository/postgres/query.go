@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package postgres
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// column is a column, or an expression over columns, that a query can be filtered on. Columns are only ever
+// built from constants in this package, never from request input, which is always bound as a query parameter.
+type column string
+
+// jsonbText is the text value at the path of keys within a jsonb column
+func jsonbText(c column, keys ...string) column {
+	if len(keys) == 0 {
+		return c
+	}
+	expression := string(c)
+	for _, key := range keys[:len(keys)-1] {
+		expression += " -> " + quoteLiteral(key)
+	}
+	return column(expression + " ->> " + quoteLiteral(keys[len(keys)-1]))
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// whereClause composes the conditions of a WHERE clause, whose values are bound as query parameters
+type whereClause struct {
+	conditions []string
+	values     []interface{}
+}
+
+func (w *whereClause) add(c column, operator string, value interface{}) *whereClause {
+	w.conditions = append(w.conditions, string(c)+" "+operator+" ?")
+	w.values = append(w.values, value)
+	return w
+}
+
+// equals matches the rows where the column equals the value
+func (w *whereClause) equals(c column, value interface{}) *whereClause {
+	return w.add(c, "=", value)
+}
+
+// atLeast matches the rows where the column is greater than or equal to the value
+func (w *whereClause) atLeast(c column, value interface{}) *whereClause {
+	return w.add(c, ">=", value)
+}
+
+// atMost matches the rows where the column is less than or equal to the value
+func (w *whereClause) atMost(c column, value interface{}) *whereClause {
+	return w.add(c, "<=", value)
+}
+
+// never matches no row
+func (w *whereClause) never() *whereClause {
+	w.conditions = append(w.conditions, "FALSE")
+	return w
+}
+
+// String returns the conditions joined with AND, with a ? placeholder for each value
+func (w *whereClause) String() string {
+	return strings.Join(w.conditions, " AND ")
+}
+
+// apply narrows the query down to the rows matching all the conditions
+func (w *whereClause) apply(db *gorm.DB) *gorm.DB {
+	if len(w.conditions) == 0 {
+		return db
+	}
+	return db.Where(w.String(), w.values...)
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJsonbText(t *testing.T) {
+	log.Trace("repository/postgres/query_test:TestJsonbText() Entering")
+	defer log.Trace("repository/postgres/query_test:TestJsonbText() Leaving")
+	assert := assert.New(t)
+
+	assert.Equal(column("trust_report"), jsonbText("trust_report"))
+	assert.Equal(column("trust_report ->> 'trusted'"), jsonbText("trust_report", "trusted"))
+	assert.Equal(column("trust_report -> 'instance_manifest' -> 'instance_info' ->> 'instance_id'"), jsonbText("trust_report", "instance_manifest", "instance_info", "instance_id"))
+	assert.Equal(column("data ->> 'it''s'"), jsonbText("data", "it's"))
+}
+
+func TestWhereClauseBindsValues(t *testing.T) {
+	log.Trace("repository/postgres/query_test:TestWhereClauseBindsValues() Entering")
+	defer log.Trace("repository/postgres/query_test:TestWhereClauseBindsValues() Leaving")
+	assert := assert.New(t)
+
+	hostile := "' OR '1'='1"
+	where := &whereClause{}
+	where.equals(reportInstanceID, hostile).atLeast(reportCreatedAt, "2021-01-01 00:00:00").atMost(reportCreatedAt, "'; DROP TABLE reports; --")
+
+	assert.Equal("instance_id = ? AND created_at >= ? AND created_at <= ?", where.String())
+	assert.Equal([]interface{}{hostile, "2021-01-01 00:00:00", "'; DROP TABLE reports; --"}, where.values)
+	assert.NotContains(where.String(), hostile)
+}
+
+func TestEmptyWhereClause(t *testing.T) {
+	log.Trace("repository/postgres/query_test:TestEmptyWhereClause() Entering")
+	defer log.Trace("repository/postgres/query_test:TestEmptyWhereClause() Leaving")
+	assert := assert.New(t)
+
+	where := &whereClause{}
+	assert.Empty(where.String())
+	assert.Nil(where.apply(nil))
+
+	where.never()
+	assert.Equal("FALSE", where.String())
+	assert.Empty(where.values)
+}
@@ -22,10 +22,18 @@ type reportEntity struct {
 	CreatedAt time.Time `sql:"type:timestamp"`
 	ExpiresOn time.Time `sql:"type:timestamp"`
 	// normalize InstanceID
-	InstanceID  string `gorm:"type:uuid;not null"`
-	Saml        string
-	TrustReport postgres.Jsonb `gorm:"type:jsonb;not null"`
-	SignedData  postgres.Jsonb `gorm:"type:jsonb;not null"`
+	InstanceID string `gorm:"type:uuid;not null;index:idx_reports_instance_id"`
+	// HostHardwareUUID, ImageID and Trusted are copied from the trust report, so that reports are filtered without reading it
+	HostHardwareUUID string `gorm:"index:idx_reports_host_hardware_uuid"`
+	ImageID          string `gorm:"index:idx_reports_image_id"`
+	Trusted          bool   `gorm:"index:idx_reports_trusted"`
+	Saml             string
+	TrustReport      postgres.Jsonb `gorm:"type:jsonb;not null"`
+	SignedData       postgres.Jsonb `gorm:"type:jsonb;not null"`
+	// Signer is the subject of the certificate the report signature was verified with
+	Signer string
+	// SignedReport is the signed report as submitted, it is empty for the reports created before it was stored
+	SignedReport []byte `gorm:"type:bytea"`
 }
 
 func (re reportEntity) TableName() string {
@@ -38,12 +46,15 @@ func (re *reportEntity) BeforeCreate(scope *gorm.Scope) error {
 	log.Trace("repository/postgres/report_entity:BeforeCreate() Entering")
 	defer log.Trace("repository/postgres/report_entity:BeforeCreate() Leaving")
 
-	id, err := uuid.NewRandom()
-	if err != nil {
-		return errors.New("repository/postgres/report_entity:BeforeCreate() unable to create uuid")
-	}
-	if err := scope.SetColumn("id", id.String()); err != nil {
-		return errors.New("repository/postgres/report_entity:BeforeCreate() unable to set column value")
+	// reports are created with their own ID when they have one
+	if re.ID == "" {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return errors.New("repository/postgres/report_entity:BeforeCreate() unable to create uuid")
+		}
+		if err := scope.SetColumn("id", id.String()); err != nil {
+			return errors.New("repository/postgres/report_entity:BeforeCreate() unable to set column value")
+		}
 	}
 
 	if !json.Valid(re.TrustReport.RawMessage) {
@@ -84,6 +95,8 @@ func (re *reportEntity) unmarshal() (*model.Report, error) {
 		return nil, errors.Wrap(err, "repository/postgres/report_entity:unmarshal() Unable to unmarshal signed data")
 	}
 	report.ID = re.ID
+	report.Signer = re.Signer
+	report.SignedReport = re.SignedReport
 	return &report, nil
 }
 
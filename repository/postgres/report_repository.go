@@ -6,13 +6,15 @@ package postgres
 
 import (
 	"encoding/json"
-	"fmt"
+	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
 	"github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"intel/isecl/workload-service/v4/model"
 	"intel/isecl/workload-service/v4/repository"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,6 +25,19 @@ type reportRepo struct {
 const dateString string = "2006-01-02T15:04:05"
 const dateFormatString string = "2006-01-02 15:04:05"
 
+// uniqueViolation is the Postgres error code of a unique constraint violation
+const uniqueViolation pq.ErrorCode = "23505"
+
+// reportsPrimaryKey is the constraint of the primary key of the reports table
+const reportsPrimaryKey string = "reports_pkey"
+
+// isDuplicateReport tells whether an error is the violation of the primary key of the reports table, which happens
+// when a report with the same ID is created concurrently
+func isDuplicateReport(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && pqErr.Constraint == reportsPrimaryKey
+}
+
 func parseTime(strTime string) (time.Time, error) {
 	log.Trace("repository/postgres/report_repository:parseTime() Entering")
 	defer log.Trace("repository/postgres/report_repository:parseTime() Leaving")
@@ -45,12 +60,11 @@ func getReportModels(reportEntities []reportEntity) ([]model.Report, error) {
 	return ids, nil
 }
 
-func (repo reportRepo) RetrieveByFilterCriteria(filter repository.ReportFilter) ([]model.Report, error) {
-	log.Trace("repository/postgres/report_repository:RetrieveByFilterCriteria() Entering")
-	defer log.Trace("repository/postgres/report_repository:RetrieveByFilterCriteria() Leaving")
+// reportsQuery returns the query selecting the reports matching the filter criteria
+func reportsQuery(filter repository.ReportFilter, db *gorm.DB) (*gorm.DB, error) {
+	log.Trace("repository/postgres/report_repository:reportsQuery() Entering")
+	defer log.Trace("repository/postgres/report_repository:reportsQuery() Leaving")
 
-	db := repo.db
-	var reportEntities []reportEntity
 	var err error
 
 	instanceID := ""
@@ -107,89 +121,248 @@ func (repo reportRepo) RetrieveByFilterCriteria(filter repository.ReportFilter)
 		filterQuery = filter.Filter
 	}
 
+	db = db.Model(&reportEntity{})
+
 	//Only fetch the report since reportid is unique across the table
 	if len(reportID) > 0 {
-		db.Where("id = ?", reportID).Find(&reportEntities)
-		return getReportModels(reportEntities)
+		return db.Where("id = ?", reportID), nil
 	}
 
 	// fetch all the reports if filter=false
 	if !filterQuery {
-		db.Find(&reportEntities)
-		return getReportModels(reportEntities)
+		return db, nil
+	}
+	query := findReports(instanceID, hardwareUUID, toDate, fromDate, db)
+	if latestPerVM {
+		query = db.Where("id IN ?", latestReports(query).SubQuery())
 	}
-	return findReports(instanceID, hardwareUUID, toDate, fromDate, latestPerVM, db)
+	return query, nil
 }
 
-func findReports(instanceID string, hardwareUUID string, toDate time.Time, fromDate time.Time, latestPerVM bool, db *gorm.DB) ([]model.Report, error) {
-	log.Trace("repository/postgres/report_repository:findReports() Entering")
-	defer log.Trace("repository/postgres/report_repository:findReports() Leaving")
+// latestReports selects the ID of the newest report of each instance among the reports selected by the query.
+// Reports of an instance created at the same time are ordered by ID, so that the same report is always selected.
+func latestReports(query *gorm.DB) *gorm.DB {
+	return query.Select("DISTINCT ON (instance_id) id").Order("instance_id").Order("created_at desc").Order("id desc")
+}
+
+func (repo reportRepo) RetrieveByFilterCriteria(filter repository.ReportFilter) ([]model.Report, error) {
+	log.Trace("repository/postgres/report_repository:RetrieveByFilterCriteria() Entering")
+	defer log.Trace("repository/postgres/report_repository:RetrieveByFilterCriteria() Leaving")
 
 	var reportEntities []reportEntity
-	partialQueryString := ""
+	query, err := reportsQuery(filter, repo.db)
+	if err != nil {
+		return nil, err
+	}
 
-	if instanceID != "" {
-		partialQueryString = fmt.Sprintf("trust_report -> 'instance_manifest' -> 'instance_info' ->> 'instance_id' = '%s'", instanceID)
+	sortOrder := repository.SortAscending
+	if filter.SortOrder == repository.SortDescending {
+		sortOrder = repository.SortDescending
+	}
+	// reports created at the same time are ordered by ID, so that pages do not overlap
+	query = query.Order("created_at " + sortOrder).Order("id " + sortOrder)
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
 	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+	if err := query.Find(&reportEntities).Error; err != nil {
+		return nil, errors.Wrap(err, "repository/postgres/report_repository:RetrieveByFilterCriteria() Failed to retrieve reports")
+	}
+	return getReportModels(reportEntities)
+}
 
-	if hardwareUUID != "" {
-		if partialQueryString != "" {
-			partialQueryString = fmt.Sprintf("%s AND trust_report -> 'instance_manifest' -> 'instance_info' ->> 'host_hardware_uuid' = '%s'", partialQueryString, hardwareUUID)
-		} else {
-			partialQueryString = fmt.Sprintf("trust_report -> 'instance_manifest' -> 'instance_info' ->> 'host_hardware_uuid' = '%s'", hardwareUUID)
+func (repo reportRepo) RetrieveLatestByInstanceIDs(instanceIDs []string) ([]model.Report, error) {
+	log.Trace("repository/postgres/report_repository:RetrieveLatestByInstanceIDs() Entering")
+	defer log.Trace("repository/postgres/report_repository:RetrieveLatestByInstanceIDs() Leaving")
+
+	// instance_id is a uuid column, instance IDs that are not UUIDs have no report
+	var ids []string
+	for _, instanceID := range instanceIDs {
+		if id, err := uuid.Parse(instanceID); err == nil {
+			ids = append(ids, id.String())
 		}
 	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
 
-	if !fromDate.IsZero() {
-		if partialQueryString != "" {
-			partialQueryString = fmt.Sprintf("%s AND created_at >= CAST('%s' AS TIMESTAMP)", partialQueryString, fromDate.Format(dateFormatString))
-		} else {
-			partialQueryString = fmt.Sprintf("created_at >= CAST('%s' AS TIMESTAMP)", fromDate.Format(dateFormatString))
-		}
+	var reportEntities []reportEntity
+	query := repo.db.Model(&reportEntity{}).Where("instance_id IN (?)", ids)
+	if err := repo.db.Where("id IN ?", latestReports(query).SubQuery()).Find(&reportEntities).Error; err != nil {
+		return nil, errors.Wrap(err, "repository/postgres/report_repository:RetrieveLatestByInstanceIDs() Failed to retrieve reports")
 	}
+	return getReportModels(reportEntities)
+}
 
-	if !toDate.IsZero() {
-		if partialQueryString != "" {
-			partialQueryString = fmt.Sprintf("%s AND created_at <= CAST('%s' AS TIMESTAMP)", partialQueryString, toDate.Format(dateFormatString))
-		} else {
-			partialQueryString = fmt.Sprintf("created_at <= CAST('%s' AS TIMESTAMP)", toDate.Format(dateFormatString))
+func (repo reportRepo) RetrieveByIDs(ids []string) ([]model.Report, error) {
+	log.Trace("repository/postgres/report_repository:RetrieveByIDs() Entering")
+	defer log.Trace("repository/postgres/report_repository:RetrieveByIDs() Leaving")
+
+	// id is a uuid column, IDs that are not UUIDs have no report
+	var reportIDs []string
+	for _, id := range ids {
+		if parsed, err := uuid.Parse(id); err == nil {
+			reportIDs = append(reportIDs, parsed.String())
 		}
 	}
-
-	if latestPerVM {
-		db.Where(partialQueryString).Order("created_at desc").First(&reportEntities)
-		return getReportModels(reportEntities)
+	if len(reportIDs) == 0 {
+		return nil, nil
 	}
 
-	db.Where(partialQueryString).Find(&reportEntities)
+	var reportEntities []reportEntity
+	if err := repo.db.Where("id IN (?)", reportIDs).Find(&reportEntities).Error; err != nil {
+		return nil, errors.Wrap(err, "repository/postgres/report_repository:RetrieveByIDs() Failed to retrieve reports")
+	}
 	return getReportModels(reportEntities)
 }
 
-func (repo reportRepo) Create(report *model.Report) error {
-	log.Trace("repository/postgres/report_repository:Create() Entering")
-	defer log.Trace("repository/postgres/report_repository:Create() Leaving")
+func (repo reportRepo) CountByFilterCriteria(filter repository.ReportFilter) (int, error) {
+	log.Trace("repository/postgres/report_repository:CountByFilterCriteria() Entering")
+	defer log.Trace("repository/postgres/report_repository:CountByFilterCriteria() Leaving")
+
+	query, err := reportsQuery(filter, repo.db)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := query.Count(&count).Error; err != nil {
+		return 0, errors.Wrap(err, "repository/postgres/report_repository:CountByFilterCriteria() Failed to count reports")
+	}
+	return count, nil
+}
+
+var (
+	reportInstanceID   = column("instance_id")
+	reportHardwareUUID = column("host_hardware_uuid")
+	reportCreatedAt    = column("created_at")
+)
+
+// findReports narrows the query down to the reports matching the instance, host and creation dates
+func findReports(instanceID string, hardwareUUID string, toDate time.Time, fromDate time.Time, db *gorm.DB) *gorm.DB {
+	log.Trace("repository/postgres/report_repository:findReports() Entering")
+	defer log.Trace("repository/postgres/report_repository:findReports() Leaving")
+
+	where := &whereClause{}
+	if instanceID != "" {
+		// instance_id is a uuid column, instance IDs that are not UUIDs match no report
+		if id, err := uuid.Parse(instanceID); err == nil {
+			where.equals(reportInstanceID, id.String())
+		} else {
+			where.never()
+		}
+	}
+	if hardwareUUID != "" {
+		where.equals(reportHardwareUUID, hardwareUUID)
+	}
+	// created_at has no time zone, so the dates are compared as they were formatted
+	if !fromDate.IsZero() {
+		where.atLeast(reportCreatedAt, fromDate.Format(dateFormatString))
+	}
+	if !toDate.IsZero() {
+		where.atMost(reportCreatedAt, toDate.Format(dateFormatString))
+	}
+	return where.apply(db)
+}
 
+// newReportEntity returns the entity storing the report
+func newReportEntity(report *model.Report) (*reportEntity, error) {
 	if report == nil {
-		return errors.New("repository/postgres/report_repository:Create() cannot create nil report")
+		return nil, errors.Wrap(repository.ErrReportInvalid, "repository/postgres/report_repository:newReportEntity() cannot create nil report")
 	}
 	if len(report.Manifest.InstanceInfo.InstanceID) == 0 && len(report.Manifest.InstanceInfo.HostHardwareUUID) == 0 && len(report.Manifest.InstanceInfo.ImageID) == 0 {
-		return errors.New("repository/postgres/report_repository:Create() instance uuid cannot be empty")
+		return nil, errors.Wrap(repository.ErrReportInvalid, "repository/postgres/report_repository:newReportEntity() instance uuid cannot be empty")
+	}
+	if _, err := uuid.Parse(report.Manifest.InstanceInfo.InstanceID); err != nil {
+		return nil, errors.Wrapf(repository.ErrReportInvalid, "repository/postgres/report_repository:newReportEntity() instance uuid %q is not a UUID", report.Manifest.InstanceInfo.InstanceID)
+	}
+	id := ""
+	if report.ID != "" {
+		parsed, err := uuid.Parse(report.ID)
+		if err != nil {
+			return nil, errors.Wrapf(repository.ErrReportInvalid, "repository/postgres/report_repository:newReportEntity() report ID %q is not a UUID", report.ID)
+		}
+		id = parsed.String()
 	}
 	reportJSON, err := json.Marshal(report.InstanceTrustReport)
 	if err != nil {
-		return errors.Wrap(err, "repository/postgres/report_repository:Create() failed to marshal instance trust report to JSON")
+		return nil, errors.Wrapf(repository.ErrReportInvalid, "repository/postgres/report_repository:newReportEntity() failed to marshal instance trust report to JSON: %v", err)
 	}
 	signedJSON, err := json.Marshal(report.SignedData)
 	if err != nil {
-		return errors.Wrap(err, "repository/postgres/report_repository:Create() failed to marshal signed data to JSON")
+		return nil, errors.Wrapf(repository.ErrReportInvalid, "repository/postgres/report_repository:newReportEntity() failed to marshal signed data to JSON: %v", err)
+	}
+	return &reportEntity{
+		ID:               id,
+		TrustReport:      postgres.Jsonb{RawMessage: reportJSON},
+		SignedData:       postgres.Jsonb{RawMessage: signedJSON},
+		InstanceID:       report.Manifest.InstanceInfo.InstanceID,
+		HostHardwareUUID: report.Manifest.InstanceInfo.HostHardwareUUID,
+		ImageID:          report.Manifest.InstanceInfo.ImageID,
+		Trusted:          report.Trusted,
+		Signer:           report.Signer,
+		SignedReport:     report.SignedReport,
+		ExpiresOn:        report.ExpiresOn,
+	}, nil
+}
+
+func (repo reportRepo) Create(report *model.Report) error {
+	log.Trace("repository/postgres/report_repository:Create() Entering")
+	defer log.Trace("repository/postgres/report_repository:Create() Leaving")
+	return repo.CreateMany([]*model.Report{report})
+}
+
+func (repo reportRepo) CreateMany(reports []*model.Report) error {
+	log.Trace("repository/postgres/report_repository:CreateMany() Entering")
+	defer log.Trace("repository/postgres/report_repository:CreateMany() Leaving")
+
+	entities := make([]*reportEntity, len(reports))
+	var ids []string
+	seen := make(map[string]bool)
+	for i, report := range reports {
+		entity, err := newReportEntity(report)
+		if err != nil {
+			return errors.Wrapf(err, "repository/postgres/report_repository:CreateMany() Invalid report %d", i)
+		}
+		if entity.ID != "" {
+			if seen[entity.ID] {
+				return errors.Wrapf(repository.ErrReportAlreadyExists, "repository/postgres/report_repository:CreateMany() Report %s is created twice", entity.ID)
+			}
+			seen[entity.ID] = true
+			ids = append(ids, entity.ID)
+		}
+		entities[i] = entity
+	}
+
+	tx := repo.db.Begin()
+	if tx.Error != nil {
+		return errors.Wrapf(repository.ErrReportBackend, "repository/postgres/report_repository:CreateMany() Failed to begin transaction: %v", tx.Error)
+	}
+	defer tx.RollbackUnlessCommitted()
+	if len(ids) > 0 {
+		var existing []string
+		if err := tx.Model(&reportEntity{}).Where("id IN (?)", ids).Pluck("id", &existing).Error; err != nil {
+			return errors.Wrapf(repository.ErrReportBackend, "repository/postgres/report_repository:CreateMany() Failed to look up report IDs: %v", err)
+		}
+		if len(existing) > 0 {
+			return errors.Wrapf(repository.ErrReportAlreadyExists, "repository/postgres/report_repository:CreateMany() Report %s already exists", existing[0])
+		}
+	}
+	for _, entity := range entities {
+		if err := tx.Create(entity).Error; err != nil {
+			// the report was created by a concurrent request since its ID was looked up
+			if isDuplicateReport(err) {
+				return errors.Wrapf(repository.ErrReportAlreadyExists, "repository/postgres/report_repository:CreateMany() Report %s already exists", entity.ID)
+			}
+			return errors.Wrapf(repository.ErrReportBackend, "repository/postgres/report_repository:CreateMany() Failed to create instance trust reports: %v", err)
+		}
 	}
-	if err := repo.db.Create(
-		&reportEntity{
-			TrustReport: postgres.Jsonb{RawMessage: reportJSON},
-			SignedData:  postgres.Jsonb{RawMessage: signedJSON},
-			InstanceID:  report.Manifest.InstanceInfo.InstanceID,
-		}).Error; err != nil {
-		return errors.Wrap(err, "repository/postgres/report_repository:Create() Failed to create instance trust report")
+	if err := tx.Commit().Error; err != nil {
+		return errors.Wrapf(repository.ErrReportBackend, "repository/postgres/report_repository:CreateMany() Failed to commit instance trust reports: %v", err)
+	}
+	for i, entity := range entities {
+		reports[i].ID = entity.ID
 	}
 	return nil
 }
@@ -199,3 +372,83 @@ func (repo reportRepo) DeleteByReportID(uuid string) error {
 	defer log.Trace("repository/postgres/report_repository:DeleteByReportID() Leaving")
 	return repo.db.Delete(&reportEntity{ID: uuid}).Error
 }
+
+// summaryGroups are the columns the reports are grouped by in the summary
+var summaryGroups = map[string]column{
+	repository.SummaryByHardwareUUID: reportHardwareUUID,
+	repository.SummaryByImageID:      column("image_id"),
+	repository.SummaryByPolicyName:   jsonbText("trust_report", "policy_name"),
+}
+
+func (repo reportRepo) Summarize(filter repository.ReportFilter, groupBy string) ([]model.ReportSummaryGroup, error) {
+	log.Trace("repository/postgres/report_repository:Summarize() Entering")
+	defer log.Trace("repository/postgres/report_repository:Summarize() Leaving")
+
+	group, ok := summaryGroups[groupBy]
+	if !ok {
+		return nil, errors.Errorf("repository/postgres/report_repository:Summarize() Unknown summary group %s", groupBy)
+	}
+	// the trust status of an instance is the one of its latest report
+	filter.Filter = true
+	filter.LatestPerVM = "true"
+	filter.ReportID = ""
+	query, err := reportsQuery(filter, repo.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []model.ReportSummaryGroup
+	err = query.Select("COALESCE(" + string(group) + ", '') AS key, COUNT(*) FILTER (WHERE trusted) AS trusted, COUNT(*) FILTER (WHERE NOT trusted) AS untrusted").
+		Group("1").Order("key").Scan(&groups).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "repository/postgres/report_repository:Summarize() Failed to summarize reports")
+	}
+	return groups, nil
+}
+
+// expiredReports narrows the query down to the reports to purge. Reports created before the retention policy have
+// no expiry date, they expire according to their creation date instead, and only once a maximum age is configured.
+func expiredReports(expiry repository.ReportExpiry, db *gorm.DB) *gorm.DB {
+	var conditions []string
+	var values []interface{}
+	if !expiry.ExpiredOn.IsZero() {
+		noExpiry := time.Time{}.Format(dateFormatString)
+		conditions = append(conditions,
+			"(expires_on > ? AND expires_on <= ?)",
+			"((expires_on IS NULL OR expires_on <= ?) AND created_at <= ?)")
+		values = append(values,
+			noExpiry, expiry.ExpiredOn.Format(dateFormatString),
+			noExpiry, expiry.CreatedOn.Format(dateFormatString))
+	}
+	if expiry.MaxPerInstance > 0 {
+		conditions = append(conditions, "id IN (SELECT id FROM (SELECT id, row_number() OVER (PARTITION BY instance_id ORDER BY created_at DESC, id DESC) AS position FROM reports) AS ranked WHERE position > ?)")
+		values = append(values, expiry.MaxPerInstance)
+	}
+	if len(conditions) == 0 {
+		return db.Where("FALSE")
+	}
+	return db.Where(strings.Join(conditions, " OR "), values...)
+}
+
+func (repo reportRepo) CountExpired(expiry repository.ReportExpiry) (int, error) {
+	log.Trace("repository/postgres/report_repository:CountExpired() Entering")
+	defer log.Trace("repository/postgres/report_repository:CountExpired() Leaving")
+
+	var count int
+	if err := expiredReports(expiry, repo.db.Model(&reportEntity{})).Count(&count).Error; err != nil {
+		return 0, errors.Wrap(err, "repository/postgres/report_repository:CountExpired() Failed to count expired reports")
+	}
+	return count, nil
+}
+
+func (repo reportRepo) DeleteExpired(expiry repository.ReportExpiry, limit int) (int, error) {
+	log.Trace("repository/postgres/report_repository:DeleteExpired() Entering")
+	defer log.Trace("repository/postgres/report_repository:DeleteExpired() Leaving")
+
+	oldest := expiredReports(expiry, repo.db.Model(&reportEntity{})).Select("id").Order("created_at").Order("id").Limit(limit)
+	result := repo.db.Where("id IN ?", oldest.SubQuery()).Delete(&reportEntity{})
+	if result.Error != nil {
+		return 0, errors.Wrap(result.Error, "repository/postgres/report_repository:DeleteExpired() Failed to delete expired reports")
+	}
+	return int(result.RowsAffected), nil
+}
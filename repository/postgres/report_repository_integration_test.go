@@ -0,0 +1,589 @@
+// +build integration
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"intel/isecl/lib/common/v4/pkg/instance"
+	"intel/isecl/lib/verifier/v4"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+
+	// Import Postgres driver
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+)
+
+func setupDatabase(t *testing.T) PostgresDatabase {
+	_, ci := os.LookupEnv("CI")
+	var host string
+	if ci {
+		host = "postgres"
+	} else {
+		host = "localhost"
+	}
+	db, err := gorm.Open("postgres", fmt.Sprintf("host=%s port=5432 user=runner dbname=wls password=test sslmode=disable", host))
+	if err != nil {
+		t.Fatal("could not open DB")
+	}
+	wlsDB := PostgresDatabase{DB: db.Debug()}
+	wlsDB.Migrate()
+	return wlsDB
+}
+
+func createReport(t *testing.T, repo repository.ReportRepository, instanceID, hardwareUUID string) {
+	createReportExpiringOn(t, repo, instanceID, hardwareUUID, time.Time{})
+}
+
+func createReportExpiringOn(t *testing.T, repo repository.ReportRepository, instanceID, hardwareUUID string, expiresOn time.Time) {
+	report := model.Report{
+		InstanceTrustReport: verifier.InstanceTrustReport{
+			Manifest: instance.Manifest{
+				InstanceInfo: instance.Info{
+					InstanceID:       instanceID,
+					HostHardwareUUID: hardwareUUID,
+					ImageID:          "670f263e-b34e-4e07-a520-40ac9a89f62d",
+				},
+				ImageEncrypted: true,
+			},
+			PolicyName: "Intel VM Policy",
+			Trusted:    true,
+		},
+		ExpiresOn: expiresOn,
+	}
+	if err := repo.Create(&report); err != nil {
+		t.Fatal("could not seed report")
+	}
+}
+
+func TestReportFilterHostileValues(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_integration_test:TestReportFilterHostileValues() Entering")
+	defer log.Trace("repository/postgres/report_repository_integration_test:TestReportFilterHostileValues() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.ReportRepository()
+
+	instanceID := uuid.New().String()
+	createReport(t, repo, instanceID, "59eed8f0-28c5-4070-91fc-f5e2e5443f6b")
+	seeded, err := repo.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: instanceID, Filter: true})
+	if err != nil || len(seeded) != 1 {
+		t.Fatal("could not retrieve seeded report")
+	}
+	defer repo.DeleteByReportID(seeded[0].ID)
+
+	hostileValues := []string{
+		"' OR '1'='1",
+		"' OR 1=1 --",
+		instanceID + "' OR ''='",
+		"'; DROP TABLE reports; --",
+		"x' UNION SELECT * FROM reports --",
+		`\'; SELECT pg_sleep(10); --`,
+	}
+	for _, hostile := range hostileValues {
+		filters := []repository.ReportFilter{
+			{InstanceID: hostile, Filter: true, LatestPerVM: "false"},
+			{HardwareUUID: hostile, Filter: true, LatestPerVM: "false"},
+			{InstanceID: instanceID, HardwareUUID: hostile, Filter: true, LatestPerVM: "false"},
+		}
+		for _, filter := range filters {
+			reports, err := repo.RetrieveByFilterCriteria(filter)
+			assert.NoError(err, "filter %+v", filter)
+			assert.Empty(reports, "filter %+v", filter)
+
+			count, err := repo.CountByFilterCriteria(filter)
+			assert.NoError(err, "filter %+v", filter)
+			assert.Zero(count, "filter %+v", filter)
+		}
+
+		// report IDs are bound to a uuid column, so values that are not UUIDs are rejected by the database
+		reports, _ := repo.RetrieveByFilterCriteria(repository.ReportFilter{ReportID: hostile, Filter: true})
+		assert.Empty(reports)
+
+		// dates are parsed before they reach the query
+		_, err = repo.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: instanceID, FromDate: hostile, Filter: true})
+		assert.Error(err)
+		_, err = repo.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: instanceID, ToDate: hostile, Filter: true})
+		assert.Error(err)
+	}
+
+	// the reports table is intact and the seeded report is still found with the same filters
+	reports, err := repo.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: instanceID, HardwareUUID: "59eed8f0-28c5-4070-91fc-f5e2e5443f6b", Filter: true, LatestPerVM: "false", FromDate: "2000-01-01T00:00:00"})
+	assert.NoError(err)
+	if assert.Len(reports, 1) {
+		assert.Equal(seeded[0].ID, reports[0].ID)
+	}
+}
+
+func TestLatestReportPerVM(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_integration_test:TestLatestReportPerVM() Entering")
+	defer log.Trace("repository/postgres/report_repository_integration_test:TestLatestReportPerVM() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.ReportRepository()
+
+	// three VMs on the first host and two on the second, with three reports each
+	hosts := []string{uuid.New().String(), uuid.New().String()}
+	instances := map[string][]string{
+		hosts[0]: {uuid.New().String(), uuid.New().String(), uuid.New().String()},
+		hosts[1]: {uuid.New().String(), uuid.New().String()},
+	}
+	for i := 0; i < 3; i++ {
+		for _, host := range hosts {
+			for _, instanceID := range instances[host] {
+				createReport(t, repo, instanceID, host)
+			}
+		}
+	}
+
+	// the newest report of each VM
+	latest := make(map[string]string)
+	for _, host := range hosts {
+		for _, instanceID := range instances[host] {
+			reports, err := repo.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: instanceID, Filter: true, LatestPerVM: "false", SortOrder: repository.SortDescending})
+			if err != nil || len(reports) != 3 {
+				t.Fatal("could not retrieve seeded reports")
+			}
+			latest[instanceID] = reports[0].ID
+			defer func(reports []model.Report) {
+				for _, report := range reports {
+					repo.DeleteByReportID(report.ID)
+				}
+			}(reports)
+		}
+	}
+
+	for _, host := range hosts {
+		filter := repository.ReportFilter{HardwareUUID: host, Filter: true, LatestPerVM: "true"}
+		reports, err := repo.RetrieveByFilterCriteria(filter)
+		assert.NoError(err)
+		assert.Len(reports, len(instances[host]))
+		for _, report := range reports {
+			assert.Equal(latest[report.Manifest.InstanceInfo.InstanceID], report.ID)
+		}
+		count, err := repo.CountByFilterCriteria(filter)
+		assert.NoError(err)
+		assert.Equal(len(instances[host]), count)
+	}
+
+	// latest_per_vm is the default, and is combined with the instance filter
+	instanceID := instances[hosts[0]][1]
+	reports, err := repo.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: instanceID, HardwareUUID: hosts[0], Filter: true})
+	assert.NoError(err)
+	if assert.Len(reports, 1) {
+		assert.Equal(latest[instanceID], reports[0].ID)
+	}
+
+	// the latest reports are paginated
+	var pages []model.Report
+	for offset := 0; offset < 3; offset += 2 {
+		reports, err := repo.RetrieveByFilterCriteria(repository.ReportFilter{HardwareUUID: hosts[0], Filter: true, Limit: 2, Offset: offset})
+		assert.NoError(err)
+		pages = append(pages, reports...)
+	}
+	if assert.Len(pages, 3) {
+		seen := make(map[string]bool)
+		for _, report := range pages {
+			assert.False(seen[report.ID], "report returned in more than one page")
+			seen[report.ID] = true
+			assert.Equal(latest[report.Manifest.InstanceInfo.InstanceID], report.ID)
+		}
+	}
+
+	// reports created after the to_date are ignored
+	reports, err = repo.RetrieveByFilterCriteria(repository.ReportFilter{HardwareUUID: hosts[1], ToDate: "2000-01-01T00:00:00", Filter: true})
+	assert.NoError(err)
+	assert.Empty(reports)
+}
+
+func TestDeleteExpiredReports(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_integration_test:TestDeleteExpiredReports() Entering")
+	defer log.Trace("repository/postgres/report_repository_integration_test:TestDeleteExpiredReports() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.ReportRepository()
+	hardwareUUID := uuid.New().String()
+	now := time.Now()
+
+	// one expired report and two unexpired reports
+	expiring := uuid.New().String()
+	createReportExpiringOn(t, repo, expiring, hardwareUUID, now.Add(-time.Hour))
+	createReportExpiringOn(t, repo, expiring, hardwareUUID, now.Add(time.Hour))
+	createReportExpiringOn(t, repo, expiring, hardwareUUID, now.Add(time.Hour))
+	// reports created before the retention policy have no expiry date
+	legacy := uuid.New().String()
+	createReport(t, repo, legacy, hardwareUUID)
+	if err := wlsDB.DB.Model(&reportEntity{}).Where("instance_id = ?", legacy).UpdateColumn("created_at", now.AddDate(0, 0, -10)).Error; err != nil {
+		t.Fatal("could not backdate report")
+	}
+	recent := uuid.New().String()
+	createReport(t, repo, recent, hardwareUUID)
+	// more reports than kept for a single instance
+	frequent := uuid.New().String()
+	for i := 0; i < 5; i++ {
+		createReportExpiringOn(t, repo, frequent, hardwareUUID, now.Add(time.Hour))
+	}
+	frequentReports, err := repo.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: frequent, Filter: true, LatestPerVM: "false", SortOrder: repository.SortDescending})
+	if err != nil || len(frequentReports) != 5 {
+		t.Fatal("could not retrieve seeded reports")
+	}
+	defer func() {
+		remaining, _ := repo.RetrieveByFilterCriteria(repository.ReportFilter{HardwareUUID: hardwareUUID, Filter: true, LatestPerVM: "false"})
+		for _, report := range remaining {
+			repo.DeleteByReportID(report.ID)
+		}
+	}()
+
+	// without a maximum age, only the reports beyond the newest of their instance expire
+	count, err := repo.CountExpired(repository.ReportExpiry{MaxPerInstance: 3})
+	assert.NoError(err)
+	assert.True(count >= 2)
+	count, err = repo.CountExpired(repository.ReportExpiry{})
+	assert.NoError(err)
+	assert.Zero(count)
+
+	expiry := repository.ReportExpiry{ExpiredOn: now, CreatedOn: now.AddDate(0, 0, -7), MaxPerInstance: 3}
+	count, err = repo.CountExpired(expiry)
+	assert.NoError(err)
+	assert.True(count >= 4)
+
+	// batches are deleted until the expired reports are all gone
+	deleted, err := repo.DeleteExpired(expiry, 1)
+	assert.NoError(err)
+	assert.Equal(1, deleted)
+	for deleted > 0 {
+		deleted, err = repo.DeleteExpired(expiry, 100)
+		assert.NoError(err)
+	}
+	count, err = repo.CountExpired(expiry)
+	assert.NoError(err)
+	assert.Zero(count)
+
+	remaining := func(instanceID string) []model.Report {
+		reports, err := repo.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: instanceID, Filter: true, LatestPerVM: "false", SortOrder: repository.SortDescending})
+		assert.NoError(err)
+		return reports
+	}
+	assert.Len(remaining(expiring), 2)
+	assert.Empty(remaining(legacy))
+	assert.Len(remaining(recent), 1)
+	// the newest reports of the instance are kept
+	kept := remaining(frequent)
+	if assert.Len(kept, 3) {
+		for i := range kept {
+			assert.Equal(frequentReports[i].ID, kept[i].ID)
+		}
+	}
+}
+
+func TestReportColumnsBackfilled(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_integration_test:TestReportColumnsBackfilled() Entering")
+	defer log.Trace("repository/postgres/report_repository_integration_test:TestReportColumnsBackfilled() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.ReportRepository()
+
+	instanceID := uuid.New().String()
+	hardwareUUID := uuid.New().String()
+	createReport(t, repo, instanceID, hardwareUUID)
+	var created reportEntity
+	if err := wlsDB.DB.Where("instance_id = ?", instanceID).First(&created).Error; err != nil {
+		t.Fatal("could not retrieve seeded report")
+	}
+	defer repo.DeleteByReportID(created.ID)
+	assert.Equal(hardwareUUID, created.HostHardwareUUID)
+	assert.Equal("670f263e-b34e-4e07-a520-40ac9a89f62d", created.ImageID)
+	assert.True(created.Trusted)
+
+	// reports created before the columns existed are backfilled from their trust report
+	assert.NoError(wlsDB.MigrateTo(2))
+	assert.NoError(wlsDB.Migrate())
+	var migrated reportEntity
+	assert.NoError(wlsDB.DB.Where("id = ?", created.ID).First(&migrated).Error)
+	assert.Equal(hardwareUUID, migrated.HostHardwareUUID)
+	assert.Equal("670f263e-b34e-4e07-a520-40ac9a89f62d", migrated.ImageID)
+	assert.True(migrated.Trusted)
+
+	reports, err := repo.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: instanceID, HardwareUUID: hardwareUUID, Filter: true})
+	assert.NoError(err)
+	if assert.Len(reports, 1) {
+		assert.Equal(created.ID, reports[0].ID)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_integration_test:TestMigrations() Entering")
+	defer log.Trace("repository/postgres/report_repository_integration_test:TestMigrations() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+
+	version, err := wlsDB.SchemaVersion()
+	assert.NoError(err)
+	assert.Equal(LatestSchemaVersion(), version)
+	status, err := wlsDB.MigrationStatus()
+	assert.NoError(err)
+	if assert.Len(status, len(migrations)) {
+		for i, m := range status {
+			assert.Equal(migrations[i].version, m.Version)
+			assert.NotNil(m.AppliedAt)
+		}
+	}
+
+	// the last migration is reverted and applied again
+	assert.NoError(wlsDB.MigrateTo(LatestSchemaVersion() - 1))
+	status, err = wlsDB.MigrationStatus()
+	assert.NoError(err)
+	if assert.Len(status, len(migrations)) {
+		assert.Nil(status[len(status)-1].AppliedAt)
+	}
+	assert.NoError(wlsDB.Migrate())
+	assert.NoError(wlsDB.Migrate())
+	version, err = wlsDB.SchemaVersion()
+	assert.NoError(err)
+	assert.Equal(LatestSchemaVersion(), version)
+
+	assert.Error(wlsDB.MigrateTo(LatestSchemaVersion() + 1))
+	assert.Error(wlsDB.MigrateTo(-1))
+
+	// a database migrated by a newer version of the service is left alone
+	newer := LatestSchemaVersion() + 1
+	if err := wlsDB.DB.Exec("INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)", newer, "from the future", time.Now()).Error; err != nil {
+		t.Fatal("could not record newer migration")
+	}
+	defer wlsDB.DB.Exec("DELETE FROM schema_migrations WHERE version = ?", newer)
+	err = wlsDB.Migrate()
+	assert.True(errors.Is(err, ErrSchemaTooNew))
+	status, err = wlsDB.MigrationStatus()
+	assert.NoError(err)
+	if assert.Len(status, len(migrations)+1) {
+		assert.Equal(newer, status[len(migrations)].Version)
+	}
+}
+
+func TestReportSummary(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_integration_test:TestReportSummary() Entering")
+	defer log.Trace("repository/postgres/report_repository_integration_test:TestReportSummary() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.ReportRepository()
+	hosts := []string{uuid.New().String(), uuid.New().String()}
+	images := []string{uuid.New().String(), uuid.New().String()}
+	instances := []string{uuid.New().String(), uuid.New().String(), uuid.New().String()}
+
+	// the trust status of each instance is the one of its latest report
+	seed := []struct {
+		instanceID, hardwareUUID, imageID string
+		trusted                           bool
+	}{
+		{instances[0], hosts[0], images[0], true},
+		{instances[0], hosts[0], images[0], false},
+		{instances[1], hosts[0], images[0], true},
+		{instances[2], hosts[1], images[1], false},
+		{instances[2], hosts[1], images[1], true},
+	}
+	for _, s := range seed {
+		report := model.Report{
+			InstanceTrustReport: verifier.InstanceTrustReport{
+				Manifest: instance.Manifest{
+					InstanceInfo: instance.Info{InstanceID: s.instanceID, HostHardwareUUID: s.hardwareUUID, ImageID: s.imageID},
+				},
+				PolicyName: "Intel VM Policy",
+				Trusted:    s.trusted,
+			},
+		}
+		if err := repo.Create(&report); err != nil {
+			t.Fatal("could not seed report")
+		}
+	}
+	defer func() {
+		for _, instanceID := range instances {
+			reports, _ := repo.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: instanceID, Filter: true, LatestPerVM: "false"})
+			for _, report := range reports {
+				repo.DeleteByReportID(report.ID)
+			}
+		}
+	}()
+
+	summarize := func(groupBy string) map[string]model.ReportSummaryGroup {
+		groups, err := repo.Summarize(repository.ReportFilter{}, groupBy)
+		assert.NoError(err)
+		byKey := make(map[string]model.ReportSummaryGroup)
+		for _, group := range groups {
+			byKey[group.Key] = group
+		}
+		return byKey
+	}
+	byHost := summarize(repository.SummaryByHardwareUUID)
+	assert.Equal(model.ReportSummaryGroup{Key: hosts[0], Trusted: 1, Untrusted: 1}, byHost[hosts[0]])
+	assert.Equal(model.ReportSummaryGroup{Key: hosts[1], Trusted: 1}, byHost[hosts[1]])
+	byImage := summarize(repository.SummaryByImageID)
+	assert.Equal(model.ReportSummaryGroup{Key: images[0], Trusted: 1, Untrusted: 1}, byImage[images[0]])
+	assert.Equal(model.ReportSummaryGroup{Key: images[1], Trusted: 1}, byImage[images[1]])
+	byPolicy := summarize(repository.SummaryByPolicyName)
+	assert.Contains(byPolicy, "Intel VM Policy")
+
+	// reports created after the to_date are ignored
+	groups, err := repo.Summarize(repository.ReportFilter{HardwareUUID: hosts[0], ToDate: "2000-01-01T00:00:00"}, repository.SummaryByHardwareUUID)
+	assert.NoError(err)
+	assert.Empty(groups)
+
+	_, err = repo.Summarize(repository.ReportFilter{}, "instance_id")
+	assert.Error(err)
+}
+
+func TestCreateManyReports(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_integration_test:TestCreateManyReports() Entering")
+	defer log.Trace("repository/postgres/report_repository_integration_test:TestCreateManyReports() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.ReportRepository()
+	hardwareUUID := uuid.New().String()
+	instances := []string{uuid.New().String(), uuid.New().String()}
+
+	var reports []*model.Report
+	for i, instanceID := range []string{instances[0], instances[1], instances[0]} {
+		reports = append(reports, &model.Report{
+			InstanceTrustReport: verifier.InstanceTrustReport{
+				Manifest: instance.Manifest{
+					InstanceInfo: instance.Info{InstanceID: instanceID, HostHardwareUUID: hardwareUUID, ImageID: uuid.New().String()},
+				},
+				PolicyName: "Intel VM Policy",
+				Trusted:    i == 2,
+			},
+		})
+	}
+	assert.NoError(repo.CreateMany(reports))
+	defer func() {
+		for _, report := range reports {
+			repo.DeleteByReportID(report.ID)
+		}
+	}()
+	for _, report := range reports {
+		assert.NotEmpty(report.ID)
+	}
+
+	latest, err := repo.RetrieveLatestByInstanceIDs([]string{instances[0], instances[1], "not-a-uuid"})
+	assert.NoError(err)
+	latestIDs := make(map[string]string)
+	for _, report := range latest {
+		latestIDs[report.Manifest.InstanceInfo.InstanceID] = report.ID
+	}
+	assert.Equal(map[string]string{instances[0]: reports[2].ID, instances[1]: reports[1].ID}, latestIDs)
+
+	latest, err = repo.RetrieveLatestByInstanceIDs(nil)
+	assert.NoError(err)
+	assert.Empty(latest)
+}
+
+func TestCreateReportErrors(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_integration_test:TestCreateReportErrors() Entering")
+	defer log.Trace("repository/postgres/report_repository_integration_test:TestCreateReportErrors() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.ReportRepository()
+
+	newReport := func(id, instanceID string) *model.Report {
+		return &model.Report{
+			ID: id,
+			InstanceTrustReport: verifier.InstanceTrustReport{
+				Manifest:   instance.Manifest{InstanceInfo: instance.Info{InstanceID: instanceID, HostHardwareUUID: uuid.New().String()}},
+				PolicyName: "Intel VM Policy",
+			},
+		}
+	}
+	id := uuid.New().String()
+	report := newReport(id, uuid.New().String())
+	assert.NoError(repo.Create(report))
+	defer repo.DeleteByReportID(id)
+	assert.Equal(id, report.ID)
+	stored, err := repo.RetrieveByIDs([]string{id, uuid.New().String(), "not-a-uuid"})
+	assert.NoError(err)
+	if assert.Len(stored, 1) {
+		assert.Equal(id, stored[0].ID)
+	}
+
+	assert.True(errors.Is(repo.Create(newReport(id, uuid.New().String())), repository.ErrReportAlreadyExists))
+	assert.True(errors.Is(repo.Create(newReport("report-1", uuid.New().String())), repository.ErrReportInvalid))
+	assert.True(errors.Is(repo.Create(newReport("", "instance-1")), repository.ErrReportInvalid))
+
+	// a duplicate ID fails all the reports of the transaction
+	other := uuid.New().String()
+	err = repo.CreateMany([]*model.Report{newReport(other, uuid.New().String()), newReport(id, uuid.New().String())})
+	assert.True(errors.Is(err, repository.ErrReportAlreadyExists))
+	stored, err = repo.RetrieveByIDs([]string{other})
+	assert.NoError(err)
+	assert.Empty(stored)
+}
+
+func TestCreateReportConcurrently(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_integration_test:TestCreateReportConcurrently() Entering")
+	defer log.Trace("repository/postgres/report_repository_integration_test:TestCreateReportConcurrently() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.ReportRepository()
+
+	// the reports with the same ID created at once all pass the ID lookup, only one of them is created
+	id := uuid.New().String()
+	defer repo.DeleteByReportID(id)
+	errs := make([]error, 10)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = repo.Create(&model.Report{
+				ID: id,
+				InstanceTrustReport: verifier.InstanceTrustReport{
+					Manifest:   instance.Manifest{InstanceInfo: instance.Info{InstanceID: uuid.New().String(), HostHardwareUUID: uuid.New().String()}},
+					PolicyName: "Intel VM Policy",
+				},
+			})
+		}(i)
+	}
+	wg.Wait()
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+		} else {
+			assert.True(errors.Is(err, repository.ErrReportAlreadyExists), err.Error())
+		}
+	}
+	assert.Equal(1, created)
+}
+
+func TestSignedReportStored(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_integration_test:TestSignedReportStored() Entering")
+	defer log.Trace("repository/postgres/report_repository_integration_test:TestSignedReportStored() Leaving")
+	assert := assert.New(t)
+	wlsDB := setupDatabase(t)
+	repo := wlsDB.ReportRepository()
+
+	signedReport := []byte("{ \"data\": \"e30=\",\n  \"signature\": \"c2ln\" }\n")
+	report := model.Report{
+		InstanceTrustReport: verifier.InstanceTrustReport{
+			Manifest: instance.Manifest{InstanceInfo: instance.Info{InstanceID: uuid.New().String(), HostHardwareUUID: uuid.New().String()}},
+		},
+		SignedReport: signedReport,
+	}
+	assert.NoError(repo.Create(&report))
+	defer repo.DeleteByReportID(report.ID)
+
+	stored, err := repo.RetrieveByIDs([]string{report.ID})
+	assert.NoError(err)
+	if assert.Len(stored, 1) {
+		assert.Equal(signedReport, stored[0].SignedReport)
+	}
+}
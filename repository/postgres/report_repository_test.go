@@ -0,0 +1,26 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package postgres
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDuplicateReport(t *testing.T) {
+	log.Trace("repository/postgres/report_repository_test:TestIsDuplicateReport() Entering")
+	defer log.Trace("repository/postgres/report_repository_test:TestIsDuplicateReport() Leaving")
+	assert := assert.New(t)
+
+	assert.True(isDuplicateReport(&pq.Error{Code: "23505", Constraint: "reports_pkey"}))
+	assert.True(isDuplicateReport(errors.Wrap(&pq.Error{Code: "23505", Constraint: "reports_pkey"}, "insert failed")))
+	// only the primary key of the reports table means the report already exists
+	assert.False(isDuplicateReport(&pq.Error{Code: "23505", Constraint: "key_releases_pkey"}))
+	assert.False(isDuplicateReport(&pq.Error{Code: "23503", Constraint: "reports_pkey"}))
+	assert.False(isDuplicateReport(errors.New("connection reset")))
+}
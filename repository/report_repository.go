@@ -5,7 +5,19 @@
 package repository
 
 import (
+	"errors"
 	"intel/isecl/workload-service/v4/model"
+	"time"
+)
+
+// The errors returned when creating reports wrap one of these errors, callers match them on errors.Cause
+var (
+	// ErrReportAlreadyExists error when a report with the same ID already exists in the database
+	ErrReportAlreadyExists = errors.New("report already exists with ID")
+	// ErrReportInvalid error when a report cannot be stored, because its ID or its instance is not valid
+	ErrReportInvalid = errors.New("report is not valid")
+	// ErrReportBackend error when the database fails to store or retrieve reports
+	ErrReportBackend = errors.New("report database operation failed")
 )
 
 // ReportRepository defines an interface that provides persistence operations for a Flavor.
@@ -14,14 +26,36 @@ import (
 // Stronger typing rather than cast everything from an interface{}
 type ReportRepository interface {
 	// C
+	// Create creates the report with its ID, or with a new random ID when it has none
 	Create(r *model.Report) error
+	// CreateMany creates all the reports in a single transaction, or none of them
+	CreateMany(reports []*model.Report) error
 	// R
 	RetrieveByFilterCriteria(filter ReportFilter) ([]model.Report, error)
+	// RetrieveByIDs retrieves the reports with the given IDs, IDs without report are omitted
+	RetrieveByIDs(ids []string) ([]model.Report, error)
+	// RetrieveLatestByInstanceIDs retrieves the latest report of each instance, instances without report are omitted
+	RetrieveLatestByInstanceIDs(instanceIDs []string) ([]model.Report, error)
+	CountByFilterCriteria(filter ReportFilter) (int, error)
+	CountExpired(expiry ReportExpiry) (int, error)
+	// Summarize counts the trusted and untrusted instances of each group, according to the latest report of each
+	// instance matching the filter criteria
+	Summarize(filter ReportFilter, groupBy string) ([]model.ReportSummaryGroup, error)
 	// D
 	DeleteByReportID(uuid string) error
+	// DeleteExpired deletes at most limit of the oldest expired reports, and returns the number of reports deleted
+	DeleteExpired(expiry ReportExpiry, limit int) (int, error)
 }
 
-// ReportFilter struct defines all the filter criterias to query the reports table
+// Sort orders of the reports by creation time
+const (
+	SortAscending  = "asc"
+	SortDescending = "desc"
+)
+
+// ReportFilter struct defines all the filter criterias to query the reports table.
+// Limit and Offset select a page of the matching reports sorted by creation time in SortOrder, ascending by default.
+// They are ignored when counting the matching reports.
 type ReportFilter struct {
 	InstanceID   string `json:"instance_id,omitempty"`
 	ReportID     string `json:"report_id,omitempty"`
@@ -31,4 +65,24 @@ type ReportFilter struct {
 	FromDate     string `json:"from_date,omitempty"`
 	NumOfDays    int    `json:"no_of_days,omitempty"`
 	Filter       bool   `json:"filter,omitempty"`
+	Limit        int    `json:"limit,omitempty"`
+	Offset       int    `json:"offset,omitempty"`
+	SortOrder    string `json:"sort_order,omitempty"`
+}
+
+// Groups of the report summary
+const (
+	SummaryByHardwareUUID = "hardware_uuid"
+	SummaryByImageID      = "image_id"
+	SummaryByPolicyName   = "policy_name"
+)
+
+// ReportExpiry struct defines the reports to purge: the reports that expired on or before ExpiredOn, the reports
+// without an expiry date created on or before CreatedOn, and the reports older than the MaxPerInstance newest reports
+// of their instance. Reports don't expire by date when ExpiredOn is zero, and MaxPerInstance is ignored when not
+// positive.
+type ReportExpiry struct {
+	ExpiredOn      time.Time
+	CreatedOn      time.Time
+	MaxPerInstance int
 }
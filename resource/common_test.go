@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"intel/isecl/lib/common/v4/middleware"
+	"intel/isecl/workload-service/v4/config"
 	"intel/isecl/workload-service/v4/constants"
 	"intel/isecl/workload-service/v4/repository"
 	"intel/isecl/workload-service/v4/repository/postgres"
@@ -19,6 +20,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
+	"gopkg.in/yaml.v3"
 )
 
 //The JWT signature verifier certificate at ./workload-service/mockJWTDir/jwtVerifier.pem and the
@@ -27,6 +29,26 @@ const BearerToken = "eyJhbGciOiJSUzM4NCIsImtpZCI6IjRiNDA3MmYyNWQ1ZDk1ZWE2NjlmZWR
 
 var cacheTime, _ = time.ParseDuration(constants.JWTCertsCacheTime)
 
+// testKBSEndpoints are the KBS of the key URLs used by the tests
+const testKBSEndpoints = `
+- url: https://kbs.server.com:9443/v1
+- url: https://kbs.server.com/v1/
+- url: http://kbs.server.com:20080/v1/
+- url: http://localhost:1337/v1/
+- url: http://localhost:6337/v1/
+`
+
+func TestMain(m *testing.M) {
+	if err := yaml.Unmarshal([]byte(testKBSEndpoints), &config.Configuration.KBSEndpoints); err != nil {
+		fmt.Fprintln(os.Stderr, "could not configure the test KBS endpoints:", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// mockFlavorSigningCertsDir holds the certificate matching the mock flavor signing key
+const mockFlavorSigningCertsDir = "../repository/mock/"
+
 func setupServer(t *testing.T) *mux.Router {
 	log.Trace("resource/common_test:setupServer() Entering")
 	defer log.Trace("resource/common_test:setupServer() Leaving")
@@ -45,6 +67,7 @@ func setupServer(t *testing.T) *mux.Router {
 	r.Use(middleware.NewTokenAuth("../mockJWTDir", "../mockJWTDir", mockRetrieveJWTSigningCerts, cacheTime))
 	wlsDB := postgres.PostgresDatabase{DB: db.Debug()}
 	wlsDB.Migrate()
+	flavorSigningCertsDir = mockFlavorSigningCertsDir
 	SetFlavorsEndpoints(r.PathPrefix("/wls/v1/flavors").Subrouter(), wlsDB)
 	SetImagesEndpoints(r.PathPrefix("/wls/v1/images").Subrouter(), wlsDB)
 	SetReportsEndpoints(r.PathPrefix("/wls/v1/reports").Subrouter(), wlsDB)
@@ -62,9 +85,13 @@ func setupMockServer(db repository.WlsDatabase) *mux.Router {
 	defer log.Trace("resource/common_test:setupMockServer() Leaving")
 	r := mux.NewRouter()
 	r.Use(middleware.NewTokenAuth("../mockJWTDir", "../mockJWTDir", mockRetrieveJWTSigningCerts, cacheTime))
+	flavorSigningCertsDir = mockFlavorSigningCertsDir
 	SetFlavorsEndpoints(r.PathPrefix("/wls/v1/flavors").Subrouter(), db)
 	SetImagesEndpoints(r.PathPrefix("/wls/v1/images").Subrouter(), db)
 	SetReportsEndpoints(r.PathPrefix("/wls/v1/reports").Subrouter(), db)
+	SetKeysEndpoints(r.PathPrefix("/wls/v1/keys").Subrouter(), db)
+	SetKeyReleasesEndpoints(r.PathPrefix("/wls/v1/key-releases").Subrouter(), db)
+	SetEventsEndpoints(r.PathPrefix("/wls/v1/events").Subrouter())
 	return r
 }
 
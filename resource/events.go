@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/lib/common/v4/validation"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/events"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SetEventsEndpoints sets endpoints for /events
+func SetEventsEndpoints(r *mux.Router) {
+	log.Trace("resource/events:SetEventsEndpoints() Entering")
+	defer log.Trace("resource/events:SetEventsEndpoints() Leaving")
+	r.HandleFunc("", errorHandler(requiresPermission(streamEvents(), []string{constants.ReportsSearch}))).Methods("GET")
+}
+
+// Streams the trust changes of instances as Server-Sent Events, optionally filtered by image or host.
+// The stream ends before the write timeout of the server, clients reconnect with the Last-Event-ID header
+// to receive the events published in between.
+func streamEvents() endpointHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.Trace("resource/events:streamEvents() Entering")
+		defer log.Trace("resource/events:streamEvents() Leaving")
+
+		var filter events.Filter
+		if imageID := r.URL.Query().Get("image_id"); imageID != "" {
+			if err := validation.ValidateUUIDv4(imageID); err != nil {
+				log.WithError(err).Errorf("resource/events:streamEvents() %s : Invalid image UUID format", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to subscribe to events - invalid image_id", StatusCode: http.StatusBadRequest}
+			}
+			filter.ImageID = imageID
+		}
+		if hardwareUUID := r.URL.Query().Get("hardware_uuid"); hardwareUUID != "" {
+			if err := validation.ValidateHardwareUUID(hardwareUUID); err != nil {
+				log.WithError(err).Errorf("resource/events:streamEvents() %s : Invalid hardware UUID format", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to subscribe to events - invalid hardware_uuid", StatusCode: http.StatusBadRequest}
+			}
+			filter.HardwareUUID = hardwareUUID
+		}
+		var lastID uint64
+		if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+			id, err := strconv.ParseUint(lastEventID, 10, 64)
+			if err != nil {
+				log.WithError(err).Errorf("resource/events:streamEvents() %s : Invalid Last-Event-ID header", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to subscribe to events - invalid Last-Event-ID", StatusCode: http.StatusBadRequest}
+			}
+			lastID = id
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			log.Errorf("resource/events:streamEvents() %s : Response writer does not support streaming", message.AppRuntimeErr)
+			return &endpointError{Message: "Failed to subscribe to events - streaming unsupported", StatusCode: http.StatusInternalServerError}
+		}
+
+		s := events.Subscribe(filter, lastID)
+		defer s.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: %d\n\n", time.Second.Milliseconds())
+		flusher.Flush()
+
+		var end <-chan time.Time
+		if d := streamDuration(); d > 0 {
+			end = time.After(d)
+		}
+		heartbeat := time.NewTicker(constants.EventStreamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return nil
+			case <-end:
+				return nil
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case e, ok := <-s.C:
+				if !ok {
+					// the client fell behind, it resumes from the last event it received when it reconnects
+					return nil
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					log.WithError(err).Errorf("resource/events:streamEvents() %s : Failed to encode event to JSON", message.AppRuntimeErr)
+					continue
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// streamDuration returns how long an event stream can last before the write timeout of the server closes the
+// connection, 0 if the server has no write timeout
+func streamDuration() time.Duration {
+	return config.Configuration.WriteTimeout * 9 / 10
+}
+
+// latestReport returns the latest report of an instance, nil if it has none or it could not be retrieved
+func latestReport(rr repository.ReportRepository, instanceID string) *model.Report {
+	reports, err := rr.RetrieveByFilterCriteria(repository.ReportFilter{InstanceID: instanceID, Filter: true, LatestPerVM: "true"})
+	if err != nil {
+		log.WithError(err).Warnf("resource/events:latestReport() Failed to retrieve the latest report of instance %s, its trust change will not be published", instanceID)
+		return nil
+	}
+	if len(reports) == 0 {
+		return nil
+	}
+	return &reports[0]
+}
+
+// publishTrustChange publishes the change of trust status between the previous and the new report of an instance
+func publishTrustChange(previous *model.Report, report *model.Report) {
+	if e, changed := events.DetectTrustChange(previous, report); changed {
+		e = events.Publish(e)
+		log.Infof("resource/events:publishTrustChange() Instance %s is now trusted: %t, published event %d", e.InstanceID, e.Trusted, e.ID)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"bufio"
+	"encoding/json"
+	"intel/isecl/workload-service/v4/events"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"intel/isecl/workload-service/v4/repository/mock"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// eventStream reads the events of a Server-Sent Events stream
+type eventStream struct {
+	resp    *http.Response
+	scanner *bufio.Scanner
+}
+
+func openEventStream(t *testing.T, server *httptest.Server, query string, lastEventID uint64) *eventStream {
+	req, _ := http.NewRequest("GET", server.URL+"/wls/v1/events?"+query, nil)
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	if lastEventID > 0 {
+		req.Header.Add("Last-Event-ID", strconv.FormatUint(lastEventID, 10))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("could not open event stream")
+	}
+	s := &eventStream{resp: resp, scanner: bufio.NewScanner(resp.Body)}
+	// the stream starts with the reconnection delay, once subscribed
+	if s.next(t) != "retry: 1000" {
+		t.Fatal("event stream did not start")
+	}
+	return s
+}
+
+// next returns the next block of lines of the stream
+func (s *eventStream) next(t *testing.T) string {
+	lines := make(chan string)
+	go func() {
+		var block []string
+		for s.scanner.Scan() {
+			if s.scanner.Text() == "" {
+				break
+			}
+			block = append(block, s.scanner.Text())
+		}
+		lines <- strings.Join(block, "\n")
+	}()
+	select {
+	case block := <-lines:
+		return block
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return ""
+	}
+}
+
+// nextEvent returns the next event of the stream
+func (s *eventStream) nextEvent(t *testing.T) events.TrustChange {
+	block := s.next(t)
+	var e events.TrustChange
+	for _, line := range strings.Split(block, "\n") {
+		if strings.HasPrefix(line, "data: ") {
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatalf("invalid event data: %s", line)
+			}
+		}
+	}
+	if !strings.HasPrefix(block, "id: "+strconv.FormatUint(e.ID, 10)+"\nevent: "+events.TrustChangedEvent+"\n") {
+		t.Fatalf("invalid event: %s", block)
+	}
+	return e
+}
+
+func TestStreamEvents(t *testing.T) {
+	log.Trace("resource/events_test:TestStreamEvents() Entering")
+	defer log.Trace("resource/events_test:TestStreamEvents() Leaving")
+	assert := assert.New(t)
+	server := httptest.NewServer(setupMockServer(new(mock.Database)))
+	defer server.Close()
+
+	stream := openEventStream(t, server, "hardware_uuid=59eed8f0-28c5-4070-91fc-f5e2e5443f6b", 0)
+	assert.Equal(http.StatusOK, stream.resp.StatusCode)
+	assert.Equal("text/event-stream", stream.resp.Header.Get("Content-Type"))
+	assert.Equal("no-cache", stream.resp.Header.Get("Cache-Control"))
+
+	events.Publish(events.TrustChange{Type: events.TrustChangedEvent, HardwareUUID: "00964993-89c1-e711-906e-00163566263e"})
+	published := events.Publish(events.TrustChange{Type: events.TrustChangedEvent, HardwareUUID: "59eed8f0-28c5-4070-91fc-f5e2e5443f6b", Trusted: true})
+	e := stream.nextEvent(t)
+	assert.Equal(published.ID, e.ID)
+	assert.True(e.Trusted)
+	stream.resp.Body.Close()
+
+	// a client reconnecting receives the events published after the last event it received
+	missed := events.Publish(events.TrustChange{Type: events.TrustChangedEvent, HardwareUUID: "59eed8f0-28c5-4070-91fc-f5e2e5443f6b"})
+	stream = openEventStream(t, server, "hardware_uuid=59eed8f0-28c5-4070-91fc-f5e2e5443f6b", published.ID)
+	defer stream.resp.Body.Close()
+	assert.Equal(missed.ID, stream.nextEvent(t).ID)
+}
+
+func TestStreamEventsInvalidFilter(t *testing.T) {
+	log.Trace("resource/events_test:TestStreamEventsInvalidFilter() Entering")
+	defer log.Trace("resource/events_test:TestStreamEventsInvalidFilter() Leaving")
+	assert := assert.New(t)
+	r := setupMockServer(new(mock.Database))
+
+	for _, query := range []string{"image_id=image", "hardware_uuid=host"} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/wls/v1/events?"+query, nil)
+		req.Header.Add("Authorization", "Bearer "+BearerToken)
+		r.ServeHTTP(recorder, req)
+		assert.Equal(http.StatusBadRequest, recorder.Code, query)
+		assert.Equal(errCodeInvalidRequest, decodeErrorResponse(t, recorder).Code, query)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/wls/v1/events", nil)
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	req.Header.Add("Last-Event-ID", "last")
+	r.ServeHTTP(recorder, req)
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func TestCreateReportPublishesTrustChange(t *testing.T) {
+	log.Trace("resource/events_test:TestCreateReportPublishesTrustChange() Entering")
+	defer log.Trace("resource/events_test:TestCreateReportPublishesTrustChange() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	db := new(mock.Database)
+	var previous []model.Report
+	db.MockReport.RetrieveByFilterCriteriaFn = func(filter repository.ReportFilter) ([]model.Report, error) {
+		assert.Equal("7b280921-83f7-4f44-9f8d-2dcf36e7af33", filter.InstanceID)
+		assert.Equal("true", filter.LatestPerVM)
+		return previous, nil
+	}
+	r := setupMockServer(db)
+	s := events.Subscribe(events.Filter{ImageID: "670f263e-b34e-4e07-a520-40ac9a89f62d"}, 0)
+	defer s.Close()
+
+	// the first report of an instance and a report with an unchanged trust status are not changes
+	assert.Equal(http.StatusCreated, postReport(r, signer.sign(t, testTrustReport(t))).Code)
+	previous = []model.Report{{}}
+	assert.Equal(http.StatusCreated, postReport(r, signer.sign(t, testTrustReport(t))).Code)
+	assert.Empty(s.C)
+
+	previous[0].Trusted = true
+	assert.Equal(http.StatusCreated, postReport(r, signer.sign(t, testTrustReport(t))).Code)
+	if assert.Len(s.C, 1) {
+		e := <-s.C
+		assert.Equal("7b280921-83f7-4f44-9f8d-2dcf36e7af33", e.InstanceID)
+		assert.Equal("59eed8f0-28c5-4070-91fc-f5e2e5443f6b", e.HardwareUUID)
+		assert.True(e.PreviousTrusted)
+		assert.False(e.Trusted)
+	}
+}
@@ -30,7 +30,7 @@ func SetFlavorsEndpoints(r *mux.Router, db repository.WlsDatabase) {
 	r.HandleFunc("/{id:(?i:[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-4[a-fA-F0-9]{3}-[8|9|aA|bB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$)}",
 		errorHandler(requiresPermission(deleteFlavorByID(db), []string{constants.FlavorsDelete}))).Methods("DELETE")
 	r.HandleFunc("", errorHandler(requiresPermission(createFlavor(db), []string{constants.FlavorsCreate}))).Methods("POST").Headers("Content-Type", "application/json")
-	r.HandleFunc("/{badid}", badId).Methods("DELETE")
+	r.HandleFunc("/{badid}", errorHandler(badId)).Methods("DELETE")
 }
 
 // Gets flavor corresponding to a VM or container image UUID
@@ -231,6 +231,31 @@ func createFlavor(db repository.WlsDatabase) endpointHandler {
 			return &endpointError{Message: msg, StatusCode: http.StatusBadRequest}
 		}
 
+		if err := verifyFlavorSignature(&f); err != nil {
+			if _, ok := err.(signatureError); ok {
+				msg := "Flavor signature verification failed: " + err.Error()
+				seclog.WithError(err).Errorf("resource/flavors:createFlavor() %s : Failed to create flavor: "+msg, message.InvalidInputProtocolViolation)
+				return &endpointError{Message: msg, StatusCode: http.StatusBadRequest, Code: errCodeFlavorSignatureInvalid}
+			}
+			if err == errNoFlavorSigningCerts {
+				// the service is misconfigured, the flavor itself may be valid
+				log.WithError(err).Errorf("resource/flavors:createFlavor() %s : Failed to create flavor", message.AppRuntimeErr)
+				return &endpointError{Message: "Failed to create flavor - flavor signature verification is not configured", StatusCode: http.StatusServiceUnavailable, Code: errCodeNoFlavorSignCerts}
+			}
+			log.WithError(err).Errorf("resource/flavors:createFlavor() %s : Unable to verify flavor signature", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{Message: "Unable to verify flavor signature", StatusCode: http.StatusInternalServerError}
+		}
+
+		// keys are only requested from the KBS of the configured registry
+		if f.ImageFlavor.Encryption != nil && f.ImageFlavor.Encryption.KeyURL != "" {
+			if _, err := trustedKBSEndpoint(f.ImageFlavor.Encryption.KeyURL); err != nil {
+				msg := "Flavor key URL is not on a trusted KBS"
+				seclog.WithError(err).Errorf("resource/flavors:createFlavor() %s : Failed to create flavor: "+msg, message.InvalidInputBadParam)
+				return &endpointError{Message: msg, StatusCode: http.StatusBadRequest}
+			}
+		}
+
 		// it's almost silly that we unmarshal, then remarshal it to store it back into the database,
 		// but at least it provides some validation of the input
 		fr := db.FlavorRepository()
@@ -12,6 +12,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"intel/isecl/lib/common/v4/middleware"
+	"intel/isecl/lib/flavor/v4"
 	flavorUtil "intel/isecl/lib/flavor/v4/util"
 	"intel/isecl/workload-service/v4/model"
 	"intel/isecl/workload-service/v4/repository/postgres"
@@ -67,6 +68,7 @@ func setupFlavorServer(t *testing.T) *mux.Router {
 	r.Use(middleware.NewTokenAuth("../mockJWTDir", "../mockJWTDir", mockRetrieveJWTSigningCerts, cacheTime))
 	wlsDB := postgres.PostgresDatabase{DB: db.Debug()}
 	wlsDB.Migrate()
+	flavorSigningCertsDir = mockFlavorSigningCertsDir
 	SetFlavorsEndpoints(r.PathPrefix("/wls/flavors").Subrouter(), wlsDB)
 	return r
 }
@@ -6,10 +6,22 @@ package resource
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	flavorUtil "intel/isecl/lib/flavor/v4/util"
 	"intel/isecl/workload-service/v4/repository/mock"
+	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
 
@@ -57,7 +69,7 @@ func TestFlavorPartValidation(t *testing.T) {
 	r := setupMockServer(db)
 
 	// Invalid flavor part (from ISECL-3459) should fail
-	badFlavorPartJson := `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"flavor_part":"image123","label":"label_image-test-3"}},"encryption_required":true,"encryption":{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}},"signature": "CStRpWgj0De7+xoX1uFSOacLAZeEcodUuvH62B4hVoiIEriVaHxrLJhBjnIuSPmIoZewCdTShw7GxmMQiMikCrVhaUilYk066TckOcLW/E3K+7NAiZ5kuS96J6dVxgJ+9k7iKf7Z+6lnWUJz92VWLP4U35WK4MtV+MPTYn2Zj1p+/tTUuSqlk8KCmpywzI1J1/XXjvqee3M9cGInnbOUGEFoLBAO1+w30yptoNxKEaB/9t3qEYywk8buT5GEMYUjJEj9PGGaW+lR37x0zcXggwMg/RsijMV6rNKsjjC0fN1vGswzoaIJPD1RJkQ8X9l3AaM0qhLBQDrurWxKK4KSQSpI0BziGPkKi5vAeeRkVfU5JXNdPxdOkyXVebeMQR9bYntXtZl41qjOZ0zIOKAHNJiBLyMYausbTZHVCwDuA/HBAT8i7JAIesxexX89bL+khPebHWkHaifS4NejymbGzM+n62EHuoeIo33qDMQ/U0FA3i6gRy0s/sFQVXR0xk8l"}`
+	badFlavorPartJson := signFlavor(t, `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"flavor_part":"image123","label":"label_image-test-3"}},"encryption_required":true,"encryption":{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}}}`)
 	recorder := httptest.NewRecorder()
 	req := httptest.NewRequest("POST", "/wls/v1/flavors", bytes.NewBufferString(badFlavorPartJson))
 	req.Header.Add("Content-Type", "application/json")
@@ -66,7 +78,7 @@ func TestFlavorPartValidation(t *testing.T) {
 	assert.Equal(http.StatusBadRequest, recorder.Code)
 
 	// "IMAGE" flavor part should be created
-	imageFlavorPartJson := `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"flavor_part":"IMAGE","label":"label_image-test-3"}},"encryption_required":true, "encryption":{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}}, "signature": "CStRpWgj0De7+xoX1uFSOacLAZeEcodUuvH62B4hVoiIEriVaHxrLJhBjnIuSPmIoZewCdTShw7GxmMQiMikCrVhaUilYk066TckOcLW/E3K+7NAiZ5kuS96J6dVxgJ+9k7iKf7Z+6lnWUJz92VWLP4U35WK4MtV+MPTYn2Zj1p+/tTUuSqlk8KCmpywzI1J1/XXjvqee3M9cGInnbOUGEFoLBAO1+w30yptoNxKEaB/9t3qEYywk8buT5GEMYUjJEj9PGGaW+lR37x0zcXggwMg/RsijMV6rNKsjjC0fN1vGswzoaIJPD1RJkQ8X9l3AaM0qhLBQDrurWxKK4KSQSpI0BziGPkKi5vAeeRkVfU5JXNdPxdOkyXVebeMQR9bYntXtZl41qjOZ0zIOKAHNJiBLyMYausbTZHVCwDuA/HBAT8i7JAIesxexX89bL+khPebHWkHaifS4NejymbGzM+n62EHuoeIo33qDMQ/U0FA3i6gRy0s/sFQVXR0xk8l"}`
+	imageFlavorPartJson := signFlavor(t, `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"flavor_part":"IMAGE","label":"label_image-test-3"}},"encryption_required":true, "encryption":{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}}}`)
 	recorder = httptest.NewRecorder()
 	req = httptest.NewRequest("POST", "/wls/v1/flavors", bytes.NewBufferString(imageFlavorPartJson))
 	req.Header.Add("Content-Type", "application/json")
@@ -75,7 +87,7 @@ func TestFlavorPartValidation(t *testing.T) {
 	assert.Equal(http.StatusCreated, recorder.Code)
 
 	// "CONTAINER_IMAGE" flavor part should be created
-	containerImageFlavorPartJson := `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"flavor_part":"CONTAINER_IMAGE","label":"label_image-test-3"}},"encryption_required":true,"encryption":	{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}},"signature": "CStRpWgj0De7+xoX1uFSOacLAZeEcodUuvH62B4hVoiIEriVaHxrLJhBjnIuSPmIoZewCdTShw7GxmMQiMikCrVhaUilYk066TckOcLW/E3K+7NAiZ5kuS96J6dVxgJ+9k7iKf7Z+6lnWUJz92VWLP4U35WK4MtV+MPTYn2Zj1p+/tTUuSqlk8KCmpywzI1J1/XXjvqee3M9cGInnbOUGEFoLBAO1+w30yptoNxKEaB/9t3qEYywk8buT5GEMYUjJEj9PGGaW+lR37x0zcXggwMg/RsijMV6rNKsjjC0fN1vGswzoaIJPD1RJkQ8X9l3AaM0qhLBQDrurWxKK4KSQSpI0BziGPkKi5vAeeRkVfU5JXNdPxdOkyXVebeMQR9bYntXtZl41qjOZ0zIOKAHNJiBLyMYausbTZHVCwDuA/HBAT8i7JAIesxexX89bL+khPebHWkHaifS4NejymbGzM+n62EHuoeIo33qDMQ/U0FA3i6gRy0s/sFQVXR0xk8l"}`
+	containerImageFlavorPartJson := signFlavor(t, `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"flavor_part":"CONTAINER_IMAGE","label":"label_image-test-3"}},"encryption_required":true,"encryption":	{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}}}`)
 	recorder = httptest.NewRecorder()
 	req = httptest.NewRequest("POST", "/wls/v1/flavors", bytes.NewBufferString(containerImageFlavorPartJson))
 	req.Header.Add("Content-Type", "application/json")
@@ -84,7 +96,7 @@ func TestFlavorPartValidation(t *testing.T) {
 	assert.Equal(http.StatusCreated, recorder.Code)
 
 	// Empty flavor part should fail
-	emptyImageFlavorPartJson := `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"flavor_part":"","label":"label_image-test-3"}},"encryption_required":true,"encryption":{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}},"signature": "CStRpWgj0De7+xoX1uFSOacLAZeEcodUuvH62B4hVoiIEriVaHxrLJhBjnIuSPmIoZewCdTShw7GxmMQiMikCrVhaUilYk066TckOcLW/E3K+7NAiZ5kuS96J6dVxgJ+9k7iKf7Z+6lnWUJz92VWLP4U35WK4MtV+MPTYn2Zj1p+/tTUuSqlk8KCmpywzI1J1/XXjvqee3M9cGInnbOUGEFoLBAO1+w30yptoNxKEaB/9t3qEYywk8buT5GEMYUjJEj9PGGaW+lR37x0zcXggwMg/RsijMV6rNKsjjC0fN1vGswzoaIJPD1RJkQ8X9l3AaM0qhLBQDrurWxKK4KSQSpI0BziGPkKi5vAeeRkVfU5JXNdPxdOkyXVebeMQR9bYntXtZl41qjOZ0zIOKAHNJiBLyMYausbTZHVCwDuA/HBAT8i7JAIesxexX89bL+khPebHWkHaifS4NejymbGzM+n62EHuoeIo33qDMQ/U0FA3i6gRy0s/sFQVXR0xk8l"}`
+	emptyImageFlavorPartJson := signFlavor(t, `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"flavor_part":"","label":"label_image-test-3"}},"encryption_required":true,"encryption":{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}}}`)
 	recorder = httptest.NewRecorder()
 	req = httptest.NewRequest("POST", "/wls/v1/flavors", bytes.NewBufferString(emptyImageFlavorPartJson))
 	req.Header.Add("Content-Type", "application/json")
@@ -93,7 +105,7 @@ func TestFlavorPartValidation(t *testing.T) {
 	assert.Equal(http.StatusBadRequest, recorder.Code)
 
 	// Omitted flavor part should fail
-	omittedImageFlavorPartJson := `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"label":"label_image-test-3"}},"encryption_required":true,"encryption":{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}},"signature": "CStRpWgj0De7+xoX1uFSOacLAZeEcodUuvH62B4hVoiIEriVaHxrLJhBjnIuSPmIoZewCdTShw7GxmMQiMikCrVhaUilYk066TckOcLW/E3K+7NAiZ5kuS96J6dVxgJ+9k7iKf7Z+6lnWUJz92VWLP4U35WK4MtV+MPTYn2Zj1p+/tTUuSqlk8KCmpywzI1J1/XXjvqee3M9cGInnbOUGEFoLBAO1+w30yptoNxKEaB/9t3qEYywk8buT5GEMYUjJEj9PGGaW+lR37x0zcXggwMg/RsijMV6rNKsjjC0fN1vGswzoaIJPD1RJkQ8X9l3AaM0qhLBQDrurWxKK4KSQSpI0BziGPkKi5vAeeRkVfU5JXNdPxdOkyXVebeMQR9bYntXtZl41qjOZ0zIOKAHNJiBLyMYausbTZHVCwDuA/HBAT8i7JAIesxexX89bL+khPebHWkHaifS4NejymbGzM+n62EHuoeIo33qDMQ/U0FA3i6gRy0s/sFQVXR0xk8l"}`
+	omittedImageFlavorPartJson := signFlavor(t, `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"label":"label_image-test-3"}},"encryption_required":true,"encryption":{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}}}`)
 	recorder = httptest.NewRecorder()
 	req = httptest.NewRequest("POST", "/wls/v1/flavors", bytes.NewBufferString(omittedImageFlavorPartJson))
 	req.Header.Add("Content-Type", "application/json")
@@ -102,6 +114,114 @@ func TestFlavorPartValidation(t *testing.T) {
 	assert.Equal(http.StatusBadRequest, recorder.Code)
 }
 
+const unsignedImageFlavor = `{"flavor":{"meta":{"id":"d6129610-4c8f-4ac4-8823-df4e925688c3","description":{"flavor_part":"IMAGE","label":"label_image-test-3"}},"encryption_required":true,"encryption":{"key_url":"https://kbs.server.com:443/v1/keys/60a9fe49-612f-4b66-bf86-b75c7873f3b3/transfer","digest":"3JiqO+O4JaL2qQxpzRhTHrsFpDGIUDV8fTWsXnjHVKY="}}}`
+
+// signFlavor signs an image flavor with the mock flavor signing key
+func signFlavor(t *testing.T, flavorJSON string) string {
+	signedFlavor, err := flavorUtil.GetSignedFlavor(flavorJSON, "../repository/mock/flavor-signing-key.pem")
+	if err != nil {
+		t.Fatal("could not sign flavor")
+	}
+	return signedFlavor
+}
+
+func postFlavor(r http.Handler, flavorJSON string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/wls/v1/flavors", bytes.NewBufferString(flavorJSON))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestCreateFlavorGarbageSignature(t *testing.T) {
+	log.Trace("resource/flavors_test:TestCreateFlavorGarbageSignature() Entering")
+	defer log.Trace("resource/flavors_test:TestCreateFlavorGarbageSignature() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	r := setupMockServer(db)
+
+	garbageSignatureJson := strings.TrimSuffix(unsignedImageFlavor, "}") + `,"signature":"CStRpWgj0De7+xoX1uFSOacLAZeEcodUuvH62B4hVoiIEriVaHxrLJhBjnIuSPmIoZewCdTShw7GxmMQiMikCrVhaUilYk066TckOcLW"}`
+	recorder := postFlavor(r, garbageSignatureJson)
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	assert.Contains(recorder.Body.String(), "Flavor signature verification failed")
+	assert.Equal(errCodeFlavorSignatureInvalid, decodeErrorResponse(t, recorder).Code)
+
+	notBase64Json := strings.TrimSuffix(unsignedImageFlavor, "}") + `,"signature":"not a signature"}`
+	recorder = postFlavor(r, notBase64Json)
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	assert.Contains(recorder.Body.String(), "Flavor signature verification failed")
+}
+
+func TestCreateFlavorTamperedFlavor(t *testing.T) {
+	log.Trace("resource/flavors_test:TestCreateFlavorTamperedFlavor() Entering")
+	defer log.Trace("resource/flavors_test:TestCreateFlavorTamperedFlavor() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	r := setupMockServer(db)
+
+	signedFlavor := signFlavor(t, unsignedImageFlavor)
+	recorder := postFlavor(r, signedFlavor)
+	assert.Equal(http.StatusCreated, recorder.Code)
+
+	// change the key url after signing
+	tamperedFlavor := strings.Replace(signedFlavor, "https://kbs.server.com:443", "https://evil.server.com:443", 1)
+	assert.NotEqual(signedFlavor, tamperedFlavor)
+	recorder = postFlavor(r, tamperedFlavor)
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	assert.Contains(recorder.Body.String(), "does not match any trusted flavor signing certificate")
+}
+
+func TestCreateFlavorUntrustedSigner(t *testing.T) {
+	log.Trace("resource/flavors_test:TestCreateFlavorUntrustedSigner() Entering")
+	defer log.Trace("resource/flavors_test:TestCreateFlavorUntrustedSigner() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	r := setupMockServer(db)
+
+	// trust only a freshly generated certificate, which did not sign the flavor
+	certsDir, err := ioutil.TempDir("", "flavorsign")
+	assert.NoError(err)
+	defer os.RemoveAll(certsDir)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Untrusted Flavor Signing Certificate"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NoError(err)
+	err = ioutil.WriteFile(filepath.Join(certsDir, "untrusted.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), 0600)
+	assert.NoError(err)
+	flavorSigningCertsDir = certsDir + "/"
+	defer func() { flavorSigningCertsDir = mockFlavorSigningCertsDir }()
+
+	recorder := postFlavor(r, signFlavor(t, unsignedImageFlavor))
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	assert.Contains(recorder.Body.String(), "does not match any trusted flavor signing certificate")
+}
+
+func TestCreateFlavorNoSigningCerts(t *testing.T) {
+	log.Trace("resource/flavors_test:TestCreateFlavorNoSigningCerts() Entering")
+	defer log.Trace("resource/flavors_test:TestCreateFlavorNoSigningCerts() Leaving")
+	assert := assert.New(t)
+	r := setupMockServer(new(mock.Database))
+
+	// no flavor signing certificate is imported
+	certsDir, err := ioutil.TempDir("", "flavorsign")
+	assert.NoError(err)
+	defer os.RemoveAll(certsDir)
+	for _, dir := range []string{certsDir + "/", certsDir + "/missing/"} {
+		flavorSigningCertsDir = dir
+		recorder := postFlavor(r, signFlavor(t, unsignedImageFlavor))
+		assert.Equal(http.StatusServiceUnavailable, recorder.Code)
+		assert.Equal(errCodeNoFlavorSignCerts, decodeErrorResponse(t, recorder).Code)
+	}
+	flavorSigningCertsDir = mockFlavorSigningCertsDir
+}
+
 //TestGetAllFlavors checks if all flavors are returned without filter
 func TestGetFlavorNoFilter(t *testing.T) {
 	log.Trace("resource/flavors_test:TestGetFlavorNoFilter() Entering")
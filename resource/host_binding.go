@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	stdcontext "context"
+	"encoding/base64"
+	"encoding/json"
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/constants"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// hostIdentitiesKey is the request context key of the host identities of the caller
+type hostIdentitiesKey struct{}
+
+// hostBindingMode returns the configured mode binding key requests to the identity of the caller
+func hostBindingMode() string {
+	if mode := config.Configuration.KeyHostBinding.Mode; mode != "" {
+		return mode
+	}
+	return constants.KeyHostBindingNone
+}
+
+// withHostIdentities attaches the host identities of the caller to the request when key requests are bound to hosts
+func withHostIdentities(r *http.Request) *http.Request {
+	mode := hostBindingMode()
+	if mode == constants.KeyHostBindingNone {
+		return r
+	}
+	return r.WithContext(stdcontext.WithValue(r.Context(), hostIdentitiesKey{}, callerHostIdentities(r, mode)))
+}
+
+// callerHostIdentities returns the identities of the caller, from the configured claim of its bearer token or from
+// the common name and subject alternative names of its client certificate
+func callerHostIdentities(r *http.Request, mode string) []string {
+	switch mode {
+	case constants.KeyHostBindingJWT:
+		claim := config.Configuration.KeyHostBinding.Claim
+		if claim == "" {
+			claim = constants.DefaultKeyHostClaim
+		}
+		identities, err := tokenClaim(r, claim)
+		if err != nil {
+			seclog.WithError(err).Warnf("resource/host_binding:callerHostIdentities() Unable to read claim %s of the bearer token", claim)
+		}
+		return identities
+	case constants.KeyHostBindingCert:
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return nil
+		}
+		cert := r.TLS.PeerCertificates[0]
+		identities := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+		for _, u := range cert.URIs {
+			identities = append(identities, u.String())
+		}
+		return identities
+	}
+	seclog.Warnf("resource/host_binding:callerHostIdentities() Unknown host binding mode %s", mode)
+	return nil
+}
+
+// tokenClaim returns the values of a claim of the bearer token. The token was verified by the authentication
+// middleware, only its payload is decoded here.
+func tokenClaim(r *http.Request, claim string) ([]string, error) {
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("bearer token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the bearer token payload")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the bearer token claims")
+	}
+
+	switch value := claims[claim].(type) {
+	case string:
+		return []string{value}, nil
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values, nil
+	}
+	return nil, nil
+}
+
+// checkHostBinding checks that a key request for the host with hardware UUID hwid is made by that host, when key
+// requests are bound to hosts
+func checkHostBinding(r *http.Request, hwid string) *endpointError {
+	if hostBindingMode() == constants.KeyHostBindingNone {
+		return nil
+	}
+	host, err := uuid.Parse(hwid)
+	if err == nil {
+		identities, _ := r.Context().Value(hostIdentitiesKey{}).([]string)
+		for _, identity := range identities {
+			if id, err := uuid.Parse(identity); err == nil && id == host {
+				return nil
+			}
+		}
+	}
+	seclog.Errorf("resource/host_binding:checkHostBinding() %s : Key requested for host %s by a caller bound to another host", message.UnauthorizedAccess, hwid)
+	return &endpointError{
+		Message:    "Hardware UUID does not match the identity of the caller",
+		StatusCode: http.StatusForbidden,
+		Code:       errCodeHostIdentityMismatch,
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository/mock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// useHostBinding binds key requests to hosts in the given mode. The returned function restores the previous mode.
+func useHostBinding(mode, claim string) func() {
+	previous := config.Configuration.KeyHostBinding
+	config.Configuration.KeyHostBinding.Mode = mode
+	config.Configuration.KeyHostBinding.Claim = claim
+	return func() {
+		config.Configuration.KeyHostBinding = previous
+	}
+}
+
+// unsignedToken returns a JWT with the given claims. Its signature is not checked when the claims are read.
+func unsignedToken(t *testing.T, claims map[string]interface{}) string {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal("could not marshal token claims")
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS384","typ":"JWT"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+}
+
+// peerCertificate returns the TLS state of a connection with a client certificate for the given names
+func peerCertificate(cn string, dnsNames []string, uris ...string) *tls.ConnectionState {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}, DNSNames: dnsNames}
+	for _, u := range uris {
+		parsed, _ := url.Parse(u)
+		cert.URIs = append(cert.URIs, parsed)
+	}
+	return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+}
+
+func TestCheckHostBinding(t *testing.T) {
+	log.Trace("resource/host_binding_test:TestCheckHostBinding() Entering")
+	defer log.Trace("resource/host_binding_test:TestCheckHostBinding() Leaving")
+	hwid := "8032632b-8fa4-e811-906e-00163566263e"
+	otherHwid := "00ecd3ab-9af4-e711-906e-001560a04062"
+
+	tests := []struct {
+		name  string
+		mode  string
+		claim string
+		token map[string]interface{}
+		tls   *tls.ConnectionState
+		match bool
+	}{
+		{name: "binding disabled", mode: constants.KeyHostBindingNone, match: true},
+		{name: "jwt subject", mode: constants.KeyHostBindingJWT, token: map[string]interface{}{"sub": hwid}, match: true},
+		{name: "jwt upper case subject", mode: constants.KeyHostBindingJWT, token: map[string]interface{}{"sub": strings.ToUpper(hwid)}, match: true},
+		{name: "jwt other subject", mode: constants.KeyHostBindingJWT, token: map[string]interface{}{"sub": otherHwid}},
+		{name: "jwt user subject", mode: constants.KeyHostBindingJWT, token: map[string]interface{}{"sub": "global_admin_user"}},
+		{
+			name:  "jwt configured claim",
+			mode:  constants.KeyHostBindingJWT,
+			claim: "hardware_uuid",
+			token: map[string]interface{}{"sub": "host-1", "hardware_uuid": hwid},
+			match: true,
+		},
+		{
+			name:  "jwt configured claim ignores subject",
+			mode:  constants.KeyHostBindingJWT,
+			claim: "hardware_uuid",
+			token: map[string]interface{}{"sub": hwid},
+		},
+		{
+			name:  "jwt claim list",
+			mode:  constants.KeyHostBindingJWT,
+			claim: "hardware_uuid",
+			token: map[string]interface{}{"hardware_uuid": []string{otherHwid, hwid}},
+			match: true,
+		},
+		{name: "jwt no token", mode: constants.KeyHostBindingJWT},
+		{name: "cert common name", mode: constants.KeyHostBindingCert, tls: peerCertificate(hwid, nil), match: true},
+		{name: "cert DNS name", mode: constants.KeyHostBindingCert, tls: peerCertificate("host-1", []string{"host-1.example.com", hwid}), match: true},
+		{name: "cert URN", mode: constants.KeyHostBindingCert, tls: peerCertificate("host-1", nil, "urn:uuid:"+hwid), match: true},
+		{name: "cert other host", mode: constants.KeyHostBindingCert, tls: peerCertificate(otherHwid, []string{"host-2.example.com"})},
+		{name: "cert missing", mode: constants.KeyHostBindingCert, token: map[string]interface{}{"sub": hwid}},
+		{name: "unknown mode", mode: "spiffe", token: map[string]interface{}{"sub": hwid}, tls: peerCertificate(hwid, nil)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			defer useHostBinding(test.mode, test.claim)()
+
+			req := httptest.NewRequest("GET", "/wls/v1/images/dddd021e-9669-4e53-9224-8880fb4e4080/flavor-key?hardware_uuid="+hwid, nil)
+			if test.token != nil {
+				req.Header.Add("Authorization", "Bearer "+unsignedToken(t, test.token))
+			}
+			req.TLS = test.tls
+
+			e := checkHostBinding(withHostIdentities(req), hwid)
+			if test.match {
+				assert.Nil(e)
+			} else if assert.NotNil(e) {
+				assert.Equal(http.StatusForbidden, e.StatusCode)
+				assert.Equal(errCodeHostIdentityMismatch, e.Code)
+			}
+		})
+	}
+}
+
+func TestRetrieveKeyHostBindingMismatch(t *testing.T) {
+	log.Trace("resource/host_binding_test:TestRetrieveKeyHostBindingMismatch() Entering")
+	defer log.Trace("resource/host_binding_test:TestRetrieveKeyHostBindingMismatch() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	hvs := &fakeHVS{trusted: true, validity: time.Hour}
+	defer setupFakeHVS(hwid, hvs, true)()
+	// the subject of the test token is a user name, not the hardware UUID of the host
+	defer useHostBinding(constants.KeyHostBindingJWT, "")()
+
+	recorder := postKeyRequest(t, hwid, samlTestKeyURL)
+	assert.Equal(http.StatusForbidden, recorder.Code)
+	assert.Equal(errCodeHostIdentityMismatch, decodeErrorResponse(t, recorder).Code)
+	assert.Equal(0, hvs.requests)
+
+	recorder = getFlavorKey(setupMockServer(new(mock.Database)), hwid)
+	assert.Equal(http.StatusForbidden, recorder.Code)
+	assert.Equal(errCodeHostIdentityMismatch, decodeErrorResponse(t, recorder).Code)
+	assert.Equal(0, hvs.requests)
+}
+
+func TestRetrieveKeyHostBindingCert(t *testing.T) {
+	log.Trace("resource/host_binding_test:TestRetrieveKeyHostBindingCert() Entering")
+	defer log.Trace("resource/host_binding_test:TestRetrieveKeyHostBindingCert() Leaving")
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, true)()
+	defer useHostBinding(constants.KeyHostBindingCert, "")()
+
+	postKey := func(state *tls.ConnectionState) *httptest.ResponseRecorder {
+		body, err := json.Marshal(model.RequestKey{HwId: hwid, KeyUrl: samlTestKeyURL})
+		if err != nil {
+			t.Fatal("could not marshal key request")
+		}
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/wls/v1/keys", bytes.NewBuffer(body))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", "Bearer "+BearerToken)
+		req.TLS = state
+		setupMockServer(new(mock.Database)).ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("matching host", func(t *testing.T) {
+		assert := assert.New(t)
+		recorder := postKey(peerCertificate(hwid, nil))
+		assert.Equal(http.StatusOK, recorder.Code)
+		var key model.ReturnKey
+		assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &key))
+		assert.Equal([]byte{0, 1, 2, 3}, key.Key)
+	})
+	t.Run("other host", func(t *testing.T) {
+		assert := assert.New(t)
+		recorder := postKey(peerCertificate(uuid.New().String(), nil))
+		assert.Equal(http.StatusForbidden, recorder.Code)
+		assert.Equal(errCodeHostIdentityMismatch, decodeErrorResponse(t, recorder).Code)
+	})
+	t.Run("no client certificate", func(t *testing.T) {
+		assert := assert.New(t)
+		recorder := postKey(nil)
+		assert.Equal(http.StatusForbidden, recorder.Code)
+		assert.Equal(errCodeHostIdentityMismatch, decodeErrorResponse(t, recorder).Code)
+	})
+}
@@ -15,7 +15,6 @@ import (
 	"intel/isecl/lib/common/v4/log/message"
 	"intel/isecl/lib/common/v4/validation"
 	"intel/isecl/workload-service/v4/constants"
-	consts "intel/isecl/workload-service/v4/constants"
 	"intel/isecl/workload-service/v4/keycache"
 	"intel/isecl/workload-service/v4/model"
 	"intel/isecl/workload-service/v4/repository"
@@ -31,10 +30,13 @@ import (
 
 // Saml is used to represent saml report struct
 type Saml struct {
-	XMLName   xml.Name    `xml:"Assertion"`
-	Subject   Subject     `xml:"Subject>SubjectConfirmation>SubjectConfirmationData"`
-	Attribute []Attribute `xml:"AttributeStatement>Attribute"`
-	Signature string      `xml:"Signature>KeyInfo>X509Data>X509Certificate"`
+	XMLName    xml.Name    `xml:"Assertion"`
+	ID         string      `xml:"ID,attr"`
+	Issuer     string      `xml:"Issuer"`
+	Subject    Subject     `xml:"Subject>SubjectConfirmation>SubjectConfirmationData"`
+	Conditions Conditions  `xml:"Conditions"`
+	Attribute  []Attribute `xml:"AttributeStatement>Attribute"`
+	Signature  string      `xml:"Signature>KeyInfo>X509Data>X509Certificate"`
 }
 
 type Subject struct {
@@ -43,6 +45,12 @@ type Subject struct {
 	NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
 }
 
+type Conditions struct {
+	NotBefore    time.Time `xml:"NotBefore,attr"`
+	NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+	Audience     []string  `xml:"AudienceRestriction>Audience"`
+}
+
 type Attribute struct {
 	XMLName        xml.Name `xml:"Attribute"`
 	Name           string   `xml:"Name,attr"`
@@ -65,6 +73,12 @@ func SetImagesEndpoints(r *mux.Router, db repository.WlsDatabase) {
 		errorHandler(requiresPermission(putAssociatedFlavor(db), []string{constants.ImageFlavorsStore}))).Methods("PUT")
 	r.HandleFunc("/{id}/flavors/{flavorID}",
 		errorHandler(requiresPermission(deleteAssociatedFlavor(db), []string{constants.ImageFlavorsDelete}))).Methods("DELETE")
+	r.HandleFunc("/{id}/policy",
+		errorHandler(requiresPermission(getKeyReleasePolicy(db), []string{constants.KeyReleasePoliciesRetrieve}))).Methods("GET")
+	r.HandleFunc("/{id}/policy",
+		errorHandler(requiresPermission(putKeyReleasePolicy(db), []string{constants.KeyReleasePoliciesStore}))).Methods("PUT").Headers("Content-Type", "application/json")
+	r.HandleFunc("/{id}/policy",
+		errorHandler(requiresPermission(deleteKeyReleasePolicy(db), []string{constants.KeyReleasePoliciesDelete}))).Methods("DELETE")
 	r.HandleFunc("/{id}",
 		errorHandler(requiresPermission(getImageByID(db), []string{constants.ImagesRetrieve}))).Methods("GET")
 	r.HandleFunc("/{id}",
@@ -72,36 +86,38 @@ func SetImagesEndpoints(r *mux.Router, db repository.WlsDatabase) {
 	r.HandleFunc("/{id}/flavor-key",
 		errorHandler(requiresPermission(retrieveFlavorAndKeyForImageID(db), []string{constants.ImageFlavorsRetrieve}))).Methods("GET").Queries("hardware_uuid", "{hardware_uuid}")
 	r.HandleFunc("/{id}/flavor-key",
-		missingQueryParameters("hardware_uuid")).Methods("GET")
+		errorHandler(missingQueryParameters("hardware_uuid"))).Methods("GET")
 	r.HandleFunc("",
 		errorHandler(requiresPermission(queryImages(db), []string{constants.ImagesSearch}))).Methods("GET")
 	r.HandleFunc("",
 		errorHandler(requiresPermission(createImage(db), []string{constants.ImagesCreate}))).Methods("POST").Headers("Content-Type", "application/json")
-	r.HandleFunc("/{badid}", badId)
+	r.HandleFunc("/{badid}", errorHandler(badId))
 }
 
 // Logs error if a UUID is not in UUIDv4 format
-func badId(w http.ResponseWriter, r *http.Request) {
+func badId(w http.ResponseWriter, r *http.Request) error {
 	log.Trace("resource/images:badId() Entering")
 	defer log.Trace("resource/images:badId() Leaving")
 	badid := mux.Vars(r)["badid"]
 	log.Errorf("resource/images:badId() %s : Request made with non compliant UUIDv4: %v", message.InvalidInputProtocolViolation, badid)
-	w.WriteHeader(http.StatusBadRequest)
-	w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
-	_, err := w.Write([]byte(fmt.Sprintf("%s is not uuidv4 compliant", badid)))
-	if err != nil {
-		log.Errorf("resource/images:badId() : Failed to write response: %v", badid)
+	return &endpointError{
+		Message:    fmt.Sprintf("%s is not uuidv4 compliant", badid),
+		StatusCode: http.StatusBadRequest,
 	}
 }
 
 // Logs error if a query is missing one or more parameters
-func missingQueryParameters(params ...string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func missingQueryParameters(params ...string) endpointHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		log.Trace("resource/images:missingQueryParameters() Entering")
 		defer log.Trace("resource/images:missingQueryParameters() Leaving")
 		errStr := fmt.Sprintf("Missing query parameters: %v", params)
 		log.Errorf("resource/images:missingQueryParameters() %s : %s", message.InvalidInputBadParam, errStr)
-		http.Error(w, errStr, http.StatusBadRequest)
+		return &endpointError{
+			Message:    errStr,
+			StatusCode: http.StatusBadRequest,
+			Details:    map[string][]string{"missing_parameters": params},
+		}
 	}
 }
 
@@ -131,6 +147,9 @@ func retrieveFlavorAndKeyForImageID(db repository.WlsDatabase) endpointHandler {
 				StatusCode: http.StatusBadRequest,
 			}
 		}
+		if e := checkHostBinding(r, hwid); e != nil {
+			return e
+		}
 		cLog := log.WithField("imageUUID", id).WithField("hardwareUUID", hwid)
 
 		cLog.Debug("resource/images:retrieveFlavorAndKeyForImageID() Retrieving Flavor and Key for Image")
@@ -146,7 +165,7 @@ func retrieveFlavorAndKeyForImageID(db repository.WlsDatabase) endpointHandler {
 		keyUrl := flavor.ImageFlavor.Encryption.KeyURL
 		// Check if flavor keyUrl is not empty
 		if flavor.ImageFlavor.EncryptionRequired && len(flavor.ImageFlavor.Encryption.KeyURL) > 0 {
-			key, err := transfer_key(true, hwid, keyUrl, id)
+			key, err := transfer_key(db, callerPrincipal(r), true, hwid, keyUrl, id)
 			if err != nil {
 				cLog.WithError(err).Error("resource/images:retrieveFlavorAndKeyForImageID() Error while retrieving key")
 				return err
@@ -460,8 +479,10 @@ func queryImages(db repository.WlsDatabase) endpointHandler {
 		locator.Filter = true // default to 'filter' to true
 
 		if len(r.URL.Query()) == 0 {
-			http.Error(w, "At least one query parameter is required", http.StatusBadRequest)
-			return nil
+			return &endpointError{
+				Message:    "At least one query parameter is required",
+				StatusCode: http.StatusBadRequest,
+			}
 		}
 
 		filter, ok := r.URL.Query()["filter"]
@@ -703,23 +724,23 @@ func createImage(db repository.WlsDatabase) endpointHandler {
 	}
 }
 
-// This method is used to check if the key for an image file is cached.
-// If the key is cached, the method you return the key ID.
-func getKeyFromCache(imageUUID string) (keycache.Key, error) {
+// This method is used to check if the key with keyID is cached for the host with hardwareUUID.
+// If the key is cached, the method returns the cached key.
+func getKeyFromCache(hardwareUUID string, keyID string) (keycache.Key, error) {
 	log.Trace("Entered resource/images:getKeyFromCache()")
 	defer log.Trace("Exited resource/images:getKeyFromCache()")
-	key, exists := keycache.Get(imageUUID)
+	key, exists := keycache.Get(hardwareUUID, keyID)
 	if exists && key.ID != "" && time.Now().Before(key.Expired) {
 		return key, nil
 	}
 	return keycache.Key{}, errors.New("resource/images:getKeyFromCache() key is not cached or expired")
 }
 
-// This method is used add the key to cache and map it with the image UUID
-func cacheKeyInMemory(imageUUID string, keyID string, key []byte) error {
+// This method is used add the key to cache and map it with the host hardware UUID and the key ID
+func cacheKeyInMemory(hardwareUUID string, keyID string, key []byte) error {
 	log.Trace("Entered resource/images:cacheKeyInMemory()")
 	defer log.Trace("Exited resource/images:cacheKeyInMemory()")
-	keycache.Store(imageUUID, keycache.Key{ID: keyID, Bytes: key, Created: time.Now(), Expired: time.Now().Add(time.Second * time.Duration(consts.DefaultKeyCacheSeconds))})
+	keycache.Store(hardwareUUID, keycache.Key{ID: keyID, Bytes: key})
 	return nil
 }
 
@@ -7,38 +7,86 @@ package resource
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/intel-secl/intel-secl/v4/pkg/clients/kbs"
+	"intel/isecl/lib/flavor/v4"
+	"intel/isecl/workload-service/v4/clients"
 	"intel/isecl/workload-service/v4/config"
 	"intel/isecl/workload-service/v4/model"
 	"intel/isecl/workload-service/v4/repository"
 	"intel/isecl/workload-service/v4/repository/mock"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// getFlavorKey requests the flavor and key of an image for a host
+func getFlavorKey(r http.Handler, hwid string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/wls/v1/images/dddd021e-9669-4e53-9224-8880fb4e4080/flavor-key?hardware_uuid="+hwid, nil)
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// useClientManager requests SAML reports through a client manager for the HVS and AAS at baseURL.
+// The returned function restores the previous client manager.
+func useClientManager(t *testing.T, baseURL string) func() {
+	dir, err := ioutil.TempDir("", "trustedca")
+	if err != nil {
+		t.Fatal("could not create trusted CA directory")
+	}
+	previous := clientManager
+	SetClientManager(clients.NewManager(baseURL+"/mtwilson/v2/", baseURL+"/aas/", "wls", "password", dir))
+	return func() {
+		SetClientManager(previous)
+		os.RemoveAll(dir)
+	}
+}
+
+// useFailingKBS makes key transfers for an image flavor with a key that is not cached fail with err.
+// The returned function restores the previous KBS client.
+func useFailingKBS(t *testing.T, db *mock.Database, err error) func() {
+	f, flavorErr := flavor.GetImageFlavor("Cirros-enc", true, "http://localhost:6337/v1/keys/"+coalescedKeyID+"/transfer", "1160f92d07a3e9bf2633c49bfc2654428c517ee5a648d715bf984c83f266a4fd")
+	if flavorErr != nil {
+		t.Fatal("could not create image flavor")
+	}
+	db.MockImage.RetrieveAssociatedImageFlavorFn = func(string) (*flavor.SignedImageFlavor, error) {
+		return &flavor.SignedImageFlavor{ImageFlavor: f.Image}, nil
+	}
+	kbsServer := newFakeKBS()
+	kbsServer.err = err
+	close(kbsServer.release)
+	previousGetKBSClient := getKBSClient
+	getKBSClient = func(baseUrl, caBundle string) (kbs.KBSClient, error) {
+		return kbsServer, nil
+	}
+	return func() {
+		getKBSClient = previousGetKBSClient
+	}
+}
+
 func TestFlavorKey(t *testing.T) {
 	log.Trace("resource/images_test:TestFlavorKey() Entering")
 	defer log.Trace("resource/images_test:TestFlavorKey() Leaving")
 	assert := assert.New(t)
 	db := new(mock.Database)
 	r := setupMockServer(db)
-	config.Configuration.HvsApiUrl = "http://localhost:1338/mtwilson/v2/"
-
-	k := mockKMS(":1337")
-	defer k.Close()
-	h := mockHVS(":1338")
-	defer h.Close()
-	time.Sleep(1 * time.Second)
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, true)()
 
 	// Test Flavor-Key
-	recorder := httptest.NewRecorder()
-	req := httptest.NewRequest("GET", "/wls/v1/images/dddd021e-9669-4e53-9224-8880fb4e4080/flavor-key?hardware_uuid=ecee021e-9669-4e53-9224-8880fb4e4080", nil)
-	req.Header.Add("Authorization", "Bearer "+BearerToken)
-	r.ServeHTTP(recorder, req)
-	assert.Equal(http.StatusInternalServerError, recorder.Code)
+	recorder := getFlavorKey(r, hwid)
+	assert.Equal(http.StatusOK, recorder.Code)
+	var flavorKey model.FlavorKey
+	assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &flavorKey))
+	assert.Equal([]byte{0, 1, 2, 3}, flavorKey.Key)
 }
 
 func TestFlavorKeyMissingHWUUID(t *testing.T) {
@@ -60,7 +108,10 @@ func TestFlavorKeyMissingHWUUID(t *testing.T) {
 	req.Header.Add("Authorization", "Bearer "+BearerToken)
 	r.ServeHTTP(recorder, req)
 	assert.Equal(http.StatusBadRequest, recorder.Code)
-	assert.Equal("Missing query parameters: [hardware_uuid]\n", recorder.Body.String())
+	errResponse := decodeErrorResponse(t, recorder)
+	assert.Equal(errCodeInvalidRequest, errResponse.Code)
+	assert.Equal("Missing query parameters: [hardware_uuid]", errResponse.Message)
+	assert.Equal(map[string]interface{}{"missing_parameters": []interface{}{"hardware_uuid"}}, errResponse.Details)
 }
 
 func TestFlavorKeyEmptyHWUUID(t *testing.T) {
@@ -85,24 +136,35 @@ func TestFlavorKeyEmptyHWUUID(t *testing.T) {
 	assert.Contains(recorder.Body.String(), "Invalid hardware uuid")
 }
 
+func TestFlavorKeyHostUntrusted(t *testing.T) {
+	log.Trace("resource/images_test:TestFlavorKeyHostUntrusted() Entering")
+	defer log.Trace("resource/images_test:TestFlavorKeyHostUntrusted() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	r := setupMockServer(db)
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: false, validity: time.Hour}, true)()
+
+	recorder := getFlavorKey(r, hwid)
+	assert.Equal(http.StatusForbidden, recorder.Code)
+	assert.Equal(errCodeHostUntrusted, decodeErrorResponse(t, recorder).Code)
+	assert.Empty(recorder.Header().Get("Retry-After"))
+}
+
 func TestFlavorKeyHVSDown(t *testing.T) {
 	log.Trace("resource/images_test:TestFlavorKeyHVSDown() Entering")
 	defer log.Trace("resource/images_test:TestFlavorKeyHVSDown() Leaving")
 	assert := assert.New(t)
 	db := new(mock.Database)
 	r := setupMockServer(db)
-	config.Configuration.HvsApiUrl = "http://localhost:4338/mtwilson/v2/"
+	defer useClientManager(t, "http://localhost:4338")()
 
-	k := mockKMS(":4337")
-	defer k.Close()
-	time.Sleep(1 * time.Second)
 	// Test Flavor-Key
-	recorder := httptest.NewRecorder()
-	req := httptest.NewRequest("GET", "/wls/v1/images/dddd021e-9669-4e53-9224-8880fb4e4080/flavor-key?hardware_uuid=ecee021e-9669-4e53-9224-8880fb4e4080", nil)
-	req.Header.Add("Authorization", "Bearer "+BearerToken)
-	r.ServeHTTP(recorder, req)
+	recorder := getFlavorKey(r, uuid.New().String())
 	t.Log(recorder.Body.String())
-	assert.Equal(http.StatusInternalServerError, recorder.Code)
+	assert.Equal(http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(errCodeHvsUnavailable, decodeErrorResponse(t, recorder).Code)
+	assert.Equal("30", recorder.Header().Get("Retry-After"))
 }
 
 func TestFlavorKyHVSBadRequest(t *testing.T) {
@@ -111,20 +173,38 @@ func TestFlavorKyHVSBadRequest(t *testing.T) {
 	assert := assert.New(t)
 	db := new(mock.Database)
 	r := setupMockServer(db)
-	config.Configuration.HvsApiUrl = "http://localhost:5338/mtwilson/v2/"
+	defer useClientManager(t, "http://localhost:5338")()
 
-	k := mockKMS(":5337")
-	defer k.Close()
 	h := badHVS(":5338")
 	defer h.Close()
 	time.Sleep(1 * time.Second)
 	// Test Flavor-Key
-	recorder := httptest.NewRecorder()
-	req := httptest.NewRequest("GET", "/wls/v1/images/dddd021e-9669-4e53-9224-8880fb4e4080/flavor-key?hardware_uuid=ecee021e-9669-4e53-9224-8880fb4e4080", nil)
-	req.Header.Add("Authorization", "Bearer "+BearerToken)
-	r.ServeHTTP(recorder, req)
+	recorder := getFlavorKey(r, uuid.New().String())
 	t.Log(recorder.Body.String())
-	assert.Equal(http.StatusInternalServerError, recorder.Code)
+	assert.Equal(http.StatusBadGateway, recorder.Code)
+	assert.Equal(errCodeHvsReportFailed, decodeErrorResponse(t, recorder).Code)
+	assert.Empty(recorder.Header().Get("Retry-After"))
+}
+
+func TestFlavorKeyInvalidSaml(t *testing.T) {
+	log.Trace("resource/images_test:TestFlavorKeyInvalidSaml() Entering")
+	defer log.Trace("resource/images_test:TestFlavorKeyInvalidSaml() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	r := setupMockServer(db)
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, false)()
+
+	recorder := getFlavorKey(r, hwid)
+	assert.Equal(http.StatusBadGateway, recorder.Code)
+	assert.Equal(errCodeSamlVerificationFailed, decodeErrorResponse(t, recorder).Code)
+
+	fetchSamlReport = func(hwid string) ([]byte, error) {
+		return []byte("<saml2:Assertion"), nil
+	}
+	recorder = getFlavorKey(r, hwid)
+	assert.Equal(http.StatusBadGateway, recorder.Code)
+	assert.Equal(errCodeSamlInvalid, decodeErrorResponse(t, recorder).Code)
 }
 
 func TestFlavorKeyKMSDown(t *testing.T) {
@@ -133,18 +213,16 @@ func TestFlavorKeyKMSDown(t *testing.T) {
 	assert := assert.New(t)
 	db := new(mock.Database)
 	r := setupMockServer(db)
-	config.Configuration.HvsApiUrl = "http://localhost:6338/mtwilson/v2/"
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, true)()
+	defer useFailingKBS(t, db, connectionRefused("http://localhost:6337/v1/keys/"+coalescedKeyID+"/transfer"))()
 
-	h := mockHVS(":6338")
-	defer h.Close()
-	time.Sleep(1 * time.Second)
 	// Test Flavor-Key
-	recorder := httptest.NewRecorder()
-	req := httptest.NewRequest("GET", "/wls/v1/images/dddd021e-9669-4e53-9224-8880fb4e4080/flavor-key?hardware_uuid=ecee021e-9669-4e53-9224-8880fb4e4080", nil)
-	req.Header.Add("Authorization", "Bearer "+BearerToken)
-	r.ServeHTTP(recorder, req)
+	recorder := getFlavorKey(r, hwid)
 	t.Log(recorder.Body.String())
-	assert.Equal(http.StatusInternalServerError, recorder.Code)
+	assert.Equal(http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(errCodeKbsUnavailable, decodeErrorResponse(t, recorder).Code)
+	assert.Equal("30", recorder.Header().Get("Retry-After"))
 }
 
 func TestFlavorKeyKMSBadRequest(t *testing.T) {
@@ -153,20 +231,16 @@ func TestFlavorKeyKMSBadRequest(t *testing.T) {
 	assert := assert.New(t)
 	db := new(mock.Database)
 	r := setupMockServer(db)
-	config.Configuration.HvsApiUrl = "http://localhost:7338/mtwilson/v2/"
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, true)()
+	defer useFailingKBS(t, db, errors.New("failed to transfer key: 400 Bad Request"))()
 
-	h := mockHVS(":7338")
-	defer h.Close()
-	k := badKMS(":7337")
-	defer k.Close()
-	time.Sleep(1 * time.Second)
 	// Test Flavor-Key
-	recorder := httptest.NewRecorder()
-	req := httptest.NewRequest("GET", "/wls/v1/images/dddd021e-9669-4e53-9224-8880fb4e4080/flavor-key?hardware_uuid=ecee021e-9669-4e53-9224-8880fb4e4080", nil)
-	req.Header.Add("Authorization", "Bearer "+BearerToken)
-	r.ServeHTTP(recorder, req)
+	recorder := getFlavorKey(r, hwid)
 	t.Log(recorder.Body.String())
-	assert.Equal(http.StatusInternalServerError, recorder.Code)
+	assert.Equal(http.StatusBadGateway, recorder.Code)
+	assert.Equal(errCodeKbsTransferFailed, decodeErrorResponse(t, recorder).Code)
+	assert.Empty(recorder.Header().Get("Retry-After"))
 }
 
 func TestQueryEmptyImagesResource(t *testing.T) {
@@ -239,3 +313,28 @@ func TestCreateImageEmptyFlavors(t *testing.T) {
 	r.ServeHTTP(recorder, req)
 	assert.Equal(http.StatusCreated, recorder.Code)
 }
+
+func TestKeyCacheAlternatingImageLaunches(t *testing.T) {
+	log.Trace("resource/images_test:TestKeyCacheAlternatingImageLaunches() Entering")
+	defer log.Trace("resource/images_test:TestKeyCacheAlternatingImageLaunches() Leaving")
+	assert := assert.New(t)
+	hwid := "3e7f9b8a-9d63-4c51-8a0a-4a6f3a0a2b11"
+	firstKeyID := "73755fda-c910-46be-821f-e8ddeab189e9"
+	secondKeyID := "1d5ae8e1-6b1e-4b92-9a43-0c52ef2a3a57"
+
+	assert.NoError(cacheKeyInMemory(hwid, firstKeyID, []byte{0, 1, 2, 3}))
+	assert.NoError(cacheKeyInMemory(hwid, secondKeyID, []byte{4, 5, 6, 7}))
+
+	// launching VMs from the two images in turn keeps both keys of the host cached
+	for i := 0; i < 3; i++ {
+		key, err := getKeyFromCache(hwid, firstKeyID)
+		assert.NoError(err)
+		assert.Equal([]byte{0, 1, 2, 3}, key.Bytes)
+		key, err = getKeyFromCache(hwid, secondKeyID)
+		assert.NoError(err)
+		assert.Equal([]byte{4, 5, 6, 7}, key.Bytes)
+	}
+
+	_, err := getKeyFromCache("ffffffff-9d63-4c51-8a0a-4a6f3a0a2b11", firstKeyID)
+	assert.Error(err)
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// transferID identifies the transfer of a key from a KBS to a host
+type transferID struct {
+	hardwareUUID string
+	kbsBaseURL   string
+	keyID        string
+}
+
+// newTransferID identifies the transfer of a key from the KBS at kbsBaseURL to a host whatever the case and format
+// of its hardware UUID and the case of the key ID
+func newTransferID(hardwareUUID, kbsBaseURL, keyID string) transferID {
+	if hwid, err := uuid.Parse(hardwareUUID); err == nil {
+		hardwareUUID = hwid.String()
+	}
+	return transferID{hardwareUUID: hardwareUUID, kbsBaseURL: kbsBaseURL, keyID: strings.ToLower(keyID)}
+}
+
+// transferResult is the key released to a host, and the ID of the SAML report of the host it was released for.
+// The SAML assertion ID is also set when the key is not released once the SAML report of the host is known.
+type transferResult struct {
+	key             []byte
+	samlAssertionID string
+}
+
+// inflightTransfer is a key transfer in progress, whose result is shared with every caller waiting on it
+type inflightTransfer struct {
+	wg      *sync.WaitGroup
+	result  transferResult
+	err     error
+	waiters int
+}
+
+// transferGroup collapses concurrent transfers of the same key from the same KBS to the same host into a single transfer
+type transferGroup struct {
+	mtx       *sync.Mutex
+	transfers map[transferID]*inflightTransfer
+}
+
+func newTransferGroup() *transferGroup {
+	return &transferGroup{
+		mtx:       &sync.Mutex{},
+		transfers: make(map[transferID]*inflightTransfer),
+	}
+}
+
+// do runs transfer, unless a transfer of the same key from the same KBS to the same host is already in progress,
+// in which case it waits for that transfer to complete and returns its result. Each caller gets its own copy of the key.
+func (g *transferGroup) do(hardwareUUID, kbsBaseURL, keyID string, transfer func() (transferResult, error)) (transferResult, error) {
+	id := newTransferID(hardwareUUID, kbsBaseURL, keyID)
+	g.mtx.Lock()
+	if t, exists := g.transfers[id]; exists {
+		t.waiters++
+		g.mtx.Unlock()
+		t.wg.Wait()
+		return t.result.copy(), t.err
+	}
+	t := &inflightTransfer{wg: &sync.WaitGroup{}, err: errors.New("resource/inflight:do() key transfer did not complete")}
+	t.wg.Add(1)
+	g.transfers[id] = t
+	g.mtx.Unlock()
+
+	// the transfer is removed and its waiters released even if it panics
+	defer func() {
+		g.mtx.Lock()
+		delete(g.transfers, id)
+		g.mtx.Unlock()
+		t.wg.Done()
+	}()
+	t.result, t.err = transfer()
+	return t.result.copy(), t.err
+}
+
+// waiting returns the number of callers waiting on the transfer of a key from a KBS to a host
+func (g *transferGroup) waiting(hardwareUUID, kbsBaseURL, keyID string) int {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if t, exists := g.transfers[newTransferID(hardwareUUID, kbsBaseURL, keyID)]; exists {
+		return t.waiters
+	}
+	return 0
+}
+
+// copy returns the result with its own copy of the key
+func (r transferResult) copy() transferResult {
+	return transferResult{key: copyBytes(r.key), samlAssertionID: r.samlAssertionID}
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
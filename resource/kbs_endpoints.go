@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/workload-service/v4/config"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// keyTransferPathRegex matches the path of a key URL relative to the base URL of its KBS
+var keyTransferPathRegex = regexp.MustCompile("^keys/" + uuidv4 + "/transfer$")
+
+// errKeyURLUntrusted is returned for key URLs that are not on a KBS of the configured registry
+var errKeyURLUntrusted = errors.New("key URL is not on a trusted KBS")
+
+// kbsEndpoint is a KBS trusted to release keys. Its TLS certificate is verified with the certificates of its CA
+// bundle if set, with the trusted CA certificates otherwise.
+type kbsEndpoint struct {
+	BaseURL  string
+	CABundle string
+	url      *url.URL
+}
+
+// configuredKBSEndpoints returns the KBS endpoints of the configuration, the invalid ones are ignored
+func configuredKBSEndpoints() []kbsEndpoint {
+	log.Trace("resource/kbs_endpoints:configuredKBSEndpoints() Entering")
+	defer log.Trace("resource/kbs_endpoints:configuredKBSEndpoints() Leaving")
+
+	var endpoints []kbsEndpoint
+	for _, e := range config.Configuration.KBSEndpoints {
+		u, err := url.Parse(e.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil ||
+			u.RawQuery != "" || u.Fragment != "" {
+			log.Errorf("resource/kbs_endpoints:configuredKBSEndpoints() %s : Invalid KBS URL %q, the KBS is ignored", message.InvalidInputBadParam, e.URL)
+			continue
+		}
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+		endpoints = append(endpoints, kbsEndpoint{BaseURL: u.String(), CABundle: e.CABundle, url: u})
+	}
+	return endpoints
+}
+
+// hostPort returns the host of a URL in lower case, along with its port or the default port of its scheme
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if strings.EqualFold(u.Scheme, "http") {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// trustedKBSEndpoint returns the KBS of the configured registry serving a key URL. The key URL must be the
+// transfer URL of a key under the base URL of the KBS.
+func trustedKBSEndpoint(keyURL string) (*kbsEndpoint, error) {
+	u, err := url.Parse(keyURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "key URL is malformed")
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" || u.Opaque != "" {
+		return nil, errKeyURLUntrusted
+	}
+	for _, e := range configuredKBSEndpoints() {
+		if !strings.EqualFold(u.Scheme, e.url.Scheme) || hostPort(u) != hostPort(e.url) ||
+			!strings.HasPrefix(u.Path, e.url.Path) {
+			continue
+		}
+		if keyTransferPathRegex.MatchString(strings.TrimPrefix(u.Path, e.url.Path)) {
+			endpoint := e
+			return &endpoint, nil
+		}
+	}
+	return nil, errKeyURLUntrusted
+}
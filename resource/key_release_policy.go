@@ -0,0 +1,241 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/lib/common/v4/validation"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// samlAttributeNameRegex matches the names of the SAML attributes a key release policy may require, such as
+// TRUST_PLATFORM, TAG_Location or FEATURE_TPM
+var samlAttributeNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,255}$`)
+
+// validateKeyReleasePolicy checks that a policy requires at least one attribute, each named once and with at least
+// one allowed value
+func validateKeyReleasePolicy(policy *model.KeyReleasePolicy) error {
+	if len(policy.RequiredAttributes) == 0 {
+		return errors.New("key release policy requires no attribute")
+	}
+	names := make(map[string]bool)
+	for _, attribute := range policy.RequiredAttributes {
+		if !samlAttributeNameRegex.MatchString(attribute.Name) {
+			return fmt.Errorf("invalid attribute name %q", attribute.Name)
+		}
+		if names[attribute.Name] {
+			return fmt.Errorf("attribute %s is required more than once", attribute.Name)
+		}
+		names[attribute.Name] = true
+		if len(attribute.Values) == 0 {
+			return fmt.Errorf("attribute %s has no allowed value", attribute.Name)
+		}
+	}
+	return nil
+}
+
+// keyReleaseDenials returns why a host with the given SAML report does not satisfy key release policies, none when
+// it satisfies all of them. A required attribute is satisfied when one of its values in the report is allowed.
+func keyReleaseDenials(policies []model.KeyReleasePolicy, samlStruct *Saml) []string {
+	log.Trace("resource/key_release_policy:keyReleaseDenials() Entering")
+	defer log.Trace("resource/key_release_policy:keyReleaseDenials() Leaving")
+
+	var reasons []string
+	for _, policy := range policies {
+		for _, required := range policy.RequiredAttributes {
+			var values []string
+			for _, attribute := range samlStruct.Attribute {
+				if attribute.Name == required.Name {
+					values = append(values, attribute.AttributeValue)
+				}
+			}
+			if len(values) == 0 {
+				reasons = append(reasons, fmt.Sprintf("%s is missing, the policy of image %s requires one of [%s]",
+					required.Name, policy.ImageID, strings.Join(required.Values, ", ")))
+				continue
+			}
+			if !anyAllowed(values, required.Values) {
+				reasons = append(reasons, fmt.Sprintf("%s is %s, the policy of image %s requires one of [%s]",
+					required.Name, strings.Join(values, ", "), policy.ImageID, strings.Join(required.Values, ", ")))
+			}
+		}
+	}
+	return reasons
+}
+
+// anyAllowed returns whether one of the values is allowed
+func anyAllowed(values, allowed []string) bool {
+	for _, v := range values {
+		for _, a := range allowed {
+			if v == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getKeyReleasePolicy(db repository.WlsDatabase) endpointHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.Trace("resource/key_release_policy:getKeyReleasePolicy() Entering")
+		defer log.Trace("resource/key_release_policy:getKeyReleasePolicy() Leaving")
+
+		id := mux.Vars(r)["id"]
+		if err := validation.ValidateUUIDv4(id); err != nil {
+			log.WithError(err).Errorf("resource/key_release_policy:getKeyReleasePolicy() %s : Invalid image UUID format", message.InvalidInputProtocolViolation)
+			log.Tracef("%+v", err)
+			return &endpointError{
+				Message:    "Failed to retrieve key release policy - invalid image UUID",
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		cLog := log.WithField("imageUUID", id)
+		policy, err := db.KeyReleasePolicyRepository().RetrieveByImageID(id)
+		if err != nil {
+			if errors.Cause(err) == repository.ErrKeyReleasePolicyNotFound {
+				cLog.Info("resource/key_release_policy:getKeyReleasePolicy() Image has no key release policy")
+				return &endpointError{
+					Message:    "Key release policy not found for image",
+					StatusCode: http.StatusNotFound,
+				}
+			}
+			cLog.WithError(err).Errorf("resource/key_release_policy:getKeyReleasePolicy() %s : Failed to retrieve key release policy", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{
+				Message:    "Failed to retrieve key release policy - backend error",
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(policy); err != nil {
+			cLog.WithError(err).Errorf("resource/key_release_policy:getKeyReleasePolicy() %s : Unexpectedly failed to encode key release policy to JSON", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{
+				Message:    "Failed to retrieve key release policy - JSON marshal error",
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+		cLog.Debug("resource/key_release_policy:getKeyReleasePolicy() Successfully retrieved key release policy")
+		return nil
+	}
+}
+
+func putKeyReleasePolicy(db repository.WlsDatabase) endpointHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.Trace("resource/key_release_policy:putKeyReleasePolicy() Entering")
+		defer log.Trace("resource/key_release_policy:putKeyReleasePolicy() Leaving")
+
+		id := mux.Vars(r)["id"]
+		if err := validation.ValidateUUIDv4(id); err != nil {
+			log.WithError(err).Errorf("resource/key_release_policy:putKeyReleasePolicy() %s : Invalid image UUID format", message.InvalidInputProtocolViolation)
+			log.Tracef("%+v", err)
+			return &endpointError{
+				Message:    "Failed to store key release policy - invalid image UUID",
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		cLog := log.WithField("imageUUID", id)
+
+		var policy model.KeyReleasePolicy
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&policy); err != nil {
+			cLog.WithError(err).Errorf("resource/key_release_policy:putKeyReleasePolicy() %s : Failed to decode request body as key release policy", message.InvalidInputBadEncoding)
+			log.Tracef("%+v", err)
+			return &endpointError{
+				Message:    "Failed to store key release policy - JSON marshal error",
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		if policy.ImageID != "" && !strings.EqualFold(policy.ImageID, id) {
+			cLog.Errorf("resource/key_release_policy:putKeyReleasePolicy() %s : Key release policy is for image %s", message.InvalidInputBadParam, policy.ImageID)
+			return &endpointError{
+				Message:    "Failed to store key release policy - image_id does not match the image",
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		if err := validateKeyReleasePolicy(&policy); err != nil {
+			cLog.WithError(err).Errorf("resource/key_release_policy:putKeyReleasePolicy() %s : Invalid key release policy", message.InvalidInputBadParam)
+			return &endpointError{
+				Message:    "Failed to store key release policy - " + err.Error(),
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		policy.ImageID = id
+
+		if err := db.KeyReleasePolicyRepository().Store(&policy); err != nil {
+			if errors.Cause(err) == repository.ErrKeyReleasePolicyImageNotFound {
+				cLog.WithError(err).Errorf("resource/key_release_policy:putKeyReleasePolicy() %s : Image does not exist", message.InvalidInputBadParam)
+				return &endpointError{
+					Message:    "Failed to store key release policy - image not found",
+					StatusCode: http.StatusNotFound,
+				}
+			}
+			cLog.WithError(err).Errorf("resource/key_release_policy:putKeyReleasePolicy() %s : Failed to store key release policy", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{
+				Message:    "Failed to store key release policy - backend error",
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+		seclog.WithField("imageUUID", id).Infof("resource/key_release_policy:putKeyReleasePolicy() %s : Key release policy stored by: %s", message.ConfigChanged, r.RemoteAddr)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(policy); err != nil {
+			cLog.WithError(err).Errorf("resource/key_release_policy:putKeyReleasePolicy() %s : Unexpectedly failed to encode key release policy to JSON", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{
+				Message:    "Failed to store key release policy - JSON marshal error",
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+		return nil
+	}
+}
+
+func deleteKeyReleasePolicy(db repository.WlsDatabase) endpointHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.Trace("resource/key_release_policy:deleteKeyReleasePolicy() Entering")
+		defer log.Trace("resource/key_release_policy:deleteKeyReleasePolicy() Leaving")
+
+		id := mux.Vars(r)["id"]
+		if err := validation.ValidateUUIDv4(id); err != nil {
+			log.WithError(err).Errorf("resource/key_release_policy:deleteKeyReleasePolicy() %s : Invalid image UUID format", message.InvalidInputProtocolViolation)
+			log.Tracef("%+v", err)
+			return &endpointError{
+				Message:    "Failed to delete key release policy - invalid image UUID",
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		cLog := log.WithField("imageUUID", id)
+		if err := db.KeyReleasePolicyRepository().DeleteByImageID(id); err != nil {
+			if errors.Cause(err) == repository.ErrKeyReleasePolicyNotFound {
+				cLog.Info("resource/key_release_policy:deleteKeyReleasePolicy() Image has no key release policy")
+				return &endpointError{
+					Message:    "Key release policy not found for image",
+					StatusCode: http.StatusNotFound,
+				}
+			}
+			cLog.WithError(err).Errorf("resource/key_release_policy:deleteKeyReleasePolicy() %s : Failed to delete key release policy", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{
+				Message:    "Failed to delete key release policy - backend error",
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+		seclog.WithField("imageUUID", id).Infof("resource/key_release_policy:deleteKeyReleasePolicy() %s : Key release policy deleted by: %s", message.ConfigChanged, r.RemoteAddr)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
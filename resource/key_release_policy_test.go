@@ -0,0 +1,273 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"intel/isecl/workload-service/v4/repository/mock"
+	"intel/isecl/workload-service/v4/samlcache"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const policyTestImageID = "dddd021e-9669-4e53-9224-8880fb4e4080"
+
+// locationPolicy requires a trusted OS on a host tagged with one of the given locations
+func locationPolicy(locations ...string) model.KeyReleasePolicy {
+	return model.KeyReleasePolicy{
+		ImageID: policyTestImageID,
+		RequiredAttributes: []model.RequiredAttribute{
+			{Name: "TRUST_OS", Values: []string{"true"}},
+			{Name: "TAG_Location", Values: locations},
+		},
+	}
+}
+
+func putPolicy(r http.Handler, imageID, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/wls/v1/images/"+imageID+"/policy", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestKeyReleaseDenials(t *testing.T) {
+	log.Trace("resource/key_release_policy_test:TestKeyReleaseDenials() Entering")
+	defer log.Trace("resource/key_release_policy_test:TestKeyReleaseDenials() Leaving")
+
+	tests := []struct {
+		name       string
+		policies   []model.KeyReleasePolicy
+		attributes []Attribute
+		reasons    []string
+	}{
+		{name: "no policy", attributes: []Attribute{{Name: "TRUST_OS", AttributeValue: "false"}}},
+		{
+			name:       "satisfied",
+			policies:   []model.KeyReleasePolicy{locationPolicy("Portland", "Hillsboro")},
+			attributes: []Attribute{{Name: "TRUST_OS", AttributeValue: "true"}, {Name: "TAG_Location", AttributeValue: "Hillsboro"}},
+		},
+		{
+			name:       "one of several tag values",
+			policies:   []model.KeyReleasePolicy{locationPolicy("Hillsboro")},
+			attributes: []Attribute{{Name: "TRUST_OS", AttributeValue: "true"}, {Name: "TAG_Location", AttributeValue: "Portland"}, {Name: "TAG_Location", AttributeValue: "Hillsboro"}},
+		},
+		{
+			name:       "attribute value not allowed",
+			policies:   []model.KeyReleasePolicy{locationPolicy("Portland")},
+			attributes: []Attribute{{Name: "TRUST_OS", AttributeValue: "false"}, {Name: "TAG_Location", AttributeValue: "Portland"}},
+			reasons:    []string{"TRUST_OS is false, the policy of image " + policyTestImageID + " requires one of [true]"},
+		},
+		{
+			name:       "attribute missing",
+			policies:   []model.KeyReleasePolicy{locationPolicy("Portland", "Hillsboro")},
+			attributes: []Attribute{{Name: "TRUST_OS", AttributeValue: "true"}},
+			reasons:    []string{"TAG_Location is missing, the policy of image " + policyTestImageID + " requires one of [Portland, Hillsboro]"},
+		},
+		{
+			name:       "attribute names are case sensitive",
+			policies:   []model.KeyReleasePolicy{locationPolicy("Portland")},
+			attributes: []Attribute{{Name: "TRUST_OS", AttributeValue: "true"}, {Name: "tag_location", AttributeValue: "Portland"}},
+			reasons:    []string{"TAG_Location is missing, the policy of image " + policyTestImageID + " requires one of [Portland]"},
+		},
+		{
+			name: "all policies are checked",
+			policies: []model.KeyReleasePolicy{
+				locationPolicy("Portland"),
+				{ImageID: "e5f1ed9e-8a4f-4f6f-94a2-4a23ba9e8e5e", RequiredAttributes: []model.RequiredAttribute{{Name: "FEATURE_TPM", Values: []string{"true"}}}},
+			},
+			attributes: []Attribute{{Name: "TRUST_OS", AttributeValue: "true"}, {Name: "TAG_Location", AttributeValue: "Portland"}, {Name: "FEATURE_TPM", AttributeValue: "false"}},
+			reasons:    []string{"FEATURE_TPM is false, the policy of image e5f1ed9e-8a4f-4f6f-94a2-4a23ba9e8e5e requires one of [true]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			samlStruct := &Saml{Attribute: append([]Attribute{{Name: "TRUST_OVERALL", AttributeValue: "true"}}, test.attributes...)}
+			assert.Equal(t, test.reasons, keyReleaseDenials(test.policies, samlStruct))
+		})
+	}
+}
+
+func TestPutKeyReleasePolicy(t *testing.T) {
+	log.Trace("resource/key_release_policy_test:TestPutKeyReleasePolicy() Entering")
+	defer log.Trace("resource/key_release_policy_test:TestPutKeyReleasePolicy() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	var stored *model.KeyReleasePolicy
+	db.MockKeyReleasePolicy.StoreFn = func(policy *model.KeyReleasePolicy) error {
+		stored = policy
+		return nil
+	}
+	r := setupMockServer(db)
+
+	recorder := putPolicy(r, policyTestImageID, `{"required_attributes":[{"name":"TRUST_OS","values":["true"]},{"name":"TAG_Location","values":["Portland"]}]}`)
+	assert.Equal(http.StatusOK, recorder.Code)
+	expected := locationPolicy("Portland")
+	assert.Equal(&expected, stored)
+	var policy model.KeyReleasePolicy
+	assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &policy))
+	assert.Equal(expected, policy)
+
+	// the image ID of the body may be omitted, but must match the image otherwise
+	recorder = putPolicy(r, policyTestImageID, `{"image_id":"`+policyTestImageID+`","required_attributes":[{"name":"TRUST_OS","values":["true"]}]}`)
+	assert.Equal(http.StatusOK, recorder.Code)
+}
+
+func TestPutKeyReleasePolicyInvalid(t *testing.T) {
+	log.Trace("resource/key_release_policy_test:TestPutKeyReleasePolicyInvalid() Entering")
+	defer log.Trace("resource/key_release_policy_test:TestPutKeyReleasePolicyInvalid() Leaving")
+	db := new(mock.Database)
+	db.MockKeyReleasePolicy.StoreFn = func(policy *model.KeyReleasePolicy) error {
+		t.Error("invalid key release policy stored")
+		return nil
+	}
+	r := setupMockServer(db)
+
+	tests := []struct {
+		name    string
+		imageID string
+		body    string
+	}{
+		{name: "invalid image UUID", imageID: "not-a-uuid", body: `{"required_attributes":[{"name":"TRUST_OS","values":["true"]}]}`},
+		{name: "malformed", body: `{"required_attributes":`},
+		{name: "unknown field", body: `{"required_attributes":[{"name":"TRUST_OS","values":["true"]}],"trusted":true}`},
+		{name: "other image", body: `{"image_id":"e5f1ed9e-8a4f-4f6f-94a2-4a23ba9e8e5e","required_attributes":[{"name":"TRUST_OS","values":["true"]}]}`},
+		{name: "no attribute", body: `{"required_attributes":[]}`},
+		{name: "no attribute name", body: `{"required_attributes":[{"name":"","values":["true"]}]}`},
+		{name: "invalid attribute name", body: `{"required_attributes":[{"name":"TAG Location","values":["Portland"]}]}`},
+		{name: "no allowed value", body: `{"required_attributes":[{"name":"TRUST_OS","values":[]}]}`},
+		{name: "duplicate attribute", body: `{"required_attributes":[{"name":"TRUST_OS","values":["true"]},{"name":"TRUST_OS","values":["false"]}]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			imageID := test.imageID
+			if imageID == "" {
+				imageID = policyTestImageID
+			}
+			recorder := putPolicy(r, imageID, test.body)
+			assert.Equal(t, http.StatusBadRequest, recorder.Code)
+			assert.Equal(t, errCodeInvalidRequest, decodeErrorResponse(t, recorder).Code)
+		})
+	}
+}
+
+func TestKeyReleasePolicyImageNotFound(t *testing.T) {
+	log.Trace("resource/key_release_policy_test:TestKeyReleasePolicyImageNotFound() Entering")
+	defer log.Trace("resource/key_release_policy_test:TestKeyReleasePolicyImageNotFound() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	db.MockKeyReleasePolicy.StoreFn = func(policy *model.KeyReleasePolicy) error {
+		return repository.ErrKeyReleasePolicyImageNotFound
+	}
+	r := setupMockServer(db)
+
+	recorder := putPolicy(r, policyTestImageID, `{"required_attributes":[{"name":"TRUST_OS","values":["true"]}]}`)
+	assert.Equal(http.StatusNotFound, recorder.Code)
+	assert.Equal(errCodeNotFound, decodeErrorResponse(t, recorder).Code)
+}
+
+func TestGetAndDeleteKeyReleasePolicy(t *testing.T) {
+	log.Trace("resource/key_release_policy_test:TestGetAndDeleteKeyReleasePolicy() Entering")
+	defer log.Trace("resource/key_release_policy_test:TestGetAndDeleteKeyReleasePolicy() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	r := setupMockServer(db)
+	request := func(method string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/wls/v1/images/"+policyTestImageID+"/policy", nil)
+		req.Header.Add("Authorization", "Bearer "+BearerToken)
+		r.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// the mock has no policy
+	recorder := request("GET")
+	assert.Equal(http.StatusNotFound, recorder.Code)
+	assert.Equal(errCodeNotFound, decodeErrorResponse(t, recorder).Code)
+	recorder = request("DELETE")
+	assert.Equal(http.StatusNotFound, recorder.Code)
+
+	policy := locationPolicy("Portland")
+	db.MockKeyReleasePolicy.RetrieveByImageIDFn = func(imageID string) (*model.KeyReleasePolicy, error) {
+		assert.Equal(policyTestImageID, imageID)
+		return &policy, nil
+	}
+	db.MockKeyReleasePolicy.DeleteByImageIDFn = func(imageID string) error {
+		assert.Equal(policyTestImageID, imageID)
+		return nil
+	}
+	recorder = request("GET")
+	assert.Equal(http.StatusOK, recorder.Code)
+	var retrieved model.KeyReleasePolicy
+	assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &retrieved))
+	assert.Equal(policy, retrieved)
+	recorder = request("DELETE")
+	assert.Equal(http.StatusNoContent, recorder.Code)
+}
+
+func TestRetrieveKeyReleasePolicyDenied(t *testing.T) {
+	log.Trace("resource/key_release_policy_test:TestRetrieveKeyReleasePolicyDenied() Entering")
+	defer log.Trace("resource/key_release_policy_test:TestRetrieveKeyReleasePolicyDenied() Leaving")
+	hwid := uuid.New().String()
+	hvs := &fakeHVS{trusted: true, validity: time.Hour, attributes: []Attribute{{Name: "TRUST_OS", AttributeValue: "true"}}}
+	// the key is cached for the host, it is released from the cache only to hosts satisfying the policy
+	defer setupFakeHVS(hwid, hvs, true)()
+
+	db := new(mock.Database)
+	db.MockKeyReleasePolicy.RetrieveByKeyIDFn = func(keyID string) ([]model.KeyReleasePolicy, error) {
+		if keyID != samlTestKeyID {
+			return nil, nil
+		}
+		return []model.KeyReleasePolicy{locationPolicy("Portland")}, nil
+	}
+	r := setupMockServer(db)
+	postKey := func() *httptest.ResponseRecorder {
+		body, err := json.Marshal(model.RequestKey{HwId: hwid, KeyUrl: samlTestKeyURL})
+		if err != nil {
+			t.Fatal("could not marshal key request")
+		}
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/wls/v1/keys", bytes.NewBuffer(body))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", "Bearer "+BearerToken)
+		r.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("denied", func(t *testing.T) {
+		assert := assert.New(t)
+		for _, recorder := range []*httptest.ResponseRecorder{getFlavorKey(r, hwid), postKey()} {
+			assert.Equal(http.StatusForbidden, recorder.Code)
+			errResponse := decodeErrorResponse(t, recorder)
+			assert.Equal(errCodeKeyReleaseDenied, errResponse.Code)
+			assert.Equal(map[string]interface{}{
+				"reasons": []interface{}{"TAG_Location is missing, the policy of image " + policyTestImageID + " requires one of [Portland]"},
+			}, errResponse.Details)
+		}
+	})
+
+	t.Run("released", func(t *testing.T) {
+		assert := assert.New(t)
+		// the host is tagged and attested again
+		hvs.attributes = append(hvs.attributes, Attribute{Name: "TAG_Location", AttributeValue: "Portland"})
+		samlcache.Delete(hwid)
+		recorder := postKey()
+		assert.Equal(http.StatusOK, recorder.Code)
+		var key model.ReturnKey
+		assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &key))
+		assert.Equal([]byte{0, 1, 2, 3}, key.Key)
+	})
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"encoding/json"
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/lib/common/v4/validation"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// maxPrincipalLength is the length of the longest principal the key releases can be filtered on
+const maxPrincipalLength = 255
+
+// SetKeyReleasesEndpoints sets endpoints for /key-releases
+func SetKeyReleasesEndpoints(r *mux.Router, db repository.WlsDatabase) {
+	log.Trace("resource/key_releases:SetKeyReleasesEndpoints() Entering")
+	defer log.Trace("resource/key_releases:SetKeyReleasesEndpoints() Leaving")
+
+	r.HandleFunc("", errorHandler(requiresPermission(getKeyReleases(db), []string{constants.KeyReleasesSearch}))).Methods("GET")
+}
+
+// callerPrincipal returns the subject of the bearer token of the caller, or the common name of its client certificate
+func callerPrincipal(r *http.Request) string {
+	if subjects, err := tokenClaim(r, "sub"); err == nil && len(subjects) > 0 {
+		return subjects[0]
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return ""
+}
+
+// recordKeyRelease records the outcome of a key transfer attempt in the audit trail. The attempt is denied when it
+// failed with a client error, and failed otherwise, including when no key came out of it. A key is not returned
+// unless its release is recorded.
+func recordKeyRelease(releases repository.KeyReleaseRepository, release *model.KeyRelease, key []byte, err error, cLog *logrus.Entry, retrievalErr string) ([]byte, error) {
+	log.Trace("resource/key_releases:recordKeyRelease() Entering")
+	defer log.Trace("resource/key_releases:recordKeyRelease() Leaving")
+
+	if err == nil && key == nil {
+		cLog.Errorf("resource/key_releases:recordKeyRelease() %s : Key transfer completed without a key", message.AppRuntimeErr)
+		err = &endpointError{
+			Message:    retrievalErr + " - No key was released",
+			StatusCode: http.StatusInternalServerError,
+			Code:       errCodeKbsTransferFailed,
+		}
+	}
+	release.Decision = model.KeyReleaseReleased
+	if err != nil {
+		release.Decision = model.KeyReleaseFailed
+		release.DenialReason = err.Error()
+		if e, ok := err.(*endpointError); ok {
+			if e.StatusCode < http.StatusInternalServerError {
+				release.Decision = model.KeyReleaseDenied
+			}
+			release.DenialReason = e.Message
+			if details, ok := e.Details.(map[string][]string); ok && len(details["reasons"]) > 0 {
+				release.DenialReason += ": " + strings.Join(details["reasons"], "; ")
+			}
+		}
+	}
+
+	if createErr := releases.Create(release); createErr != nil {
+		cLog.WithError(createErr).Errorf("resource/key_releases:recordKeyRelease() %s : Failed to record the key release", message.AppRuntimeErr)
+		log.Tracef("%+v", createErr)
+		if err == nil {
+			return nil, &endpointError{
+				Message:    retrievalErr + " - Unable to record the key release",
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+	}
+	return key, err
+}
+
+// Gets the key release records matching the query parameters, by pages
+func getKeyReleases(db repository.WlsDatabase) endpointHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.Trace("resource/key_releases:getKeyReleases() Entering")
+		defer log.Trace("resource/key_releases:getKeyReleases() Leaving")
+
+		query := r.URL.Query()
+		filter := repository.KeyReleaseFilter{}
+
+		if hwid := query.Get("hardware_uuid"); hwid != "" {
+			if err := validation.ValidateHardwareUUID(hwid); err != nil {
+				log.WithError(err).Errorf("resource/key_releases:getKeyReleases() %s : Invalid hardware UUID format", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve key releases - invalid hardware_uuid", StatusCode: http.StatusBadRequest}
+			}
+			filter.HardwareUUID = strings.ToLower(hwid)
+		}
+		if imageID := query.Get("image_id"); imageID != "" {
+			if err := validation.ValidateUUIDv4(imageID); err != nil {
+				log.WithError(err).Errorf("resource/key_releases:getKeyReleases() %s : Invalid image UUID format", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve key releases - invalid image_id", StatusCode: http.StatusBadRequest}
+			}
+			filter.ImageID = strings.ToLower(imageID)
+		}
+		if keyID := query.Get("key_id"); keyID != "" {
+			if err := validation.ValidateUUIDv4(keyID); err != nil {
+				log.WithError(err).Errorf("resource/key_releases:getKeyReleases() %s : Invalid key UUID format", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve key releases - invalid key_id", StatusCode: http.StatusBadRequest}
+			}
+			filter.KeyID = strings.ToLower(keyID)
+		}
+		if principal := query.Get("principal"); principal != "" {
+			if len(principal) > maxPrincipalLength {
+				log.Errorf("resource/key_releases:getKeyReleases() %s : Invalid principal query parameter, too long", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve key releases - invalid principal", StatusCode: http.StatusBadRequest}
+			}
+			filter.Principal = principal
+		}
+		if decision := query.Get("decision"); decision != "" {
+			if decision != model.KeyReleaseReleased && decision != model.KeyReleaseDenied && decision != model.KeyReleaseFailed {
+				log.Errorf("resource/key_releases:getKeyReleases() %s : Invalid decision query parameter", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve key releases - decision must be released, denied or failed", StatusCode: http.StatusBadRequest}
+			}
+			filter.Decision = decision
+		}
+		if fromDate := query.Get("from_date"); fromDate != "" {
+			if err := validation.ValidateDate(fromDate); err != nil {
+				log.WithError(err).Errorf("resource/key_releases:getKeyReleases() %s : Invalid from date format. Expected date format yyyy-mm-ddThh:mm:ss", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve key releases - invalid from_date", StatusCode: http.StatusBadRequest}
+			}
+			filter.FromDate = fromDate
+		}
+		if toDate := query.Get("to_date"); toDate != "" {
+			if err := validation.ValidateDate(toDate); err != nil {
+				log.WithError(err).Errorf("resource/key_releases:getKeyReleases() %s : Invalid to date format. Expected date format yyyy-mm-ddThh:mm:ss", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve key releases - invalid to_date", StatusCode: http.StatusBadRequest}
+			}
+			filter.ToDate = toDate
+		}
+
+		// key releases are returned by pages of at most the configured maximum page size
+		maxPageSize := config.Configuration.ReportsMaxPageSize
+		if maxPageSize <= 0 {
+			maxPageSize = constants.DefaultReportsMaxPageSize
+		}
+		filter.Limit = maxPageSize
+		if limit := query.Get("limit"); limit != "" {
+			l, err := strconv.Atoi(limit)
+			if err != nil || l < 1 {
+				log.WithError(err).Errorf("resource/key_releases:getKeyReleases() %s : Invalid limit query parameter, must be a positive integer", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve key releases - limit must be a positive integer", StatusCode: http.StatusBadRequest}
+			}
+			if l < maxPageSize {
+				filter.Limit = l
+			}
+		}
+		if offset := query.Get("offset"); offset != "" {
+			o, err := strconv.Atoi(offset)
+			if err != nil || o < 0 {
+				log.WithError(err).Errorf("resource/key_releases:getKeyReleases() %s : Invalid offset query parameter, must be a non-negative integer", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve key releases - offset must be a non-negative integer", StatusCode: http.StatusBadRequest}
+			}
+			filter.Offset = o
+		}
+		if sortOrder := query.Get("sort_order"); sortOrder != "" {
+			order := strings.ToLower(sortOrder)
+			if order != repository.SortAscending && order != repository.SortDescending {
+				log.Errorf("resource/key_releases:getKeyReleases() %s : Invalid sort_order query parameter, must be asc or desc", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve key releases - sort_order must be asc or desc", StatusCode: http.StatusBadRequest}
+			}
+			filter.SortOrder = order
+		}
+
+		releases, err := db.KeyReleaseRepository().RetrieveByFilterCriteria(filter)
+		if err != nil {
+			log.WithError(err).Errorf("resource/key_releases:getKeyReleases() %s : Failed to retrieve key releases", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{Message: "Failed to retrieve key releases", StatusCode: http.StatusInternalServerError}
+		}
+		total, err := db.KeyReleaseRepository().CountByFilterCriteria(filter)
+		if err != nil {
+			log.WithError(err).Errorf("resource/key_releases:getKeyReleases() %s : Failed to count key releases", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{Message: "Failed to retrieve key releases", StatusCode: http.StatusInternalServerError}
+		}
+		if releases == nil {
+			releases = []model.KeyRelease{}
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		if nextOffset := filter.Offset + len(releases); len(releases) > 0 && nextOffset < total {
+			w.Header().Set("X-Next-Offset", strconv.Itoa(nextOffset))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(releases); err != nil {
+			log.WithError(err).Errorf("resource/key_releases:getKeyReleases() %s : Unexpectedly failed to encode key releases to JSON", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{Message: "Failed to retrieve key releases - JSON encode failed", StatusCode: http.StatusInternalServerError}
+		}
+		log.Debug("resource/key_releases:getKeyReleases() Successfully retrieved key releases")
+		return nil
+	}
+}
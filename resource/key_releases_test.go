@@ -0,0 +1,281 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/intel-secl/intel-secl/v4/pkg/clients/kbs"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"intel/isecl/workload-service/v4/repository/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDatabase is a mock database recording the key releases created in it
+func recordingDatabase() (*mock.Database, *[]model.KeyRelease) {
+	db := new(mock.Database)
+	releases := &[]model.KeyRelease{}
+	db.MockKeyRelease.CreateFn = func(release *model.KeyRelease) error {
+		*releases = append(*releases, *release)
+		return nil
+	}
+	return db, releases
+}
+
+func postKey(t *testing.T, r http.Handler, hwid, keyURL string) *httptest.ResponseRecorder {
+	body, err := json.Marshal(model.RequestKey{HwId: hwid, KeyUrl: keyURL})
+	if err != nil {
+		t.Fatal("could not marshal key request")
+	}
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/wls/v1/keys", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestKeyReleaseRecorded(t *testing.T) {
+	log.Trace("resource/key_releases_test:TestKeyReleaseRecorded() Entering")
+	defer log.Trace("resource/key_releases_test:TestKeyReleaseRecorded() Leaving")
+	assert := assert.New(t)
+	hwid := strings.ToUpper(uuid.New().String())
+	hvs := &fakeHVS{trusted: true, validity: time.Hour}
+	defer setupFakeHVS(hwid, hvs, true)()
+	db, releases := recordingDatabase()
+	r := setupMockServer(db)
+
+	assert.Equal(http.StatusOK, postKey(t, r, hwid, samlTestKeyURL).Code)
+	if assert.Len(*releases, 1) {
+		release := (*releases)[0]
+		assert.Equal(model.KeyReleaseReleased, release.Decision)
+		assert.Equal("global_admin_user", release.Principal)
+		assert.Equal(strings.ToLower(hwid), release.HardwareUUID)
+		assert.Equal(samlTestKeyID, release.KeyID)
+		assert.Empty(release.ImageID)
+		assert.Equal("HostTrustAssertion", release.SamlAssertionID)
+		assert.Empty(release.DenialReason)
+	}
+}
+
+func TestKeyReleaseDeniedRecorded(t *testing.T) {
+	log.Trace("resource/key_releases_test:TestKeyReleaseDeniedRecorded() Entering")
+	defer log.Trace("resource/key_releases_test:TestKeyReleaseDeniedRecorded() Leaving")
+
+	t.Run("untrusted host", func(t *testing.T) {
+		assert := assert.New(t)
+		hwid := uuid.New().String()
+		defer setupFakeHVS(hwid, &fakeHVS{trusted: false, validity: time.Hour}, true)()
+		db, releases := recordingDatabase()
+		r := setupMockServer(db)
+
+		assert.Equal(http.StatusForbidden, postKey(t, r, hwid, samlTestKeyURL).Code)
+		if assert.Len(*releases, 1) {
+			assert.Equal(model.KeyReleaseDenied, (*releases)[0].Decision)
+			assert.Contains((*releases)[0].DenialReason, "Host is untrusted")
+			assert.Equal("HostTrustAssertion", (*releases)[0].SamlAssertionID)
+		}
+	})
+
+	t.Run("key release policy", func(t *testing.T) {
+		assert := assert.New(t)
+		hwid := uuid.New().String()
+		defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour, attributes: []Attribute{{Name: "TRUST_OS", AttributeValue: "true"}}}, true)()
+		db, releases := recordingDatabase()
+		db.MockKeyReleasePolicy.RetrieveByKeyIDFn = func(string) ([]model.KeyReleasePolicy, error) {
+			return []model.KeyReleasePolicy{locationPolicy("Portland")}, nil
+		}
+		r := setupMockServer(db)
+
+		assert.Equal(http.StatusForbidden, getFlavorKey(r, hwid).Code)
+		if assert.Len(*releases, 1) {
+			release := (*releases)[0]
+			assert.Equal(model.KeyReleaseDenied, release.Decision)
+			assert.Equal(policyTestImageID, release.ImageID)
+			assert.True(strings.HasSuffix(release.DenialReason, ": TAG_Location is missing, the policy of image "+policyTestImageID+" requires one of [Portland]"), release.DenialReason)
+		}
+	})
+}
+
+func TestKeyReleaseFailedRecorded(t *testing.T) {
+	log.Trace("resource/key_releases_test:TestKeyReleaseFailedRecorded() Entering")
+	defer log.Trace("resource/key_releases_test:TestKeyReleaseFailedRecorded() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, true)()
+	kbsServer := newFakeKBS()
+	kbsServer.err = errors.New("key transfer denied")
+	close(kbsServer.release)
+	previousGetKBSClient := getKBSClient
+	getKBSClient = func(baseUrl, caBundle string) (kbs.KBSClient, error) {
+		return kbsServer, nil
+	}
+	defer func() {
+		getKBSClient = previousGetKBSClient
+	}()
+	db, releases := recordingDatabase()
+	r := setupMockServer(db)
+
+	assert.Equal(http.StatusBadGateway, postKey(t, r, hwid, coalescedKeyURL).Code)
+	if assert.Len(*releases, 1) {
+		assert.Equal(model.KeyReleaseFailed, (*releases)[0].Decision)
+		assert.Equal(coalescedKeyID, (*releases)[0].KeyID)
+		assert.Contains((*releases)[0].DenialReason, "Failed to retrieve key")
+	}
+}
+
+func TestKeyReleaseWithoutOverallTrust(t *testing.T) {
+	log.Trace("resource/key_releases_test:TestKeyReleaseWithoutOverallTrust() Entering")
+	defer log.Trace("resource/key_releases_test:TestKeyReleaseWithoutOverallTrust() Leaving")
+	for _, test := range []struct {
+		trustOverall string
+		status       int
+		code         string
+		decision     string
+	}{
+		{"", http.StatusBadGateway, errCodeSamlInvalid, model.KeyReleaseFailed},
+		{"TRUE", http.StatusForbidden, errCodeHostUntrusted, model.KeyReleaseDenied},
+		{"unknown", http.StatusForbidden, errCodeHostUntrusted, model.KeyReleaseDenied},
+	} {
+		t.Run(test.trustOverall, func(t *testing.T) {
+			assert := assert.New(t)
+			hwid := uuid.New().String()
+			trustOverall := test.trustOverall
+			defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour, trustOverall: &trustOverall}, true)()
+			db, releases := recordingDatabase()
+			r := setupMockServer(db)
+
+			// the key cached for the host is not released either
+			recorder := postKey(t, r, hwid, samlTestKeyURL)
+			assert.Equal(test.status, recorder.Code)
+			assert.Equal(test.code, decodeErrorResponse(t, recorder).Code)
+			if assert.Len(*releases, 1) {
+				assert.Equal(test.decision, (*releases)[0].Decision)
+			}
+		})
+	}
+}
+
+func TestKeyReleaseWithoutKeyFailed(t *testing.T) {
+	log.Trace("resource/key_releases_test:TestKeyReleaseWithoutKeyFailed() Entering")
+	defer log.Trace("resource/key_releases_test:TestKeyReleaseWithoutKeyFailed() Leaving")
+	assert := assert.New(t)
+	db, releases := recordingDatabase()
+	release := &model.KeyRelease{KeyID: samlTestKeyID}
+
+	key, err := recordKeyRelease(db.KeyReleaseRepository(), release, nil, nil, log.WithField("test", "TestKeyReleaseWithoutKeyFailed"), "Failed to retrieve key")
+	assert.Nil(key)
+	if assert.Error(err) {
+		assert.Equal(http.StatusInternalServerError, err.(*endpointError).StatusCode)
+	}
+	if assert.Len(*releases, 1) {
+		assert.Equal(model.KeyReleaseFailed, (*releases)[0].Decision)
+	}
+}
+
+func TestKeyReleaseNotRecordedNotReleased(t *testing.T) {
+	log.Trace("resource/key_releases_test:TestKeyReleaseNotRecordedNotReleased() Entering")
+	defer log.Trace("resource/key_releases_test:TestKeyReleaseNotRecordedNotReleased() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, true)()
+	db := new(mock.Database)
+	db.MockKeyRelease.CreateFn = func(*model.KeyRelease) error {
+		return errors.New("database is unavailable")
+	}
+	r := setupMockServer(db)
+
+	recorder := postKey(t, r, hwid, samlTestKeyURL)
+	assert.Equal(http.StatusInternalServerError, recorder.Code)
+	var key model.ReturnKey
+	assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &key))
+	assert.Empty(key.Key)
+}
+
+func getKeyReleasesRequest(r http.Handler, query string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/wls/v1/key-releases"+query, nil)
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestGetKeyReleases(t *testing.T) {
+	log.Trace("resource/key_releases_test:TestGetKeyReleases() Entering")
+	defer log.Trace("resource/key_releases_test:TestGetKeyReleases() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	var filter repository.KeyReleaseFilter
+	release := model.KeyRelease{
+		ID:           "2b8c4a5f-9d0e-4f2b-8a1c-3e5d7f9b1a2c",
+		HardwareUUID: "00ecd3ab-9af4-e711-906e-001560a04062",
+		KeyID:        samlTestKeyID,
+		Decision:     model.KeyReleaseReleased,
+	}
+	db.MockKeyRelease.RetrieveByFilterCriteriaFn = func(f repository.KeyReleaseFilter) ([]model.KeyRelease, error) {
+		filter = f
+		return []model.KeyRelease{release}, nil
+	}
+	db.MockKeyRelease.CountByFilterCriteriaFn = func(repository.KeyReleaseFilter) (int, error) {
+		return 3, nil
+	}
+	r := setupMockServer(db)
+
+	recorder := getKeyReleasesRequest(r, "?hardware_uuid=00ECD3AB-9AF4-E711-906E-001560A04062&key_id="+samlTestKeyID+
+		"&decision=released&principal=global_admin_user&from_date=2021-01-01T00:00:00&limit=1&offset=1&sort_order=DESC")
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal(repository.KeyReleaseFilter{
+		HardwareUUID: "00ecd3ab-9af4-e711-906e-001560a04062",
+		KeyID:        samlTestKeyID,
+		Principal:    "global_admin_user",
+		Decision:     model.KeyReleaseReleased,
+		FromDate:     "2021-01-01T00:00:00",
+		Limit:        1,
+		Offset:       1,
+		SortOrder:    repository.SortDescending,
+	}, filter)
+	assert.Equal("3", recorder.Header().Get("X-Total-Count"))
+	assert.Equal("2", recorder.Header().Get("X-Next-Offset"))
+	var releases []model.KeyRelease
+	assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &releases))
+	assert.Equal([]model.KeyRelease{release}, releases)
+
+	// the last page has no next offset
+	recorder = getKeyReleasesRequest(r, "?offset=2")
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Empty(recorder.Header().Get("X-Next-Offset"))
+}
+
+func TestGetKeyReleasesInvalidQuery(t *testing.T) {
+	log.Trace("resource/key_releases_test:TestGetKeyReleasesInvalidQuery() Entering")
+	defer log.Trace("resource/key_releases_test:TestGetKeyReleasesInvalidQuery() Leaving")
+	r := setupMockServer(new(mock.Database))
+
+	for _, query := range []string{
+		"?hardware_uuid=not-a-uuid",
+		"?image_id=not-a-uuid",
+		"?key_id=not-a-uuid",
+		"?decision=granted",
+		"?principal=" + strings.Repeat("a", maxPrincipalLength+1),
+		"?limit=0",
+		"?offset=-1",
+		"?sort_order=newest",
+	} {
+		t.Run(query, func(t *testing.T) {
+			recorder := getKeyReleasesRequest(r, query)
+			assert.Equal(t, http.StatusBadRequest, recorder.Code)
+			assert.Equal(t, errCodeInvalidRequest, decodeErrorResponse(t, recorder).Code)
+		})
+	}
+}
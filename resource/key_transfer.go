@@ -6,7 +6,9 @@
 package resource
 
 import (
+	"crypto/x509"
 	"encoding/xml"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/intel-secl/intel-secl/v4/pkg/clients/hvsclient"
 	"github.com/intel-secl/intel-secl/v4/pkg/clients/kbs"
@@ -15,167 +17,400 @@ import (
 	"github.com/intel-secl/intel-secl/v4/pkg/model/hvs"
 	"intel/isecl/lib/common/v4/log/message"
 	"intel/isecl/lib/common/v4/validation"
+	"intel/isecl/workload-service/v4/clients"
 	"intel/isecl/workload-service/v4/config"
 	"intel/isecl/workload-service/v4/constants"
 	consts "intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"intel/isecl/workload-service/v4/samlcache"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
-// Verifies host and retrieves key from KMS
-// getFlavor is true for the images API and false for the keys API
-// id is only required when using the images API
-func transfer_key(getFlavor bool, hwid string, kUrl string, id string) ([]byte, error) {
-	var endpoint, funcName, retrievalErr string
-	if getFlavor {
-		endpoint = "resource/images"
-		funcName = "retrieveFlavorandKeyForImageID()"
-		retrievalErr = "Failed to retrieve Flavor/Key for Image"
-	} else {
-		endpoint = "resource/keys"
-		funcName = "retrieveKey()"
-		retrievalErr = "Failed to retrieve Key for Image"
+// keyIDRegex matches the key ID in a key URL
+var keyIDRegex = regexp.MustCompile("(?i)([0-9A-F]{8}-[0-9A-F]{4}-4[0-9A-F]{3}-[89AB][0-9A-F]{3}-[0-9A-F]{12})")
+
+// keyTransfers collapses concurrent transfers of the same key from the same KBS to the same host
+var keyTransfers = newTransferGroup()
+
+// clientManager holds the HVS and KBS clients shared by all key transfer requests
+var clientManager *clients.Manager
+
+// SetClientManager sets the client manager shared by all key transfer requests.
+// Without a client manager, the clients are created for each request from the configuration.
+func SetClientManager(m *clients.Manager) {
+	clientManager = m
+}
+
+// getKBSClient returns a KBS client for the KBS at baseUrl, verified with the certificates of caBundle if set
+var getKBSClient = func(baseUrl, caBundle string) (kbs.KBSClient, error) {
+	if clientManager != nil {
+		return clientManager.KBSClient(baseUrl, caBundle)
 	}
-	// we have key URL
-	// http://10.1.68.21:20080/v1/keys/73755fda-c910-46be-821f-e8ddeab189e9/transfer"
-	// post HVS with hardwareUUID
-	// extract key_id from KeyUrl
-	cLog := log.WithField("hardwareUUID", hwid).WithField("keyUrl", kUrl)
-	if getFlavor {
-		cLog = cLog.WithField("id", id)
+	//Load trusted CA certificates
+	var caCerts []x509.Certificate
+	var err error
+	if caBundle != "" {
+		caCerts, err = clients.LoadCABundle(caBundle)
+	} else {
+		caCerts, err = crypt.GetCertsFromDir(consts.TrustedCaCertsDir)
 	}
-	cLog.Debugf("%s:%s KeyUrl is present", endpoint, funcName)
-	keyUrl, err := url.Parse(kUrl)
 	if err != nil {
-		cLog.WithError(err).Errorf("%s:%s %s : KeyUrl is malformed", endpoint, funcName, message.InvalidInputProtocolViolation)
-		log.Tracef("%+v", err)
-		return nil, &endpointError{
-			Message:    retrievalErr + " - KeyUrl is malformed",
-			StatusCode: http.StatusBadRequest,
-		}
+		return nil, err
 	}
-	re := regexp.MustCompile("(?i)([0-9A-F]{8}-[0-9A-F]{4}-4[0-9A-F]{3}-[89AB][0-9A-F]{3}-[0-9A-F]{12})")
-	keyID := re.FindString(keyUrl.Path)
+	kbsUrl, _ := url.Parse(baseUrl)
+	//Initialize the KBS client
+	return kbs.NewKBSClient(nil, kbsUrl, "", "", caCerts), nil
+}
 
-	// retrieve host SAML report from HVS
+// fetchSamlReport requests a new SAML report for the host from HVS
+var fetchSamlReport = func(hwid string) ([]byte, error) {
+	if clientManager != nil {
+		return clientManager.CreateSAMLReport(hwid)
+	}
 	vsClientFactory, err := hvsclient.NewVSClientFactoryWithUserCredentials(config.Configuration.HvsApiUrl, config.Configuration.AasApiUrl, config.Configuration.WLS.User, config.Configuration.WLS.Password, constants.TrustedCaCertsDir)
 	if err != nil {
-		cLog.WithError(err).Error("Error while instantiating VSClientFactory")
+		log.WithError(err).Error("Error while instantiating VSClientFactory")
 		return nil, &endpointError{
 			Message:    "Error while instantiating VSClientFactory",
 			StatusCode: http.StatusInternalServerError,
+			Code:       errCodeHvsReportFailed,
 		}
 	}
 
 	reportsClient, err := vsClientFactory.ReportsClient()
 	if err != nil {
-		cLog.WithError(err).Error("Error while instantiating ReportsClient")
+		log.WithError(err).Error("Error while instantiating ReportsClient")
+		if upstreamUnavailable(err) {
+			return nil, &endpointError{
+				Message:    "Error while instantiating ReportsClient - AAS is unavailable",
+				StatusCode: http.StatusServiceUnavailable,
+				Code:       errCodeHvsUnavailable,
+				RetryAfter: consts.UpstreamRetryAfterSecs,
+			}
+		}
 		return nil, &endpointError{
 			Message:    "Error while instantiating ReportsClient",
 			StatusCode: http.StatusInternalServerError,
+			Code:       errCodeHvsReportFailed,
 		}
 	}
 	reportCreateRequest := hvs.ReportCreateRequest{
 		HardwareUUID: uuid.MustParse(hwid),
 	}
-	saml, err := reportsClient.CreateSAMLReport(reportCreateRequest)
+	return reportsClient.CreateSAMLReport(reportCreateRequest)
+}
+
+// upstreamUnavailable checks whether an error from HVS, AAS or KBS means the service could not be reached or
+// is temporarily unable to handle requests, rather than that it rejected the request. Only timeouts, refused
+// connections, failed dials and DNS errors count as unreachable, a failed TLS verification does not.
+func upstreamUnavailable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	if status, ok := clients.ResponseStatus(err); ok {
+		switch status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+// verifySamlSignature verifies the signature and certificate chain of a SAML report
+var verifySamlSignature = samlVerifier.VerifySamlSignature
+
+// getHostSaml returns the SAML report of the host along with its parsed form. A verified report cached for the
+// host is reused until its NotOnOrAfter time minus the configured skew, otherwise a new report is requested from HVS.
+func getHostSaml(hwid string, cLog *logrus.Entry, endpoint, funcName, retrievalErr string) ([]byte, *Saml, error) {
+	var samlStruct Saml
+	if !config.Configuration.SamlCacheDisabled {
+		if cached, exists := samlcache.Get(hwid); exists {
+			if err := xml.Unmarshal(cached.Saml, &samlStruct); err == nil {
+				if err = validateSamlAssertion(&samlStruct, hwid, time.Now()); err == nil {
+					cLog.Debugf("%s:%s Reusing cached SAML report valid until %s", endpoint, funcName, samlStruct.Subject.NotOnOrAfter)
+					return cached.Saml, &samlStruct, nil
+				}
+				cLog.WithError(err).Warnf("%s:%s Cached SAML report is no longer valid for the host", endpoint, funcName)
+			}
+			samlcache.Delete(hwid)
+			samlStruct = Saml{}
+		}
+	}
+
+	saml, err := fetchSamlReport(hwid)
 	if err != nil {
+		if endpointErr, ok := err.(*endpointError); ok {
+			return nil, nil, endpointErr
+		}
 		cLog.WithError(err).Errorf("%s:%s %s : Failed to read HVS response body", endpoint, funcName, message.BadConnection)
 		log.Tracef("%+v", err)
-		return nil, &endpointError{
+		if upstreamUnavailable(err) {
+			return nil, nil, &endpointError{
+				Message:    retrievalErr + " - HVS is unavailable",
+				StatusCode: http.StatusServiceUnavailable,
+				Code:       errCodeHvsUnavailable,
+				RetryAfter: consts.UpstreamRetryAfterSecs,
+			}
+		}
+		return nil, nil, &endpointError{
 			Message:    retrievalErr + " - Failed to read HVS response",
-			StatusCode: http.StatusInternalServerError,
+			StatusCode: http.StatusBadGateway,
+			Code:       errCodeHvsReportFailed,
 		}
 	}
 
 	// validate the response from HVS
 	if err = validation.ValidateXMLString(string(saml)); err != nil {
 		cLog.WithError(err).Errorf("%s:%s %s : HVS response validation failed", endpoint, funcName, message.AppRuntimeErr)
-		return nil, &endpointError{
+		return nil, nil, &endpointError{
 			Message:    retrievalErr + " - Invalid SAML report format received from HVS",
-			StatusCode: http.StatusInternalServerError,
+			StatusCode: http.StatusBadGateway,
+			Code:       errCodeSamlInvalid,
 		}
 	}
 
-	var samlStruct Saml
 	cLog.WithField("saml", string(saml)).Debugf("%s:%s Successfully got SAML report from HVS", endpoint, funcName)
 	err = xml.Unmarshal(saml, &samlStruct)
 	if err != nil {
 		cLog.WithError(err).Errorf("%s:%s %s : Failed to unmarshal host SAML report", endpoint, funcName, message.AppRuntimeErr)
 		log.Tracef("%+v", err)
-		return nil, &endpointError{
+		return nil, nil, &endpointError{
 			Message:    retrievalErr + " - Failed to unmarshal host SAML report",
-			StatusCode: http.StatusInternalServerError,
+			StatusCode: http.StatusBadGateway,
+			Code:       errCodeSamlInvalid,
 		}
 	}
 
 	// verify saml cert chain
-	verified := samlVerifier.VerifySamlSignature(string(saml), constants.SamlCaCertFilePath, constants.TrustedCaCertsDir)
+	verified := verifySamlSignature(string(saml), constants.SamlCaCertFilePath, constants.TrustedCaCertsDir)
 	if !verified {
-		cLog.WithError(err).Errorf("%s:%s SAML certificate chain verification failed", endpoint, funcName)
-		return nil, &endpointError{
+		cLog.Errorf("%s:%s SAML certificate chain verification failed", endpoint, funcName)
+		return nil, nil, &endpointError{
 			Message:    retrievalErr + " - SAML signature or certificate chain verification failed",
+			StatusCode: http.StatusBadGateway,
+			Code:       errCodeSamlVerificationFailed,
+		}
+	}
+
+	// the report must be about the requesting host and currently valid before any key is released to it
+	if err = validateSamlAssertion(&samlStruct, hwid, time.Now()); err != nil {
+		cLog.WithError(err).Errorf("%s:%s %s : SAML report is not bound to the host", endpoint, funcName, message.InvalidInputBadParam)
+		return nil, nil, &endpointError{
+			Message:    retrievalErr + " - SAML report is not bound to the host",
+			StatusCode: http.StatusBadGateway,
+			Code:       errCodeSamlVerificationFailed,
+		}
+	}
+
+	// only reports of trusted hosts are cached, so that a host which becomes trusted again is attested right away
+	if !config.Configuration.SamlCacheDisabled && isHostTrusted(&samlStruct) {
+		// a report stops being reused once it could be considered expired by the clock skew allowed for SAML reports
+		skewSeconds := config.Configuration.SamlClockSkewSeconds
+		if skewSeconds <= 0 {
+			skewSeconds = constants.DefaultSamlClockSkewSecs
+		}
+		expiry := samlStruct.Subject.NotOnOrAfter.Add(-time.Second * time.Duration(skewSeconds))
+		if time.Now().Before(expiry) {
+			samlcache.Store(hwid, samlcache.Report{Saml: saml, Expiry: expiry})
+		}
+	}
+	return saml, &samlStruct, nil
+}
+
+// trustOverall returns the value of the TRUST_OVERALL attribute of a SAML report, and false if the report has none
+func trustOverall(samlStruct *Saml) (string, bool) {
+	for _, attribute := range samlStruct.Attribute {
+		if attribute.Name == "TRUST_OVERALL" {
+			return attribute.AttributeValue, true
+		}
+	}
+	return "", false
+}
+
+// isHostTrusted checks the TRUST_OVERALL attribute of a SAML report
+func isHostTrusted(samlStruct *Saml) bool {
+	trust, _ := trustOverall(samlStruct)
+	return trust == "true"
+}
+
+// Verifies host and retrieves key from KMS
+// getFlavor is true for the images API and false for the keys API
+// id is only required when using the images API
+// principal identifies the caller in the key release audit trail
+func transfer_key(db repository.WlsDatabase, principal string, getFlavor bool, hwid string, kUrl string, id string) ([]byte, error) {
+	var endpoint, funcName, retrievalErr string
+	if getFlavor {
+		endpoint = "resource/images"
+		funcName = "retrieveFlavorandKeyForImageID()"
+		retrievalErr = "Failed to retrieve Flavor/Key for Image"
+	} else {
+		endpoint = "resource/keys"
+		funcName = "retrieveKey()"
+		retrievalErr = "Failed to retrieve Key for Image"
+	}
+	// we have key URL
+	// http://10.1.68.21:20080/v1/keys/73755fda-c910-46be-821f-e8ddeab189e9/transfer"
+	// post HVS with hardwareUUID
+	// extract key_id from KeyUrl
+	cLog := log.WithField("hardwareUUID", hwid).WithField("keyUrl", kUrl)
+	if getFlavor {
+		cLog = cLog.WithField("id", id)
+	}
+	cLog.Debugf("%s:%s KeyUrl is present", endpoint, funcName)
+
+	// every attempt is recorded, whether the key is released or not
+	release := &model.KeyRelease{Principal: principal, HardwareUUID: strings.ToLower(hwid), ImageID: strings.ToLower(id)}
+	key, err := attemptKeyTransfer(db.KeyReleasePolicyRepository(), release, hwid, kUrl, cLog, endpoint, funcName, retrievalErr)
+	return recordKeyRelease(db.KeyReleaseRepository(), release, key, err, cLog, retrievalErr)
+}
+
+// attemptKeyTransfer validates the key URL and releases the key to the host, it records the key ID and the SAML
+// assertion ID of the attempt in release
+func attemptKeyTransfer(policies repository.KeyReleasePolicyRepository, release *model.KeyRelease, hwid, kUrl string, cLog *logrus.Entry, endpoint, funcName, retrievalErr string) ([]byte, error) {
+	keyUrl, err := url.Parse(kUrl)
+	if err != nil {
+		cLog.WithError(err).Errorf("%s:%s %s : KeyUrl is malformed", endpoint, funcName, message.InvalidInputProtocolViolation)
+		log.Tracef("%+v", err)
+		return nil, &endpointError{
+			Message:    retrievalErr + " - KeyUrl is malformed",
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	keyID := keyIDRegex.FindString(keyUrl.Path)
+	release.KeyID = strings.ToLower(keyID)
+	// the SAML report of the host is only sent to the KBS of the configured registry
+	kbsEndpoint, err := trustedKBSEndpoint(kUrl)
+	if err != nil {
+		seclog.WithError(err).Errorf("%s:%s %s : KeyUrl is not on a trusted KBS", endpoint, funcName, message.InvalidInputBadParam)
+		return nil, &endpointError{
+			Message:    retrievalErr + " - KeyUrl is not on a trusted KBS",
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	// the key is released only to hosts satisfying the policies of all the images encrypted with it, whichever
+	// API it is requested with
+	keyPolicies, err := policies.RetrieveByKeyID(keyID)
+	if err != nil {
+		cLog.WithError(err).Errorf("%s:%s %s : Failed to retrieve the key release policies", endpoint, funcName, message.AppRuntimeErr)
+		log.Tracef("%+v", err)
+		return nil, &endpointError{
+			Message:    retrievalErr + " - Unable to retrieve the key release policies",
 			StatusCode: http.StatusInternalServerError,
 		}
 	}
 
-	var key []byte
-	for i := 0; i < len(samlStruct.Attribute); i++ {
-		if samlStruct.Attribute[i].Name == "TRUST_OVERALL" {
-			if samlStruct.Attribute[i].AttributeValue == "false" {
-				return nil, &endpointError{
-					Message:    retrievalErr + " - Host is untrusted",
-					StatusCode: http.StatusInternalServerError,
-				}
-			}
-			// check if the key is cached and retrieve it
-			// try to obtain the key from the cache. If the key is not found in the cache,
-			// then it will return and error. In this case, we ignore it and pro
-
-			var cachedKeyID string
-			cachedKey, err := getKeyFromCache(hwid)
-			if err == nil {
-				cachedKeyID = cachedKey.ID
-				cLog.Infof("%s:%s %s : Retrieved Key from in-memory cache. key ID: %s", endpoint, funcName, message.EncKeyUsed, cachedKey.ID)
-			}
-			// check if the key cached is same as the one in the flavor
-			if cachedKeyID != "" && cachedKeyID == keyID {
-				key = cachedKey.Bytes
-			} else {
-				//Load trusted CA certificates
-				caCerts, err := crypt.GetCertsFromDir(consts.TrustedCaCertsDir)
-				if err != nil {
-					cLog.WithError(err).Errorf("%s:%s %s : Failed to load CA certificates", endpoint, funcName, message.AppRuntimeErr)
-					return nil, &endpointError{
-						Message:    retrievalErr + " - Unable to load CA certificates",
-						StatusCode: http.StatusInternalServerError,
-					}
-				}
+	// concurrent requests for the same key from the same KBS on the same host wait for a single transfer and share
+	// its result, the key release policies they evaluate are the ones of the key
+	result, err := keyTransfers.do(hwid, kbsEndpoint.BaseURL, keyID, func() (transferResult, error) {
+		return releaseKeyToHost(hwid, kbsEndpoint, keyID, keyPolicies, cLog, endpoint, funcName, retrievalErr)
+	})
+	release.SamlAssertionID = result.samlAssertionID
+	return result.key, err
+}
 
-				baseUrl := strings.TrimSuffix(re.Split(kUrl, 2)[0], "keys/")
-				kbsUrl, _ := url.Parse(baseUrl)
-				//Initialize the KBS client
-				kc := kbs.NewKBSClient(nil, kbsUrl, "", "", caCerts)
-
-				// post to KBS client with saml
-				cLog.Infof("%s:%s baseURL: %s, keyID: %s : start to retrieve key from KMS", endpoint, funcName, baseUrl, keyID)
-				key, err = kc.TransferKeyWithSaml(keyID, string(saml))
-				if err != nil {
-					cLog.WithError(err).Errorf("%s:%s %s : Failed to retrieve key from KMS", endpoint, funcName, message.AppRuntimeErr)
-					return nil, &endpointError{
-						Message:    "Failed to retrieve key ",
-						StatusCode: http.StatusInternalServerError,
-					}
-				}
-				cLog.Infof("%s:%s Successfully got key from KMS", endpoint, funcName)
-				err = cacheKeyInMemory(hwid, keyID, key)
-				if err != nil {
-					cLog.WithError(err).Errorf("Failed to cache key")
-				}
+// releaseKeyToHost checks that the host is trusted and satisfies the key release policies, and retrieves the key for
+// it from the key cache or from KBS
+func releaseKeyToHost(hwid string, kbsEndpoint *kbsEndpoint, keyID string, policies []model.KeyReleasePolicy, cLog *logrus.Entry, endpoint, funcName, retrievalErr string) (transferResult, error) {
+	// retrieve host SAML report from HVS, or reuse the one cached for the host
+	saml, samlStruct, err := getHostSaml(hwid, cLog, endpoint, funcName, retrievalErr)
+	if err != nil {
+		return transferResult{}, err
+	}
+
+	result := transferResult{samlAssertionID: samlStruct.ID}
+	trustOverall, found := trustOverall(samlStruct)
+	if !found {
+		cLog.Errorf("%s:%s %s : SAML report of the host has no TRUST_OVERALL attribute", endpoint, funcName, message.InvalidInputProtocolViolation)
+		return result, &endpointError{
+			Message:    retrievalErr + " - SAML report has no overall trust status",
+			StatusCode: http.StatusBadGateway,
+			Code:       errCodeSamlInvalid,
+		}
+	}
+	if trustOverall != "true" {
+		return result, &endpointError{
+			Message:    retrievalErr + " - Host is untrusted",
+			StatusCode: http.StatusForbidden,
+			Code:       errCodeHostUntrusted,
+		}
+	}
+	if reasons := keyReleaseDenials(policies, samlStruct); len(reasons) > 0 {
+		seclog.WithField("hardwareUUID", hwid).Errorf("%s:%s %s : Host does not satisfy the key release policy of key %s: %s", endpoint, funcName, message.UnauthorizedAccess, keyID, strings.Join(reasons, "; "))
+		return result, &endpointError{
+			Message:    retrievalErr + " - Host does not satisfy the key release policy",
+			StatusCode: http.StatusForbidden,
+			Code:       errCodeKeyReleaseDenied,
+			Details:    map[string][]string{"reasons": reasons},
+		}
+	}
+	// check if the key is cached and retrieve it
+	// try to obtain the key from the cache. The cache holds the keys released to this host,
+	// only reached once the host has been found trusted above. If the key is not found in the cache,
+	// then it will return and error. In this case, we ignore it and retrieve the key from KBS
+	cachedKey, err := getKeyFromCache(hwid, keyID)
+	if err == nil {
+		cLog.Infof("%s:%s %s : Retrieved Key from in-memory cache. key ID: %s", endpoint, funcName, message.EncKeyUsed, cachedKey.ID)
+		result.key = cachedKey.Bytes
+		return result, nil
+	}
+
+	baseUrl := kbsEndpoint.BaseURL
+	kc, err := getKBSClient(baseUrl, kbsEndpoint.CABundle)
+	if err != nil {
+		cLog.WithError(err).Errorf("%s:%s %s : Failed to load CA certificates", endpoint, funcName, message.AppRuntimeErr)
+		return result, &endpointError{
+			Message:    retrievalErr + " - Unable to load CA certificates",
+			StatusCode: http.StatusInternalServerError,
+			Code:       errCodeKbsTransferFailed,
+		}
+	}
+
+	// post to KBS client with saml
+	cLog.Infof("%s:%s baseURL: %s, keyID: %s : start to retrieve key from KMS", endpoint, funcName, baseUrl, keyID)
+	key, err := kc.TransferKeyWithSaml(keyID, string(saml))
+	if err != nil {
+		cLog.WithError(err).Errorf("%s:%s %s : Failed to retrieve key from KMS", endpoint, funcName, message.AppRuntimeErr)
+		if upstreamUnavailable(err) {
+			return result, &endpointError{
+				Message:    "Failed to retrieve key - KBS is unavailable",
+				StatusCode: http.StatusServiceUnavailable,
+				Code:       errCodeKbsUnavailable,
+				RetryAfter: consts.UpstreamRetryAfterSecs,
 			}
 		}
+		return result, &endpointError{
+			Message:    "Failed to retrieve key",
+			StatusCode: http.StatusBadGateway,
+			Code:       errCodeKbsTransferFailed,
+		}
+	}
+	cLog.Infof("%s:%s Successfully got key from KMS", endpoint, funcName)
+	err = cacheKeyInMemory(hwid, keyID, key)
+	if err != nil {
+		cLog.WithError(err).Errorf("Failed to cache key")
 	}
-	return key, nil
+	result.key = key
+	return result, nil
 }
@@ -0,0 +1,329 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/intel-secl/intel-secl/v4/pkg/clients/kbs"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/repository/mock"
+	"intel/isecl/workload-service/v4/samlcache"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	samlTestKeyID  = "73755fda-c910-46be-821f-e8ddeab189e9"
+	samlTestKeyURL = "https://kbs.server.com:9443/v1/keys/" + samlTestKeyID + "/transfer"
+)
+
+// fakeHVS issues SAML reports for hosts and counts the requests it receives
+type fakeHVS struct {
+	mtx      sync.Mutex
+	requests int
+	trusted  bool
+	validity time.Duration
+	// subject is the hardware UUID the reports are issued for, the requesting host if empty
+	subject string
+	// attributes are added to the reports, after TRUST_OVERALL and hardwareUuid
+	attributes []Attribute
+	// trustOverall replaces the TRUST_OVERALL value of the reports if set, an empty value leaves it out
+	trustOverall *string
+}
+
+func (h *fakeHVS) createSamlReport(hwid string) ([]byte, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.requests++
+	fixture := newSamlFixture(hwid, h.trusted, h.validity)
+	if h.subject != "" {
+		fixture.hardwareUUID = h.subject
+	}
+	fixture.attributes = h.attributes
+	if h.trustOverall != nil {
+		fixture.trustOverall = *h.trustOverall
+	}
+	return fixture.bytes(), nil
+}
+
+// setupFakeHVS routes SAML report requests for the host to a fake HVS, and caches a key for the host so that
+// no KBS is needed. The returned function restores the previous state.
+func setupFakeHVS(hwid string, hvs *fakeHVS, verified bool) func() {
+	previousFetch := fetchSamlReport
+	previousVerify := verifySamlSignature
+	previousDisabled := config.Configuration.SamlCacheDisabled
+	previousSkew := config.Configuration.SamlClockSkewSeconds
+
+	fetchSamlReport = hvs.createSamlReport
+	verifySamlSignature = func(saml, samlCaCertFilePath, trustedCaCertsDir string) bool {
+		return verified
+	}
+	config.Configuration.SamlClockSkewSeconds = 60
+	samlcache.Delete(hwid)
+	cacheKeyInMemory(hwid, samlTestKeyID, []byte{0, 1, 2, 3})
+	return func() {
+		fetchSamlReport = previousFetch
+		verifySamlSignature = previousVerify
+		config.Configuration.SamlCacheDisabled = previousDisabled
+		config.Configuration.SamlClockSkewSeconds = previousSkew
+		samlcache.Delete(hwid)
+	}
+}
+
+func TestSamlReportReused(t *testing.T) {
+	log.Trace("resource/key_transfer_test:TestSamlReportReused() Entering")
+	defer log.Trace("resource/key_transfer_test:TestSamlReportReused() Leaving")
+	assert := assert.New(t)
+	hwid := "0d3c5ac5-a4a8-4b1b-9f05-0e1b4f2a6a01"
+	hvs := &fakeHVS{trusted: true, validity: time.Hour}
+	defer setupFakeHVS(hwid, hvs, true)()
+
+	for i := 0; i < 3; i++ {
+		key, err := transfer_key(new(mock.Database), "", false, hwid, samlTestKeyURL, "")
+		assert.NoError(err)
+		assert.Equal([]byte{0, 1, 2, 3}, key)
+	}
+	assert.Equal(1, hvs.requests)
+}
+
+func TestSamlCacheDisabled(t *testing.T) {
+	log.Trace("resource/key_transfer_test:TestSamlCacheDisabled() Entering")
+	defer log.Trace("resource/key_transfer_test:TestSamlCacheDisabled() Leaving")
+	assert := assert.New(t)
+	hwid := "0d3c5ac5-a4a8-4b1b-9f05-0e1b4f2a6a02"
+	hvs := &fakeHVS{trusted: true, validity: time.Hour}
+	defer setupFakeHVS(hwid, hvs, true)()
+	config.Configuration.SamlCacheDisabled = true
+
+	for i := 0; i < 3; i++ {
+		_, err := transfer_key(new(mock.Database), "", false, hwid, samlTestKeyURL, "")
+		assert.NoError(err)
+	}
+	assert.Equal(3, hvs.requests)
+}
+
+func TestSamlReportWithinSkewNotReused(t *testing.T) {
+	log.Trace("resource/key_transfer_test:TestSamlReportWithinSkewNotReused() Entering")
+	defer log.Trace("resource/key_transfer_test:TestSamlReportWithinSkewNotReused() Leaving")
+	assert := assert.New(t)
+	hwid := "0d3c5ac5-a4a8-4b1b-9f05-0e1b4f2a6a03"
+	// the report expires within the 60 seconds skew, so it must not be reused
+	hvs := &fakeHVS{trusted: true, validity: 30 * time.Second}
+	defer setupFakeHVS(hwid, hvs, true)()
+
+	for i := 0; i < 2; i++ {
+		_, err := transfer_key(new(mock.Database), "", false, hwid, samlTestKeyURL, "")
+		assert.NoError(err)
+	}
+	assert.Equal(2, hvs.requests)
+}
+
+func TestSamlReportUntrustedHostNotCached(t *testing.T) {
+	log.Trace("resource/key_transfer_test:TestSamlReportUntrustedHostNotCached() Entering")
+	defer log.Trace("resource/key_transfer_test:TestSamlReportUntrustedHostNotCached() Leaving")
+	assert := assert.New(t)
+	hwid := "0d3c5ac5-a4a8-4b1b-9f05-0e1b4f2a6a04"
+	hvs := &fakeHVS{trusted: false, validity: time.Hour}
+	defer setupFakeHVS(hwid, hvs, true)()
+
+	for i := 0; i < 2; i++ {
+		key, err := transfer_key(new(mock.Database), "", false, hwid, samlTestKeyURL, "")
+		assert.Nil(key)
+		if assert.Error(err) {
+			assert.Contains(err.Error(), "Host is untrusted")
+		}
+	}
+	assert.Equal(2, hvs.requests)
+
+	// once the host is trusted again, the key is released right away
+	hvs.trusted = true
+	_, err := transfer_key(new(mock.Database), "", false, hwid, samlTestKeyURL, "")
+	assert.NoError(err)
+	assert.Equal(3, hvs.requests)
+}
+
+func TestSamlReportFailedVerificationNotCached(t *testing.T) {
+	log.Trace("resource/key_transfer_test:TestSamlReportFailedVerificationNotCached() Entering")
+	defer log.Trace("resource/key_transfer_test:TestSamlReportFailedVerificationNotCached() Leaving")
+	assert := assert.New(t)
+	hwid := "0d3c5ac5-a4a8-4b1b-9f05-0e1b4f2a6a05"
+	hvs := &fakeHVS{trusted: true, validity: time.Hour}
+	defer setupFakeHVS(hwid, hvs, false)()
+
+	for i := 0; i < 2; i++ {
+		_, err := transfer_key(new(mock.Database), "", false, hwid, samlTestKeyURL, "")
+		assert.Error(err)
+	}
+	assert.Equal(2, hvs.requests)
+	_, exists := samlcache.Get(hwid)
+	assert.False(exists)
+}
+
+const (
+	coalescedKeyID  = "1d5ae8e1-6b1e-4b92-9a43-0c52ef2a3a57"
+	coalescedKeyURL = "https://kbs.server.com:9443/v1/keys/" + coalescedKeyID + "/transfer"
+)
+
+// fakeKBS releases keys once the test allows it and counts the transfers it receives
+type fakeKBS struct {
+	mtx       sync.Mutex
+	transfers int
+	started   chan struct{}
+	release   chan struct{}
+	err       error
+}
+
+func newFakeKBS() *fakeKBS {
+	return &fakeKBS{started: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (k *fakeKBS) TransferKeyWithSaml(keyID, saml string) ([]byte, error) {
+	k.mtx.Lock()
+	k.transfers++
+	k.mtx.Unlock()
+	select {
+	case k.started <- struct{}{}:
+	default:
+	}
+	<-k.release
+	if k.err != nil {
+		return nil, k.err
+	}
+	return []byte(keyID), nil
+}
+
+// launchConcurrentTransfers starts n key transfers for the host and releases the fake KBS once
+// all but the first transfer are waiting on the first one. The transfers alternate between the given
+// spellings of the hardware UUID of the host.
+func launchConcurrentTransfers(t *testing.T, hwid string, n int, kbsServer *fakeKBS, spellings ...string) ([][]byte, []error) {
+	previousGetKBSClient := getKBSClient
+	getKBSClient = func(baseUrl, caBundle string) (kbs.KBSClient, error) {
+		return kbsServer, nil
+	}
+	defer func() {
+		getKBSClient = previousGetKBSClient
+	}()
+
+	spellings = append([]string{hwid}, spellings...)
+	keys := make([][]byte, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i], errs[i] = transfer_key(new(mock.Database), "", true, spellings[i%len(spellings)], coalescedKeyURL, "dddd021e-9669-4e53-9224-8880fb4e4080")
+		}(i)
+	}
+
+	select {
+	case <-kbsServer.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no key transfer reached KBS")
+	}
+	kbsEndpoint, err := trustedKBSEndpoint(coalescedKeyURL)
+	if err != nil {
+		t.Fatal("key URL is not on a trusted KBS")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for keyTransfers.waiting(hwid, kbsEndpoint.BaseURL, coalescedKeyID) < n-1 {
+		if time.Now().After(deadline) {
+			t.Fatal("concurrent transfers did not wait on the transfer in progress")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(kbsServer.release)
+	wg.Wait()
+	return keys, errs
+}
+
+func TestConcurrentKeyTransfersCoalesced(t *testing.T) {
+	log.Trace("resource/key_transfer_test:TestConcurrentKeyTransfersCoalesced() Entering")
+	defer log.Trace("resource/key_transfer_test:TestConcurrentKeyTransfersCoalesced() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	hvs := &fakeHVS{trusted: true, validity: time.Hour}
+	defer setupFakeHVS(hwid, hvs, true)()
+	config.Configuration.SamlCacheDisabled = true
+	kbsServer := newFakeKBS()
+
+	keys, errs := launchConcurrentTransfers(t, hwid, 20, kbsServer)
+	for i := range keys {
+		assert.NoError(errs[i])
+		assert.Equal([]byte(coalescedKeyID), keys[i])
+	}
+	assert.Equal(1, hvs.requests)
+	assert.Equal(1, kbsServer.transfers)
+
+	// callers get their own copy of the key
+	keys[0][0] = 0
+	assert.Equal([]byte(coalescedKeyID), keys[1])
+
+	// the transferred key is cached for the following launches
+	key, err := transfer_key(new(mock.Database), "", true, hwid, coalescedKeyURL, "dddd021e-9669-4e53-9224-8880fb4e4080")
+	assert.NoError(err)
+	assert.Equal([]byte(coalescedKeyID), key)
+	assert.Equal(1, kbsServer.transfers)
+}
+
+func TestConcurrentKeyTransfersCoalescedAcrossCase(t *testing.T) {
+	log.Trace("resource/key_transfer_test:TestConcurrentKeyTransfersCoalescedAcrossCase() Entering")
+	defer log.Trace("resource/key_transfer_test:TestConcurrentKeyTransfersCoalescedAcrossCase() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	hvs := &fakeHVS{trusted: true, validity: time.Hour}
+	defer setupFakeHVS(hwid, hvs, true)()
+	config.Configuration.SamlCacheDisabled = true
+	kbsServer := newFakeKBS()
+
+	keys, errs := launchConcurrentTransfers(t, hwid, 20, kbsServer, strings.ToUpper(hwid))
+	for i := range keys {
+		assert.NoError(errs[i])
+		assert.Equal([]byte(coalescedKeyID), keys[i])
+	}
+	assert.Equal(1, hvs.requests)
+	assert.Equal(1, kbsServer.transfers)
+}
+
+func TestTransferID(t *testing.T) {
+	log.Trace("resource/key_transfer_test:TestTransferID() Entering")
+	defer log.Trace("resource/key_transfer_test:TestTransferID() Leaving")
+	assert := assert.New(t)
+	hwid := "00ecd3ab-9af4-e711-906e-001560a04062"
+	id := newTransferID(hwid, "https://kbs.server.com:9443/v1/", coalescedKeyID)
+
+	// the same key from the same KBS to the same host, whatever the case of the UUIDs
+	assert.Equal(id, newTransferID(strings.ToUpper(hwid), "https://kbs.server.com:9443/v1/", strings.ToUpper(coalescedKeyID)))
+	// the same key ID on another KBS, or another key, is another transfer
+	assert.NotEqual(id, newTransferID(hwid, "http://kbs.server.com:20080/v1/", coalescedKeyID))
+	assert.NotEqual(id, newTransferID(hwid, "https://kbs.server.com:9443/v1/", samlTestKeyID))
+}
+
+func TestConcurrentKeyTransfersShareError(t *testing.T) {
+	log.Trace("resource/key_transfer_test:TestConcurrentKeyTransfersShareError() Entering")
+	defer log.Trace("resource/key_transfer_test:TestConcurrentKeyTransfersShareError() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	hvs := &fakeHVS{trusted: true, validity: time.Hour}
+	defer setupFakeHVS(hwid, hvs, true)()
+	config.Configuration.SamlCacheDisabled = true
+	kbsServer := newFakeKBS()
+	kbsServer.err = errors.New("key transfer denied")
+
+	keys, errs := launchConcurrentTransfers(t, hwid, 20, kbsServer)
+	for i := range keys {
+		assert.Nil(keys[i])
+		if assert.Error(errs[i]) {
+			assert.Contains(errs[i].Error(), "Failed to retrieve key")
+		}
+	}
+	assert.Equal(1, hvs.requests)
+	assert.Equal(1, kbsServer.transfers)
+}
@@ -50,6 +50,9 @@ func retrieveKey(db repository.WlsDatabase) endpointHandler {
 				StatusCode: http.StatusBadRequest,
 			}
 		}
+		if e := checkHostBinding(r, hwid); e != nil {
+			return e
+		}
 		cLog := log.WithField("hardwareUUID", hwid)
 
 		cLog.Debug("resource/keys:retrievendKey() Retrieving  Key")
@@ -57,7 +60,7 @@ func retrieveKey(db repository.WlsDatabase) endpointHandler {
 		keyUrl := formBody.KeyUrl
 		// Check if flavor keyUrl is not empty
 		if len(keyUrl) > 0 {
-			key, err := transfer_key(false, hwid, keyUrl, "")
+			key, err := transfer_key(db, callerPrincipal(r), false, hwid, keyUrl, "")
 			if err != nil {
 				cLog.WithError(err).Error("resource/keys:retrieveKey() Error while retrieving key")
 				return err
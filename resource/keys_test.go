@@ -0,0 +1,183 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/intel-secl/intel-secl/v4/pkg/clients/kbs"
+	"intel/isecl/workload-service/v4/clients"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository/mock"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// connectionRefused is the error of a request to a service that is not listening
+func connectionRefused(requestURL string) error {
+	return &url.Error{Op: "Post", URL: requestURL, Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}
+}
+
+func postKeyRequest(t *testing.T, hwid, keyURL string) *httptest.ResponseRecorder {
+	r := setupMockServer(new(mock.Database))
+	body, err := json.Marshal(model.RequestKey{HwId: hwid, KeyUrl: keyURL})
+	if err != nil {
+		t.Fatal("could not marshal key request")
+	}
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/wls/v1/keys", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestRetrieveKeyInvalidHardwareUUID(t *testing.T) {
+	log.Trace("resource/keys_test:TestRetrieveKeyInvalidHardwareUUID() Entering")
+	defer log.Trace("resource/keys_test:TestRetrieveKeyInvalidHardwareUUID() Leaving")
+	assert := assert.New(t)
+
+	recorder := postKeyRequest(t, "not-a-uuid", samlTestKeyURL)
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	errResponse := decodeErrorResponse(t, recorder)
+	assert.Equal(errCodeInvalidRequest, errResponse.Code)
+	assert.Equal("Invalid hardware UUID format", errResponse.Message)
+}
+
+func TestRetrieveKeyHostUntrusted(t *testing.T) {
+	log.Trace("resource/keys_test:TestRetrieveKeyHostUntrusted() Entering")
+	defer log.Trace("resource/keys_test:TestRetrieveKeyHostUntrusted() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: false, validity: time.Hour}, true)()
+
+	recorder := postKeyRequest(t, hwid, samlTestKeyURL)
+	assert.Equal(http.StatusForbidden, recorder.Code)
+	assert.Equal(errCodeHostUntrusted, decodeErrorResponse(t, recorder).Code)
+	assert.Empty(recorder.Header().Get("Retry-After"))
+}
+
+func TestRetrieveKeySamlVerificationFailed(t *testing.T) {
+	log.Trace("resource/keys_test:TestRetrieveKeySamlVerificationFailed() Entering")
+	defer log.Trace("resource/keys_test:TestRetrieveKeySamlVerificationFailed() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, false)()
+
+	recorder := postKeyRequest(t, hwid, samlTestKeyURL)
+	assert.Equal(http.StatusBadGateway, recorder.Code)
+	assert.Equal(errCodeSamlVerificationFailed, decodeErrorResponse(t, recorder).Code)
+}
+
+func TestRetrieveKeyHVSFailure(t *testing.T) {
+	log.Trace("resource/keys_test:TestRetrieveKeyHVSFailure() Entering")
+	defer log.Trace("resource/keys_test:TestRetrieveKeyHVSFailure() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, true)()
+	fetchSamlReport = func(hwid string) ([]byte, error) {
+		return nil, &clients.StatusError{URL: "https://hvs.server.com:8443/mtwilson/v2/reports", StatusCode: http.StatusBadRequest}
+	}
+
+	recorder := postKeyRequest(t, hwid, samlTestKeyURL)
+	assert.Equal(http.StatusBadGateway, recorder.Code)
+	assert.Equal(errCodeHvsReportFailed, decodeErrorResponse(t, recorder).Code)
+	assert.Empty(recorder.Header().Get("Retry-After"))
+
+	fetchSamlReport = func(hwid string) ([]byte, error) {
+		return []byte("not a SAML report"), nil
+	}
+	recorder = postKeyRequest(t, hwid, samlTestKeyURL)
+	assert.Equal(http.StatusBadGateway, recorder.Code)
+	assert.Equal(errCodeSamlInvalid, decodeErrorResponse(t, recorder).Code)
+}
+
+func TestRetrieveKeyHVSUnavailable(t *testing.T) {
+	log.Trace("resource/keys_test:TestRetrieveKeyHVSUnavailable() Entering")
+	defer log.Trace("resource/keys_test:TestRetrieveKeyHVSUnavailable() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, true)()
+
+	for _, err := range []error{
+		connectionRefused("https://hvs.server.com:8443/mtwilson/v2/reports"),
+		&clients.StatusError{URL: "https://hvs.server.com:8443/mtwilson/v2/reports", StatusCode: http.StatusServiceUnavailable},
+		fmt.Errorf("Failed to fetch token from AAS: %w", &clients.StatusError{URL: "https://aas.server.com:8444/aas/v1/token", StatusCode: http.StatusBadGateway}),
+	} {
+		fetchErr := err
+		fetchSamlReport = func(hwid string) ([]byte, error) {
+			return nil, fetchErr
+		}
+		recorder := postKeyRequest(t, hwid, samlTestKeyURL)
+		assert.Equal(http.StatusServiceUnavailable, recorder.Code)
+		assert.Equal(errCodeHvsUnavailable, decodeErrorResponse(t, recorder).Code)
+		assert.Equal("30", recorder.Header().Get("Retry-After"))
+	}
+}
+
+func TestRetrieveKeyKBSFailure(t *testing.T) {
+	log.Trace("resource/keys_test:TestRetrieveKeyKBSFailure() Entering")
+	defer log.Trace("resource/keys_test:TestRetrieveKeyKBSFailure() Leaving")
+	assert := assert.New(t)
+	hwid := uuid.New().String()
+	defer setupFakeHVS(hwid, &fakeHVS{trusted: true, validity: time.Hour}, true)()
+	kbsServer := newFakeKBS()
+	kbsServer.err = errors.New("key transfer denied")
+	close(kbsServer.release)
+	previousGetKBSClient := getKBSClient
+	defer func() {
+		getKBSClient = previousGetKBSClient
+	}()
+
+	getKBSClient = func(baseUrl, caBundle string) (kbs.KBSClient, error) {
+		return kbsServer, nil
+	}
+	recorder := postKeyRequest(t, hwid, coalescedKeyURL)
+	assert.Equal(http.StatusBadGateway, recorder.Code)
+	assert.Equal(errCodeKbsTransferFailed, decodeErrorResponse(t, recorder).Code)
+	assert.Empty(recorder.Header().Get("Retry-After"))
+
+	for _, err := range []error{
+		connectionRefused(coalescedKeyURL),
+		&clients.StatusError{URL: coalescedKeyURL, StatusCode: http.StatusServiceUnavailable},
+	} {
+		kbsServer.err = err
+		recorder = postKeyRequest(t, hwid, coalescedKeyURL)
+		assert.Equal(http.StatusServiceUnavailable, recorder.Code)
+		assert.Equal(errCodeKbsUnavailable, decodeErrorResponse(t, recorder).Code)
+		assert.Equal("30", recorder.Header().Get("Retry-After"))
+	}
+
+	// a KBS whose certificate can't be verified is reachable but can't be trusted
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	_, kbsServer.err = http.Post(tlsServer.URL, "application/json", nil)
+	tlsServer.Close()
+	var certErr x509.UnknownAuthorityError
+	if assert.True(errors.As(kbsServer.err, &certErr)) {
+		recorder = postKeyRequest(t, hwid, coalescedKeyURL)
+		assert.Equal(http.StatusBadGateway, recorder.Code)
+		assert.Equal(errCodeKbsTransferFailed, decodeErrorResponse(t, recorder).Code)
+		assert.Empty(recorder.Header().Get("Retry-After"))
+	}
+
+	getKBSClient = func(baseUrl, caBundle string) (kbs.KBSClient, error) {
+		return nil, errors.New("no CA certificates")
+	}
+	recorder = postKeyRequest(t, hwid, coalescedKeyURL)
+	assert.Equal(http.StatusInternalServerError, recorder.Code)
+	assert.Equal(errCodeKbsTransferFailed, decodeErrorResponse(t, recorder).Code)
+}
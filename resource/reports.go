@@ -5,16 +5,24 @@
 package resource
 
 import (
+	"bytes"
+	"crypto/x509"
 	"encoding/json"
-	"fmt"
 	"intel/isecl/lib/common/v4/log/message"
 	"intel/isecl/lib/common/v4/validation"
+	"intel/isecl/lib/verifier/v4"
+	"intel/isecl/workload-service/v4/config"
 	"intel/isecl/workload-service/v4/constants"
 	"intel/isecl/workload-service/v4/model"
 	"intel/isecl/workload-service/v4/repository"
+	"intel/isecl/workload-service/v4/retention"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 )
@@ -25,9 +33,14 @@ func SetReportsEndpoints(r *mux.Router, db repository.WlsDatabase) {
 	defer log.Trace("resource/reports:SetReportsEndpoints() Leaving")
 	r.HandleFunc("", errorHandler(requiresPermission(getReport(db), []string{constants.ReportsSearch}))).Methods("GET")
 	r.HandleFunc("", errorHandler(requiresPermission(createReport(db), []string{constants.ReportsCreate}))).Methods("POST").Headers("Content-Type", "application/json")
+	// registered before /{id}, so that summary and bulk are not taken for report IDs
+	r.HandleFunc("/summary", errorHandler(requiresPermission(summarizeReports(db), []string{constants.ReportsSearch}))).Methods("GET")
+	r.HandleFunc("/bulk", errorHandler(requiresPermission(createReports(db), []string{constants.ReportsCreate}))).Methods("POST")
+	r.HandleFunc("/{id}",
+		errorHandler(requiresPermission(getReportByID(db), []string{constants.ReportsSearch}))).Methods("GET")
 	r.HandleFunc("/{id}",
 		errorHandler(requiresPermission(deleteReportByID(db), []string{constants.ReportsDelete}))).Methods("DELETE")
-	r.HandleFunc("/{badid}", badId)
+	r.HandleFunc("/{badid}", errorHandler(badId))
 }
 
 // Gets report for a given set of parameters
@@ -44,8 +57,10 @@ func getReport(db repository.WlsDatabase) endpointHandler {
 		// if no parameters were provided, just return an empty reports array
 		if len(r.URL.Query()) == 0 {
 			log.Errorf("resource/reports:getReport() %s : Query params missing in request", message.InvalidInputBadParam)
-			http.Error(w, "At least one query parameter is required", http.StatusBadRequest)
-			return nil
+			return &endpointError{
+				Message:    "At least one query parameter is required",
+				StatusCode: http.StatusBadRequest,
+			}
 		}
 
 		instanceID, ok := r.URL.Query()["instance_id"]
@@ -60,7 +75,7 @@ func getReport(db repository.WlsDatabase) endpointHandler {
 
 		reportID, ok := r.URL.Query()["report_id"]
 		if ok && len(reportID[0]) >= 1 {
-			if err := validation.ValidateUUIDv4(reportID[0]); err != nil {
+			if err := validateReportID(reportID[0]); err != nil {
 				log.WithError(err).Errorf("resource/reports:getReport() %s : Invalid report UUID format", message.InvalidInputProtocolViolation)
 				log.Tracef("%+v", err)
 				return &endpointError{Message: "Failed to retrieve report", StatusCode: http.StatusBadRequest}
@@ -135,8 +150,47 @@ func getReport(db repository.WlsDatabase) endpointHandler {
 			}
 			filterCriteria.Filter = boolValue
 		}
-		cLog.Debugf("HwId: %s|ReportID: %s|InstanceID: %s|ToDate: %s|FromDate: %s|NumOfDays: %d|Filter: %t|LatestPerVM: %s", filterCriteria.HardwareUUID,
-			filterCriteria.ReportID, filterCriteria.InstanceID, filterCriteria.ToDate, filterCriteria.FromDate, filterCriteria.NumOfDays, filterCriteria.Filter, filterCriteria.LatestPerVM)
+
+		// reports are returned by pages of at most the configured maximum page size
+		maxPageSize := config.Configuration.ReportsMaxPageSize
+		if maxPageSize <= 0 {
+			maxPageSize = constants.DefaultReportsMaxPageSize
+		}
+		filterCriteria.Limit = maxPageSize
+		limit, ok := r.URL.Query()["limit"]
+		if ok && len(limit[0]) >= 1 {
+			l, err := strconv.Atoi(limit[0])
+			if err != nil || l < 1 {
+				cLog.WithError(err).Errorf("resource/reports:getReport() %s : Invalid limit query parameter, must be a positive integer", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve reports - limit must be a positive integer", StatusCode: http.StatusBadRequest}
+			}
+			if l < maxPageSize {
+				filterCriteria.Limit = l
+			}
+		}
+
+		offset, ok := r.URL.Query()["offset"]
+		if ok && len(offset[0]) >= 1 {
+			o, err := strconv.Atoi(offset[0])
+			if err != nil || o < 0 {
+				cLog.WithError(err).Errorf("resource/reports:getReport() %s : Invalid offset query parameter, must be a non-negative integer", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve reports - offset must be a non-negative integer", StatusCode: http.StatusBadRequest}
+			}
+			filterCriteria.Offset = o
+		}
+
+		sortOrder, ok := r.URL.Query()["sort_order"]
+		if ok && len(sortOrder[0]) >= 1 {
+			order := strings.ToLower(sortOrder[0])
+			if order != repository.SortAscending && order != repository.SortDescending {
+				cLog.Errorf("resource/reports:getReport() %s : Invalid sort_order query parameter, must be asc or desc", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to retrieve reports - sort_order must be asc or desc", StatusCode: http.StatusBadRequest}
+			}
+			filterCriteria.SortOrder = order
+		}
+		cLog.Debugf("HwId: %s|ReportID: %s|InstanceID: %s|ToDate: %s|FromDate: %s|NumOfDays: %d|Filter: %t|LatestPerVM: %s|Limit: %d|Offset: %d|SortOrder: %s", filterCriteria.HardwareUUID,
+			filterCriteria.ReportID, filterCriteria.InstanceID, filterCriteria.ToDate, filterCriteria.FromDate, filterCriteria.NumOfDays, filterCriteria.Filter, filterCriteria.LatestPerVM,
+			filterCriteria.Limit, filterCriteria.Offset, filterCriteria.SortOrder)
 
 		if filterCriteria.HardwareUUID == "" && filterCriteria.ReportID == "" && filterCriteria.InstanceID == "" && filterCriteria.ToDate == "" && filterCriteria.FromDate == "" && filterCriteria.LatestPerVM == "" && filterCriteria.NumOfDays <= 0 && filterCriteria.Filter {
 			cLog.Errorf("resource/reports:getReport() %s : Invalid filter criteria. Allowed filter criteria are instance_id, report_id, hardware_uuid, from_date, to_date, latest_per_vm, num_of_days >=1 and filter = false\n", message.InvalidInputProtocolViolation)
@@ -149,6 +203,16 @@ func getReport(db repository.WlsDatabase) endpointHandler {
 			log.Tracef("%+v", err)
 			return &endpointError{Message: "Failed to retrieve reports", StatusCode: http.StatusInternalServerError}
 		}
+		total, err := db.ReportRepository().CountByFilterCriteria(filterCriteria)
+		if err != nil {
+			cLog.WithError(err).Errorf("resource/reports:getReport() %s : Failed to count reports", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{Message: "Failed to retrieve reports", StatusCode: http.StatusInternalServerError}
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		if nextOffset := filterCriteria.Offset + len(reports); len(reports) > 0 && nextOffset < total {
+			w.Header().Set("X-Next-Offset", strconv.Itoa(nextOffset))
+		}
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(reports); err != nil {
 			cLog.WithError(err).Errorf("resource/reports:getReport() %s : Unexpectedly failed to encode reports to JSON", message.AppRuntimeErr)
@@ -160,23 +224,138 @@ func getReport(db repository.WlsDatabase) endpointHandler {
 	}
 }
 
+// Counts the trusted and untrusted instances of each host, image or policy, according to the latest report of each instance
+func summarizeReports(db repository.WlsDatabase) endpointHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.Trace("resource/reports:summarizeReports() Entering")
+		defer log.Trace("resource/reports:summarizeReports() Leaving")
+
+		var cLog = log
+		filterCriteria := repository.ReportFilter{Filter: true}
+
+		groupBy := repository.SummaryByHardwareUUID
+		if g := r.URL.Query().Get("group_by"); g != "" {
+			if g != repository.SummaryByHardwareUUID && g != repository.SummaryByImageID && g != repository.SummaryByPolicyName {
+				cLog.Errorf("resource/reports:summarizeReports() %s : Invalid group_by query parameter", message.InvalidInputProtocolViolation)
+				return &endpointError{Message: "Failed to summarize reports - group_by must be hardware_uuid, image_id or policy_name", StatusCode: http.StatusBadRequest}
+			}
+			groupBy = g
+		}
+		cLog = cLog.WithField("GroupBy", groupBy)
+
+		if fromDate := r.URL.Query().Get("from_date"); fromDate != "" {
+			if err := validation.ValidateDate(fromDate); err != nil {
+				cLog.WithError(err).Errorf("resource/reports:summarizeReports() %s : Invalid from date format. Expected date format mm-dd-yyyy", message.InvalidInputProtocolViolation)
+				log.Tracef("%+v", err)
+				return &endpointError{Message: "Failed to summarize reports - invalid from_date", StatusCode: http.StatusBadRequest}
+			}
+			filterCriteria.FromDate = fromDate
+		}
+
+		if toDate := r.URL.Query().Get("to_date"); toDate != "" {
+			if err := validation.ValidateDate(toDate); err != nil {
+				cLog.WithError(err).Errorf("resource/reports:summarizeReports() %s : Invalid to date format. Expected date format mm-dd-yyyy", message.InvalidInputProtocolViolation)
+				log.Tracef("%+v", err)
+				return &endpointError{Message: "Failed to summarize reports - invalid to_date", StatusCode: http.StatusBadRequest}
+			}
+			filterCriteria.ToDate = toDate
+		}
+
+		if numOfDays := r.URL.Query().Get("num_of_days"); numOfDays != "" {
+			nd, err := strconv.Atoi(numOfDays)
+			if err != nil {
+				cLog.WithError(err).Errorf("resource/reports:summarizeReports() %s : Invalid integer value for num_of_days query parameter", message.InvalidInputProtocolViolation)
+				log.Tracef("%+v", err)
+				return &endpointError{Message: "Failed to summarize reports - invalid num_of_days", StatusCode: http.StatusBadRequest}
+			}
+			filterCriteria.NumOfDays = nd
+		}
+
+		groups, err := db.ReportRepository().Summarize(filterCriteria, groupBy)
+		if err != nil {
+			cLog.WithError(err).Errorf("resource/reports:summarizeReports() %s : Failed to summarize reports", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{Message: "Failed to summarize reports", StatusCode: http.StatusInternalServerError}
+		}
+		summary := model.ReportSummary{GroupBy: groupBy, Groups: groups}
+		if summary.Groups == nil {
+			summary.Groups = []model.ReportSummaryGroup{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			cLog.WithError(err).Errorf("resource/reports:summarizeReports() %s : Unexpectedly failed to encode report summary to JSON", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{Message: "Failed to summarize reports - JSON encode failed", StatusCode: http.StatusInternalServerError}
+		}
+		return nil
+	}
+}
+
+// loadReportCAs loads the CA certificates that report signing certificates must chain to
+func loadReportCAs() ([]*x509.Certificate, *endpointError) {
+	caCerts, err := loadCertificatesFromDir(trustedCaCertsDir)
+	if err != nil {
+		log.WithError(err).Errorf("resource/reports:loadReportCAs() %s : Unable to load trusted CA certificates", message.AppRuntimeErr)
+		log.Tracef("%+v", err)
+		return nil, &endpointError{
+			Message:    "Unable to verify report signature",
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	return caCerts, nil
+}
+
+// verifyReport verifies the signature of a report, and replaces its trust report with the signed one
+func verifyReport(vtr *model.Report, caCerts []*x509.Certificate) *endpointError {
+	signingCert, err := verifyReportSignature(&vtr.SignedData, caCerts)
+	if err != nil {
+		if _, ok := err.(signatureError); ok {
+			seclog.WithError(err).Errorf("resource/reports:verifyReport() %s : Report signature verification failed", message.InvalidInputProtocolViolation)
+			return &endpointError{
+				Message:    "Report signature verification failed: " + err.Error(),
+				StatusCode: http.StatusBadRequest,
+				Code:       errCodeReportSignatureInvalid,
+			}
+		}
+		log.WithError(err).Errorf("resource/reports:verifyReport() %s : Unable to verify report signature", message.AppRuntimeErr)
+		log.Tracef("%+v", err)
+		return &endpointError{
+			Message:    "Unable to verify report signature",
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	vtr.Signer = signingCert.Subject.String()
+
+	// the stored trust report is always the signed one, never fields supplied next to the signed data
+	vtr.InstanceTrustReport = verifier.InstanceTrustReport{}
+	if err := json.Unmarshal(vtr.Data, &vtr.InstanceTrustReport); err != nil {
+		log.WithError(err).Errorf("resource/reports:verifyReport() %s : Report creation failed", message.AppRuntimeErr)
+		return &endpointError{
+			Message:    "Report creation failed",
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
 // Creates report for json request/content-type
 func createReport(db repository.WlsDatabase) endpointHandler {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		log.Trace("resource/reports:createReport() Entering")
 		defer log.Trace("resource/reports:createReport() Leaving")
 
-		var vtr model.Report
-		dec := json.NewDecoder(r.Body)
-		dec.DisallowUnknownFields()
-		if err := dec.Decode(&vtr); err != nil {
-			log.WithError(err).Errorf("resource/reports:createReport() %s : Report creation failed", message.AppRuntimeErr)
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.WithError(err).Errorf("resource/reports:createReport() %s : Failed to read request body", message.AppRuntimeErr)
 			return &endpointError{
 				Message:    "Report creation failed",
 				StatusCode: http.StatusBadRequest,
 			}
 		}
-		if err := json.Unmarshal(vtr.Data, &vtr.InstanceTrustReport); err != nil {
+		var vtr model.Report
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&vtr); err != nil {
 			log.WithError(err).Errorf("resource/reports:createReport() %s : Report creation failed", message.AppRuntimeErr)
 			return &endpointError{
 				Message:    "Report creation failed",
@@ -184,45 +363,197 @@ func createReport(db repository.WlsDatabase) endpointHandler {
 			}
 		}
 
+		caCerts, cerr := loadReportCAs()
+		if cerr != nil {
+			return cerr
+		}
+		if err := verifyReport(&vtr, caCerts); err != nil {
+			return err
+		}
+
+		if err := assignReportID(&vtr); err != nil {
+			return err
+		}
+		// the signed report is stored as submitted, so that its signature can be verified again
+		vtr.SignedReport = body
+
 		// it's almost silly that we unmarshal, then remarshal it to store it back into the database, but at least it provides some validation of the input
 		rr := db.ReportRepository()
-
-		// Performance Related:
-		// currently, we don't decipher the creation error to see if Creation failed because a collision happened between a primary or unique key.
-		// It would be nice to know why the record creation fails, and return the proper http status code.
-		// It could be done several ways:
-		// - Type assert the error back to PSQL (should be done in the repository layer), and bubble up that information somehow
-		// - Manually run a query to see if anything exists with uuid or label (should be done in the repository layer, so we can execute it in a transaction)
-		//    - Currently doing this ^
+		vtr.ExpiresOn = retention.ConfiguredPolicy().ExpiresOn(time.Now())
+		previous := latestReport(rr, vtr.Manifest.InstanceInfo.InstanceID)
 		cLog := log.WithField("report", vtr)
-		switch err := rr.Create(&vtr); err {
-		case errors.New("resource/reports:createReport() report already exists with UUID"):
-			msg := fmt.Sprintf("Report with UUID %s already exists", vtr.Manifest.InstanceInfo.InstanceID)
-			cLog.Errorf("resource/reports:createReport() %s : %s", message.InvalidInputBadParam, msg)
-			return &endpointError{
-				Message:    msg,
-				StatusCode: http.StatusConflict,
-			}
+		status := http.StatusCreated
+		switch err := rr.Create(&vtr); errors.Cause(err) {
 		case nil:
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusCreated)
-			if err := json.NewEncoder(w).Encode(vtr); err != nil {
-				cLog.WithError(err).Errorf("resource/reports:createReport() %s : Unexpectedly failed to encode Report to JSON", message.AppRuntimeErr)
-				log.Tracef("%+v", err)
-				return &endpointError{
-					Message:    "Failed to create reports - JSON encode failed",
-					StatusCode: http.StatusConflict,
-				}
+			publishTrustChange(previous, &vtr)
+		case repository.ErrReportAlreadyExists:
+			// the same report submitted again is not an error, its stored report is returned
+			existing, rerr := rr.RetrieveByIDs([]string{vtr.ID})
+			if rerr != nil {
+				cLog.WithError(rerr).Errorf("resource/reports:createReport() %s : Failed to retrieve report with ID %s", message.AppRuntimeErr, vtr.ID)
+				log.Tracef("%+v", rerr)
+				return createReportError(rerr)
 			}
-			return nil
+			if len(existing) == 0 || !sameReport(&existing[0], &vtr) {
+				cLog.WithError(err).Errorf("resource/reports:createReport() %s : Report with ID %s already exists", message.InvalidInputBadParam, vtr.ID)
+				return createReportError(err)
+			}
+			cLog.Debugf("resource/reports:createReport() Report with ID %s was already created", vtr.ID)
+			vtr = existing[0]
+			status = http.StatusOK
 		default:
-			cLog.WithError(err).Errorf("resource/reports:createReport() %s : Unexpected error when creating report", message.AppRuntimeErr)
+			cLog.WithError(err).Errorf("resource/reports:createReport() %s : Failed to create report", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return createReportError(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(vtr); err != nil {
+			cLog.WithError(err).Errorf("resource/reports:createReport() %s : Unexpectedly failed to encode Report to JSON", message.AppRuntimeErr)
 			log.Tracef("%+v", err)
 			return &endpointError{
-				Message:    "Unexpected error when creating report, check input format",
+				Message:    "Failed to create reports - JSON encode failed",
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+		return nil
+	}
+}
+
+// reportIDNamespace is the namespace of the report IDs derived from the signed reports
+var reportIDNamespace = uuid.MustParse("3c0a5f8e-7d2b-4e61-9a4f-1b8d6c2e5f90")
+
+// assignReportID keeps the ID supplied with a report, or derives its ID from the signed report, so that a report
+// submitted again is not created twice
+func assignReportID(vtr *model.Report) *endpointError {
+	if vtr.ID != "" {
+		id, err := uuid.Parse(vtr.ID)
+		if err != nil {
+			log.WithError(err).Errorf("resource/reports:assignReportID() %s : Invalid report ID %s", message.InvalidInputBadParam, vtr.ID)
+			return &endpointError{
+				Message:    "Report creation failed - report ID must be a UUID",
 				StatusCode: http.StatusBadRequest,
 			}
 		}
+		vtr.ID = id.String()
+		return nil
+	}
+	content := make([]byte, 0, len(vtr.Data)+len(vtr.Signature))
+	content = append(append(content, vtr.Data...), vtr.Signature...)
+	vtr.ID = uuid.NewSHA1(reportIDNamespace, content).String()
+	return nil
+}
+
+// validateInstanceID checks that the instance ID of a report is a UUID, as required to store the report
+func validateInstanceID(vtr *model.Report) *endpointError {
+	if _, err := uuid.Parse(vtr.Manifest.InstanceInfo.InstanceID); err != nil {
+		log.WithError(err).Errorf("resource/reports:validateInstanceID() %s : Invalid instance ID %s", message.InvalidInputBadParam, vtr.Manifest.InstanceInfo.InstanceID)
+		return &endpointError{
+			Message:    "Report creation failed - instance ID must be a UUID",
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
+// instanceKey returns the canonical form of a valid instance ID, as the instance IDs of stored reports are returned
+func instanceKey(instanceID string) string {
+	if id, err := uuid.Parse(instanceID); err == nil {
+		return id.String()
+	}
+	return instanceID
+}
+
+// validateReportID checks that a report ID is a UUID. Report IDs derived from the signed reports are not version 4
+// UUIDs, so any version is accepted.
+func validateReportID(id string) error {
+	if _, err := uuid.Parse(id); err != nil || len(id) != 36 {
+		return errors.New("report ID must be a UUID")
+	}
+	return nil
+}
+
+// sameReport tells whether two reports hold the same signed report
+func sameReport(a, b *model.Report) bool {
+	return bytes.Equal(a.Data, b.Data) && bytes.Equal(a.Signature, b.Signature)
+}
+
+// createReportError returns the endpoint error of a report the repository failed to create
+func createReportError(err error) *endpointError {
+	switch errors.Cause(err) {
+	case repository.ErrReportAlreadyExists:
+		return &endpointError{
+			Message:    "Report creation failed - a different report with the same ID already exists",
+			StatusCode: http.StatusConflict,
+		}
+	case repository.ErrReportInvalid:
+		return &endpointError{
+			Message:    "Report creation failed - report is not valid",
+			StatusCode: http.StatusBadRequest,
+		}
+	default:
+		return &endpointError{
+			Message:    "Unexpected error when creating report",
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+}
+
+// signedReportMediaType is the media type of the signed report exactly as it was submitted
+const signedReportMediaType = "application/vnd.wls.signed-report+json"
+
+// Gets the report with the given ID. The signed report exactly as it was submitted is returned instead, when the
+// client prefers signedReportMediaType to application/json.
+func getReportByID(db repository.WlsDatabase) endpointHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.Trace("resource/reports:getReportByID() Entering")
+		defer log.Trace("resource/reports:getReportByID() Leaving")
+
+		id := mux.Vars(r)["id"]
+		if err := validateReportID(id); err != nil {
+			log.WithError(err).Errorf("resource/reports:getReportByID() %s : Invalid report UUID format: %s", message.InvalidInputProtocolViolation, id)
+			return &endpointError{Message: "Failed to retrieve report - report ID must be a UUID", StatusCode: http.StatusBadRequest}
+		}
+		cLog := log.WithField("id", id)
+		reports, err := db.ReportRepository().RetrieveByIDs([]string{id})
+		if err != nil {
+			cLog.WithError(err).Errorf("resource/reports:getReportByID() %s : Failed to retrieve report", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{Message: "Failed to retrieve report", StatusCode: http.StatusInternalServerError}
+		}
+		if len(reports) == 0 {
+			cLog.Debug("resource/reports:getReportByID() Report not found")
+			return &endpointError{Message: "Report with ID " + id + " not found", StatusCode: http.StatusNotFound}
+		}
+		report := reports[0]
+
+		w.Header().Add("Vary", "Accept")
+		if !prefersOverJSON(r.Header.Get("Accept"), signedReportMediaType) {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(report); err != nil {
+				cLog.WithError(err).Errorf("resource/reports:getReportByID() %s : Unexpectedly failed to encode report to JSON", message.AppRuntimeErr)
+				log.Tracef("%+v", err)
+				return &endpointError{Message: "Failed to retrieve report - JSON encode failed", StatusCode: http.StatusInternalServerError}
+			}
+			return nil
+		}
+
+		signedReport := report.SignedReport
+		if len(signedReport) == 0 {
+			// reports created before the signed reports were stored only have their signed data, the signature
+			// covers the data, so it can still be verified
+			cLog.Debug("resource/reports:getReportByID() Report was stored without its signed report, returning its signed data")
+			if signedReport, err = json.Marshal(report.SignedData); err != nil {
+				cLog.WithError(err).Errorf("resource/reports:getReportByID() %s : Unexpectedly failed to encode signed data to JSON", message.AppRuntimeErr)
+				log.Tracef("%+v", err)
+				return &endpointError{Message: "Failed to retrieve report - JSON encode failed", StatusCode: http.StatusInternalServerError}
+			}
+		}
+		w.Header().Set("Content-Type", signedReportMediaType)
+		if _, err := w.Write(signedReport); err != nil {
+			cLog.WithError(err).Errorf("resource/reports:getReportByID() %s : Failed to write signed report", message.AppRuntimeErr)
+		}
+		return nil
 	}
 }
 
@@ -233,14 +564,14 @@ func deleteReportByID(db repository.WlsDatabase) endpointHandler {
 
 		uuid := mux.Vars(r)["id"]
 		// validate UUID
-		if err := validation.ValidateUUIDv4(uuid); err != nil {
+		if err := validateReportID(uuid); err != nil {
 			log.WithError(err).Errorf("resource/reports:deleteReportByID() %s : Invalid report UUID format: %s", message.InvalidInputProtocolViolation, uuid)
 			log.Tracef("%+v", err)
 			return &endpointError{Message: "Failed to delete report by UUID", StatusCode: http.StatusBadRequest}
 		}
 		cLog := log.WithField("uuid", uuid)
 
-		// TODO: Potential dupe check. Shouldn't this be validated by the ValidateUUIDv4 call above?
+		// TODO: Potential dupe check. Shouldn't this be validated by the validateReportID call above?
 		if uuid == "" {
 			log.Errorf("resource/reports:deleteReportByID() %s : Report id cannot be empty", message.InvalidInputBadParam)
 			return &endpointError{
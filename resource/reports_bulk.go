@@ -0,0 +1,294 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"intel/isecl/workload-service/v4/retention"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Content types of bulk report requests
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+var (
+	errBulkBodyTooLarge   = errors.New("request body is too large")
+	errBulkTooManyReports = errors.New("request holds too many reports")
+)
+
+// bodyLimiter reads at most limit bytes of a request body, failing with errBulkBodyTooLarge past the limit
+type bodyLimiter struct {
+	r     io.Reader
+	limit int64
+}
+
+func (l *bodyLimiter) Read(p []byte) (int, error) {
+	if l.limit <= 0 {
+		// the body is exactly limit bytes long when nothing is left to read
+		var b [1]byte
+		if n, _ := l.r.Read(b[:]); n > 0 {
+			return 0, errBulkBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.limit {
+		p = p[:l.limit]
+	}
+	n, err := l.r.Read(p)
+	l.limit -= int64(n)
+	return n, err
+}
+
+// decodeBulkReports splits a bulk report request body, a JSON array or newline delimited JSON, into its reports.
+// The reports themselves are decoded later, so that an invalid report does not fail the other reports.
+func decodeBulkReports(body io.Reader, ndjson bool, maxReports int) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(body)
+	var reports []json.RawMessage
+	if ndjson {
+		for {
+			var report json.RawMessage
+			if err := dec.Decode(&report); err == io.EOF {
+				return reports, nil
+			} else if err != nil {
+				return nil, err
+			}
+			if reports = append(reports, report); len(reports) > maxReports {
+				return nil, errBulkTooManyReports
+			}
+		}
+	}
+
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('[') {
+		return nil, errors.New("request body is not a JSON array")
+	}
+	for dec.More() {
+		var report json.RawMessage
+		if err := dec.Decode(&report); err != nil {
+			return nil, err
+		}
+		if reports = append(reports, report); len(reports) > maxReports {
+			return nil, errBulkTooManyReports
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON array")
+	}
+	return reports, nil
+}
+
+// bulkReportFailure is the result of a report that was not created
+func bulkReportFailure(index int, e *endpointError) model.BulkReportResult {
+	return model.BulkReportResult{Index: index, Status: e.StatusCode, Code: errorCode(e), Message: e.Message}
+}
+
+// Creates the reports of a JSON array or of newline delimited JSON, in a single transaction. Each report is verified
+// as by POST /reports, the response holds the status of each report.
+func createReports(db repository.WlsDatabase) endpointHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.Trace("resource/reports_bulk:createReports() Entering")
+		defer log.Trace("resource/reports_bulk:createReports() Leaving")
+
+		contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (contentType != contentTypeJSON && contentType != contentTypeNDJSON) {
+			log.Errorf("resource/reports_bulk:createReports() %s : Unsupported content type %s", message.InvalidInputProtocolViolation, r.Header.Get("Content-Type"))
+			return &endpointError{
+				Message:    "Bulk report creation failed - content type must be " + contentTypeJSON + " or " + contentTypeNDJSON,
+				StatusCode: http.StatusUnsupportedMediaType,
+			}
+		}
+
+		maxReports := config.Configuration.ReportsMaxBulkSize
+		if maxReports <= 0 {
+			maxReports = constants.DefaultReportsMaxBulkSize
+		}
+		maxBytes := config.Configuration.ReportsMaxBulkBytes
+		if maxBytes <= 0 {
+			maxBytes = constants.DefaultReportsBulkBytes
+		}
+		items, err := decodeBulkReports(&bodyLimiter{r: r.Body, limit: int64(maxBytes)}, contentType == contentTypeNDJSON, maxReports)
+		switch {
+		case err == errBulkBodyTooLarge:
+			log.Errorf("resource/reports_bulk:createReports() %s : Request body exceeds %d bytes", message.InvalidInputBadParam, maxBytes)
+			return &endpointError{Message: "Bulk report creation failed - " + err.Error(), StatusCode: http.StatusRequestEntityTooLarge}
+		case err == errBulkTooManyReports:
+			log.Errorf("resource/reports_bulk:createReports() %s : Request holds more than %d reports", message.InvalidInputBadParam, maxReports)
+			return &endpointError{Message: "Bulk report creation failed - " + err.Error(), StatusCode: http.StatusRequestEntityTooLarge}
+		case err != nil:
+			log.WithError(err).Errorf("resource/reports_bulk:createReports() %s : Failed to decode bulk report request", message.InvalidInputProtocolViolation)
+			return &endpointError{Message: "Bulk report creation failed - invalid request body", StatusCode: http.StatusBadRequest}
+		case len(items) == 0:
+			log.Errorf("resource/reports_bulk:createReports() %s : Request holds no report", message.InvalidInputBadParam)
+			return &endpointError{Message: "Bulk report creation failed - request holds no report", StatusCode: http.StatusBadRequest}
+		}
+
+		caCerts, cerr := loadReportCAs()
+		if cerr != nil {
+			return cerr
+		}
+		expiresOn := retention.ConfiguredPolicy().ExpiresOn(time.Now())
+		response := model.BulkReportResponse{Results: make([]model.BulkReportResult, len(items))}
+		var reports []*model.Report
+		var indexes []int
+		inRequest := make(map[string]int)
+		for i, item := range items {
+			var vtr model.Report
+			dec := json.NewDecoder(bytes.NewReader(item))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&vtr); err != nil {
+				log.WithError(err).Errorf("resource/reports_bulk:createReports() %s : Report %d creation failed", message.InvalidInputProtocolViolation, i)
+				response.Results[i] = bulkReportFailure(i, &endpointError{Message: "Report creation failed", StatusCode: http.StatusBadRequest})
+				continue
+			}
+			if e := verifyReport(&vtr, caCerts); e != nil {
+				response.Results[i] = bulkReportFailure(i, e)
+				continue
+			}
+			// the IDs are validated here, so that an invalid report does not fail the creation of the other reports
+			if e := validateInstanceID(&vtr); e != nil {
+				response.Results[i] = bulkReportFailure(i, e)
+				continue
+			}
+			if e := assignReportID(&vtr); e != nil {
+				response.Results[i] = bulkReportFailure(i, e)
+				continue
+			}
+			vtr.SignedReport = item
+			if first, ok := inRequest[vtr.ID]; ok {
+				log.Errorf("resource/reports_bulk:createReports() %s : Report %d has the ID of report %d", message.InvalidInputBadParam, i, first)
+				response.Results[i] = bulkReportFailure(i, &endpointError{
+					Message:    fmt.Sprintf("Report creation failed - report %d of the request has the same ID", first),
+					StatusCode: http.StatusConflict,
+				})
+				continue
+			}
+			inRequest[vtr.ID] = i
+			vtr.ExpiresOn = expiresOn
+			reports = append(reports, &vtr)
+			indexes = append(indexes, i)
+		}
+
+		rr := db.ReportRepository()
+		reports, indexes = skipExistingReports(rr, reports, indexes, response.Results)
+		if len(reports) > 0 {
+			previous := latestReports(rr, reports)
+			if err := rr.CreateMany(reports); err != nil {
+				log.WithError(err).Errorf("resource/reports_bulk:createReports() %s : Failed to create reports", message.AppRuntimeErr)
+				log.Tracef("%+v", err)
+				for _, i := range indexes {
+					response.Results[i] = bulkReportFailure(i, createReportError(err))
+				}
+			} else {
+				for k, vtr := range reports {
+					response.Results[indexes[k]] = model.BulkReportResult{Index: indexes[k], Status: http.StatusCreated, ID: vtr.ID}
+					// a report is compared with the previous report of its instance in the request, if any
+					instanceID := instanceKey(vtr.Manifest.InstanceInfo.InstanceID)
+					publishTrustChange(previous[instanceID], vtr)
+					previous[instanceID] = vtr
+				}
+			}
+		}
+		for _, result := range response.Results {
+			if result.Status == http.StatusCreated || result.Status == http.StatusOK {
+				response.Created++
+			} else {
+				response.Failed++
+			}
+		}
+		log.Infof("resource/reports_bulk:createReports() Created %d reports, %d reports failed", response.Created, response.Failed)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.WithError(err).Errorf("resource/reports_bulk:createReports() %s : Unexpectedly failed to encode bulk report response to JSON", message.AppRuntimeErr)
+			log.Tracef("%+v", err)
+			return &endpointError{Message: "Failed to create reports - JSON encode failed", StatusCode: http.StatusInternalServerError}
+		}
+		return nil
+	}
+}
+
+// skipExistingReports sets the results of the reports that were already created, and returns the other reports with
+// their indexes. A report submitted again is not an error, a different report with the ID of a stored report is.
+func skipExistingReports(rr repository.ReportRepository, reports []*model.Report, indexes []int, results []model.BulkReportResult) ([]*model.Report, []int) {
+	if len(reports) == 0 {
+		return reports, indexes
+	}
+	ids := make([]string, len(reports))
+	for k, report := range reports {
+		ids[k] = report.ID
+	}
+	stored, err := rr.RetrieveByIDs(ids)
+	if err != nil {
+		log.WithError(err).Errorf("resource/reports_bulk:skipExistingReports() %s : Failed to retrieve reports by ID", message.AppRuntimeErr)
+		log.Tracef("%+v", err)
+		for _, i := range indexes {
+			results[i] = bulkReportFailure(i, createReportError(err))
+		}
+		return nil, nil
+	}
+	existing := make(map[string]*model.Report)
+	for k := range stored {
+		existing[stored[k].ID] = &stored[k]
+	}
+
+	var remaining []*model.Report
+	var remainingIndexes []int
+	for k, report := range reports {
+		i := indexes[k]
+		switch e, ok := existing[report.ID]; {
+		case !ok:
+			remaining = append(remaining, report)
+			remainingIndexes = append(remainingIndexes, i)
+		case sameReport(e, report):
+			results[i] = model.BulkReportResult{Index: i, Status: http.StatusOK, ID: report.ID}
+		default:
+			log.Errorf("resource/reports_bulk:skipExistingReports() %s : Report with ID %s already exists", message.InvalidInputBadParam, report.ID)
+			results[i] = bulkReportFailure(i, createReportError(repository.ErrReportAlreadyExists))
+		}
+	}
+	return remaining, remainingIndexes
+}
+
+// latestReports returns the latest stored report of the instances of the reports, by canonical instance ID. The trust
+// changes of the reports are not published when the latest reports cannot be retrieved.
+func latestReports(rr repository.ReportRepository, reports []*model.Report) map[string]*model.Report {
+	var instanceIDs []string
+	seen := make(map[string]bool)
+	for _, report := range reports {
+		if id := instanceKey(report.Manifest.InstanceInfo.InstanceID); !seen[id] {
+			seen[id] = true
+			instanceIDs = append(instanceIDs, id)
+		}
+	}
+	previous := make(map[string]*model.Report)
+	latest, err := rr.RetrieveLatestByInstanceIDs(instanceIDs)
+	if err != nil {
+		log.WithError(err).Warn("resource/reports_bulk:latestReports() Failed to retrieve the latest reports of the instances, their trust changes will not be published")
+		return previous
+	}
+	for i := range latest {
+		previous[instanceKey(latest[i].Manifest.InstanceInfo.InstanceID)] = &latest[i]
+	}
+	return previous
+}
@@ -0,0 +1,335 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"intel/isecl/lib/common/v4/crypt"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/events"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository/mock"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func postBulkReports(r http.Handler, contentType string, body []byte) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/wls/v1/reports/bulk", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", contentType)
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func decodeBulkReportResponse(t *testing.T, recorder *httptest.ResponseRecorder) model.BulkReportResponse {
+	var response model.BulkReportResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid bulk report response: %s", recorder.Body.String())
+	}
+	return response
+}
+
+// otherTrustReport returns a trust report of the same instance as testTrustReport, with another policy
+func otherTrustReport(t *testing.T, policy string) []byte {
+	return bytes.Replace(testTrustReport(t), []byte(`"policy_name":"Intel VM Policy"`), []byte(`"policy_name":"`+policy+`"`), 1)
+}
+
+// bulkReports returns a valid, a tampered, an unsigned and another valid report, in this order
+func bulkReports(t *testing.T, signer reportSigner) []interface{} {
+	tampered := signer.sign(t, testTrustReport(t))
+	tampered.Data = bytes.Replace(tampered.Data, []byte(`"trusted":false`), []byte(`"trusted":true`), 1)
+	return []interface{}{
+		signer.sign(t, testTrustReport(t)),
+		tampered,
+		crypt.SignedData{Data: testTrustReport(t)},
+		signer.sign(t, otherTrustReport(t, "Other VM Policy")),
+	}
+}
+
+func TestCreateBulkReports(t *testing.T) {
+	log.Trace("resource/reports_bulk_test:TestCreateBulkReports() Entering")
+	defer log.Trace("resource/reports_bulk_test:TestCreateBulkReports() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	reports := bulkReports(t, signer)
+	array, _ := json.Marshal(reports)
+	var ndjson bytes.Buffer
+	enc := json.NewEncoder(&ndjson)
+	for _, report := range reports {
+		enc.Encode(report)
+	}
+	// an item that is not a report fails alone
+	ndjson.WriteString(`{"unknown":true}` + "\n")
+
+	for contentType, body := range map[string][]byte{
+		"application/json":                    array,
+		"application/x-ndjson; charset=utf-8": ndjson.Bytes(),
+	} {
+		var created []*model.Report
+		db := new(mock.Database)
+		db.MockReport.CreateManyFn = func(reports []*model.Report) error {
+			created = reports
+			for i, report := range reports {
+				report.ID = "report-" + strconv.Itoa(i)
+			}
+			return nil
+		}
+		r := setupMockServer(db)
+
+		recorder := postBulkReports(r, contentType, body)
+		assert.Equal(http.StatusOK, recorder.Code, contentType)
+		response := decodeBulkReportResponse(t, recorder)
+		assert.Equal(2, response.Created, contentType)
+		if assert.Len(created, 2, contentType) {
+			assert.Equal("CN=Workload Agent Signing Certificate", created[0].Signer)
+			first, _ := json.Marshal(reports[0])
+			assert.Equal(first, created[0].SignedReport, contentType)
+			assert.Equal("7b280921-83f7-4f44-9f8d-2dcf36e7af33", created[1].Manifest.InstanceInfo.InstanceID)
+		}
+		assert.Equal(model.BulkReportResult{Index: 0, Status: http.StatusCreated, ID: "report-0"}, response.Results[0], contentType)
+		assert.Equal(http.StatusBadRequest, response.Results[1].Status, contentType)
+		assert.Equal(errCodeReportSignatureInvalid, response.Results[1].Code, contentType)
+		assert.Contains(response.Results[1].Message, "report signature does not match report data", contentType)
+		assert.Equal(http.StatusBadRequest, response.Results[2].Status, contentType)
+		assert.Equal(model.BulkReportResult{Index: 3, Status: http.StatusCreated, ID: "report-1"}, response.Results[3], contentType)
+		if contentType == "application/json" {
+			assert.Equal(2, response.Failed)
+			assert.Len(response.Results, 4)
+		} else {
+			assert.Equal(3, response.Failed)
+			assert.Equal(4, response.Results[4].Index)
+			assert.Equal(http.StatusBadRequest, response.Results[4].Status)
+		}
+	}
+}
+
+func TestCreateBulkReportsCreateFailure(t *testing.T) {
+	log.Trace("resource/reports_bulk_test:TestCreateBulkReportsCreateFailure() Entering")
+	defer log.Trace("resource/reports_bulk_test:TestCreateBulkReportsCreateFailure() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	db := new(mock.Database)
+	db.MockReport.CreateManyFn = func(reports []*model.Report) error {
+		return errors.New("transaction failed")
+	}
+	r := setupMockServer(db)
+
+	body, _ := json.Marshal(bulkReports(t, signer))
+	recorder := postBulkReports(r, "application/json", body)
+	assert.Equal(http.StatusOK, recorder.Code)
+	response := decodeBulkReportResponse(t, recorder)
+	assert.Equal(0, response.Created)
+	assert.Equal(4, response.Failed)
+	for _, i := range []int{0, 3} {
+		assert.Equal(http.StatusInternalServerError, response.Results[i].Status)
+		assert.Equal(errCodeInternal, response.Results[i].Code)
+		assert.Empty(response.Results[i].ID)
+	}
+	assert.Equal(http.StatusBadRequest, response.Results[1].Status)
+}
+
+func TestCreateBulkReportsInvalidRequest(t *testing.T) {
+	log.Trace("resource/reports_bulk_test:TestCreateBulkReportsInvalidRequest() Entering")
+	defer log.Trace("resource/reports_bulk_test:TestCreateBulkReportsInvalidRequest() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+	previous := config.Configuration
+	defer func() { config.Configuration = previous }()
+	config.Configuration.ReportsMaxBulkSize = 3
+	config.Configuration.ReportsMaxBulkBytes = 64 << 10
+
+	db := new(mock.Database)
+	db.MockReport.CreateManyFn = func(reports []*model.Report) error {
+		assert.Fail("an invalid request must not create reports")
+		return nil
+	}
+	r := setupMockServer(db)
+
+	report, _ := json.Marshal(signer.sign(t, testTrustReport(t)))
+	tooLarge := []byte(`[` + string(report) + `, "` + strings.Repeat("x", config.Configuration.ReportsMaxBulkBytes) + `"]`)
+	tooMany, _ := json.Marshal(bulkReports(t, signer))
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{"unsupported content type", "text/plain", report, http.StatusUnsupportedMediaType},
+		{"not an array", "application/json", report, http.StatusBadRequest},
+		{"malformed array", "application/json", []byte(`[{}, {`), http.StatusBadRequest},
+		{"trailing data", "application/json", []byte(`[{}] {}`), http.StatusBadRequest},
+		{"malformed ndjson", "application/x-ndjson", []byte("{}\n{"), http.StatusBadRequest},
+		{"no report", "application/json", []byte(`[]`), http.StatusBadRequest},
+		{"too many reports", "application/json", tooMany, http.StatusRequestEntityTooLarge},
+		{"body too large", "application/json", tooLarge, http.StatusRequestEntityTooLarge},
+	} {
+		recorder := postBulkReports(r, tc.contentType, tc.body)
+		assert.Equal(tc.status, recorder.Code, tc.name)
+		if tc.status != http.StatusUnsupportedMediaType {
+			assert.Equal(errCodeInvalidRequest, decodeErrorResponse(t, recorder).Code, tc.name)
+		}
+	}
+}
+
+func TestCreateBulkReportsPublishesTrustChanges(t *testing.T) {
+	log.Trace("resource/reports_bulk_test:TestCreateBulkReportsPublishesTrustChanges() Entering")
+	defer log.Trace("resource/reports_bulk_test:TestCreateBulkReportsPublishesTrustChanges() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	db := new(mock.Database)
+	db.MockReport.RetrieveLatestByInstanceIDsFn = func(instanceIDs []string) ([]model.Report, error) {
+		assert.Equal([]string{"7b280921-83f7-4f44-9f8d-2dcf36e7af33"}, instanceIDs)
+		var previous model.Report
+		previous.Trusted = true
+		previous.Manifest.InstanceInfo.InstanceID = "7b280921-83f7-4f44-9f8d-2dcf36e7af33"
+		return []model.Report{previous}, nil
+	}
+	r := setupMockServer(db)
+	s := events.Subscribe(events.Filter{ImageID: "670f263e-b34e-4e07-a520-40ac9a89f62d"}, 0)
+	defer s.Close()
+
+	// the second report is compared with the first one, its trust status is unchanged
+	body, _ := json.Marshal([]crypt.SignedData{signer.sign(t, testTrustReport(t)), signer.sign(t, otherTrustReport(t, "Other VM Policy"))})
+	recorder := postBulkReports(r, "application/json", body)
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal(2, decodeBulkReportResponse(t, recorder).Created)
+	if assert.Len(s.C, 1) {
+		e := <-s.C
+		assert.Equal("7b280921-83f7-4f44-9f8d-2dcf36e7af33", e.InstanceID)
+		assert.True(e.PreviousTrusted)
+		assert.False(e.Trusted)
+	}
+}
+
+func TestCreateBulkReportsInvalidInstanceID(t *testing.T) {
+	log.Trace("resource/reports_bulk_test:TestCreateBulkReportsInvalidInstanceID() Entering")
+	defer log.Trace("resource/reports_bulk_test:TestCreateBulkReportsInvalidInstanceID() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	var created []*model.Report
+	db := new(mock.Database)
+	db.MockReport.CreateManyFn = func(reports []*model.Report) error {
+		created = reports
+		return nil
+	}
+	r := setupMockServer(db)
+
+	// a report the repository would reject fails alone, instead of failing the whole request
+	invalid := bytes.Replace(testTrustReport(t), []byte("7b280921-83f7-4f44-9f8d-2dcf36e7af33"), []byte("not-a-uuid"), 1)
+	body, _ := json.Marshal([]crypt.SignedData{signer.sign(t, testTrustReport(t)), signer.sign(t, invalid)})
+	recorder := postBulkReports(r, "application/json", body)
+	assert.Equal(http.StatusOK, recorder.Code)
+	response := decodeBulkReportResponse(t, recorder)
+	assert.Equal(1, response.Created)
+	assert.Equal(1, response.Failed)
+	assert.Equal(http.StatusCreated, response.Results[0].Status)
+	assert.Equal(http.StatusBadRequest, response.Results[1].Status)
+	assert.Contains(response.Results[1].Message, "instance ID must be a UUID")
+	assert.Len(created, 1)
+}
+
+func TestCreateBulkReportsPreviousReportAcrossCase(t *testing.T) {
+	log.Trace("resource/reports_bulk_test:TestCreateBulkReportsPreviousReportAcrossCase() Entering")
+	defer log.Trace("resource/reports_bulk_test:TestCreateBulkReportsPreviousReportAcrossCase() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	// the repository returns the canonical instance ID of the stored report
+	db := new(mock.Database)
+	db.MockReport.RetrieveLatestByInstanceIDsFn = func(instanceIDs []string) ([]model.Report, error) {
+		var previous model.Report
+		previous.Trusted = true
+		previous.Manifest.InstanceInfo.InstanceID = "7b280921-83f7-4f44-9f8d-2dcf36e7af33"
+		return []model.Report{previous}, nil
+	}
+	r := setupMockServer(db)
+	s := events.Subscribe(events.Filter{ImageID: "670f263e-b34e-4e07-a520-40ac9a89f62d"}, 0)
+	defer s.Close()
+
+	report := bytes.Replace(testTrustReport(t), []byte("7b280921-83f7-4f44-9f8d-2dcf36e7af33"), []byte("7B280921-83F7-4F44-9F8D-2DCF36E7AF33"), 1)
+	body, _ := json.Marshal([]crypt.SignedData{signer.sign(t, report)})
+	recorder := postBulkReports(r, "application/json", body)
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal(1, decodeBulkReportResponse(t, recorder).Created)
+	if assert.Len(s.C, 1) {
+		e := <-s.C
+		assert.True(e.PreviousTrusted)
+		assert.False(e.Trusted)
+	}
+}
+
+func TestCreateBulkReportsIdempotent(t *testing.T) {
+	log.Trace("resource/reports_bulk_test:TestCreateBulkReportsIdempotent() Entering")
+	defer log.Trace("resource/reports_bulk_test:TestCreateBulkReportsIdempotent() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	stored := signer.sign(t, testTrustReport(t))
+	conflicting := signer.sign(t, otherTrustReport(t, "Conflicting VM Policy"))
+	db := new(mock.Database)
+	db.MockReport.RetrieveByIDsFn = func(ids []string) ([]model.Report, error) {
+		// the stored report and a different report stored with the ID supplied for the conflicting report
+		return []model.Report{
+			{ID: ids[0], SignedData: stored},
+			{ID: "2a3ab4ba-b6a6-4a4e-8f5e-0d7bd1d6f64c", SignedData: stored},
+		}, nil
+	}
+	var created []*model.Report
+	db.MockReport.CreateManyFn = func(reports []*model.Report) error {
+		created = reports
+		return nil
+	}
+	r := setupMockServer(db)
+
+	body, _ := json.Marshal([]interface{}{
+		stored,
+		map[string]interface{}{
+			"id":        "2a3ab4ba-b6a6-4a4e-8f5e-0d7bd1d6f64c",
+			"data":      conflicting.Data,
+			"hash_alg":  conflicting.Alg,
+			"cert":      conflicting.Cert,
+			"signature": conflicting.Signature,
+		},
+		signer.sign(t, otherTrustReport(t, "Other VM Policy")),
+		signer.sign(t, otherTrustReport(t, "Other VM Policy")),
+		map[string]interface{}{"id": "report-1", "data": stored.Data, "hash_alg": stored.Alg, "cert": stored.Cert, "signature": stored.Signature},
+	})
+	recorder := postBulkReports(r, "application/json", body)
+	assert.Equal(http.StatusOK, recorder.Code)
+	response := decodeBulkReportResponse(t, recorder)
+	assert.Equal(2, response.Created)
+	assert.Equal(3, response.Failed)
+	if assert.Len(created, 1) {
+		assert.Equal(created[0].ID, response.Results[2].ID)
+	}
+	assert.Equal(http.StatusOK, response.Results[0].Status)
+	assert.NotEmpty(response.Results[0].ID)
+	assert.Equal(http.StatusConflict, response.Results[1].Status)
+	assert.Equal(errCodeConflict, response.Results[1].Code)
+	assert.Equal(http.StatusCreated, response.Results[2].Status)
+	// the same report twice in a request is created once
+	assert.Equal(http.StatusConflict, response.Results[3].Status)
+	assert.Equal(http.StatusBadRequest, response.Results[4].Status)
+}
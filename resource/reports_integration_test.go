@@ -11,7 +11,9 @@ import (
 	"bytes"
 	"crypto"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/intel-secl/intel-secl/v4/pkg/model/wls"
 	"intel/isecl/lib/common/v4/crypt"
 	"intel/isecl/lib/common/v4/middleware"
@@ -19,11 +21,14 @@ import (
 	"intel/isecl/lib/flavor/v4"
 	flavorUtil "intel/isecl/lib/flavor/v4/util"
 	"intel/isecl/lib/verifier/v4"
+	"intel/isecl/workload-service/v4/constants"
 	"intel/isecl/workload-service/v4/model"
 	"intel/isecl/workload-service/v4/repository/postgres"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	"io/ioutil"
@@ -83,6 +88,16 @@ func TestReportResource(t *testing.T) {
 
 	signedReport := crypt.SignedData{fJSON, crypt.GetHashingAlgorithmName(crypto.SHA256), cert, signature}
 
+	// trust the self signed report signing certificate
+	signingCert, _, err := parseCertificateChain(cert)
+	checkErr(err)
+	certsDir, err := ioutil.TempDir("", "trustedca")
+	checkErr(err)
+	defer os.RemoveAll(certsDir)
+	checkErr(ioutil.WriteFile(filepath.Join(certsDir, "report-signing-cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signingCert.Raw}), 0600))
+	trustedCaCertsDir = certsDir + "/"
+	defer func() { trustedCaCertsDir = constants.TrustedCaCertsDir }()
+
 	signedJSON, err := json.Marshal(signedReport)
 	checkErr(err)
 
@@ -98,6 +113,28 @@ func TestReportResource(t *testing.T) {
 	rbody, _ := ioutil.ReadAll(recorder.Result().Body)
 	log.Infof("%v", string(rbody))
 	assert.Equal(http.StatusCreated, recorder.Code)
+	var created model.Report
+	checkErr(json.Unmarshal(rbody, &created))
+
+	// the same report submitted again is not created twice
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/wls/v1/reports", bytes.NewBuffer(signedJSON))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	assert.Equal(http.StatusOK, recorder.Code)
+	var resubmitted model.Report
+	checkErr(json.Unmarshal(recorder.Body.Bytes(), &resubmitted))
+	assert.Equal(created.ID, resubmitted.ID)
+
+	// the signed report is returned as it was submitted
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/wls/v1/reports/"+created.ID, nil)
+	req.Header.Add("Accept", signedReportMediaType)
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal(signedJSON, recorder.Body.Bytes())
 
 	// ISECL-3639: a GET without parameters to /wls/v1/reports should return 400 and an error message
 	recorder = httptest.NewRecorder()
@@ -180,3 +217,98 @@ func TestReportResource(t *testing.T) {
 	assert.Equal(0, len(rResponse1))
 
 }
+
+func TestReportPagination(t *testing.T) {
+	log.Trace("resource/reports_integration_test:TestReportPagination() Entering")
+	defer log.Trace("resource/reports_integration_test:TestReportPagination() Leaving")
+	assert := assert.New(t)
+	_, ci := os.LookupEnv("CI")
+	var host string
+	if ci {
+		host = "postgres"
+	} else {
+		host = "localhost"
+	}
+	db, err := gorm.Open("postgres", fmt.Sprintf("host=%s port=5432 user=runner dbname=wls password=test sslmode=disable", host))
+	if err != nil {
+		t.Fatal("could not open DB")
+	}
+	wlsDB := postgres.PostgresDatabase{DB: db}
+	wlsDB.Migrate()
+
+	// seed reports for a single instance, so that other reports in the database do not affect the result
+	instanceID := uuid.New().String()
+	const reportCount = 57
+	for i := 0; i < reportCount; i++ {
+		report := model.Report{
+			InstanceTrustReport: verifier.InstanceTrustReport{
+				Manifest: instance.Manifest{
+					InstanceInfo: instance.Info{
+						InstanceID:       instanceID,
+						HostHardwareUUID: "59eed8f0-28c5-4070-91fc-f5e2e5443f6b",
+						ImageID:          "670f263e-b34e-4e07-a520-40ac9a89f62d",
+					},
+					ImageEncrypted: true,
+				},
+				PolicyName: "Intel VM Policy",
+				Trusted:    true,
+			},
+		}
+		if err := wlsDB.ReportRepository().Create(&report); err != nil {
+			t.Fatal("could not seed report")
+		}
+	}
+
+	r := mux.NewRouter()
+	r.Use(middleware.NewTokenAuth("../mockJWTDir", "../mockJWTDir", mockRetrieveJWTSigningCerts, cacheTime))
+	SetReportsEndpoints(r.PathPrefix("/wls/v1/reports").Subrouter(), wlsDB)
+
+	// walkPages returns the IDs of the reports of all the pages, in order
+	walkPages := func(sortOrder string) []string {
+		var ids []string
+		offset := "0"
+		for pages := 0; offset != ""; pages++ {
+			if pages > reportCount/10+1 {
+				assert.FailNow("pagination did not end")
+			}
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/wls/v1/reports?instance_id="+instanceID+"&latest_per_vm=false&limit=10&sort_order="+sortOrder+"&offset="+offset, nil)
+			req.Header.Add("Authorization", "Bearer "+BearerToken)
+			r.ServeHTTP(recorder, req)
+			assert.Equal(http.StatusOK, recorder.Code)
+			assert.Equal(strconv.Itoa(reportCount), recorder.Header().Get("X-Total-Count"))
+			var page []model.Report
+			assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &page))
+			assert.True(len(page) <= 10)
+			for _, report := range page {
+				ids = append(ids, report.ID)
+			}
+			offset = recorder.Header().Get("X-Next-Offset")
+		}
+		return ids
+	}
+
+	ascending := walkPages("asc")
+	descending := walkPages("desc")
+	assert.Len(ascending, reportCount)
+	assert.Len(descending, reportCount)
+	seen := make(map[string]bool)
+	for i, id := range ascending {
+		assert.False(seen[id], "report returned in more than one page")
+		seen[id] = true
+		assert.Equal(id, descending[reportCount-1-i])
+	}
+
+	// by default, only the latest report of the instance is returned
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/wls/v1/reports?instance_id="+instanceID, nil)
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal("1", recorder.Header().Get("X-Total-Count"))
+	assert.Empty(recorder.Header().Get("X-Next-Offset"))
+
+	for _, id := range ascending {
+		assert.NoError(wlsDB.ReportRepository().DeleteByReportID(id))
+	}
+}
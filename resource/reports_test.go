@@ -0,0 +1,644 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"intel/isecl/lib/common/v4/crypt"
+	"intel/isecl/lib/common/v4/pkg/instance"
+	"intel/isecl/lib/verifier/v4"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/events"
+	"intel/isecl/workload-service/v4/model"
+	"intel/isecl/workload-service/v4/repository"
+	"intel/isecl/workload-service/v4/repository/mock"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// reportSigner holds a report signing key and certificate issued by a test CA
+type reportSigner struct {
+	caCert  *x509.Certificate
+	key     *rsa.PrivateKey
+	certPem string
+}
+
+func newReportSigner(t *testing.T, commonName string) reportSigner {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("could not generate CA key")
+	}
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("could not create CA certificate")
+	}
+	caCert, _ := x509.ParseCertificate(caDer)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("could not generate signing key")
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("could not create signing certificate")
+	}
+	return reportSigner{
+		caCert:  caCert,
+		key:     key,
+		certPem: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+// trust writes the CA certificate of the signer to a new trusted CA directory
+func (s reportSigner) trust(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "trustedca")
+	if err != nil {
+		t.Fatal("could not create trusted CA directory")
+	}
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.pem"), caPem, 0600); err != nil {
+		t.Fatal("could not write CA certificate")
+	}
+	previousDir := trustedCaCertsDir
+	trustedCaCertsDir = dir + "/"
+	return func() {
+		trustedCaCertsDir = previousDir
+		os.RemoveAll(dir)
+	}
+}
+
+func (s reportSigner) sign(t *testing.T, data []byte) crypt.SignedData {
+	digest := sha256.Sum256(data)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal("could not sign report")
+	}
+	return crypt.SignedData{
+		Data:      data,
+		Alg:       crypt.GetHashingAlgorithmName(crypto.SHA256),
+		Cert:      s.certPem,
+		Signature: signature,
+	}
+}
+
+func testTrustReport(t *testing.T) []byte {
+	report := verifier.InstanceTrustReport{
+		Manifest: instance.Manifest{
+			InstanceInfo: instance.Info{
+				InstanceID:       "7b280921-83f7-4f44-9f8d-2dcf36e7af33",
+				HostHardwareUUID: "59eed8f0-28c5-4070-91fc-f5e2e5443f6b",
+				ImageID:          "670f263e-b34e-4e07-a520-40ac9a89f62d",
+			},
+			ImageEncrypted: true,
+		},
+		PolicyName: "Intel VM Policy",
+		Trusted:    false,
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal("could not marshal trust report")
+	}
+	return data
+}
+
+func postReport(r http.Handler, body interface{}) *httptest.ResponseRecorder {
+	reportJSON, _ := json.Marshal(body)
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/wls/v1/reports", bytes.NewBuffer(reportJSON))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestCreateReportValidSignature(t *testing.T) {
+	log.Trace("resource/reports_test:TestCreateReportValidSignature() Entering")
+	defer log.Trace("resource/reports_test:TestCreateReportValidSignature() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	var created *model.Report
+	db := new(mock.Database)
+	db.MockReport.CreateFn = func(r *model.Report) error {
+		created = r
+		return nil
+	}
+	r := setupMockServer(db)
+
+	recorder := postReport(r, signer.sign(t, testTrustReport(t)))
+	assert.Equal(http.StatusCreated, recorder.Code)
+	if assert.NotNil(created) {
+		assert.Equal("CN=Workload Agent Signing Certificate", created.Signer)
+		assert.Equal("7b280921-83f7-4f44-9f8d-2dcf36e7af33", created.Manifest.InstanceInfo.InstanceID)
+	}
+}
+
+func TestCreateReportTamperedData(t *testing.T) {
+	log.Trace("resource/reports_test:TestCreateReportTamperedData() Entering")
+	defer log.Trace("resource/reports_test:TestCreateReportTamperedData() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	db := new(mock.Database)
+	db.MockReport.CreateFn = func(r *model.Report) error {
+		assert.Fail("a tampered report must not be stored")
+		return nil
+	}
+	r := setupMockServer(db)
+
+	signedReport := signer.sign(t, testTrustReport(t))
+	signedReport.Data = bytes.Replace(signedReport.Data, []byte(`"trusted":false`), []byte(`"trusted":true`), 1)
+	recorder := postReport(r, signedReport)
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	assert.Contains(recorder.Body.String(), "report signature does not match report data")
+
+	// fields supplied next to the signed data must not override it
+	db.MockReport.CreateFn = func(r *model.Report) error {
+		return nil
+	}
+	signedReport = signer.sign(t, testTrustReport(t))
+	recorder = postReport(r, map[string]interface{}{
+		"data":      signedReport.Data,
+		"hash_alg":  signedReport.Alg,
+		"cert":      signedReport.Cert,
+		"signature": signedReport.Signature,
+		"trusted":   true,
+	})
+	assert.Equal(http.StatusCreated, recorder.Code)
+	var report model.Report
+	assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.False(report.Trusted)
+}
+
+func TestCreateReportUntrustedChain(t *testing.T) {
+	log.Trace("resource/reports_test:TestCreateReportUntrustedChain() Entering")
+	defer log.Trace("resource/reports_test:TestCreateReportUntrustedChain() Leaving")
+	assert := assert.New(t)
+	trustedSigner := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer trustedSigner.trust(t)()
+	untrustedSigner := newReportSigner(t, "Forged Signing Certificate")
+
+	db := new(mock.Database)
+	db.MockReport.CreateFn = func(r *model.Report) error {
+		assert.Fail("a report with an untrusted signer must not be stored")
+		return nil
+	}
+	r := setupMockServer(db)
+
+	recorder := postReport(r, untrustedSigner.sign(t, testTrustReport(t)))
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	assert.Contains(recorder.Body.String(), "report signing certificate is not issued by a trusted CA")
+	assert.Equal(errCodeReportSignatureInvalid, decodeErrorResponse(t, recorder).Code)
+}
+
+func TestCreateReportUnsigned(t *testing.T) {
+	log.Trace("resource/reports_test:TestCreateReportUnsigned() Entering")
+	defer log.Trace("resource/reports_test:TestCreateReportUnsigned() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	db := new(mock.Database)
+	r := setupMockServer(db)
+
+	recorder := postReport(r, crypt.SignedData{Data: testTrustReport(t)})
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	assert.Contains(recorder.Body.String(), "report is not signed")
+}
+
+func TestCreateReportID(t *testing.T) {
+	log.Trace("resource/reports_test:TestCreateReportID() Entering")
+	defer log.Trace("resource/reports_test:TestCreateReportID() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	var ids []string
+	db := new(mock.Database)
+	db.MockReport.CreateFn = func(r *model.Report) error {
+		ids = append(ids, r.ID)
+		return nil
+	}
+	r := setupMockServer(db)
+
+	// reports without ID are identified by their content
+	signedReport := signer.sign(t, testTrustReport(t))
+	assert.Equal(http.StatusCreated, postReport(r, signedReport).Code)
+	assert.Equal(http.StatusCreated, postReport(r, signedReport).Code)
+	assert.Equal(http.StatusCreated, postReport(r, signer.sign(t, otherTrustReport(t, "Other VM Policy"))).Code)
+	if assert.Len(ids, 3) {
+		assert.Equal(ids[0], ids[1])
+		assert.NotEqual(ids[0], ids[2])
+		id, err := uuid.Parse(ids[0])
+		assert.NoError(err)
+		assert.Equal(uuid.Version(5), id.Version())
+	}
+
+	withID := func(id string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":        id,
+			"data":      signedReport.Data,
+			"hash_alg":  signedReport.Alg,
+			"cert":      signedReport.Cert,
+			"signature": signedReport.Signature,
+		}
+	}
+	assert.Equal(http.StatusCreated, postReport(r, withID("2A3AB4BA-B6A6-4A4E-8F5E-0D7BD1D6F64C")).Code)
+	assert.Equal("2a3ab4ba-b6a6-4a4e-8f5e-0d7bd1d6f64c", ids[3])
+	recorder := postReport(r, withID("report-1"))
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	assert.Len(ids, 4)
+}
+
+func TestCreateReportRepositoryErrors(t *testing.T) {
+	log.Trace("resource/reports_test:TestCreateReportRepositoryErrors() Entering")
+	defer log.Trace("resource/reports_test:TestCreateReportRepositoryErrors() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errors.Wrap(repository.ErrReportAlreadyExists, "report exists"), http.StatusConflict, errCodeConflict},
+		{errors.Wrap(repository.ErrReportInvalid, "instance uuid cannot be empty"), http.StatusBadRequest, errCodeInvalidRequest},
+		{errors.Wrap(repository.ErrReportBackend, "connection refused"), http.StatusInternalServerError, errCodeInternal},
+		{errors.New("unexpected"), http.StatusInternalServerError, errCodeInternal},
+	} {
+		db := new(mock.Database)
+		db.MockReport.CreateFn = func(r *model.Report) error {
+			return tc.err
+		}
+		r := setupMockServer(db)
+		recorder := postReport(r, signer.sign(t, testTrustReport(t)))
+		assert.Equal(tc.status, recorder.Code, tc.err.Error())
+		assert.Equal(tc.code, decodeErrorResponse(t, recorder).Code, tc.err.Error())
+	}
+}
+
+func TestCreateReportAlreadyExists(t *testing.T) {
+	log.Trace("resource/reports_test:TestCreateReportAlreadyExists() Entering")
+	defer log.Trace("resource/reports_test:TestCreateReportAlreadyExists() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	stored := model.Report{SignedData: signer.sign(t, testTrustReport(t)), Signer: "CN=Workload Agent Signing Certificate"}
+	db := new(mock.Database)
+	db.MockReport.CreateFn = func(r *model.Report) error {
+		return errors.Wrap(repository.ErrReportAlreadyExists, "report exists")
+	}
+	db.MockReport.RetrieveByIDsFn = func(ids []string) ([]model.Report, error) {
+		stored.ID = ids[0]
+		return []model.Report{stored}, nil
+	}
+	r := setupMockServer(db)
+	s := events.Subscribe(events.Filter{}, 0)
+	defer s.Close()
+
+	// the same report submitted again returns the stored report
+	recorder := postReport(r, stored.SignedData)
+	assert.Equal(http.StatusOK, recorder.Code)
+	var report model.Report
+	assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(stored.ID, report.ID)
+	assert.Empty(s.C)
+
+	// a different report with the ID of the stored report conflicts with it
+	other := signer.sign(t, otherTrustReport(t, "Other VM Policy"))
+	recorder = postReport(r, other)
+	assert.Equal(http.StatusConflict, recorder.Code)
+	assert.Equal(errCodeConflict, decodeErrorResponse(t, recorder).Code)
+}
+
+func getReportByIDRequest(r http.Handler, id, accept string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/wls/v1/reports/"+id, nil)
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	if accept != "" {
+		req.Header.Add("Accept", accept)
+	}
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestGetReportByID(t *testing.T) {
+	log.Trace("resource/reports_test:TestGetReportByID() Entering")
+	defer log.Trace("resource/reports_test:TestGetReportByID() Leaving")
+	assert := assert.New(t)
+	signer := newReportSigner(t, "Workload Agent Signing Certificate")
+	defer signer.trust(t)()
+
+	// the report is stored as submitted, with its fields in an unusual order and spacing
+	signedData := signer.sign(t, testTrustReport(t))
+	field := func(v interface{}) []byte {
+		j, _ := json.Marshal(v)
+		return j
+	}
+	submitted := []byte(fmt.Sprintf("{ \"signature\": %s,\n  \"cert\": %s, \"hash_alg\": %s, \"data\": %s }\n",
+		field(signedData.Signature), field(signedData.Cert), field(signedData.Alg), field(signedData.Data)))
+
+	var stored *model.Report
+	db := new(mock.Database)
+	db.MockReport.CreateFn = func(r *model.Report) error {
+		stored = r
+		return nil
+	}
+	db.MockReport.RetrieveByIDsFn = func(ids []string) ([]model.Report, error) {
+		if stored == nil || ids[0] != stored.ID {
+			return nil, nil
+		}
+		return []model.Report{*stored}, nil
+	}
+	r := setupMockServer(db)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/wls/v1/reports", bytes.NewBuffer(submitted))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	if !assert.Equal(http.StatusCreated, recorder.Code) || !assert.NotNil(stored) {
+		t.FailNow()
+	}
+	assert.Equal(submitted, stored.SignedReport)
+
+	recorder = getReportByIDRequest(r, stored.ID, "")
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal("application/json", recorder.Header().Get("Content-Type"))
+	var report model.Report
+	assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(stored.ID, report.ID)
+	assert.Equal(signedData.Data, report.Data)
+
+	// the signed report is returned byte for byte
+	recorder = getReportByIDRequest(r, stored.ID, signedReportMediaType+", application/json;q=0.5")
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal(signedReportMediaType, recorder.Header().Get("Content-Type"))
+	assert.Equal(submitted, recorder.Body.Bytes())
+
+	// reports stored before the signed reports were kept return their signed data
+	stored.SignedReport = nil
+	recorder = getReportByIDRequest(r, stored.ID, signedReportMediaType)
+	assert.Equal(http.StatusOK, recorder.Code)
+	var envelope crypt.SignedData
+	assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &envelope))
+	assert.Equal(signedData, envelope)
+}
+
+func TestGetReportByIDErrors(t *testing.T) {
+	log.Trace("resource/reports_test:TestGetReportByIDErrors() Entering")
+	defer log.Trace("resource/reports_test:TestGetReportByIDErrors() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	r := setupMockServer(db)
+
+	recorder := getReportByIDRequest(r, "7b280921-83f7-4f44-9f8d-2dcf36e7af33", "")
+	assert.Equal(http.StatusNotFound, recorder.Code)
+	assert.Equal(errCodeNotFound, decodeErrorResponse(t, recorder).Code)
+
+	recorder = getReportByIDRequest(r, "report-1", "")
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+
+	db.MockReport.RetrieveByIDsFn = func(ids []string) ([]model.Report, error) {
+		return nil, errors.New("connection refused")
+	}
+	recorder = getReportByIDRequest(r, "7b280921-83f7-4f44-9f8d-2dcf36e7af33", "")
+	assert.Equal(http.StatusInternalServerError, recorder.Code)
+}
+
+func getReports(r http.Handler, query string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/wls/v1/reports?"+query, nil)
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// mockReports serves the pages of a set of reports from the mock database
+func mockReports(db *mock.Database, count int) *[]repository.ReportFilter {
+	var filters []repository.ReportFilter
+	reports := make([]model.Report, count)
+	for i := range reports {
+		reports[i].ID = fmt.Sprintf("ffff021e-9669-4e53-9224-%012d", i)
+	}
+	db.MockReport.RetrieveByFilterCriteriaFn = func(filter repository.ReportFilter) ([]model.Report, error) {
+		filters = append(filters, filter)
+		if filter.Offset >= len(reports) {
+			return []model.Report{}, nil
+		}
+		end := filter.Offset + filter.Limit
+		if end > len(reports) {
+			end = len(reports)
+		}
+		return reports[filter.Offset:end], nil
+	}
+	db.MockReport.CountByFilterCriteriaFn = func(filter repository.ReportFilter) (int, error) {
+		return len(reports), nil
+	}
+	return &filters
+}
+
+func TestGetReportsPagination(t *testing.T) {
+	log.Trace("resource/reports_test:TestGetReportsPagination() Entering")
+	defer log.Trace("resource/reports_test:TestGetReportsPagination() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	filters := mockReports(db, 25)
+	r := setupMockServer(db)
+
+	var ids []string
+	query := "filter=false&limit=10&sort_order=DESC"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			assert.FailNow("pagination did not end")
+		}
+		recorder := getReports(r, query)
+		assert.Equal(http.StatusOK, recorder.Code)
+		assert.Equal("25", recorder.Header().Get("X-Total-Count"))
+		var reports []model.Report
+		assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &reports))
+		for _, report := range reports {
+			ids = append(ids, report.ID)
+		}
+		nextOffset := recorder.Header().Get("X-Next-Offset")
+		if nextOffset == "" {
+			break
+		}
+		query = "filter=false&limit=10&sort_order=desc&offset=" + nextOffset
+	}
+	assert.Len(ids, 25)
+	assert.Equal("ffff021e-9669-4e53-9224-000000000024", ids[24])
+	if assert.Len(*filters, 3) {
+		for i, filter := range *filters {
+			assert.Equal(10, filter.Limit)
+			assert.Equal(i*10, filter.Offset)
+			assert.Equal(repository.SortDescending, filter.SortOrder)
+		}
+	}
+}
+
+func TestGetReportsMaxPageSize(t *testing.T) {
+	log.Trace("resource/reports_test:TestGetReportsMaxPageSize() Entering")
+	defer log.Trace("resource/reports_test:TestGetReportsMaxPageSize() Leaving")
+	assert := assert.New(t)
+	previousMaxPageSize := config.Configuration.ReportsMaxPageSize
+	defer func() {
+		config.Configuration.ReportsMaxPageSize = previousMaxPageSize
+	}()
+	config.Configuration.ReportsMaxPageSize = 5
+	db := new(mock.Database)
+	filters := mockReports(db, 8)
+	r := setupMockServer(db)
+
+	// the page size defaults to and cannot exceed the maximum page size
+	for _, query := range []string{"filter=false", "filter=false&limit=100"} {
+		recorder := getReports(r, query)
+		assert.Equal(http.StatusOK, recorder.Code)
+		assert.Equal("8", recorder.Header().Get("X-Total-Count"))
+		assert.Equal("5", recorder.Header().Get("X-Next-Offset"))
+	}
+	recorder := getReports(r, "filter=false&limit=3&offset=5")
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Empty(recorder.Header().Get("X-Next-Offset"))
+
+	if assert.Len(*filters, 3) {
+		assert.Equal(5, (*filters)[0].Limit)
+		assert.Equal(5, (*filters)[1].Limit)
+		assert.Equal(3, (*filters)[2].Limit)
+		assert.Equal(5, (*filters)[2].Offset)
+	}
+}
+
+func TestGetReportsInvalidPagination(t *testing.T) {
+	log.Trace("resource/reports_test:TestGetReportsInvalidPagination() Entering")
+	defer log.Trace("resource/reports_test:TestGetReportsInvalidPagination() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	filters := mockReports(db, 1)
+	r := setupMockServer(db)
+
+	for _, query := range []string{"filter=false&limit=0", "filter=false&limit=ten", "filter=false&offset=-1", "filter=false&sort_order=newest"} {
+		recorder := getReports(r, query)
+		assert.Equal(http.StatusBadRequest, recorder.Code, query)
+		assert.Equal(errCodeInvalidRequest, decodeErrorResponse(t, recorder).Code, query)
+	}
+	assert.Empty(*filters)
+}
+
+func getReportSummary(r http.Handler, query string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/wls/v1/reports/summary?"+query, nil)
+	req.Header.Add("Authorization", "Bearer "+BearerToken)
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestGetReportSummary(t *testing.T) {
+	log.Trace("resource/reports_test:TestGetReportSummary() Entering")
+	defer log.Trace("resource/reports_test:TestGetReportSummary() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	var filters []repository.ReportFilter
+	var groupBys []string
+	db.MockReport.SummarizeFn = func(filter repository.ReportFilter, groupBy string) ([]model.ReportSummaryGroup, error) {
+		filters = append(filters, filter)
+		groupBys = append(groupBys, groupBy)
+		return []model.ReportSummaryGroup{{Key: "00964993-89c1-e711-906e-00163566263e", Trusted: 3, Untrusted: 1}}, nil
+	}
+	r := setupMockServer(db)
+
+	recorder := getReportSummary(r, "from_date=2021-03-01&to_date=2021-03-31")
+	assert.Equal(http.StatusOK, recorder.Code)
+	var summary model.ReportSummary
+	assert.NoError(json.Unmarshal(recorder.Body.Bytes(), &summary))
+	assert.Equal(model.ReportSummary{
+		GroupBy: repository.SummaryByHardwareUUID,
+		Groups:  []model.ReportSummaryGroup{{Key: "00964993-89c1-e711-906e-00163566263e", Trusted: 3, Untrusted: 1}},
+	}, summary)
+
+	recorder = getReportSummary(r, "group_by=image_id&num_of_days=7")
+	assert.Equal(http.StatusOK, recorder.Code)
+
+	assert.Equal([]string{repository.SummaryByHardwareUUID, repository.SummaryByImageID}, groupBys)
+	if assert.Len(filters, 2) {
+		assert.Equal("2021-03-01", filters[0].FromDate)
+		assert.Equal("2021-03-31", filters[0].ToDate)
+		assert.Equal(7, filters[1].NumOfDays)
+	}
+}
+
+func TestGetReportSummaryNoReports(t *testing.T) {
+	log.Trace("resource/reports_test:TestGetReportSummaryNoReports() Entering")
+	defer log.Trace("resource/reports_test:TestGetReportSummaryNoReports() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	db.MockReport.SummarizeFn = func(filter repository.ReportFilter, groupBy string) ([]model.ReportSummaryGroup, error) {
+		return nil, nil
+	}
+	r := setupMockServer(db)
+
+	recorder := getReportSummary(r, "group_by=policy_name")
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.JSONEq(`{"group_by":"policy_name","groups":[]}`, recorder.Body.String())
+}
+
+func TestGetReportSummaryInvalidQuery(t *testing.T) {
+	log.Trace("resource/reports_test:TestGetReportSummaryInvalidQuery() Entering")
+	defer log.Trace("resource/reports_test:TestGetReportSummaryInvalidQuery() Leaving")
+	assert := assert.New(t)
+	db := new(mock.Database)
+	summarized := false
+	db.MockReport.SummarizeFn = func(filter repository.ReportFilter, groupBy string) ([]model.ReportSummaryGroup, error) {
+		summarized = true
+		return nil, nil
+	}
+	r := setupMockServer(db)
+
+	for _, query := range []string{"group_by=instance_id", "num_of_days=seven"} {
+		recorder := getReportSummary(r, query)
+		assert.Equal(http.StatusBadRequest, recorder.Code, query)
+		assert.Equal(errCodeInvalidRequest, decodeErrorResponse(t, recorder).Code, query)
+	}
+	assert.False(summarized)
+}
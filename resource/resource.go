@@ -5,6 +5,7 @@
 package resource
 
 import (
+	"encoding/json"
 	"fmt"
 	"intel/isecl/lib/common/v4/auth"
 	"intel/isecl/lib/common/v4/context"
@@ -12,9 +13,14 @@ import (
 	"intel/isecl/lib/common/v4/log/message"
 	ct "intel/isecl/lib/common/v4/types/aas"
 	consts "intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/model"
 	"intel/isecl/workload-service/v4/repository"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
 
-	"github.com/pkg/errors"
+	"github.com/google/uuid"
 
 	"net/http"
 
@@ -28,14 +34,46 @@ var seclog = commLog.GetSecurityLogger()
 
 const uuidv4 = "(?i:[0-9A-F]{8}-[0-9A-F]{4}-4[0-9A-F]{3}-[89AB][0-9A-F]{3}-[0-9A-F]{12})"
 
+// Error codes returned in the code field of error responses. Clients match on these, so they must not be changed.
+const (
+	errCodeInvalidRequest         = "invalid_request"
+	errCodeUnauthorized           = "unauthorized"
+	errCodeNotFound               = "not_found"
+	errCodeConflict               = "conflict"
+	errCodeInternal               = "internal_error"
+	errCodeFlavorSignatureInvalid = "flavor_signature_invalid"
+	errCodeReportSignatureInvalid = "report_signature_invalid"
+	errCodeHostUntrusted          = "host_untrusted"
+	errCodeHvsReportFailed        = "hvs_report_failed"
+	errCodeHvsUnavailable         = "hvs_unavailable"
+	errCodeSamlInvalid            = "saml_invalid"
+	errCodeSamlVerificationFailed = "saml_verification_failed"
+	errCodeKbsTransferFailed      = "kbs_transfer_failed"
+	errCodeKbsUnavailable         = "kbs_unavailable"
+	errCodeHostIdentityMismatch   = "host_identity_mismatch"
+	errCodeKeyReleaseDenied       = "key_release_policy_denied"
+	errCodeNoFlavorSignCerts      = "flavor_signing_not_configured"
+)
+
+// requestIDHeader carries the ID of a request, which is echoed back in the response and in error bodies
+const requestIDHeader = "X-Request-Id"
+
+// a request ID supplied by the client is only reused if it is reasonably short and printable
+var requestIDRegex = regexp.MustCompile("^[A-Za-z0-9._:-]{1,128}$")
+
 // endpointSetter is a function that takes a Gorilla Mux Subrouter, and an instance of a WlsDatabase connection,
 // and allows the end user to set and handle any API endpoints on that upaht
 type endpointSetter func(r *mux.Router, db repository.WlsDatabase)
 
-// endpointError is a custom error type that lets the thrower specify an http status code
+// endpointError is a custom error type that lets the thrower specify an http status code, along with an optional
+// error code and details. When Code is empty, the code is derived from the status code. RetryAfter is the number
+// of seconds after which the client may retry the request, if set.
 type endpointError struct {
 	Message    string
 	StatusCode int
+	Code       string
+	Details    interface{}
+	RetryAfter int
 }
 
 type privilegeError struct {
@@ -62,27 +100,20 @@ func requiresPermission(eh endpointHandler, permissionNames []string) endpointHa
 	return func(w http.ResponseWriter, r *http.Request) error {
 		privileges, err := context.GetUserPermissions(r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
-			_, err := w.Write([]byte("Could not get user roles from http context"))
-			if err != nil {
-				log.Errorf("resource/resource:requiresPermission(): Failed to write response")
-			}
-			seclog.Errorf("resource/resource:requiresPermission() %s Roles: %v | Context: %v", message.AuthenticationFailed, permissionNames, r.Context())
-			return errors.Wrap(err, "resource/resource:requiresPermission() Could not get user roles from http context")
+			seclog.WithError(err).Errorf("resource/resource:requiresPermission() %s Roles: %v | Context: %v", message.AuthenticationFailed, permissionNames, r.Context())
+			return &endpointError{Message: "Could not get user roles from http context", StatusCode: http.StatusInternalServerError}
 		}
 		reqPermissions := ct.PermissionInfo{Service: consts.ServiceName, Rules: permissionNames}
 
 		_, foundMatchingPermission := auth.ValidatePermissionAndGetPermissionsContext(privileges, reqPermissions,
 			true)
 		if !foundMatchingPermission {
-			w.WriteHeader(http.StatusUnauthorized)
 			seclog.Error(message.UnauthorizedAccess)
 			seclog.Errorf("resource/resource:requiresPermission() %s Insufficient privileges to access %s", message.UnauthorizedAccess, r.RequestURI)
-			return &privilegeError{Message: "Insufficient privileges to access " + r.RequestURI, StatusCode: http.StatusUnauthorized}
+			return privilegeError{Message: "Insufficient privileges to access " + r.RequestURI, StatusCode: http.StatusUnauthorized}
 		}
 		seclog.Infof("resource/resource:requiresPermission() %s - %s", message.AuthorizedAccess, r.RequestURI)
-		return eh(w, r)
+		return eh(w, withHostIdentities(r))
 	}
 }
 
@@ -94,19 +125,118 @@ func errorHandler(eh endpointHandler) http.HandlerFunc {
 	log.Trace("resource/resource:errorHandler() Entering")
 	defer log.Trace("resource/resource:errorHandler() Leaving")
 	return func(w http.ResponseWriter, r *http.Request) {
+		setRequestID(w, r)
 		if err := eh(w, r); err != nil {
 			if gorm.IsRecordNotFoundError(err) {
-				http.Error(w, err.Error(), http.StatusNotFound)
+				writeError(w, r, &endpointError{Message: err.Error(), StatusCode: http.StatusNotFound})
 				return
 			}
 			switch t := err.(type) {
 			case *endpointError:
-				http.Error(w, t.Message, t.StatusCode)
+				writeError(w, r, t)
 			case privilegeError:
-				http.Error(w, t.Message, t.StatusCode)
+				writeError(w, r, &endpointError{Message: t.Message, StatusCode: t.StatusCode})
 			default:
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, r, &endpointError{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+			}
+		}
+	}
+}
+
+// setRequestID sets the request ID header of the response, if not already set. The ID supplied by the client
+// is reused when valid, otherwise a new one is generated.
+func setRequestID(w http.ResponseWriter, r *http.Request) string {
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		return id
+	}
+	id := r.Header.Get(requestIDHeader)
+	if !requestIDRegex.MatchString(id) {
+		id = uuid.New().String()
+	}
+	w.Header().Set(requestIDHeader, id)
+	return id
+}
+
+// errorCode returns the code of an endpoint error, or the default code for its status code
+func errorCode(e *endpointError) string {
+	if e.Code != "" {
+		return e.Code
+	}
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return errCodeUnauthorized
+	case e.StatusCode == http.StatusNotFound:
+		return errCodeNotFound
+	case e.StatusCode == http.StatusConflict:
+		return errCodeConflict
+	case e.StatusCode >= 400 && e.StatusCode < 500:
+		return errCodeInvalidRequest
+	default:
+		return errCodeInternal
+	}
+}
+
+// writeError writes an endpoint error to the response. The body is a JSON error envelope, unless the client
+// prefers text/plain over application/json in its Accept header.
+func writeError(w http.ResponseWriter, r *http.Request, e *endpointError) {
+	log.Trace("resource/resource:writeError() Entering")
+	defer log.Trace("resource/resource:writeError() Leaving")
+	requestID := setRequestID(w, r)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(e.RetryAfter))
+	}
+	if prefersPlainText(r.Header.Get("Accept")) {
+		http.Error(w, e.Message, e.StatusCode)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.StatusCode)
+	err := json.NewEncoder(w).Encode(model.ErrorResponse{
+		Code:      errorCode(e),
+		Message:   e.Message,
+		RequestID: requestID,
+		Details:   e.Details,
+	})
+	if err != nil {
+		log.WithError(err).Errorf("resource/resource:writeError() %s : Failed to write error response", message.AppRuntimeErr)
+	}
+}
+
+// prefersPlainText checks whether an Accept header gives text/plain a higher quality than application/json.
+// Wildcard media ranges only apply to application/json when it is not listed.
+func prefersPlainText(accept string) bool {
+	return prefersOverJSON(accept, "text/plain")
+}
+
+// prefersOverJSON checks whether an Accept header gives a media type a higher quality than application/json.
+// Wildcard media ranges only apply to application/json when it is not listed.
+func prefersOverJSON(accept string, preferred string) bool {
+	preferredQuality, jsonQuality, wildcardQuality := 0.0, -1.0, 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
 			}
 		}
+		switch mediaType {
+		case preferred:
+			preferredQuality = quality
+		case "application/json":
+			jsonQuality = quality
+		case "application/*", "*/*":
+			if quality > wildcardQuality {
+				wildcardQuality = quality
+			}
+		}
+	}
+	if jsonQuality < 0 {
+		jsonQuality = wildcardQuality
 	}
+	return preferredQuality > jsonQuality
 }
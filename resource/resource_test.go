@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"encoding/json"
+	"errors"
+	"intel/isecl/workload-service/v4/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeErrorResponse decodes the JSON error body of a response and checks that it carries the request ID
+func decodeErrorResponse(t *testing.T, recorder *httptest.ResponseRecorder) model.ErrorResponse {
+	var errResponse model.ErrorResponse
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	if err := json.Unmarshal(recorder.Body.Bytes(), &errResponse); err != nil {
+		t.Fatalf("error response is not a JSON error envelope: %s", recorder.Body.String())
+	}
+	assert.NotEmpty(t, errResponse.RequestID)
+	assert.Equal(t, recorder.Header().Get(requestIDHeader), errResponse.RequestID)
+	return errResponse
+}
+
+func serveError(err error, header http.Header) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/wls/v1/flavors", nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	errorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return err
+	})(recorder, req)
+	return recorder
+}
+
+func TestErrorResponseCodes(t *testing.T) {
+	log.Trace("resource/resource_test:TestErrorResponseCodes() Entering")
+	defer log.Trace("resource/resource_test:TestErrorResponseCodes() Leaving")
+	assert := assert.New(t)
+
+	tests := []struct {
+		err        error
+		statusCode int
+		code       string
+		message    string
+	}{
+		{&endpointError{Message: "Invalid UUID", StatusCode: http.StatusBadRequest}, http.StatusBadRequest, errCodeInvalidRequest, "Invalid UUID"},
+		{&endpointError{Message: "Record not found", StatusCode: http.StatusNotFound}, http.StatusNotFound, errCodeNotFound, "Record not found"},
+		{&endpointError{Message: "already exists", StatusCode: http.StatusConflict}, http.StatusConflict, errCodeConflict, "already exists"},
+		{&endpointError{Message: "backend error", StatusCode: http.StatusInternalServerError}, http.StatusInternalServerError, errCodeInternal, "backend error"},
+		{&endpointError{Message: "Host is untrusted", StatusCode: http.StatusInternalServerError, Code: errCodeHostUntrusted}, http.StatusInternalServerError, errCodeHostUntrusted, "Host is untrusted"},
+		{privilegeError{Message: "Insufficient privileges", StatusCode: http.StatusUnauthorized}, http.StatusUnauthorized, errCodeUnauthorized, "Insufficient privileges"},
+		{gorm.ErrRecordNotFound, http.StatusNotFound, errCodeNotFound, gorm.ErrRecordNotFound.Error()},
+		{errors.New("unexpected"), http.StatusInternalServerError, errCodeInternal, "unexpected"},
+	}
+	for _, test := range tests {
+		recorder := serveError(test.err, nil)
+		assert.Equal(test.statusCode, recorder.Code)
+		errResponse := decodeErrorResponse(t, recorder)
+		assert.Equal(test.code, errResponse.Code)
+		assert.Equal(test.message, errResponse.Message)
+		assert.Nil(errResponse.Details)
+	}
+}
+
+func TestErrorResponseDetails(t *testing.T) {
+	log.Trace("resource/resource_test:TestErrorResponseDetails() Entering")
+	defer log.Trace("resource/resource_test:TestErrorResponseDetails() Leaving")
+	assert := assert.New(t)
+
+	recorder := serveError(&endpointError{
+		Message:    "Missing query parameters: [hardware_uuid]",
+		StatusCode: http.StatusBadRequest,
+		Details:    map[string][]string{"missing_parameters": {"hardware_uuid"}},
+	}, nil)
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	assert.Contains(recorder.Body.String(), `"details":{"missing_parameters":["hardware_uuid"]}`)
+
+	// details are left out of the body when there are none
+	recorder = serveError(&endpointError{Message: "Invalid UUID", StatusCode: http.StatusBadRequest}, nil)
+	assert.NotContains(recorder.Body.String(), "details")
+}
+
+func TestErrorResponseRequestID(t *testing.T) {
+	log.Trace("resource/resource_test:TestErrorResponseRequestID() Entering")
+	defer log.Trace("resource/resource_test:TestErrorResponseRequestID() Leaving")
+	assert := assert.New(t)
+	err := &endpointError{Message: "Invalid UUID", StatusCode: http.StatusBadRequest}
+
+	// the request ID supplied by the client is echoed back
+	recorder := serveError(err, http.Header{requestIDHeader: {"3f6e2c1a-request.42"}})
+	assert.Equal("3f6e2c1a-request.42", decodeErrorResponse(t, recorder).RequestID)
+
+	// a new ID is generated when the client does not supply a usable one
+	for _, id := range []string{"", "not a valid id", strings.Repeat("a", 129)} {
+		recorder = serveError(err, http.Header{requestIDHeader: {id}})
+		generated := decodeErrorResponse(t, recorder).RequestID
+		assert.NotEqual(id, generated)
+		assert.Regexp("^"+uuidv4+"$", generated)
+	}
+
+	// successful responses carry the request ID as well
+	recorder = serveError(nil, http.Header{requestIDHeader: {"3f6e2c1a-request.43"}})
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal("3f6e2c1a-request.43", recorder.Header().Get(requestIDHeader))
+}
+
+func TestErrorResponsePlainText(t *testing.T) {
+	log.Trace("resource/resource_test:TestErrorResponsePlainText() Entering")
+	defer log.Trace("resource/resource_test:TestErrorResponsePlainText() Leaving")
+	assert := assert.New(t)
+	err := &endpointError{Message: "Invalid UUID", StatusCode: http.StatusBadRequest, Code: errCodeInvalidRequest}
+
+	for _, accept := range []string{"text/plain", "text/plain, */*;q=0.5", "application/json;q=0.2, text/plain;q=0.9"} {
+		recorder := serveError(err, http.Header{"Accept": {accept}})
+		assert.Equal(http.StatusBadRequest, recorder.Code, accept)
+		assert.Equal("Invalid UUID\n", recorder.Body.String(), accept)
+		assert.True(strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"), accept)
+		assert.NotEmpty(recorder.Header().Get(requestIDHeader), accept)
+	}
+
+	for _, accept := range []string{"", "*/*", "application/json", "application/json, text/plain", "text/plain;q=0.5, application/*", "text/html"} {
+		recorder := serveError(err, http.Header{"Accept": {accept}})
+		assert.Equal(http.StatusBadRequest, recorder.Code, accept)
+		assert.Equal(errCodeInvalidRequest, decodeErrorResponse(t, recorder).Code, accept)
+	}
+}
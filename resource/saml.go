@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package resource
+
+import (
+	"fmt"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/constants"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// samlHardwareUUIDAttribute is the SAML attribute holding the hardware UUID of the attested host
+const samlHardwareUUIDAttribute = "hardwareUuid"
+
+// samlAttribute returns the value of an attribute of a SAML report
+func samlAttribute(samlStruct *Saml, name string) (string, bool) {
+	for _, attribute := range samlStruct.Attribute {
+		if attribute.Name == name {
+			return attribute.AttributeValue, true
+		}
+	}
+	return "", false
+}
+
+// validateSamlAssertion checks that a SAML report was issued for the host with hardware UUID hwid, by the configured
+// issuer, to the configured audience and that it is valid at the given time, allowing for the configured clock skew.
+// The signature of the report is verified separately.
+func validateSamlAssertion(samlStruct *Saml, hwid string, now time.Time) error {
+	log.Trace("resource/saml:validateSamlAssertion() Entering")
+	defer log.Trace("resource/saml:validateSamlAssertion() Leaving")
+
+	value, ok := samlAttribute(samlStruct, samlHardwareUUIDAttribute)
+	if !ok {
+		return errors.New("SAML report has no hardware UUID")
+	}
+	subject, err := uuid.Parse(value)
+	if err != nil {
+		return errors.Wrapf(err, "SAML report has an invalid hardware UUID %s", value)
+	}
+	host, err := uuid.Parse(hwid)
+	if err != nil {
+		return errors.Wrapf(err, "invalid host hardware UUID %s", hwid)
+	}
+	if subject != host {
+		return fmt.Errorf("SAML report is for host %s, not host %s", subject, host)
+	}
+
+	if samlStruct.Issuer == "" {
+		return errors.New("SAML report has no issuer")
+	}
+	if issuer := config.Configuration.SamlIssuer; issuer != "" && samlStruct.Issuer != issuer {
+		return fmt.Errorf("SAML report is issued by %s, not %s", samlStruct.Issuer, issuer)
+	}
+
+	skewSeconds := config.Configuration.SamlClockSkewSeconds
+	if skewSeconds <= 0 {
+		skewSeconds = constants.DefaultSamlClockSkewSecs
+	}
+	skew := time.Second * time.Duration(skewSeconds)
+	if samlStruct.Subject.NotOnOrAfter.IsZero() {
+		return errors.New("SAML report has no validity period")
+	}
+	if err := checkSamlValidity(samlStruct.Subject.NotBefore, samlStruct.Subject.NotOnOrAfter, now, skew); err != nil {
+		return errors.Wrap(err, "SAML subject confirmation")
+	}
+	if err := checkSamlValidity(samlStruct.Conditions.NotBefore, samlStruct.Conditions.NotOnOrAfter, now, skew); err != nil {
+		return errors.Wrap(err, "SAML conditions")
+	}
+
+	if audience := config.Configuration.SamlAudience; audience != "" {
+		for _, a := range samlStruct.Conditions.Audience {
+			if a == audience {
+				return nil
+			}
+		}
+		return fmt.Errorf("SAML report is not restricted to audience %s", audience)
+	}
+	return nil
+}
+
+// checkSamlValidity checks that now is within a SAML validity period, a zero time leaving the period open
+func checkSamlValidity(notBefore, notOnOrAfter, now time.Time, skew time.Duration) error {
+	if !notBefore.IsZero() && now.Add(skew).Before(notBefore) {
+		return fmt.Errorf("not valid before %s", notBefore)
+	}
+	if !notOnOrAfter.IsZero() && !now.Add(-skew).Before(notOnOrAfter) {
+		return fmt.Errorf("expired on %s", notOnOrAfter)
+	}
+	return nil
+}
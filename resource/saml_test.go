@@ -0,0 +1,264 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"encoding/xml"
+	"fmt"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/repository/mock"
+	"intel/isecl/workload-service/v4/samlcache"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const samlTestIssuer = "AttestationService-0.5"
+
+// samlFixture crafts SAML reports as issued by HVS, a zero time or an empty value leaves its element out
+type samlFixture struct {
+	hardwareUUID           string
+	issuer                 string
+	trustOverall           string
+	notBefore              time.Time
+	notOnOrAfter           time.Time
+	conditionsNotBefore    time.Time
+	conditionsNotOnOrAfter time.Time
+	audiences              []string
+	attributes             []Attribute
+}
+
+// newSamlFixture returns a SAML report of the host valid from a minute ago for the given duration
+func newSamlFixture(hwid string, trusted bool, validity time.Duration) samlFixture {
+	now := time.Now().UTC()
+	return samlFixture{
+		hardwareUUID:           hwid,
+		issuer:                 samlTestIssuer,
+		trustOverall:           strconv.FormatBool(trusted),
+		notBefore:              now.Add(-time.Minute),
+		notOnOrAfter:           now.Add(validity),
+		conditionsNotBefore:    now.Add(-time.Minute),
+		conditionsNotOnOrAfter: now.Add(validity),
+	}
+}
+
+func samlTimeAttr(name string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(` %s="%s"`, name, t.Format(time.RFC3339))
+}
+
+func (f samlFixture) bytes() []byte {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<saml2:Assertion ID="HostTrustAssertion" Version="2.0" xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion">
+`)
+	if f.issuer != "" {
+		fmt.Fprintf(&b, "    <saml2:Issuer>%s</saml2:Issuer>\n", f.issuer)
+	}
+	fmt.Fprintf(&b, `    <saml2:Subject>
+        <saml2:NameID>host-%s</saml2:NameID>
+        <saml2:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:sender-vouches">
+            <saml2:SubjectConfirmationData%s%s/>
+        </saml2:SubjectConfirmation>
+    </saml2:Subject>
+`, f.hardwareUUID, samlTimeAttr("NotBefore", f.notBefore), samlTimeAttr("NotOnOrAfter", f.notOnOrAfter))
+	fmt.Fprintf(&b, "    <saml2:Conditions%s%s>\n", samlTimeAttr("NotBefore", f.conditionsNotBefore), samlTimeAttr("NotOnOrAfter", f.conditionsNotOnOrAfter))
+	if len(f.audiences) > 0 {
+		b.WriteString("        <saml2:AudienceRestriction>\n")
+		for _, audience := range f.audiences {
+			fmt.Fprintf(&b, "            <saml2:Audience>%s</saml2:Audience>\n", audience)
+		}
+		b.WriteString("        </saml2:AudienceRestriction>\n")
+	}
+	b.WriteString("    </saml2:Conditions>\n    <saml2:AttributeStatement>\n")
+	if f.trustOverall != "" {
+		fmt.Fprintf(&b, `        <saml2:Attribute Name="TRUST_OVERALL">
+            <saml2:AttributeValue>%s</saml2:AttributeValue>
+        </saml2:Attribute>
+`, f.trustOverall)
+	}
+	if f.hardwareUUID != "" {
+		fmt.Fprintf(&b, `        <saml2:Attribute Name="hardwareUuid">
+            <saml2:AttributeValue>%s</saml2:AttributeValue>
+        </saml2:Attribute>
+`, f.hardwareUUID)
+	}
+	for _, attribute := range f.attributes {
+		fmt.Fprintf(&b, `        <saml2:Attribute Name="%s">
+            <saml2:AttributeValue>%s</saml2:AttributeValue>
+        </saml2:Attribute>
+`, attribute.Name, attribute.AttributeValue)
+	}
+	b.WriteString("    </saml2:AttributeStatement>\n</saml2:Assertion>")
+	return []byte(b.String())
+}
+
+func TestValidateSamlAssertion(t *testing.T) {
+	log.Trace("resource/saml_test:TestValidateSamlAssertion() Entering")
+	defer log.Trace("resource/saml_test:TestValidateSamlAssertion() Leaving")
+	hwid := "8032632b-8fa4-e811-906e-00163566263e"
+	now := time.Now().UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name     string
+		fixture  func(f *samlFixture)
+		issuer   string
+		audience string
+		skew     int
+		err      string
+	}{
+		{name: "valid", fixture: func(f *samlFixture) {}},
+		{name: "upper case hardware UUID", fixture: func(f *samlFixture) { f.hardwareUUID = strings.ToUpper(hwid) }},
+		{
+			name:    "other host",
+			fixture: func(f *samlFixture) { f.hardwareUUID = "00ecd3ab-9af4-e711-906e-001560a04062" },
+			err:     "not host " + hwid,
+		},
+		{name: "no hardware UUID", fixture: func(f *samlFixture) { f.hardwareUUID = "" }, err: "no hardware UUID"},
+		{name: "invalid hardware UUID", fixture: func(f *samlFixture) { f.hardwareUUID = "host-1" }, err: "invalid hardware UUID"},
+		{name: "no issuer", fixture: func(f *samlFixture) { f.issuer = "" }, err: "no issuer"},
+		{name: "configured issuer", fixture: func(f *samlFixture) {}, issuer: samlTestIssuer},
+		{
+			name:    "other issuer",
+			fixture: func(f *samlFixture) { f.issuer = "rogue-issuer" },
+			issuer:  samlTestIssuer,
+			err:     "issued by rogue-issuer",
+		},
+		{
+			name:    "not yet valid",
+			fixture: func(f *samlFixture) { f.notBefore = now.Add(2 * time.Minute) },
+			err:     "subject confirmation: not valid before",
+		},
+		{name: "not yet valid within skew", fixture: func(f *samlFixture) { f.notBefore = now.Add(30 * time.Second) }},
+		{
+			name:    "not yet valid beyond configured skew",
+			fixture: func(f *samlFixture) { f.notBefore = now.Add(30 * time.Second) },
+			skew:    10,
+			err:     "not valid before",
+		},
+		{
+			name:    "expired",
+			fixture: func(f *samlFixture) { f.notOnOrAfter = now.Add(-2 * time.Minute) },
+			err:     "subject confirmation: expired",
+		},
+		{name: "expired within skew", fixture: func(f *samlFixture) { f.notOnOrAfter = now.Add(-30 * time.Second) }},
+		{name: "no validity period", fixture: func(f *samlFixture) { f.notOnOrAfter = time.Time{} }, err: "no validity period"},
+		{
+			name:    "conditions expired",
+			fixture: func(f *samlFixture) { f.conditionsNotOnOrAfter = now.Add(-2 * time.Minute) },
+			err:     "conditions: expired",
+		},
+		{
+			name:    "conditions not yet valid",
+			fixture: func(f *samlFixture) { f.conditionsNotBefore = now.Add(2 * time.Minute) },
+			err:     "conditions: not valid before",
+		},
+		{
+			name: "no conditions validity period",
+			fixture: func(f *samlFixture) {
+				f.conditionsNotBefore = time.Time{}
+				f.conditionsNotOnOrAfter = time.Time{}
+			},
+		},
+		{
+			name:     "configured audience",
+			fixture:  func(f *samlFixture) { f.audiences = []string{"https://kbs.example.com", "https://wls.example.com"} },
+			audience: "https://wls.example.com",
+		},
+		{
+			name:     "other audience",
+			fixture:  func(f *samlFixture) { f.audiences = []string{"https://kbs.example.com"} },
+			audience: "https://wls.example.com",
+			err:      "not restricted to audience",
+		},
+		{name: "no audience", fixture: func(f *samlFixture) {}, audience: "https://wls.example.com", err: "not restricted to audience"},
+	}
+
+	previousIssuer := config.Configuration.SamlIssuer
+	previousAudience := config.Configuration.SamlAudience
+	previousSkew := config.Configuration.SamlClockSkewSeconds
+	defer func() {
+		config.Configuration.SamlIssuer = previousIssuer
+		config.Configuration.SamlAudience = previousAudience
+		config.Configuration.SamlClockSkewSeconds = previousSkew
+	}()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			config.Configuration.SamlIssuer = test.issuer
+			config.Configuration.SamlAudience = test.audience
+			config.Configuration.SamlClockSkewSeconds = test.skew
+
+			fixture := samlFixture{
+				hardwareUUID:           hwid,
+				issuer:                 samlTestIssuer,
+				trustOverall:           "true",
+				notBefore:              now.Add(-time.Minute),
+				notOnOrAfter:           now.Add(time.Hour),
+				conditionsNotBefore:    now.Add(-time.Minute),
+				conditionsNotOnOrAfter: now.Add(time.Hour),
+			}
+			test.fixture(&fixture)
+			var samlStruct Saml
+			if !assert.NoError(xml.Unmarshal(fixture.bytes(), &samlStruct)) {
+				return
+			}
+
+			err := validateSamlAssertion(&samlStruct, hwid, now)
+			if test.err == "" {
+				assert.NoError(err)
+			} else if assert.Error(err) {
+				assert.Contains(err.Error(), test.err)
+			}
+		})
+	}
+}
+
+func TestSamlReportOtherHost(t *testing.T) {
+	log.Trace("resource/saml_test:TestSamlReportOtherHost() Entering")
+	defer log.Trace("resource/saml_test:TestSamlReportOtherHost() Leaving")
+	assert := assert.New(t)
+	hwid := "0d3c5ac5-a4a8-4b1b-9f05-0e1b4f2a6a11"
+	// a trusted report with a valid signature, but about another host
+	hvs := &fakeHVS{trusted: true, validity: time.Hour, subject: "0d3c5ac5-a4a8-4b1b-9f05-0e1b4f2a6a12"}
+	defer setupFakeHVS(hwid, hvs, true)()
+
+	key, err := transfer_key(new(mock.Database), "", false, hwid, samlTestKeyURL, "")
+	assert.Nil(key)
+	if e, ok := err.(*endpointError); assert.True(ok) {
+		assert.Equal(errCodeSamlVerificationFailed, e.Code)
+		assert.Contains(e.Message, "not bound to the host")
+	}
+	_, exists := samlcache.Get(hwid)
+	assert.False(exists)
+}
+
+func TestSamlReportCachedForOtherHostNotReused(t *testing.T) {
+	log.Trace("resource/saml_test:TestSamlReportCachedForOtherHostNotReused() Entering")
+	defer log.Trace("resource/saml_test:TestSamlReportCachedForOtherHostNotReused() Leaving")
+	assert := assert.New(t)
+	hwid := "0d3c5ac5-a4a8-4b1b-9f05-0e1b4f2a6a13"
+	hvs := &fakeHVS{trusted: true, validity: time.Hour}
+	defer setupFakeHVS(hwid, hvs, true)()
+
+	other := newSamlFixture("0d3c5ac5-a4a8-4b1b-9f05-0e1b4f2a6a14", true, time.Hour)
+	samlcache.Store(hwid, samlcache.Report{Saml: other.bytes(), Expiry: time.Now().Add(time.Hour)})
+
+	// the cached report is dropped and the key is released on a report of the host itself
+	_, err := transfer_key(new(mock.Database), "", false, hwid, samlTestKeyURL, "")
+	assert.NoError(err)
+	assert.Equal(1, hvs.requests)
+	if cached, exists := samlcache.Get(hwid); assert.True(exists) {
+		var samlStruct Saml
+		assert.NoError(xml.Unmarshal(cached.Saml, &samlStruct))
+		assert.NoError(validateSamlAssertion(&samlStruct, hwid, time.Now()))
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package resource
+
+import (
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"intel/isecl/lib/common/v4/crypt"
+	cos "intel/isecl/lib/common/v4/os"
+	flvr "intel/isecl/lib/flavor/v4"
+	"intel/isecl/workload-service/v4/constants"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// flavorSigningCertsDir is the directory holding the certificates trusted to sign image flavors
+var flavorSigningCertsDir = constants.FlavorSigningCertsDir
+
+// trustedCaCertsDir is the directory holding the CA certificates that report signing certificates must chain to
+var trustedCaCertsDir = constants.TrustedCaCertsDir
+
+// errNoFlavorSigningCerts is returned when no flavor signing certificate is imported, so no flavor can be verified
+var errNoFlavorSigningCerts = errors.New("no flavor signing certificates are imported, run the import_flavor_signing_cert setup task with " + constants.FlavorSigningCertPathEnv)
+
+// reportHashAlgorithms are the hash algorithms accepted for report signatures
+var reportHashAlgorithms = []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+// signatureError is returned when a signature does not verify, as opposed to errors in the verification setup
+type signatureError struct {
+	reason string
+}
+
+func (e signatureError) Error() string {
+	return e.reason
+}
+
+// loadCertificatesFromDir parses every PEM encoded certificate found in the *.pem files of dir.
+// PEM blocks that are not certificates are skipped.
+func loadCertificatesFromDir(dir string) ([]*x509.Certificate, error) {
+	log.Trace("resource/signature:loadCertificatesFromDir() Entering")
+	defer log.Trace("resource/signature:loadCertificatesFromDir() Leaving")
+
+	pemFiles, err := cos.GetDirFileContents(dir, "*.pem")
+	if err != nil {
+		return nil, errors.Wrapf(err, "resource/signature:loadCertificatesFromDir() Unable to read certificates from %s", dir)
+	}
+
+	var certs []*x509.Certificate
+	for _, pemFile := range pemFiles {
+		for block, rest := pem.Decode(pemFile); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "resource/signature:loadCertificatesFromDir() Unable to parse certificate in %s", dir)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	return certs, nil
+}
+
+// verifyFlavorSignature checks that the signature of the flavor was produced over the ImageFlavor JSON
+// by the private key of one of the certificates in the trusted flavor signing certificate directory
+func verifyFlavorSignature(f *flvr.SignedImageFlavor) error {
+	log.Trace("resource/signature:verifyFlavorSignature() Entering")
+	defer log.Trace("resource/signature:verifyFlavorSignature() Leaving")
+
+	signature, err := base64.StdEncoding.DecodeString(f.Signature)
+	if err != nil {
+		return signatureError{reason: "flavor signature is not base64 encoded"}
+	}
+
+	flavorJSON, err := json.Marshal(f.ImageFlavor)
+	if err != nil {
+		return errors.Wrap(err, "resource/signature:verifyFlavorSignature() Unable to marshal flavor")
+	}
+	digest := sha512.Sum384(flavorJSON)
+
+	if pemFiles, _ := filepath.Glob(flavorSigningCertsDir + "*.pem"); len(pemFiles) == 0 {
+		return errNoFlavorSigningCerts
+	}
+	certs, err := loadCertificatesFromDir(flavorSigningCertsDir)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return errNoFlavorSigningCerts
+	}
+
+	for _, cert := range certs {
+		publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA384, digest[:], signature) == nil {
+			log.Debugf("resource/signature:verifyFlavorSignature() Flavor signature verified with certificate %s", cert.Subject.String())
+			return nil
+		}
+	}
+	return signatureError{reason: "flavor signature does not match any trusted flavor signing certificate"}
+}
+
+// parseCertificateChain parses the certificate of a signed report. The certificate can be provided as one or more
+// PEM blocks, in which case the first block is the signing certificate and the remaining ones are intermediates,
+// or as a single DER certificate, optionally base64 encoded.
+func parseCertificateChain(certificate string) (*x509.Certificate, []*x509.Certificate, error) {
+	log.Trace("resource/signature:parseCertificateChain() Entering")
+	defer log.Trace("resource/signature:parseCertificateChain() Leaving")
+
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode([]byte(certificate)); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, signatureError{reason: "report signing certificate could not be parsed"}
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) > 0 {
+		return chain[0], chain[1:], nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(certificate)
+	if err != nil {
+		der = []byte(certificate)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, signatureError{reason: "report signing certificate could not be parsed"}
+	}
+	return cert, nil, nil
+}
+
+// verifyReportSignature checks the signature of a report over its data with the certificate embedded in the report,
+// and that this certificate chains to one of the trusted CA certificates. The verified signing certificate is returned.
+func verifyReportSignature(sd *crypt.SignedData, caCerts []*x509.Certificate) (*x509.Certificate, error) {
+	log.Trace("resource/signature:verifyReportSignature() Entering")
+	defer log.Trace("resource/signature:verifyReportSignature() Leaving")
+
+	if len(sd.Data) == 0 || len(sd.Signature) == 0 || sd.Cert == "" {
+		return nil, signatureError{reason: "report is not signed"}
+	}
+
+	var hash crypto.Hash
+	for _, h := range reportHashAlgorithms {
+		if sd.Alg == crypt.GetHashingAlgorithmName(h) {
+			hash = h
+			break
+		}
+	}
+	if hash == 0 {
+		return nil, signatureError{reason: "unsupported report signature hash algorithm " + sd.Alg}
+	}
+
+	cert, intermediates, err := parseCertificateChain(sd.Cert)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, signatureError{reason: "report signing certificate does not contain an RSA public key"}
+	}
+
+	h := hash.New()
+	h.Write(sd.Data)
+	if err := rsa.VerifyPKCS1v15(publicKey, hash, h.Sum(nil), sd.Signature); err != nil {
+		return nil, signatureError{reason: "report signature does not match report data"}
+	}
+
+	verifyOpts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, caCert := range caCerts {
+		verifyOpts.Roots.AddCert(caCert)
+	}
+	for _, intermediate := range intermediates {
+		verifyOpts.Intermediates.AddCert(intermediate)
+	}
+	if _, err := cert.Verify(verifyOpts); err != nil {
+		log.WithError(err).Debug("resource/signature:verifyReportSignature() Report signing certificate chain verification failed")
+		return nil, signatureError{reason: "report signing certificate is not issued by a trusted CA"}
+	}
+	return cert, nil
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package retention
+
+import (
+	commLog "intel/isecl/lib/common/v4/log"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/repository"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var log = commLog.GetDefaultLogger()
+
+// Policy defines how long reports are kept, and how many reports are kept for each instance. A zero MaxAge or
+// MaxReportsPerInstance means no limit.
+type Policy struct {
+	MaxAge                time.Duration
+	MaxReportsPerInstance int
+	PurgeInterval         time.Duration
+	PurgeBatchSize        int
+}
+
+// ConfiguredPolicy returns the retention policy of the configuration. Reports are kept indefinitely unless a maximum
+// age or a maximum number of reports per instance is configured, the purge settings have defaults.
+func ConfiguredPolicy() Policy {
+	log.Trace("retention/retention:ConfiguredPolicy() Entering")
+	defer log.Trace("retention/retention:ConfiguredPolicy() Leaving")
+
+	c := config.Configuration.ReportRetention
+	p := Policy{
+		MaxAge:                time.Duration(c.MaxAgeDays) * 24 * time.Hour,
+		MaxReportsPerInstance: c.MaxReportsPerInstance,
+		PurgeInterval:         time.Duration(c.PurgeIntervalMinutes) * time.Minute,
+		PurgeBatchSize:        c.PurgeBatchSize,
+	}
+	if p.MaxAge < 0 {
+		p.MaxAge = 0
+	}
+	if p.MaxReportsPerInstance < 0 {
+		p.MaxReportsPerInstance = 0
+	}
+	if p.PurgeInterval <= 0 {
+		p.PurgeInterval = constants.DefaultReportPurgeMinutes * time.Minute
+	}
+	if p.PurgeBatchSize <= 0 {
+		p.PurgeBatchSize = constants.DefaultReportPurgeBatch
+	}
+	return p
+}
+
+// Enabled checks whether the policy limits the reports kept at all
+func (p Policy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxReportsPerInstance > 0
+}
+
+// ExpiresOn returns when a report created at the given time expires, or the zero time if reports don't expire
+func (p Policy) ExpiresOn(createdAt time.Time) time.Time {
+	if p.MaxAge <= 0 {
+		return time.Time{}
+	}
+	return createdAt.Add(p.MaxAge)
+}
+
+// expiry returns the reports to purge at the given time
+func (p Policy) expiry(now time.Time) repository.ReportExpiry {
+	expiry := repository.ReportExpiry{MaxPerInstance: p.MaxReportsPerInstance}
+	if p.MaxAge > 0 {
+		expiry.ExpiredOn = now
+		expiry.CreatedOn = now.Add(-p.MaxAge)
+	}
+	return expiry
+}
+
+// Purge deletes the expired reports in batches of at most PurgeBatchSize reports, or only counts them when dryRun
+// is set. It returns the number of reports purged, or that would be purged. Nothing is purged unless the policy
+// is enabled.
+func Purge(repo repository.ReportRepository, p Policy, dryRun bool) (int, error) {
+	log.Trace("retention/retention:Purge() Entering")
+	defer log.Trace("retention/retention:Purge() Leaving")
+
+	if !p.Enabled() {
+		return 0, nil
+	}
+
+	expiry := p.expiry(time.Now())
+	if dryRun {
+		count, err := repo.CountExpired(expiry)
+		if err != nil {
+			return 0, errors.Wrap(err, "retention/retention:Purge() Failed to count expired reports")
+		}
+		return count, nil
+	}
+
+	purged := 0
+	for {
+		deleted, err := repo.DeleteExpired(expiry, p.PurgeBatchSize)
+		if err != nil {
+			return purged, errors.Wrap(err, "retention/retention:Purge() Failed to delete expired reports")
+		}
+		purged += deleted
+		// a partial batch is the last one
+		if deleted < p.PurgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// Run purges the expired reports right away and then every PurgeInterval, until stop is closed. It is only meant to
+// run for an enabled policy.
+func Run(repo repository.ReportRepository, p Policy, stop <-chan struct{}) {
+	log.Trace("retention/retention:Run() Entering")
+	defer log.Trace("retention/retention:Run() Leaving")
+
+	ticker := time.NewTicker(p.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := Purge(repo, p, false)
+		if err != nil {
+			log.WithError(err).Error("retention/retention:Run() Failed to purge expired reports")
+			log.Tracef("%+v", err)
+		}
+		if purged > 0 {
+			log.Infof("retention/retention:Run() Purged %d expired reports", purged)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
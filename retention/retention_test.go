@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package retention
+
+import (
+	"errors"
+	"intel/isecl/workload-service/v4/config"
+	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/repository"
+	"intel/isecl/workload-service/v4/repository/mock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// expiredReports returns a mock repository holding count expired reports, that records the purge requests
+func expiredReports(count int) (*mock.MockReport, *[]repository.ReportExpiry, *[]int) {
+	var expiries []repository.ReportExpiry
+	var limits []int
+	return &mock.MockReport{
+		CountExpiredFn: func(expiry repository.ReportExpiry) (int, error) {
+			expiries = append(expiries, expiry)
+			return count, nil
+		},
+		DeleteExpiredFn: func(expiry repository.ReportExpiry, limit int) (int, error) {
+			expiries = append(expiries, expiry)
+			limits = append(limits, limit)
+			deleted := limit
+			if count < limit {
+				deleted = count
+			}
+			count -= deleted
+			return deleted, nil
+		},
+	}, &expiries, &limits
+}
+
+func TestConfiguredPolicy(t *testing.T) {
+	log.Trace("retention/retention_test:TestConfiguredPolicy() Entering")
+	defer log.Trace("retention/retention_test:TestConfiguredPolicy() Leaving")
+	assert := assert.New(t)
+	previous := config.Configuration.ReportRetention
+	defer func() { config.Configuration.ReportRetention = previous }()
+
+	// reports are kept indefinitely unless a limit is configured
+	config.Configuration.ReportRetention.MaxAgeDays = 0
+	config.Configuration.ReportRetention.MaxReportsPerInstance = -1
+	config.Configuration.ReportRetention.PurgeIntervalMinutes = 0
+	config.Configuration.ReportRetention.PurgeBatchSize = 0
+	p := ConfiguredPolicy()
+	assert.Equal(Policy{
+		PurgeInterval:  constants.DefaultReportPurgeMinutes * time.Minute,
+		PurgeBatchSize: constants.DefaultReportPurgeBatch,
+	}, p)
+	assert.False(p.Enabled())
+	assert.True(p.ExpiresOn(time.Now()).IsZero())
+
+	config.Configuration.ReportRetention.MaxAgeDays = 7
+	config.Configuration.ReportRetention.MaxReportsPerInstance = 10
+	config.Configuration.ReportRetention.PurgeIntervalMinutes = 5
+	config.Configuration.ReportRetention.PurgeBatchSize = 50
+	p = ConfiguredPolicy()
+	assert.True(p.Enabled())
+	assert.Equal(Policy{MaxAge: 7 * 24 * time.Hour, MaxReportsPerInstance: 10, PurgeInterval: 5 * time.Minute, PurgeBatchSize: 50}, p)
+
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(time.Date(2021, 3, 8, 12, 0, 0, 0, time.UTC), p.ExpiresOn(createdAt))
+}
+
+func TestPurgeInBatches(t *testing.T) {
+	log.Trace("retention/retention_test:TestPurgeInBatches() Entering")
+	defer log.Trace("retention/retention_test:TestPurgeInBatches() Leaving")
+	assert := assert.New(t)
+	repo, expiries, limits := expiredReports(250)
+	p := Policy{MaxAge: 24 * time.Hour, MaxReportsPerInstance: 10, PurgeBatchSize: 100}
+
+	before := time.Now()
+	purged, err := Purge(repo, p, false)
+	assert.NoError(err)
+	assert.Equal(250, purged)
+	assert.Equal([]int{100, 100, 100}, *limits)
+
+	// every batch purges the reports expired when the purge started
+	for _, expiry := range *expiries {
+		assert.Equal((*expiries)[0], expiry)
+		assert.False(expiry.ExpiredOn.Before(before))
+		assert.Equal(expiry.ExpiredOn.Add(-24*time.Hour), expiry.CreatedOn)
+		assert.Equal(10, expiry.MaxPerInstance)
+	}
+
+	// an exact number of batches ends with an empty batch
+	repo, _, limits = expiredReports(200)
+	purged, err = Purge(repo, p, false)
+	assert.NoError(err)
+	assert.Equal(200, purged)
+	assert.Len(*limits, 3)
+}
+
+func TestPurgeWithoutMaxAge(t *testing.T) {
+	log.Trace("retention/retention_test:TestPurgeWithoutMaxAge() Entering")
+	defer log.Trace("retention/retention_test:TestPurgeWithoutMaxAge() Leaving")
+	assert := assert.New(t)
+	repo, expiries, _ := expiredReports(250)
+
+	// without a limit nothing is purged
+	purged, err := Purge(repo, Policy{PurgeBatchSize: 100}, false)
+	assert.NoError(err)
+	assert.Zero(purged)
+	purged, err = Purge(repo, Policy{PurgeBatchSize: 100}, true)
+	assert.NoError(err)
+	assert.Zero(purged)
+	assert.Empty(*expiries)
+
+	// reports only expire by date when a maximum age is configured
+	purged, err = Purge(repo, Policy{MaxReportsPerInstance: 10, PurgeBatchSize: 100}, false)
+	assert.NoError(err)
+	assert.Equal(250, purged)
+	for _, expiry := range *expiries {
+		assert.True(expiry.ExpiredOn.IsZero())
+		assert.True(expiry.CreatedOn.IsZero())
+		assert.Equal(10, expiry.MaxPerInstance)
+	}
+}
+
+func TestPurgeDryRun(t *testing.T) {
+	log.Trace("retention/retention_test:TestPurgeDryRun() Entering")
+	defer log.Trace("retention/retention_test:TestPurgeDryRun() Leaving")
+	assert := assert.New(t)
+	repo, expiries, limits := expiredReports(250)
+
+	purged, err := Purge(repo, Policy{MaxAge: time.Hour, PurgeBatchSize: 100}, true)
+	assert.NoError(err)
+	assert.Equal(250, purged)
+	assert.Len(*expiries, 1)
+	assert.Empty(*limits)
+}
+
+func TestPurgeFailure(t *testing.T) {
+	log.Trace("retention/retention_test:TestPurgeFailure() Entering")
+	defer log.Trace("retention/retention_test:TestPurgeFailure() Leaving")
+	assert := assert.New(t)
+	batches := 0
+	repo := &mock.MockReport{
+		DeleteExpiredFn: func(expiry repository.ReportExpiry, limit int) (int, error) {
+			batches++
+			if batches > 1 {
+				return 0, errors.New("connection reset")
+			}
+			return limit, nil
+		},
+		CountExpiredFn: func(expiry repository.ReportExpiry) (int, error) {
+			return 0, errors.New("connection reset")
+		},
+	}
+
+	purged, err := Purge(repo, Policy{MaxAge: time.Hour, PurgeBatchSize: 100}, false)
+	assert.Error(err)
+	assert.Equal(100, purged)
+
+	_, err = Purge(repo, Policy{MaxAge: time.Hour, PurgeBatchSize: 100}, true)
+	assert.Error(err)
+}
+
+func TestRunPurgesUntilStopped(t *testing.T) {
+	log.Trace("retention/retention_test:TestRunPurgesUntilStopped() Entering")
+	defer log.Trace("retention/retention_test:TestRunPurgesUntilStopped() Leaving")
+	assert := assert.New(t)
+	purges := make(chan struct{}, 10)
+	repo := &mock.MockReport{
+		DeleteExpiredFn: func(expiry repository.ReportExpiry, limit int) (int, error) {
+			purges <- struct{}{}
+			return 0, nil
+		},
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		Run(repo, Policy{MaxAge: time.Hour, PurgeInterval: 10 * time.Millisecond, PurgeBatchSize: 100}, stop)
+		close(done)
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-purges:
+		case <-time.After(5 * time.Second):
+			t.Fatal("reports were not purged periodically")
+		}
+	}
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail("purge did not stop")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package samlcache
+
+import (
+	commLog "intel/isecl/lib/common/v4/log"
+	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/ttlcache"
+	"time"
+)
+
+var log = commLog.GetDefaultLogger()
+
+// sweepInterval is the period at which expired reports are removed from a cache
+const sweepInterval = time.Minute
+
+// Report is a verified SAML report of a host, reusable until Expiry
+type Report struct {
+	Saml   []byte
+	Expiry time.Time
+}
+
+// Cache is a mutex protected cache of verified SAML reports by host hardware UUID
+// This implements an in-memory store only, and any data is effectively lost on application exit.
+// Expired reports are removed by a background sweeper. When the cache holds its maximum number
+// of reports, the report of the least recently used host is evicted to make room.
+type Cache struct {
+	reports *ttlcache.Cache
+}
+
+// NewCache creates a new instance of a SAML report cache holding the reports of at most maxEntries hosts.
+// A maxEntries <= 0 does not bound the cache.
+// It returns a pointer to the Cache struct
+func NewCache(maxEntries int) *Cache {
+	log.Trace("samlcache/samlcache:NewCache() Entering")
+	defer log.Trace("samlcache/samlcache:NewCache() Leaving")
+	return &Cache{
+		reports: ttlcache.New(ttlcache.Options{MaxEntries: maxEntries, SweepInterval: sweepInterval}),
+	}
+}
+
+// Get retrieves the SAML report of a host by its hardware UUID
+// It returns the report, as well as a bool that indicates if an unexpired report exists in the cache
+func (c *Cache) Get(hardwareUUID string) (report Report, exists bool) {
+	log.Trace("samlcache/samlcache:Get() Entering")
+	defer log.Trace("samlcache/samlcache:Get() Leaving")
+	value, exists := c.reports.Get(hardwareUUID)
+	if !exists {
+		return Report{}, false
+	}
+	return value.(Report), true
+}
+
+// Store persists the SAML report of a host by its hardware UUID
+func (c *Cache) Store(hardwareUUID string, report Report) {
+	log.Trace("samlcache/samlcache:Store() Entering")
+	defer log.Trace("samlcache/samlcache:Store() Leaving")
+	c.reports.Store(hardwareUUID, report, report.Expiry)
+}
+
+// Delete removes the SAML report of a host by its hardware UUID
+func (c *Cache) Delete(hardwareUUID string) {
+	log.Trace("samlcache/samlcache:Delete() Entering")
+	defer log.Trace("samlcache/samlcache:Delete() Leaving")
+	c.reports.Delete(hardwareUUID)
+}
+
+// Len returns the number of reports in the cache
+func (c *Cache) Len() int {
+	return c.reports.Stats().Entries
+}
+
+// Close stops the background sweeper and removes all the reports held by the cache
+func (c *Cache) Close() {
+	log.Trace("samlcache/samlcache:Close() Entering")
+	defer log.Trace("samlcache/samlcache:Close() Leaving")
+	c.reports.Close()
+}
+
+var global *ttlcache.Global
+
+func init() {
+	log.Trace("samlcache/samlcache:init() Entering")
+	defer log.Trace("samlcache/samlcache:init() Leaving")
+	global = ttlcache.NewGlobal(NewCache(constants.DefaultSamlCacheEntries))
+}
+
+// Configure replaces the default global cache with one holding the reports of at most maxEntries hosts
+func Configure(maxEntries int) {
+	log.Trace("samlcache/samlcache:Configure() Entering")
+	defer log.Trace("samlcache/samlcache:Configure() Leaving")
+	global.Replace(NewCache(maxEntries))
+}
+
+func globalCache() *Cache {
+	return global.Load().(*Cache)
+}
+
+// Get retrieves the SAML report of a host by its hardware UUID from the default global cache
+func Get(hardwareUUID string) (report Report, exists bool) {
+	log.Trace("samlcache/samlcache:Get() Entering")
+	defer log.Trace("samlcache/samlcache:Get() Leaving")
+	return globalCache().Get(hardwareUUID)
+}
+
+// Store persists the SAML report of a host by its hardware UUID in the default global cache
+func Store(hardwareUUID string, report Report) {
+	log.Trace("samlcache/samlcache:Store() Entering")
+	defer log.Trace("samlcache/samlcache:Store() Leaving")
+	globalCache().Store(hardwareUUID, report)
+}
+
+// Delete removes the SAML report of a host by its hardware UUID from the default global cache
+func Delete(hardwareUUID string) {
+	log.Trace("samlcache/samlcache:Delete() Entering")
+	defer log.Trace("samlcache/samlcache:Delete() Leaving")
+	globalCache().Delete(hardwareUUID)
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package samlcache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestGetAndStore(t *testing.T) {
+	log.Trace("samlcache/samlcache_test:TestGetAndStore() Entering")
+	defer log.Trace("samlcache/samlcache_test:TestGetAndStore() Leaving")
+	assert := assert.New(t)
+	cache := NewCache(0)
+	defer cache.Close()
+
+	report := Report{[]byte("<saml2:Assertion/>"), time.Now().Add(time.Minute)}
+	cache.Store("foobar", report)
+	actual, exists := cache.Get("foobar")
+	assert.True(exists)
+	assert.Equal(report, actual)
+
+	cache.Delete("foobar")
+	_, exists = cache.Get("foobar")
+	assert.False(exists)
+}
+
+func TestExpiredReport(t *testing.T) {
+	log.Trace("samlcache/samlcache_test:TestExpiredReport() Entering")
+	defer log.Trace("samlcache/samlcache_test:TestExpiredReport() Leaving")
+	assert := assert.New(t)
+	cache := NewCache(0)
+	defer cache.Close()
+
+	cache.Store("foo", Report{[]byte("<saml2:Assertion/>"), time.Now().Add(-time.Second)})
+	actual, exists := cache.Get("foo")
+	assert.False(exists)
+	assert.Zero(actual)
+
+	// the sweeper removes the expired reports of hosts no longer requesting keys
+	cache.Store("foo", Report{[]byte("<saml2:Assertion/>"), time.Now().Add(-time.Second)})
+	cache.Store("bar", Report{[]byte("<saml2:Assertion/>"), time.Now().Add(time.Minute)})
+	assert.Equal(2, cache.Len())
+	cache.reports.Sweep()
+	assert.Equal(1, cache.Len())
+	_, exists = cache.Get("bar")
+	assert.True(exists)
+}
+
+func TestCapacity(t *testing.T) {
+	log.Trace("samlcache/samlcache_test:TestCapacity() Entering")
+	defer log.Trace("samlcache/samlcache_test:TestCapacity() Leaving")
+	assert := assert.New(t)
+	cache := NewCache(2)
+	defer cache.Close()
+
+	report := Report{[]byte("<saml2:Assertion/>"), time.Now().Add(time.Minute)}
+	cache.Store("foo", report)
+	cache.Store("bar", report)
+	// foo becomes the most recently used host, so bar is evicted when baz is stored
+	_, exists := cache.Get("foo")
+	assert.True(exists)
+	cache.Store("baz", report)
+
+	_, exists = cache.Get("bar")
+	assert.False(exists)
+	_, exists = cache.Get("foo")
+	assert.True(exists)
+	_, exists = cache.Get("baz")
+	assert.True(exists)
+	assert.Equal(2, cache.Len())
+
+	// storing the report of a cached host again replaces it
+	cache.Store("foo", Report{[]byte("<saml2:Assertion ID=\"new\"/>"), time.Now().Add(time.Minute)})
+	actual, _ := cache.Get("foo")
+	assert.Equal([]byte("<saml2:Assertion ID=\"new\"/>"), actual.Saml)
+	assert.Equal(2, cache.Len())
+}
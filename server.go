@@ -13,10 +13,15 @@ import (
 	"intel/isecl/lib/common/v4/log/message"
 	"intel/isecl/lib/common/v4/middleware"
 	cos "intel/isecl/lib/common/v4/os"
+	"intel/isecl/workload-service/v4/clients"
 	"intel/isecl/workload-service/v4/config"
 	"intel/isecl/workload-service/v4/constants"
+	"intel/isecl/workload-service/v4/events"
+	"intel/isecl/workload-service/v4/keycache"
 	"intel/isecl/workload-service/v4/repository/postgres"
 	"intel/isecl/workload-service/v4/resource"
+	"intel/isecl/workload-service/v4/retention"
+	"intel/isecl/workload-service/v4/samlcache"
 	"io/ioutil"
 	stdlog "log"
 	"net/http"
@@ -91,6 +96,12 @@ func startServer() error {
 	log.Trace("server:startServer() Entering")
 	defer log.Trace("server:startServer() Leaving")
 
+	// Without a trusted KBS, the service runs but every key request is rejected
+	if len(config.Configuration.KBSEndpoints) == 0 {
+		log.Warnf("server:startServer() No KBS endpoint is configured, keys will not be retrieved until %s is set and \"workload-service setup update_service_config --force\" is run, or kbs_endpoints is added to %s",
+			constants.KbsEndpointsEnv, constants.ConfigFile)
+	}
+
 	// Open database
 	wlsDB, err := postgres.Open(config.Configuration.Postgres.Hostname, config.Configuration.Postgres.Port, config.Configuration.Postgres.DBName,
 		config.Configuration.Postgres.UserName, config.Configuration.Postgres.Password, config.Configuration.Postgres.SSLMode, config.Configuration.Postgres.SSLCert)
@@ -98,11 +109,44 @@ func startServer() error {
 		return errors.Wrap(err, "failed to open Postgres database")
 	}
 	defer wlsDB.Close()
+	// Configure the key cache
+	keyCacheSeconds := config.Configuration.KeyCacheSeconds
+	if keyCacheSeconds <= 0 {
+		keyCacheSeconds = constants.DefaultKeyCacheSeconds
+	}
+	keyCacheMaxEntries := config.Configuration.KeyCacheMaxEntries
+	if keyCacheMaxEntries <= 0 {
+		keyCacheMaxEntries = constants.DefaultKeyCacheMaxEntries
+	}
+	keycache.Configure(time.Second*time.Duration(keyCacheSeconds), keyCacheMaxEntries)
+	// Configure the SAML report cache
+	samlCacheMaxEntries := config.Configuration.SamlCacheMaxEntries
+	if samlCacheMaxEntries <= 0 {
+		samlCacheMaxEntries = constants.DefaultSamlCacheEntries
+	}
+	samlcache.Configure(samlCacheMaxEntries)
+	// Share the HVS and KBS clients between requests
+	resource.SetClientManager(clients.NewManager(config.Configuration.HvsApiUrl, config.Configuration.AasApiUrl,
+		config.Configuration.WLS.User, config.Configuration.WLS.Password, constants.TrustedCaCertsDir))
 	log.Trace("Migrating Database")
 	err = wlsDB.Migrate()
 	if err != nil {
 		return errors.Wrap(err, "failed to migrate database")
 	}
+	// Purge the expired reports in the background, when a retention limit is configured
+	stopPurge := make(chan struct{})
+	defer close(stopPurge)
+	if retentionPolicy := retention.ConfiguredPolicy(); retentionPolicy.Enabled() {
+		go retention.Run(wlsDB.ReportRepository(), retentionPolicy, stopPurge)
+	} else {
+		log.Info("server:startServer() No report retention limit is configured, reports are kept indefinitely")
+	}
+	// Deliver the trust changes to the webhooks in the background
+	stopWebhooks := make(chan struct{})
+	defer close(stopWebhooks)
+	for _, webhook := range events.ConfiguredWebhooks() {
+		go webhook.Run(stopWebhooks)
+	}
 
 	r := mux.NewRouter()
 	// ISECL-8715 - Prevent potential open redirects to external URLs
@@ -123,6 +167,10 @@ func startServer() error {
 	resource.SetImagesEndpoints(authr.PathPrefix("/images").Subrouter(), wlsDB)
 	// Set Key Endpoints
 	resource.SetKeysEndpoints(authr.PathPrefix("/keys").Subrouter(), wlsDB)
+	// Set Key Release Endpoints
+	resource.SetKeyReleasesEndpoints(authr.PathPrefix("/key-releases").Subrouter(), wlsDB)
+	// Set Events Endpoints
+	resource.SetEventsEndpoints(authr.PathPrefix("/events").Subrouter())
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGKILL)
@@ -148,6 +196,21 @@ func startServer() error {
 			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
 	}
+	// hosts bound by their client certificate present it along with their token
+	if config.Configuration.KeyHostBinding.Mode == constants.KeyHostBindingCert {
+		clientCAPems, err := cos.GetDirFileContents(constants.TrustedCaCertsDir, "*.pem")
+		if err != nil {
+			return errors.Wrap(err, "server:startServer() Could not read client CA certificates")
+		}
+		clientCAs := x509.NewCertPool()
+		for _, clientCAPem := range clientCAPems {
+			if ok := clientCAs.AppendCertsFromPEM(clientCAPem); !ok {
+				return errors.New("server:startServer() Could not parse client CA certificates")
+			}
+		}
+		tlsconfig.ClientCAs = clientCAs
+		tlsconfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
 	l := stdlog.New(httpWriter, "", 0)
 	h := &http.Server{
 		Addr:              fmt.Sprintf(":%d", config.Configuration.Port),
@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package setup
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"intel/isecl/lib/common/v4/crypt"
+	cos "intel/isecl/lib/common/v4/os"
+	csetup "intel/isecl/lib/common/v4/setup"
+	"intel/isecl/workload-service/v4/constants"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+type Import_Flavor_Signing_Cert struct {
+	Flags []string
+}
+
+func (fs Import_Flavor_Signing_Cert) Run(c csetup.Context) error {
+	log.Trace("setup/import_flavor_signing_cert:Run() Entering")
+	defer log.Trace("setup/import_flavor_signing_cert:Run() Leaving")
+
+	flagSet := flag.NewFlagSet("Import_Flavor_Signing_Cert", flag.ExitOnError)
+	force := flagSet.Bool("force", false, "force rerun of WLS setup to import flavor signing certificates")
+
+	err := flagSet.Parse(fs.Flags)
+	if err != nil {
+		fmt.Println("setup/import_flavor_signing_cert: Unable to parse flags")
+		return fmt.Errorf("setup/import_flavor_signing_cert: Unable to parse flags")
+	}
+
+	imported, err := importedFlavorSigningCerts()
+	if err != nil {
+		return errors.Wrap(err, "setup/import_flavor_signing_cert:Run() Error while reading the imported flavor signing certificates")
+	}
+
+	certPath, err := c.GetenvString(constants.FlavorSigningCertPathEnv, "Flavor signing certificate file path")
+	if err != nil {
+		certPath = ""
+	}
+	if imported > 0 && (certPath == "" || !*force) {
+		fmt.Println("setup/import_flavor_signing_cert:Run() Flavor signing certificates are already imported by WLS. Skipping setup task execution...")
+		log.Info("setup/import_flavor_signing_cert:Run() Flavor signing certificates are already imported by WLS. Skipping setup task execution...")
+		return nil
+	}
+	// the task is optional, the certificates can be imported once the flavor signers are known
+	if certPath == "" {
+		fmt.Println("setup/import_flavor_signing_cert:Run() " + constants.FlavorSigningCertPathEnv + " is not defined in environment, no flavor can be created until flavor signing certificates are imported. Skipping setup task execution...")
+		log.Warn("setup/import_flavor_signing_cert:Run() " + constants.FlavorSigningCertPathEnv + " is not defined in environment, no flavor signing certificates are imported. Skipping setup task execution...")
+		return nil
+	}
+	log.Info("setup/import_flavor_signing_cert:Run() Importing flavor signing certificates.")
+
+	certPems, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return errors.Wrapf(err, "setup/import_flavor_signing_cert:Run() Error while reading file:%s", certPath)
+	}
+
+	if _, err = os.Stat(constants.FlavorSigningCertsDir); os.IsNotExist(err) {
+		if err = os.MkdirAll(constants.FlavorSigningCertsDir, 0755); err != nil {
+			return errors.Wrapf(err, "setup/import_flavor_signing_cert:Run() Error while creating directory:%s", constants.FlavorSigningCertsDir)
+		}
+	}
+
+	imported = 0
+	for block, rest := pem.Decode(certPems); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return errors.Wrapf(err, "setup/import_flavor_signing_cert:Run() Invalid certificate in file:%s", certPath)
+		}
+		err = crypt.SavePemCertWithShortSha1FileName(pem.EncodeToMemory(block), constants.FlavorSigningCertsDir)
+		if err != nil {
+			return errors.Wrap(err, "setup/import_flavor_signing_cert:Run() Error while saving flavor signing certificate")
+		}
+		imported++
+	}
+	if imported == 0 {
+		return errors.Errorf("setup/import_flavor_signing_cert:Run() No certificates found in file:%s", certPath)
+	}
+
+	log.Infof("setup/import_flavor_signing_cert:Run() Imported %d flavor signing certificate(s)", imported)
+	return nil
+}
+
+// Validate checks that the imported flavor signing certificates can be read. No certificate being imported is not an
+// error since the task is optional, flavors are rejected until certificates are imported.
+func (fs Import_Flavor_Signing_Cert) Validate(c csetup.Context) error {
+	log.Trace("setup/import_flavor_signing_cert:Validate() Entering")
+	defer log.Trace("setup/import_flavor_signing_cert:Validate() Leaving")
+
+	log.Info("setup/import_flavor_signing_cert:Validate() Validation for importing flavor signing certificates.")
+
+	imported, err := importedFlavorSigningCerts()
+	if err != nil {
+		return errors.Wrap(err, "setup/import_flavor_signing_cert:Validate() Error while validating import_flavor_signing_cert setup task")
+	}
+	if imported == 0 {
+		log.Warn("setup/import_flavor_signing_cert:Validate() No flavor signing certificates are imported, flavors cannot be created")
+	}
+	return nil
+}
+
+// importedFlavorSigningCerts returns the number of certificates imported in the flavor signing certificates directory
+func importedFlavorSigningCerts() (int, error) {
+	if pemFiles, _ := filepath.Glob(constants.FlavorSigningCertsDir + "*.pem"); len(pemFiles) == 0 {
+		return 0, nil
+	}
+	certPems, err := cos.GetDirFileContents(constants.FlavorSigningCertsDir, "*.pem")
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	for _, certPem := range certPems {
+		for block, rest := pem.Decode(certPem); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+				return 0, errors.Wrapf(err, "Invalid certificate in directory:%s", constants.FlavorSigningCertsDir)
+			}
+			imported++
+		}
+	}
+	return imported, nil
+}
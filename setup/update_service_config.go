@@ -12,7 +12,10 @@ import (
 	csetup "intel/isecl/lib/common/v4/setup"
 	"intel/isecl/workload-service/v4/config"
 	"intel/isecl/workload-service/v4/constants"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -67,6 +70,129 @@ func (uc Update_Service_Config) Run(c csetup.Context) error {
 		config.Configuration.KeyCacheSeconds = constants.DefaultKeyCacheSeconds
 	}
 
+	keyCacheMaxEntries, err := c.GetenvInt(constants.KeyCacheMaxEntriesEnv, "Key Cache Maximum Entries")
+	if err == nil && keyCacheMaxEntries > 0 {
+		config.Configuration.KeyCacheMaxEntries = keyCacheMaxEntries
+	} else if config.Configuration.KeyCacheMaxEntries <= 0 {
+		log.Infof("setup/update_service_config:Run() %s not defined, using default value", constants.KeyCacheMaxEntriesEnv)
+		config.Configuration.KeyCacheMaxEntries = constants.DefaultKeyCacheMaxEntries
+	}
+
+	samlCacheDisabled, err := c.GetenvString(constants.SamlCacheDisabledEnv, "SAML Cache Disabled Flag")
+	if err == nil && samlCacheDisabled != "" {
+		config.Configuration.SamlCacheDisabled = strings.ToLower(samlCacheDisabled) == "true"
+	}
+
+	samlCacheMaxEntries, err := c.GetenvInt(constants.SamlCacheMaxEntriesEnv, "SAML Cache Maximum Entries")
+	if err == nil && samlCacheMaxEntries > 0 {
+		config.Configuration.SamlCacheMaxEntries = samlCacheMaxEntries
+	} else if config.Configuration.SamlCacheMaxEntries <= 0 {
+		log.Infof("setup/update_service_config:Run() %s not defined, using default value", constants.SamlCacheMaxEntriesEnv)
+		config.Configuration.SamlCacheMaxEntries = constants.DefaultSamlCacheEntries
+	}
+
+	samlClockSkewSeconds, err := c.GetenvInt(constants.SamlClockSkewSecondsEnv, "SAML Clock Skew Seconds")
+	if err == nil && samlClockSkewSeconds > 0 {
+		config.Configuration.SamlClockSkewSeconds = samlClockSkewSeconds
+	} else if config.Configuration.SamlClockSkewSeconds <= 0 {
+		log.Infof("setup/update_service_config:Run() %s not defined, using default value", constants.SamlClockSkewSecondsEnv)
+		config.Configuration.SamlClockSkewSeconds = constants.DefaultSamlClockSkewSecs
+	}
+
+	samlIssuer, err := c.GetenvString(constants.SamlIssuerEnv, "SAML Issuer")
+	if err == nil && samlIssuer != "" {
+		config.Configuration.SamlIssuer = samlIssuer
+	}
+
+	samlAudience, err := c.GetenvString(constants.SamlAudienceEnv, "SAML Audience")
+	if err == nil && samlAudience != "" {
+		config.Configuration.SamlAudience = samlAudience
+	}
+
+	keyHostBinding, err := c.GetenvString(constants.KeyHostBindingEnv, "Key Request Host Binding Mode")
+	if err == nil && keyHostBinding != "" {
+		config.Configuration.KeyHostBinding.Mode = strings.ToLower(keyHostBinding)
+	} else if config.Configuration.KeyHostBinding.Mode == "" {
+		log.Infof("setup/update_service_config:Run() %s not defined, using default value", constants.KeyHostBindingEnv)
+		config.Configuration.KeyHostBinding.Mode = constants.KeyHostBindingNone
+	}
+	switch config.Configuration.KeyHostBinding.Mode {
+	case constants.KeyHostBindingNone, constants.KeyHostBindingJWT, constants.KeyHostBindingCert:
+	default:
+		return errors.Errorf("setup/update_service_config:Run() %s must be one of %s, %s or %s", constants.KeyHostBindingEnv,
+			constants.KeyHostBindingNone, constants.KeyHostBindingJWT, constants.KeyHostBindingCert)
+	}
+
+	keyHostBindingClaim, err := c.GetenvString(constants.KeyHostBindingClaimEnv, "Key Request Host Binding Claim")
+	if err == nil && keyHostBindingClaim != "" {
+		config.Configuration.KeyHostBinding.Claim = keyHostBindingClaim
+	} else if config.Configuration.KeyHostBinding.Claim == "" {
+		log.Infof("setup/update_service_config:Run() %s not defined, using default value", constants.KeyHostBindingClaimEnv)
+		config.Configuration.KeyHostBinding.Claim = constants.DefaultKeyHostClaim
+	}
+
+	reportsMaxPageSize, err := c.GetenvInt(constants.ReportsMaxPageSizeEnv, "Reports Maximum Page Size")
+	if err == nil && reportsMaxPageSize > 0 {
+		config.Configuration.ReportsMaxPageSize = reportsMaxPageSize
+	} else if config.Configuration.ReportsMaxPageSize <= 0 {
+		log.Infof("setup/update_service_config:Run() %s not defined, using default value", constants.ReportsMaxPageSizeEnv)
+		config.Configuration.ReportsMaxPageSize = constants.DefaultReportsMaxPageSize
+	}
+
+	reportsMaxBulkSize, err := c.GetenvInt(constants.ReportsMaxBulkSizeEnv, "Reports Maximum Bulk Size")
+	if err == nil && reportsMaxBulkSize > 0 {
+		config.Configuration.ReportsMaxBulkSize = reportsMaxBulkSize
+	} else if config.Configuration.ReportsMaxBulkSize <= 0 {
+		log.Infof("setup/update_service_config:Run() %s not defined, using default value", constants.ReportsMaxBulkSizeEnv)
+		config.Configuration.ReportsMaxBulkSize = constants.DefaultReportsMaxBulkSize
+	}
+
+	reportsMaxBulkBytes, err := c.GetenvInt(constants.ReportsMaxBulkBytesEnv, "Reports Maximum Bulk Request Bytes")
+	if err == nil && reportsMaxBulkBytes > 0 {
+		config.Configuration.ReportsMaxBulkBytes = reportsMaxBulkBytes
+	} else if config.Configuration.ReportsMaxBulkBytes <= 0 {
+		log.Infof("setup/update_service_config:Run() %s not defined, using default value", constants.ReportsMaxBulkBytesEnv)
+		config.Configuration.ReportsMaxBulkBytes = constants.DefaultReportsBulkBytes
+	}
+
+	kbsEndpoints, err := c.GetenvString(constants.KbsEndpointsEnv, "Trusted KBS Endpoints")
+	if err == nil && kbsEndpoints != "" {
+		if config.Configuration.KBSEndpoints, err = parseKBSEndpoints(kbsEndpoints); err != nil {
+			return errors.Wrapf(err, "setup/update_service_config:Run() Invalid %s", constants.KbsEndpointsEnv)
+		}
+	} else if len(config.Configuration.KBSEndpoints) == 0 {
+		fmt.Printf("%s is not defined, keys can't be retrieved until a KBS endpoint is configured\n", constants.KbsEndpointsEnv)
+		log.Warnf("setup/update_service_config:Run() %s not defined, no KBS endpoint is configured", constants.KbsEndpointsEnv)
+	}
+
+	retention := &config.Configuration.ReportRetention
+	// reports are kept indefinitely unless a retention limit is set, 0 removes the limit
+	reportMaxAgeDays, err := c.GetenvInt(constants.ReportMaxAgeDaysEnv, "Report Maximum Age in Days")
+	if err == nil && reportMaxAgeDays >= 0 {
+		retention.MaxAgeDays = reportMaxAgeDays
+	}
+
+	reportsPerInstance, err := c.GetenvInt(constants.ReportsPerInstanceEnv, "Maximum Reports per Instance")
+	if err == nil && reportsPerInstance >= 0 {
+		retention.MaxReportsPerInstance = reportsPerInstance
+	}
+
+	reportPurgeInterval, err := c.GetenvInt(constants.ReportPurgeIntervalEnv, "Report Purge Interval in Minutes")
+	if err == nil && reportPurgeInterval > 0 {
+		retention.PurgeIntervalMinutes = reportPurgeInterval
+	} else if retention.PurgeIntervalMinutes <= 0 {
+		log.Infof("setup/update_service_config:Run() %s not defined, using default value", constants.ReportPurgeIntervalEnv)
+		retention.PurgeIntervalMinutes = constants.DefaultReportPurgeMinutes
+	}
+
+	reportPurgeBatchSize, err := c.GetenvInt(constants.ReportPurgeBatchSizeEnv, "Report Purge Batch Size")
+	if err == nil && reportPurgeBatchSize > 0 {
+		retention.PurgeBatchSize = reportPurgeBatchSize
+	} else if retention.PurgeBatchSize <= 0 {
+		log.Infof("setup/update_service_config:Run() %s not defined, using default value", constants.ReportPurgeBatchSizeEnv)
+		retention.PurgeBatchSize = constants.DefaultReportPurgeBatch
+	}
+
 	ll, err := c.GetenvString(constants.WlsLoglevelEnv, "Logging Level")
 	if err != nil {
 		if config.Configuration.LogLevel == "" {
@@ -138,6 +264,38 @@ func (uc Update_Service_Config) Run(c csetup.Context) error {
 	return config.Save()
 }
 
+// parseKBSEndpoints parses a comma separated list of KBS base URLs, each optionally followed by "|" and the path of
+// the CA bundle its TLS certificate is verified with
+func parseKBSEndpoints(value string) ([]config.KBSEndpoint, error) {
+	var endpoints []config.KBSEndpoint
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		endpoint := config.KBSEndpoint{URL: entry}
+		if i := strings.Index(entry, "|"); i >= 0 {
+			endpoint.URL = strings.TrimSpace(entry[:i])
+			endpoint.CABundle = strings.TrimSpace(entry[i+1:])
+		}
+		u, err := url.Parse(endpoint.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil ||
+			u.RawQuery != "" || u.Fragment != "" {
+			return nil, errors.Errorf("%q is not a valid KBS URL", endpoint.URL)
+		}
+		if endpoint.CABundle != "" {
+			if _, err := os.Stat(endpoint.CABundle); err != nil {
+				return nil, errors.Wrapf(err, "CA bundle of KBS %s can't be read", endpoint.URL)
+			}
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("no KBS URL is set")
+	}
+	return endpoints, nil
+}
+
 // Validate checks whether or not the Update_Service_Config task configured successfully or not
 func (uc Update_Service_Config) Validate(c csetup.Context) error {
 	log.Trace("setup/update_service_config:Validate() Entering")
@@ -5,6 +5,13 @@
 // When the encrypted image is used to launch new VM or container, WLA will request the decryption key from the Workload Service.
 // Then Workload Service will initiate the key transfer request to the Key Broker.
 //
+// Failed requests return a JSON error body with a stable error code, a message and the request ID, which is also
+// returned in the X-Request-Id header. A plain text body is returned instead when the Accept header prefers text/plain.
+// The error codes are invalid_request, unauthorized, not_found, conflict, internal_error, flavor_signature_invalid,
+// report_signature_invalid, host_untrusted, hvs_report_failed, hvs_unavailable, saml_invalid, saml_verification_failed,
+// kbs_transfer_failed, kbs_unavailable, host_identity_mismatch, key_release_policy_denied and
+// flavor_signing_not_configured.
+//
 //  License: Copyright (C) 2020 Intel Corporation. SPDX-License-Identifier: BSD-3-Clause
 //
 //  Version: 2.2
@@ -23,6 +30,15 @@
 // swagger:meta
 package docs
 
+import "intel/isecl/workload-service/v4/model"
+
+// ErrorResponse response payload
+// swagger:response ErrorResponse
+type ErrorResponse struct {
+	// in:body
+	Body model.ErrorResponse
+}
+
 // swagger:operation GET /version Version getVersion
 // ---
 // description: Retrieves the version of workload service.
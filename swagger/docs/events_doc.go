@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package docs
+
+// swagger:operation GET /events Events streamEvents
+// ---
+// description: |
+//   Streams the trust changes of the VMs as Server-Sent Events. A trust_changed event is sent when a new report of
+//   a VM is trusted and the previous report of the VM was not, or the other way around.
+//   The stream ends before the write timeout of the server. Clients reconnect with the Last-Event-ID header set to
+//   the id of the last event they received, to receive the events published in between.
+//   A valid bearer token should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - text/event-stream
+// parameters:
+// - name: image_id
+//   description: Only the events of the VMs launched from this image are streamed.
+//   in: query
+//   type: string
+//   format: uuid
+// - name: hardware_uuid
+//   description: Only the events of the VMs running on this host are streamed.
+//   in: query
+//   type: string
+//   format: uuid
+// - name: Last-Event-ID
+//   description: Id of the last event received, the events published after it are streamed first.
+//   in: header
+//   type: integer
+// responses:
+//   '200':
+//     description: Successfully subscribed to the trust change events.
+//     content: text/event-stream
+//   '400':
+//     description: Invalid image_id, hardware_uuid or Last-Event-ID.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//
+// x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/events?image_id=12002400-d06b-4c9b-ae3b-ad462cceb674
+// x-sample-call-output: |
+//  retry: 1000
+//
+//  id: 42
+//  event: trust_changed
+//  data: {"id":42,"type":"trust_changed","report_id":"f52023eb-7991-47ba-91fc-c43bd9d80c29",
+//    "instance_id":"7f803018-f56f-45bb-942a-88fb838ca231","hardware_uuid":"808b706f-5631-e511-906e-0012795d96dd",
+//    "image_id":"12002400-d06b-4c9b-ae3b-ad462cceb674","policy_name":"Intel VM Policy","previous_trusted":true,
+//    "trusted":false,"reported_at":"2021-03-08T12:18:54Z"}
+// ---
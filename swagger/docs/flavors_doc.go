@@ -37,6 +37,8 @@ type SwaggFlavorsResponse struct {
 // description: |
 //   Creates a flavor for the encrypted image in the workload service database.
 //   Flavor can be created by providing the image flavor content obtained from the WPM after encrypting the image.
+//   The flavor signature must verify against one of the flavor signing certificates imported with the
+//   import_flavor_signing_cert setup task, otherwise the flavor is rejected.
 //   A valid bearer token should be provided to authorize this REST call.
 //
 // security:
@@ -56,6 +58,12 @@ type SwaggFlavorsResponse struct {
 //     description: Successfully created the flavor.
 //     schema:
 //       "$ref": "#/definitions/ImageFlavor"
+//   '400':
+//     description: Invalid request body, the flavor signature could not be verified, or the key URL is not on a trusted KBS.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '409':
+//     description: A flavor with the same ID or label already exists.
 //
 // x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/flavors
 // x-sample-call-input: |
@@ -4,6 +4,8 @@
  */
 package docs
 
+import "intel/isecl/workload-service/v4/model"
+
 type ImageInfo struct {
 	ID        string   `json:"id"`
 	FlavorIDs []string `json:"flavor_ids"`
@@ -25,6 +27,20 @@ type SwaggImagesResponse struct {
 	Body ImagesResponse
 }
 
+// KeyReleasePolicy request payload
+// swagger:parameters KeyReleasePolicy
+type SwaggKeyReleasePolicy struct {
+	// in:body
+	Body model.KeyReleasePolicy
+}
+
+// FlavorKeyResponse response payload
+// swagger:response FlavorKeyResponse
+type FlavorKeyResponse struct {
+	// in:body
+	Body model.FlavorKey
+}
+
 // swagger:operation POST /images Images createImage
 // ---
 //
@@ -412,3 +428,201 @@ type SwaggImagesResponse struct {
 //    }
 //  ]
 // ---
+
+// swagger:operation GET /images/{image_id}/flavor-key ImageFlavor retrieveFlavorAndKeyForImageId
+// ---
+// description: |
+//   Retrieves the flavor associated with the specified image, along with the image decryption key wrapped for the
+//   host if the image is encrypted. The key is only released if the SAML report of the host is trusted and bound to
+//   the host.
+//   The query parameter 'hardware_uuid' is mandatory.
+//   A valid bearer token should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/json
+// parameters:
+// - name: image_id
+//   description: Unique ID of the image.
+//   in: path
+//   required: true
+//   type: string
+//   format: uuid
+// - name: hardware_uuid
+//   description: Hardware UUID of the host launching the image.
+//   in: query
+//   required: true
+//   type: string
+//   format: uuid
+// responses:
+//   '200':
+//     description: Successfully retrieved the flavor and key for the image.
+//     schema:
+//       "$ref": "#/definitions/FlavorKey"
+//   '400':
+//     description: Invalid image UUID or hardware UUID.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '403':
+//     description: |
+//       The host is not trusted, error code host_untrusted. The request should not be retried until the host is trusted again.
+//       When key requests are bound to hosts, the error code is host_identity_mismatch if the hardware UUID does not
+//       match the identity claim of the bearer token or the client certificate of the caller.
+//       The error code is key_release_policy_denied if the host does not satisfy the key release policy of an image
+//       encrypted with the key, the reasons are listed in details.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '502':
+//     description: |
+//       HVS or KBS failed the request. The error code is hvs_report_failed when HVS did not return a SAML report,
+//       saml_invalid when the SAML report is malformed, saml_verification_failed when its signature or certificate
+//       chain could not be verified or when it is not about the requesting host, not issued by the configured issuer,
+//       outside its validity period or not restricted to the configured audience, and kbs_transfer_failed when KBS
+//       did not release the key.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '503':
+//     description: |
+//       HVS or KBS is unavailable, error code hvs_unavailable or kbs_unavailable. The request may be retried
+//       after the number of seconds in the Retry-After header.
+//     headers:
+//       Retry-After:
+//         type: integer
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//
+// x-sample-call-endpoint: |
+//    https://workloadservice.com:5000/wls/v1/images/ffff021e-9669-4e53-9224-8880fb4e4081/flavor-key?hardware_uuid=ecee021e-9669-4e53-9224-8880fb4e4080
+// ---
+
+// swagger:operation GET /images/{image_id}/policy KeyReleasePolicy getKeyReleasePolicy
+// ---
+// description: |
+//   Retrieves the key release policy of the specified image. The key of the image is released only to trusted hosts
+//   whose SAML report has, for each required attribute, one of its allowed values.
+//   A valid bearer token with key_release_policies:retrieve permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/json
+// parameters:
+// - name: image_id
+//   description: Unique ID of the image.
+//   in: path
+//   required: true
+//   type: string
+//   format: uuid
+// responses:
+//   '200':
+//     description: Successfully retrieved the key release policy of the image.
+//     schema:
+//       "$ref": "#/definitions/KeyReleasePolicy"
+//   '404':
+//     description: The image has no key release policy.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//
+// x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/images/ffff021e-9669-4e53-9224-8880fb4e4081/policy
+// x-sample-call-output: |
+//    {
+//       "image_id": "ffff021e-9669-4e53-9224-8880fb4e4081",
+//       "required_attributes": [
+//           {"name": "TRUST_OS", "values": ["true"]},
+//           {"name": "TAG_Location", "values": ["Portland", "Hillsboro"]}
+//       ],
+//       "updated_at": "2021-03-19T10:05:43.103845Z"
+//    }
+// ---
+
+// swagger:operation PUT /images/{image_id}/policy KeyReleasePolicy putKeyReleasePolicy
+// ---
+// description: |
+//   Creates or replaces the key release policy of the specified image. Each required attribute is a SAML attribute,
+//   such as TRUST_PLATFORM, TRUST_OS, an asset tag TAG_<name> or a hardware feature, with the values it may have.
+//   The policy is checked on top of TRUST_OVERALL whenever the key of the image is requested, from the flavor-key or
+//   the keys API, before KBS is contacted. The image_id of the request body is optional.
+//   A valid bearer token with key_release_policies:store permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// consumes:
+//  - application/json
+// produces:
+//  - application/json
+// parameters:
+// - name: image_id
+//   description: Unique ID of the image.
+//   in: path
+//   required: true
+//   type: string
+//   format: uuid
+// - name: request body
+//   in: body
+//   required: true
+//   schema:
+//     "$ref": "#/definitions/KeyReleasePolicy"
+// responses:
+//   '200':
+//     description: Successfully stored the key release policy of the image.
+//     schema:
+//       "$ref": "#/definitions/KeyReleasePolicy"
+//   '400':
+//     description: |
+//       Invalid policy. A policy requires at least one attribute, each named once with letters, digits or any of
+//       _.:- and with at least one allowed value.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '404':
+//     description: The image does not exist.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//
+// x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/images/ffff021e-9669-4e53-9224-8880fb4e4081/policy
+// x-sample-call-input: |
+//    {
+//       "required_attributes": [
+//           {"name": "TRUST_OS", "values": ["true"]},
+//           {"name": "TAG_Location", "values": ["Portland", "Hillsboro"]}
+//       ]
+//    }
+// x-sample-call-output: |
+//    {
+//       "image_id": "ffff021e-9669-4e53-9224-8880fb4e4081",
+//       "required_attributes": [
+//           {"name": "TRUST_OS", "values": ["true"]},
+//           {"name": "TAG_Location", "values": ["Portland", "Hillsboro"]}
+//       ],
+//       "updated_at": "2021-03-19T10:05:43.103845Z"
+//    }
+// ---
+
+// swagger:operation DELETE /images/{image_id}/policy KeyReleasePolicy deleteKeyReleasePolicy
+// ---
+// description: |
+//   Deletes the key release policy of the specified image, its key is then released to any trusted host.
+//   A valid bearer token with key_release_policies:delete permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// parameters:
+// - name: image_id
+//   description: Unique ID of the image.
+//   in: path
+//   required: true
+//   type: string
+//   format: uuid
+// responses:
+//   '204':
+//     description: Successfully deleted the key release policy of the image.
+//   '404':
+//     description: The image has no key release policy.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//
+// x-sample-call-endpoint: |
+//    https://workloadservice.com:5000/wls/v1/images/ffff021e-9669-4e53-9224-8880fb4e4081/policy
+// x-sample-call-output: |
+//    204 No content
+// ---
@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package docs
+
+import "intel/isecl/workload-service/v4/model"
+
+type KeyReleasesResponse []model.KeyRelease
+
+// KeyReleasesResponse response payload
+// swagger:response KeyReleasesResponse
+type SwaggKeyReleasesResponse struct {
+	// in:body
+	Body KeyReleasesResponse
+}
+
+// swagger:operation GET /key-releases KeyReleases queryKeyReleases
+// ---
+// description: |
+//   Searches the audit trail of the key release attempts. Every request for a key, from GET /images/{id}/flavor-key
+//   or POST /keys, is recorded with the caller, the host, the image and key, the SAML assertion of the host and
+//   the decision: released, denied when the host was untrusted or did not satisfy a key release policy, or failed
+//   when the key could not be retrieved. A key is not released unless its release is recorded.
+//   A valid bearer token with the key_releases:search permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/json
+// parameters:
+// - name: hardware_uuid
+//   description: Unique hardware UUID of the host the key was requested for.
+//   in: query
+//   type: string
+//   format: uuid
+// - name: image_id
+//   description: Unique ID of the image whose key was requested.
+//   in: query
+//   type: string
+//   format: uuid
+// - name: key_id
+//   description: Unique ID of the requested key.
+//   in: query
+//   type: string
+//   format: uuid
+// - name: principal
+//   description: Subject of the bearer token, or common name of the client certificate, of the caller.
+//   in: query
+//   type: string
+// - name: decision
+//   description: Decision on the key release.
+//   in: query
+//   type: string
+//   enum: [released, denied, failed]
+// - name: from_date
+//   description: Key releases returned will be restricted to after this date. from_date should be given in date format yyyy-mm-ddTHH:mm:ss.
+//   in: query
+//   type: string
+// - name: to_date
+//   description: Key releases returned will be restricted to before this date. to_date should be given in date format yyyy-mm-ddTHH:mm:ss.
+//   in: query
+//   type: string
+// - name: limit
+//   description: |
+//      Maximum number of key releases to return. Defaults to and cannot exceed the maximum page size of the reports
+//      configured in the workload service, 1000 by default.
+//   in: query
+//   type: integer
+//   minimum: 1
+// - name: offset
+//   description: Number of matching key releases to skip. Default value is 0.
+//   in: query
+//   type: integer
+//   minimum: 0
+// - name: sort_order
+//   description: Order of the key releases by time, asc or desc. Default value is asc.
+//   in: query
+//   type: string
+//   enum: [asc, desc]
+// responses:
+//   '200':
+//     description: Successfully retrieved the key releases matching the filter criteria.
+//     headers:
+//       X-Total-Count:
+//         description: Total number of key releases matching the filter criteria.
+//         type: integer
+//       X-Next-Offset:
+//         description: Offset of the next page of key releases. Not set on the last page.
+//         type: integer
+//     schema:
+//       "$ref": "#/definitions/KeyReleasesResponse"
+//   '400':
+//     description: Invalid filter criteria or pagination parameters.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '500':
+//     description: The key releases could not be retrieved.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//
+// x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/key-releases?hardware_uuid=00ecd3ab-9af4-e711-906e-001560a04062&decision=denied
+// x-sample-call-output: |
+//  [
+//   {
+//     "id": "2b8c4a5f-9d0e-4f2b-8a1c-3e5d7f9b1a2c",
+//     "created_at": "2021-03-12T10:21:34.502839Z",
+//     "principal": "wlagent",
+//     "hardware_uuid": "00ecd3ab-9af4-e711-906e-001560a04062",
+//     "image_id": "ffff021e-9669-4e53-9224-8880fb4e4081",
+//     "key_id": "73755fda-c910-46be-821f-e8ddeab189e9",
+//     "decision": "denied",
+//     "denial_reason": "Failed to retrieve Flavor/Key for Image - Host does not satisfy the key release policy: TAG_Location is missing, the policy of image ffff021e-9669-4e53-9224-8880fb4e4081 requires one of [Portland]",
+//     "saml_assertion_id": "HostTrustAssertion"
+//   }
+//  ]
+// ---
@@ -43,6 +43,37 @@ type KeyResponse struct {
 //     description: Successfully return wrapped key from KBS
 //     schema:
 //       "$ref": "#/definitions/ReturnKey"
+//   '400':
+//     description: Invalid request body or hardware UUID, or the key URL is not on a KBS of the kbs_endpoints configuration.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '403':
+//     description: |
+//       The host is not trusted, error code host_untrusted. The request should not be retried until the host is trusted again.
+//       When key requests are bound to hosts, the error code is host_identity_mismatch if the hardware UUID does not
+//       match the identity claim of the bearer token or the client certificate of the caller.
+//       The error code is key_release_policy_denied if the host does not satisfy the key release policy of an image
+//       encrypted with the key, the reasons are listed in details.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '502':
+//     description: |
+//       HVS or KBS failed the request. The error code is hvs_report_failed when HVS did not return a SAML report,
+//       saml_invalid when the SAML report is malformed, saml_verification_failed when its signature or certificate
+//       chain could not be verified or when it is not about the requesting host, not issued by the configured issuer,
+//       outside its validity period or not restricted to the configured audience, and kbs_transfer_failed when KBS
+//       did not release the key.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '503':
+//     description: |
+//       HVS or KBS is unavailable, error code hvs_unavailable or kbs_unavailable. The request may be retried
+//       after the number of seconds in the Retry-After header.
+//     headers:
+//       Retry-After:
+//         type: integer
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
 //
 // x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/keys
 // x-sample-call-input: |
@@ -50,6 +50,45 @@ type SwaggReportsResponse struct {
 	Body ReportsResponse
 }
 
+type ReportSummaryResponse struct {
+	GroupBy string               `json:"group_by"`
+	Groups  []ReportSummaryGroup `json:"groups"`
+}
+
+type ReportSummaryGroup struct {
+	Key       string `json:"key"`
+	Trusted   int    `json:"trusted"`
+	Untrusted int    `json:"untrusted"`
+}
+
+// ReportSummaryResponse response payload
+// swagger:response ReportSummaryResponse
+type SwaggReportSummaryResponse struct {
+	// in:body
+	Body ReportSummaryResponse
+}
+
+type BulkReportResponse struct {
+	Created int                `json:"created"`
+	Failed  int                `json:"failed"`
+	Results []BulkReportResult `json:"results"`
+}
+
+type BulkReportResult struct {
+	Index   int    `json:"index"`
+	Status  int    `json:"status"`
+	ID      string `json:"id,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// BulkReportResponse response payload
+// swagger:response BulkReportResponse
+type SwaggBulkReportResponse struct {
+	// in:body
+	Body BulkReportResponse
+}
+
 // swagger:operation POST /reports Reports createReport
 // ---
 //
@@ -58,6 +97,10 @@ type SwaggReportsResponse struct {
 //   The report schema provided in the request body contains an interface called Rule which works on
 //   any matching policy based on provided rule_name. Rule policy can be either image encryption policy
 //   or flavor integrity policy or integrity policy.
+//   The report must be signed, and its signing certificate must chain to one of the trusted CA certificates
+//   of the workload service, otherwise the report is rejected.
+//   The report is created with the id supplied in the request body, or with an id derived from the signed report.
+//   A report submitted again with the same id and signed report is not created twice, the stored report is returned.
 //   A valid bearer token should be provided to authorize this REST call.
 //
 // security:
@@ -73,10 +116,26 @@ type SwaggReportsResponse struct {
 //   schema:
 //     "$ref": "#/definitions/Report"
 // responses:
+//   '200':
+//     description: The same report was already created.
+//     schema:
+//       "$ref": "#/definitions/Report"
 //   '201':
 //     description: Successfully created the trust report for the image.
 //     schema:
 //       "$ref": "#/definitions/Report"
+//   '400':
+//     description: Invalid request body or report id, or the report signature could not be verified.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '409':
+//     description: A different report with the same id already exists.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '500':
+//     description: The report could not be stored.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
 //
 // x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/reports
 // x-sample-call-input: |
@@ -316,6 +375,7 @@ type SwaggReportsResponse struct {
 // - name: latest_per_vm
 //   description: |
 //      By default this is set to TRUE, returning only the latest report for each VM.
+//      The latest reports are selected among the reports matching the other filters, before the results are paginated.
 //   in: query
 //   type: boolean
 // - name: num_of_days
@@ -324,11 +384,39 @@ type SwaggReportsResponse struct {
 //      This option will override other date options.
 //   in: query
 //   type: integer
+// - name: limit
+//   description: |
+//      Maximum number of reports to return. Defaults to and cannot exceed the maximum page size configured in the
+//      workload service, 1000 by default.
+//   in: query
+//   type: integer
+//   minimum: 1
+// - name: offset
+//   description: Number of matching reports to skip. Default value is 0.
+//   in: query
+//   type: integer
+//   minimum: 0
+// - name: sort_order
+//   description: Order of the reports by creation time, asc or desc. Default value is asc.
+//   in: query
+//   type: string
+//   enum: [asc, desc]
 // responses:
 //   '200':
 //     description: Successfully retrieved the reports based on filter criteria.
+//     headers:
+//       X-Total-Count:
+//         description: Total number of reports matching the filter criteria.
+//         type: integer
+//       X-Next-Offset:
+//         description: Offset of the next page of reports. Not set on the last page.
+//         type: integer
 //     schema:
 //       "$ref": "#/definitions/ReportsResponse"
+//   '400':
+//     description: Invalid filter criteria or pagination parameters.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
 //
 // x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/reports?report_id=f52023eb-7991-47ba-91fc-c43bd9d80c29
 // x-sample-call-output: |
@@ -429,6 +517,176 @@ type SwaggReportsResponse struct {
 
 // ---
 
+// swagger:operation POST /reports/bulk Reports createReports
+// ---
+// description: |
+//   Creates the image trust reports of a JSON array, or of newline delimited JSON with one report per line.
+//   Each report is verified as by POST /reports. The verified reports are created in a single transaction,
+//   the reports that fail verification are skipped. The response holds the status of each report, in the order
+//   of the request. Reports are identified as by POST /reports, the status of a report that was already created
+//   is 200.
+//   The number of reports and the size of the request body are limited by the REPORTS_MAX_BULK_SIZE and
+//   REPORTS_MAX_BULK_BYTES settings.
+//   A valid bearer token should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// consumes:
+//  - application/json
+//  - application/x-ndjson
+// produces:
+//  - application/json
+// parameters:
+// - name: request body
+//   in: body
+//   required: true
+//   schema:
+//     type: array
+//     items:
+//       "$ref": "#/definitions/Report"
+// responses:
+//   '200':
+//     description: Processed the reports, the status of each report is given in the results.
+//     schema:
+//       "$ref": "#/definitions/BulkReportResponse"
+//   '400':
+//     description: Malformed request body, or the request holds no report.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '413':
+//     description: The request holds too many reports, or its body is too large.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '415':
+//     description: The request body is neither application/json nor application/x-ndjson.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//
+// x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/reports/bulk
+// x-sample-call-output: |
+//  {
+//   "created": 1,
+//   "failed": 1,
+//   "results": [
+//      {
+//         "index": 0,
+//         "status": 201,
+//         "id": "f52023eb-7991-47ba-91fc-c43bd9d80c29"
+//      },
+//      {
+//         "index": 1,
+//         "status": 400,
+//         "code": "report_signature_invalid",
+//         "message": "Report signature verification failed: report signature does not match report data"
+//      }
+//   ]
+//  }
+
+// ---
+
+// swagger:operation GET /reports/summary Reports summarizeReports
+// ---
+// description: |
+//   Counts the trusted and untrusted VMs of each host, image or policy. The trust status of a VM is the one of
+//   its latest report, among the reports created in the requested time range.
+//   A valid bearer token should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/json
+// parameters:
+// - name: group_by
+//   description: Groups the VMs by host hardware UUID, image ID or policy name. Default value is hardware_uuid.
+//   in: query
+//   type: string
+//   enum: [hardware_uuid, image_id, policy_name]
+// - name: from_date
+//   description: Only the reports created after this date are counted. from_date should be given in date format yyyy-mm-ddTHH:mm:ss.
+//   in: query
+//   type: string
+// - name: to_date
+//   description: Only the reports created before this date are counted. to_date should be given in date format yyyy-mm-ddTHH:mm:ss.
+//   in: query
+//   type: string
+// - name: num_of_days
+//   description: |
+//      Only the reports created between the current date and number of days prior are counted.
+//      This option will override other date options.
+//   in: query
+//   type: integer
+// responses:
+//   '200':
+//     description: Successfully summarized the reports.
+//     schema:
+//       "$ref": "#/definitions/ReportSummaryResponse"
+//   '400':
+//     description: Invalid group_by or date parameters.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//
+// x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/reports/summary?group_by=image_id
+// x-sample-call-output: |
+//  {
+//   "group_by": "image_id",
+//   "groups": [
+//      {
+//         "key": "12002400-d06b-4c9b-ae3b-ad462cceb674",
+//         "trusted": 12,
+//         "untrusted": 1
+//      }
+//   ]
+//  }
+
+// ---
+
+// swagger:operation GET /reports/{report_id} Reports getReportById
+// ---
+// description: |
+//   Retrieves the image trust report with the specified report id.
+//   When the Accept header prefers application/vnd.wls.signed-report+json to application/json, the signed report
+//   is returned exactly as it was submitted, so that its signature can be verified again. Reports created before
+//   the signed reports were stored return their signed data instead.
+//   A valid bearer token should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/json
+//  - application/vnd.wls.signed-report+json
+// parameters:
+// - name: report_id
+//   description: Unique ID of the report.
+//   in: path
+//   required: true
+//   type: string
+//   format: uuid
+// responses:
+//   '200':
+//     description: Successfully retrieved the report.
+//     schema:
+//       "$ref": "#/definitions/Report"
+//   '400':
+//     description: Invalid report id.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//   '404':
+//     description: No report with the report id.
+//     schema:
+//       "$ref": "#/definitions/ErrorResponse"
+//
+// x-sample-call-endpoint: https://workloadservice.com:5000/wls/v1/reports/f52023eb-7991-47ba-91fc-c43bd9d80c29
+// x-sample-call-input: |
+//    Accept: application/vnd.wls.signed-report+json
+// x-sample-call-output: |
+//  {
+//   "data": "eyJpbnN0YW5jZV9tYW5pZmVzdCI6eyJpbnN0YW5jZV9pbmZvIjp7Imluc3RhbmNlX2lkIjoiN2Y4MDMwMTgtZjU2Zi00NWJiLTk0MmEtODhm",
+//   "hash_alg": "SHA-384",
+//   "cert": "-----BEGIN CERTIFICATE-----\nMIIEoDCCAwigAwIBAgIBADANBgkqhkiG9w0BAQwFADBQMQswCQYDVQQGEwJVUzELMAkG...\n-----END CERTIFICATE-----\n",
+//   "signature": "tcWuM6dk/a0XYFcbqSpDIe7BvN/EsX2CskB6xecryFhXS3HbbeB97K6GqI/TQnZZPC40KfQDUTVn7oSDH9AvnFIDQSsBUCqcfl0Q0CRdm9KE9brCT"
+//  }
+// ---
+
 // swagger:operation DELETE /reports/{report_id} Reports deleteReportById
 // ---
 // description: |
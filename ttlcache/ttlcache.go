@@ -0,0 +1,229 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package ttlcache
+
+import (
+	"container/list"
+	commLog "intel/isecl/lib/common/v4/log"
+	"sync"
+	"time"
+)
+
+var log = commLog.GetDefaultLogger()
+
+// Stats holds the counters of a cache
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// Options define the capacity of a cache and how its values are handed out and released
+type Options struct {
+	// MaxEntries bounds the number of values in the cache, a MaxEntries <= 0 does not bound the cache
+	MaxEntries int
+	// SweepInterval is the period at which expired values are removed
+	SweepInterval time.Duration
+	// Copy returns the copy of a value handed out by Get, values are handed out as is if nil
+	Copy func(value interface{}) interface{}
+	// Release is called with every value leaving the cache, when it expires, is evicted, replaced or deleted
+	Release func(value interface{})
+}
+
+type entry struct {
+	key    interface{}
+	value  interface{}
+	expiry time.Time
+}
+
+// Cache is a mutex protected in-memory cache of values expiring at a given time.
+// Expired values are removed by a background sweeper. When the cache holds its maximum number
+// of values, the least recently used value is evicted to make room.
+type Cache struct {
+	options  Options
+	entries  map[interface{}]*list.Element
+	lru      *list.List
+	stats    Stats
+	mtx      *sync.Mutex
+	stop     chan struct{}
+	stopOnce *sync.Once
+}
+
+// New creates a cache and starts its background sweeper, which runs until the cache is closed
+func New(options Options) *Cache {
+	log.Trace("ttlcache/ttlcache:New() Entering")
+	defer log.Trace("ttlcache/ttlcache:New() Leaving")
+	c := &Cache{
+		options:  options,
+		entries:  make(map[interface{}]*list.Element),
+		lru:      list.New(),
+		mtx:      &sync.Mutex{},
+		stop:     make(chan struct{}),
+		stopOnce: &sync.Once{},
+	}
+	go c.sweeper()
+	return c
+}
+
+// sweeper periodically removes expired values until the cache is closed
+func (c *Cache) sweeper() {
+	ticker := time.NewTicker(c.options.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Sweep()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Sweep removes all expired values from the cache
+func (c *Cache) Sweep() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	now := time.Now()
+	for e := c.lru.Back(); e != nil; {
+		prev := e.Prev()
+		if !now.Before(e.Value.(*entry).expiry) {
+			c.evict(e)
+		}
+		e = prev
+	}
+}
+
+// evict removes an element from the cache and releases its value. The cache mutex must be held.
+func (c *Cache) evict(e *list.Element) {
+	ent := c.lru.Remove(e).(*entry)
+	delete(c.entries, ent.key)
+	c.release(ent.value)
+	c.stats.Evictions++
+}
+
+func (c *Cache) release(value interface{}) {
+	if c.options.Release != nil {
+		c.options.Release(value)
+	}
+}
+
+// Get retrieves the value cached by key
+// It returns a copy of the value, as well as a bool that indicates
+// if the value exists in the cache and has not expired
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, exists := c.entries[key]
+	if !exists {
+		c.stats.Misses++
+		return nil, false
+	}
+	ent := e.Value.(*entry)
+	if !time.Now().Before(ent.expiry) {
+		c.evict(e)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	c.stats.Hits++
+	if c.options.Copy != nil {
+		return c.options.Copy(ent.value), true
+	}
+	return ent.value, true
+}
+
+// Peek returns the value cached by key as is, expired or not, without counting a hit or making it the most
+// recently used value
+func (c *Cache) Peek(key interface{}) (interface{}, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if e, exists := c.entries[key]; exists {
+		return e.Value.(*entry).value, true
+	}
+	return nil, false
+}
+
+// Store caches the value by key until expiry, replacing and releasing the value previously cached by key
+func (c *Cache) Store(key interface{}, value interface{}, expiry time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if e, exists := c.entries[key]; exists {
+		ent := e.Value.(*entry)
+		c.release(ent.value)
+		ent.value = value
+		ent.expiry = expiry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, value: value, expiry: expiry})
+	for c.options.MaxEntries > 0 && c.lru.Len() > c.options.MaxEntries {
+		c.evict(c.lru.Back())
+	}
+}
+
+// Delete removes the value cached by key
+func (c *Cache) Delete(key interface{}) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if e, exists := c.entries[key]; exists {
+		c.evict(e)
+	}
+}
+
+// Stats returns the hit, miss and eviction counters and the number of values in the cache
+func (c *Cache) Stats() Stats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// Close stops the background sweeper and releases all the values held by the cache
+func (c *Cache) Close() {
+	log.Trace("ttlcache/ttlcache:Close() Entering")
+	defer log.Trace("ttlcache/ttlcache:Close() Leaving")
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for e := c.lru.Back(); e != nil; e = c.lru.Back() {
+		c.evict(e)
+	}
+}
+
+// Closer is a cache that can be closed when it is replaced
+type Closer interface {
+	Close()
+}
+
+// Global holds the default cache of a package, that can be replaced once the configuration is loaded
+type Global struct {
+	mtx   *sync.RWMutex
+	cache Closer
+}
+
+// NewGlobal creates the holder of a default cache
+func NewGlobal(cache Closer) *Global {
+	return &Global{mtx: &sync.RWMutex{}, cache: cache}
+}
+
+// Load returns the current default cache
+func (g *Global) Load() Closer {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	return g.cache
+}
+
+// Replace makes cache the default cache and closes the previous one
+func (g *Global) Replace(cache Closer) {
+	g.mtx.Lock()
+	previous := g.cache
+	g.cache = cache
+	g.mtx.Unlock()
+	previous.Close()
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package ttlcache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCopyAndRelease(t *testing.T) {
+	log.Trace("ttlcache/ttlcache_test:TestCopyAndRelease() Entering")
+	defer log.Trace("ttlcache/ttlcache_test:TestCopyAndRelease() Leaving")
+	assert := assert.New(t)
+	var released []string
+	cache := New(Options{
+		MaxEntries:    2,
+		SweepInterval: time.Minute,
+		Copy: func(value interface{}) interface{} {
+			return value.(string) + "-copy"
+		},
+		Release: func(value interface{}) {
+			released = append(released, value.(string))
+		},
+	})
+
+	expiry := time.Now().Add(time.Minute)
+	cache.Store("foo", "a", expiry)
+	actual, exists := cache.Get("foo")
+	assert.True(exists)
+	assert.Equal("a-copy", actual)
+
+	// every value leaving the cache is released, whether replaced, evicted, expired, deleted or closed
+	cache.Store("foo", "b", expiry)
+	cache.Store("bar", "c", expiry)
+	cache.Store("baz", "d", expiry)
+	cache.Store("qux", "e", time.Now().Add(-time.Second))
+	cache.Sweep()
+	cache.Delete("baz")
+	assert.Equal([]string{"a", "b", "c", "e", "d"}, released)
+
+	cache.Store("foo", "f", expiry)
+	cache.Close()
+	assert.Equal([]string{"a", "b", "c", "e", "d", "f"}, released)
+	assert.Zero(cache.Stats().Entries)
+}
+
+func TestGlobalReplace(t *testing.T) {
+	log.Trace("ttlcache/ttlcache_test:TestGlobalReplace() Entering")
+	defer log.Trace("ttlcache/ttlcache_test:TestGlobalReplace() Leaving")
+	assert := assert.New(t)
+	previous := New(Options{SweepInterval: time.Minute})
+	previous.Store("foo", "bar", time.Now().Add(time.Minute))
+	global := NewGlobal(previous)
+
+	next := New(Options{SweepInterval: time.Minute})
+	defer next.Close()
+	global.Replace(next)
+	assert.Equal(next, global.Load())
+	// the replaced cache is closed
+	assert.Zero(previous.Stats().Entries)
+}